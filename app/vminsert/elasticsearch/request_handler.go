@@ -0,0 +1,115 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/contentencoding"
+	parser "github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/elasticsearch"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var maxRequestSize = flagutil.NewBytes("elasticsearch.maxRequestSize", 64*1024*1024, "The maximum size in bytes of uncompressed request body "+
+	"at /insert/<tenant>/elasticsearch/_bulk. This protects from compression bombs")
+
+var (
+	rowsInserted  = tenantmetrics.NewCounterMap(`vm_rows_inserted_total{type="elasticsearch"}`)
+	rowsPerInsert = metrics.NewHistogram(`vm_rows_per_insert{type="elasticsearch"}`)
+)
+
+// elasticsearchVersion is the version reported to clients, which check Elasticsearch version before writing data.
+const elasticsearchVersion = "8.9.0"
+
+// InfoHandler responds to Elasticsearch root API request at /insert/<tenant>/elasticsearch/ .
+//
+// Clients such as Filebeat and Logstash refuse sending data until they receive Elasticsearch version.
+func InfoHandler(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	fmt.Fprintf(w, `{"name":"vminsert","cluster_name":"vminsert","version":{"number":%q},"tagline":"You Know, for Search"}`, elasticsearchVersion)
+}
+
+// InsertHandler processes Elasticsearch bulk request at /insert/<tenant>/elasticsearch/_bulk .
+//
+// Elasticsearch-compatible response is written to w on success.
+// Documents rejected by vminsert or vmstorage are reported in the corresponding items of the response.
+func InsertHandler(at *auth.Token, w http.ResponseWriter, req *http.Request) error {
+	startTime := time.Now()
+	zr, err := contentencoding.GetReader(req.Body, req.Header.Get("Content-Encoding"), int64(maxRequestSize.N))
+	if err != nil {
+		return err
+	}
+	defer contentencoding.PutReader(zr)
+
+	var items []parser.Item
+	err = writeconcurrencylimiter.Do(func() error {
+		var err error
+		items, err = parser.ParseStream(items[:0], zr, func(rows []parser.Row) error {
+			return insertRows(at, rows)
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Write(parser.MarshalResponse(nil, time.Since(startTime), items))
+	return nil
+}
+
+func insertRows(at *auth.Token, rows []parser.Row) error {
+	ctx := netstorage.GetInsertCtx()
+	defer netstorage.PutInsertCtx(ctx)
+
+	ctx.Reset() // This line is required for initializing ctx internals.
+	hasRelabeling := relabel.HasRelabeling()
+	for i := range rows {
+		r := &rows[i]
+		ctx.Labels = ctx.Labels[:0]
+		for j := range r.Labels {
+			label := &r.Labels[j]
+			ctx.AddLabel(label.Name, label.Value)
+		}
+		if hasRelabeling {
+			ctx.ApplyRelabeling()
+		}
+		if len(ctx.Labels) == 0 {
+			// Skip log entry without labels.
+			continue
+		}
+		ctx.SetRowID(i)
+		if err := ctx.WriteDataPoint(at, ctx.Labels, r.Timestamp, r.Message); err != nil {
+			return err
+		}
+	}
+	rowsInserted.Get(at).Add(len(rows))
+	rowsPerInsert.Update(float64(len(rows)))
+	err := ctx.FlushBufs()
+	if !netstorage.IsRejectedRowsError(err) {
+		return err
+	}
+	// The remaining rows are stored, so report the rejected rows in the corresponding items of the response,
+	// while the remaining documents of the request continue to be processed.
+	rejectedRows := ctx.GetRejectedRows()
+	if len(rejectedRows) == 0 {
+		return err
+	}
+	rre := &parser.RejectedRowsError{
+		Rows: make([]parser.RejectedRow, 0, len(rejectedRows)),
+	}
+	for _, rr := range rejectedRows {
+		rre.Rows = append(rre.Rows, parser.RejectedRow{
+			Idx:    rr.ID,
+			Reason: fmt.Sprintf("%s (reason=%s)", rr.Msg, rr.Reason),
+		})
+	}
+	return rre
+}
@@ -0,0 +1,175 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/pipeline"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/redaction"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/tenantlimits"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/handshakeext"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+)
+
+func TestInsertHandlerRejectedRows(t *testing.T) {
+	if err := flag.Set("elasticsearch.streamFields", "host"); err != nil {
+		t.Fatalf("cannot set -elasticsearch.streamFields: %s", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start fake vmstorage: %s", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	go runFakeVMStorage(ln)
+
+	relabel.Init()
+	redaction.Init()
+	pipeline.Init()
+	tenantlimits.Init()
+	writeconcurrencylimiter.Init()
+	netstorage.InitStorageNodes([]string{ln.Addr().String()})
+	defer netstorage.Stop()
+
+	doc := func(message string) string {
+		return fmt.Sprintf(`{"index":{}}`+"\n"+`{"@timestamp":%d,"host":"h1","message":%q}`+"\n", time.Now().UnixNano()/1e6, message)
+	}
+	body := doc("accepted 1") +
+		doc("reject") +
+		doc(strings.Repeat("x", storage.MaxEntrySize+1)) +
+		`{"index":{}}` + "\n" + `{"message":"without host"}` + "\n" +
+		doc("accepted 2")
+	at := &auth.Token{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+	var resp bulkResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req, err := http.NewRequest("POST", "/insert/12:34/elasticsearch/_bulk", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("cannot create request: %s", err)
+		}
+		w := httptest.NewRecorder()
+		if err := InsertHandler(at, w, req); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp = bulkResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("cannot parse response %q: %s", w.Body.String(), err)
+		}
+		// The rows rejected by vmstorage are reported only after the connection to vmstorage is established.
+		if len(resp.Items) > 1 && resp.Items[1]["index"].Error != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for the rows rejected by vmstorage; the last response: %q", w.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !resp.Errors {
+		t.Fatalf("expecting errors in the response %+v", resp)
+	}
+	reasonsExpected := []string{"", "reason=too_many_streams", "reason=too_long", "-elasticsearch.streamFields", ""}
+	if len(resp.Items) != len(reasonsExpected) {
+		t.Fatalf("unexpected number of items; got %d; want %d", len(resp.Items), len(reasonsExpected))
+	}
+	for i, reasonExpected := range reasonsExpected {
+		item := resp.Items[i]["index"]
+		if reasonExpected == "" {
+			if item.Status != 201 || item.Error != nil {
+				t.Fatalf("unexpected item #%d; got %+v; want accepted item", i, item)
+			}
+			continue
+		}
+		if item.Status != 400 || item.Error == nil || !strings.Contains(item.Error.Reason, reasonExpected) {
+			t.Fatalf("unexpected item #%d; got %+v; want rejected item with %q in the reason", i, item, reasonExpected)
+		}
+	}
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// runFakeVMStorage accepts vminsert connections at ln and rejects rows with "reject" value.
+//
+// The verdict for every packet is sent in the `ack` for this packet.
+func runFakeVMStorage(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() {
+				_ = c.Close()
+			}()
+			bc, _, err := handshakeext.VMInsertServer(c, 0, handshakeext.CapRejectedRows)
+			if err != nil {
+				return
+			}
+			packetSeq := uint64(0)
+			sizeBuf := make([]byte, 8)
+			for {
+				if _, err := io.ReadFull(bc, sizeBuf); err != nil {
+					return
+				}
+				buf := make([]byte, encoding.UnmarshalUint64(sizeBuf))
+				if _, err := io.ReadFull(bc, buf); err != nil {
+					return
+				}
+				ack := []byte{1}
+				if len(buf) > 0 {
+					var rejectedRows []storage.RejectedRow
+					for idx := 0; len(buf) > 0; idx++ {
+						var mr storage.MetricRow
+						buf, err = mr.Unmarshal(buf)
+						if err != nil {
+							return
+						}
+						if string(mr.Value) == "reject" {
+							rejectedRows = append(rejectedRows, storage.RejectedRow{
+								Idx:       idx,
+								AccountID: encoding.UnmarshalUint32(mr.MetricNameRaw),
+								ProjectID: encoding.UnmarshalUint32(mr.MetricNameRaw[4:]),
+							})
+						}
+					}
+					verdicts := encoding.MarshalUint64(nil, packetSeq)
+					verdicts = storage.MarshalRejectedRows(verdicts, rejectedRows)
+					packetSeq++
+					ack = append([]byte{2}, encoding.MarshalUint64(nil, uint64(len(verdicts)))...)
+					ack = append(ack, verdicts...)
+				}
+				if _, err := bc.Write(ack); err != nil {
+					return
+				}
+				if err := bc.Flush(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,89 @@
+package fluentforward
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	writeRequestsTCP = metrics.NewCounter(`vm_ingestserver_requests_total{type="fluentforward", name="write", net="tcp"}`)
+	writeErrorsTCP   = metrics.NewCounter(`vm_ingestserver_request_errors_total{type="fluentforward", name="write", net="tcp"}`)
+)
+
+// Server accepts Fluent Forward protocol connections over TCP.
+type Server struct {
+	addr  string
+	lnTCP net.Listener
+	wg    sync.WaitGroup
+}
+
+// MustStartServer starts Fluent Forward server on the given addr.
+//
+// TCP connections are processed with insertHandler, which may write acks to the connection.
+//
+// MustStop must be called on the returned server when it is no longer needed.
+func MustStartServer(addr string, insertHandler func(c net.Conn) error) *Server {
+	logger.Infof("starting TCP Fluent Forward server at %q", addr)
+	lnTCP, err := netutil.NewTCPListener("fluentforward", addr)
+	if err != nil {
+		logger.Fatalf("cannot start TCP Fluent Forward server at %q: %s", addr, err)
+	}
+
+	s := &Server{
+		addr:  addr,
+		lnTCP: lnTCP,
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		serveTCP(lnTCP, insertHandler)
+		logger.Infof("stopped TCP Fluent Forward server at %q", addr)
+	}()
+	return s
+}
+
+// MustStop stops the server.
+func (s *Server) MustStop() {
+	logger.Infof("stopping TCP Fluent Forward server at %q...", s.addr)
+	if err := s.lnTCP.Close(); err != nil {
+		logger.Errorf("cannot close TCP Fluent Forward server: %s", err)
+	}
+	s.wg.Wait()
+	logger.Infof("TCP Fluent Forward server at %q has been stopped", s.addr)
+}
+
+func serveTCP(ln net.Listener, insertHandler func(c net.Conn) error) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) {
+				if ne.Temporary() {
+					logger.Errorf("fluentforward: temporary error when listening for TCP addr %q: %s", ln.Addr(), err)
+					time.Sleep(time.Second)
+					continue
+				}
+				if strings.Contains(err.Error(), "use of closed network connection") {
+					break
+				}
+				logger.Fatalf("unrecoverable error when accepting TCP Fluent Forward connections: %s", err)
+			}
+			logger.Fatalf("unexpected error when accepting TCP Fluent Forward connections: %s", err)
+		}
+		go func() {
+			writeRequestsTCP.Inc()
+			if err := insertHandler(c); err != nil {
+				writeErrorsTCP.Inc()
+				logger.Errorf("error in TCP Fluent Forward conn %q<->%q: %s", c.LocalAddr(), c.RemoteAddr(), err)
+			}
+			_ = c.Close()
+		}()
+	}
+}
@@ -0,0 +1,94 @@
+package gelf
+
+import (
+	"flag"
+	"io"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	parser "github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/gelf"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var tenantID = flag.String("gelf.tenantID", "0:0", "Tenant in the form accountID[:projectID] for logs received via -gelfListenAddr")
+
+var (
+	rowsInserted  = tenantmetrics.NewCounterMap(`vm_rows_inserted_total{type="gelf"}`)
+	rowsPerInsert = metrics.NewHistogram(`vm_rows_per_insert{type="gelf"}`)
+)
+
+var (
+	server *Server
+	at     auth.Token
+)
+
+// MustStart starts GELF server on the given addr.
+//
+// MustStop must be called when the server is no longer needed.
+func MustStart(addr string) {
+	token, err := auth.NewToken(*tenantID)
+	if err != nil {
+		logger.Fatalf("cannot parse -gelf.tenantID=%q: %s", *tenantID, err)
+	}
+	at = *token
+	server = MustStartServer(addr, insertHandler, insertDatagramHandler)
+}
+
+// MustStop stops GELF server started with MustStart.
+func MustStop() {
+	server.MustStop()
+	server = nil
+}
+
+func insertHandler(r io.Reader) error {
+	// GELF connections may be open for long periods of time,
+	// so limit the concurrency per each batch instead of per connection.
+	return parser.ParseStream(r, func(rows []parser.Row) error {
+		return writeconcurrencylimiter.Do(func() error {
+			return insertRows(rows)
+		})
+	})
+}
+
+func insertDatagramHandler(data []byte) error {
+	return parser.ParseDatagram(data, func(rows []parser.Row) error {
+		return writeconcurrencylimiter.Do(func() error {
+			return insertRows(rows)
+		})
+	})
+}
+
+func insertRows(rows []parser.Row) error {
+	ctx := netstorage.GetInsertCtx()
+	defer netstorage.PutInsertCtx(ctx)
+
+	ctx.Reset() // This line is required for initializing ctx internals.
+	rowsTotal := 0
+	hasRelabeling := relabel.HasRelabeling()
+	for i := range rows {
+		r := &rows[i]
+		ctx.Labels = ctx.Labels[:0]
+		for j := range r.Labels {
+			label := &r.Labels[j]
+			ctx.AddLabel(label.Name, label.Value)
+		}
+		if hasRelabeling {
+			ctx.ApplyRelabeling()
+		}
+		if len(ctx.Labels) == 0 {
+			// Skip message without labels.
+			continue
+		}
+		if err := ctx.WriteDataPoint(&at, ctx.Labels, r.Timestamp, r.Message); err != nil {
+			return err
+		}
+		rowsTotal++
+	}
+	rowsInserted.Get(&at).Add(rowsTotal)
+	rowsPerInsert.Update(float64(rowsTotal))
+	return ctx.FlushBufsLogRejected("GELF")
+}
@@ -3,23 +3,41 @@ package importer
 import (
 	"bytes"
 	"io"
+	"net/http"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/contentencoding"
 	parser "github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/importer"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/valyala/fastjson/fastfloat"
 )
 
+var maxRequestSize = flagutil.NewBytes("import.maxRequestSize", 1024*1024*1024, "The maximum size in bytes of uncompressed request body "+
+	"at /api/v1/import/prometheus. This protects from compression bombs")
+
 var (
 	rowsInserted  = tenantmetrics.NewCounterMap(`vm_rows_inserted_total{type="importer"}`)
 	rowsPerInsert = metrics.NewHistogram(`vm_rows_per_insert{type="importer"}`)
 )
 
+// InsertHTTPHandler processes plaintext protocol request at /api/v1/import/prometheus.
+//
+// The request body may be compressed with gzip or zstd according to Content-Encoding header.
+func InsertHTTPHandler(at *auth.Token, req *http.Request) error {
+	zr, err := contentencoding.GetReader(req.Body, req.Header.Get("Content-Encoding"), int64(maxRequestSize.N))
+	if err != nil {
+		return err
+	}
+	defer contentencoding.PutReader(zr)
+	return InsertHandler(at, zr)
+}
+
 // InsertHandler processes remote write for plaintext protocol.
 func InsertHandler(at *auth.Token, r io.Reader) error {
 	return writeconcurrencylimiter.Do(func() error {
@@ -61,7 +79,8 @@ func insertRows(at *auth.Token, rows []parser.Row) error {
 			// Skip metric without labels.
 			continue
 		}
-		if err := ctx.WriteDataPoint(&atCopy, ctx.Labels, r.Timestamp, r.Value); err != nil {
+		// Imported timestamps are in milliseconds, while the storage expects nanoseconds.
+		if err := ctx.WriteDataPoint(&atCopy, ctx.Labels, r.Timestamp*1e6, r.Value); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,114 @@
+package jsonline
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/contentencoding"
+	parser "github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/jsonline"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var maxRequestSize = flagutil.NewBytes("jsonline.maxRequestSize", 64*1024*1024, "The maximum size in bytes of uncompressed request body "+
+	"at /insert/<tenant>/jsonline. This protects from compression bombs")
+
+var (
+	rowsInserted  = tenantmetrics.NewCounterMap(`vm_rows_inserted_total{type="jsonline"}`)
+	rowsPerInsert = metrics.NewHistogram(`vm_rows_per_insert{type="jsonline"}`)
+)
+
+// InsertHandler processes newline-delimited JSON request at /insert/<tenant>/jsonline .
+//
+// Stream fields, message field, time field and time format are read from `stream_fields`, `message_field`,
+// `time_field` and `time_format` query args. `X-Stream-Fields`, `X-Message-Field`, `X-Time-Field`
+// and `X-Time-Format` headers are used if the corresponding query args are missing.
+func InsertHandler(at *auth.Token, req *http.Request) error {
+	cfg, err := getConfig(req)
+	if err != nil {
+		return err
+	}
+	zr, err := contentencoding.GetReader(req.Body, req.Header.Get("Content-Encoding"), int64(maxRequestSize.N))
+	if err != nil {
+		return err
+	}
+	defer contentencoding.PutReader(zr)
+
+	return writeconcurrencylimiter.Do(func() error {
+		return parser.ParseStream(zr, cfg, func(rows []parser.Row) error {
+			return insertRows(at, rows)
+		})
+	})
+}
+
+func getConfig(req *http.Request) (*parser.Config, error) {
+	var streamFields []string
+	for _, sf := range strings.Split(getParam(req, "stream_fields", "X-Stream-Fields"), ",") {
+		sf = strings.TrimSpace(sf)
+		if sf != "" {
+			streamFields = append(streamFields, sf)
+		}
+	}
+	if len(streamFields) == 0 {
+		return nil, fmt.Errorf("missing stream fields; pass them via `stream_fields` query arg or via `X-Stream-Fields` header, e.g. `stream_fields=app,host`")
+	}
+	messageField := getParam(req, "message_field", "X-Message-Field")
+	if messageField == "" {
+		messageField = "message"
+	}
+	timeField := getParam(req, "time_field", "X-Time-Field")
+	if timeField == "" {
+		timeField = "time"
+	}
+	timeFormat := parser.TimeFormatRFC3339
+	if s := getParam(req, "time_format", "X-Time-Format"); s != "" {
+		tf, err := parser.ParseTimeFormat(s)
+		if err != nil {
+			return nil, err
+		}
+		timeFormat = tf
+	}
+	return parser.NewConfig(streamFields, messageField, timeField, timeFormat), nil
+}
+
+func getParam(req *http.Request, argName, headerName string) string {
+	if s := req.URL.Query().Get(argName); s != "" {
+		return s
+	}
+	return req.Header.Get(headerName)
+}
+
+func insertRows(at *auth.Token, rows []parser.Row) error {
+	ctx := netstorage.GetInsertCtx()
+	defer netstorage.PutInsertCtx(ctx)
+
+	ctx.Reset() // This line is required for initializing ctx internals.
+	hasRelabeling := relabel.HasRelabeling()
+	for i := range rows {
+		r := &rows[i]
+		ctx.Labels = ctx.Labels[:0]
+		for j := range r.Labels {
+			label := &r.Labels[j]
+			ctx.AddLabel(label.Name, label.Value)
+		}
+		if hasRelabeling {
+			ctx.ApplyRelabeling()
+		}
+		if len(ctx.Labels) == 0 {
+			// Skip log entry without labels.
+			continue
+		}
+		if err := ctx.WriteDataPoint(at, ctx.Labels, r.Timestamp, r.Message); err != nil {
+			return err
+		}
+	}
+	rowsInserted.Get(at).Add(len(rows))
+	rowsPerInsert.Update(float64(len(rows)))
+	return ctx.FlushBufs()
+}
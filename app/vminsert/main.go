@@ -9,10 +9,19 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/elasticsearch"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/fluentforward"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/gelf"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/importer"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/jsonline"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/opentelemetry"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/pipeline"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/redaction"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/remotewrite"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/syslog"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/tenantlimits"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
@@ -30,6 +39,9 @@ import (
 
 var (
 	importerListenAddr     = flag.String("importerListenAddr", "", "TCP and UDP address to listen for plaintext data. Usually :2003 must be set. Doesn't work if empty")
+	syslogListenAddrs      = flagutil.NewArray("syslogListenAddr", "TCP and UDP address to listen for RFC 5424 and RFC 3164 syslog messages. Usually :514 must be set. See also -syslog.tenantID and -syslog.extraLabels")
+	gelfListenAddr         = flag.String("gelfListenAddr", "", "TCP and UDP address to listen for GELF messages. Usually :12201 must be set. Doesn't work if empty. See also -gelf.labelFields")
+	fluentListenAddr       = flag.String("fluentForwardListenAddr", "", "TCP address to listen for Fluentd and Fluent Bit logs in Fluent Forward protocol. Usually :24224 must be set. Doesn't work if empty. See also -fluentForward.labelFields")
 	httpListenAddr         = flag.String("httpListenAddr", ":8480", "Address to listen for http connections")
 	maxLabelsPerTimeseries = flag.Int("maxLabelsPerTimeseries", 30, "The maximum number of labels accepted per time series. Superflouos labels are dropped")
 	storageNodes           = flagutil.NewArray("storageNode", "Address of vmstorage nodes; usage: -storageNode=vmstorage-host1:8400 -storageNode=vmstorage-host2:8400")
@@ -52,6 +64,9 @@ func main() {
 	logger.Infof("successfully initialized netstorage in %.3f seconds", time.Since(startTime).Seconds())
 
 	relabel.Init()
+	redaction.Init()
+	pipeline.Init()
+	tenantlimits.Init()
 	storage.SetMaxLabelsPerTimeseries(*maxLabelsPerTimeseries)
 	common.StartUnmarshalWorkers()
 	writeconcurrencylimiter.Init()
@@ -63,6 +78,18 @@ func main() {
 		})
 	}
 
+	if len(*syslogListenAddrs) > 0 {
+		syslog.MustStart(*syslogListenAddrs)
+	}
+
+	if *gelfListenAddr != "" {
+		gelf.MustStart(*gelfListenAddr)
+	}
+
+	if *fluentListenAddr != "" {
+		fluentforward.MustStart(*fluentListenAddr)
+	}
+
 	go func() {
 		httpserver.Serve(*httpListenAddr, requestHandler)
 	}()
@@ -71,6 +98,15 @@ func main() {
 	logger.Infof("service received signal %s", sig)
 
 	startTime = time.Now()
+	if len(*syslogListenAddrs) > 0 {
+		syslog.MustStop()
+	}
+	if *gelfListenAddr != "" {
+		gelf.MustStop()
+	}
+	if *fluentListenAddr != "" {
+		fluentforward.MustStop()
+	}
 	logger.Infof("successfully shut down http service in %.3f seconds", time.Since(startTime).Seconds())
 
 	common.StopUnmarshalWorkers()
@@ -115,6 +151,43 @@ func requestHandler(w http.ResponseWriter, r *http.Request) bool {
 		}
 		w.WriteHeader(http.StatusNoContent)
 		return true
+	case "opentelemetry/v1/logs":
+		opentelemetryRequests.Inc()
+		if err := opentelemetry.InsertHandler(at, w, r); err != nil {
+			opentelemetryErrors.Inc()
+			httpserver.Errorf(w, r, "error in %q: %s", r.URL.Path, err)
+			return true
+		}
+		return true
+	case "api/v1/import/prometheus":
+		importerRequests.Inc()
+		if err := importer.InsertHTTPHandler(at, r); err != nil {
+			importerErrors.Inc()
+			httpserver.Errorf(w, r, "error in %q: %s", r.URL.Path, err)
+			return true
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	case "jsonline":
+		jsonlineRequests.Inc()
+		if err := jsonline.InsertHandler(at, r); err != nil {
+			jsonlineErrors.Inc()
+			httpserver.Errorf(w, r, "error in %q: %s", r.URL.Path, err)
+			return true
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	case "elasticsearch", "elasticsearch/":
+		elasticsearch.InfoHandler(w)
+		return true
+	case "elasticsearch/_bulk":
+		elasticsearchBulkRequests.Inc()
+		if err := elasticsearch.InsertHandler(at, w, r); err != nil {
+			elasticsearchBulkErrors.Inc()
+			httpserver.Errorf(w, r, "error in %q: %s", r.URL.Path, err)
+			return true
+		}
+		return true
 	default:
 		// This is not our link
 		return false
@@ -125,6 +198,18 @@ var (
 	prometheusWriteRequests = metrics.NewCounter(`vm_http_requests_total{path="/insert/{}/prometheus/", protocol="remotewrite"}`)
 	prometheusWriteErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/insert/{}/prometheus/", protocol="remotewrite"}`)
 
+	opentelemetryRequests = metrics.NewCounter(`vm_http_requests_total{path="/insert/{}/opentelemetry/v1/logs", protocol="opentelemetry"}`)
+	opentelemetryErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/insert/{}/opentelemetry/v1/logs", protocol="opentelemetry"}`)
+
+	importerRequests = metrics.NewCounter(`vm_http_requests_total{path="/insert/{}/api/v1/import/prometheus", protocol="importer"}`)
+	importerErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/insert/{}/api/v1/import/prometheus", protocol="importer"}`)
+
+	jsonlineRequests = metrics.NewCounter(`vm_http_requests_total{path="/insert/{}/jsonline", protocol="jsonline"}`)
+	jsonlineErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/insert/{}/jsonline", protocol="jsonline"}`)
+
+	elasticsearchBulkRequests = metrics.NewCounter(`vm_http_requests_total{path="/insert/{}/elasticsearch/_bulk", protocol="elasticsearch"}`)
+	elasticsearchBulkErrors   = metrics.NewCounter(`vm_http_request_errors_total{path="/insert/{}/elasticsearch/_bulk", protocol="elasticsearch"}`)
+
 	_ = metrics.NewGauge(`vm_metrics_with_dropped_labels_total`, func() float64 {
 		return float64(atomic.LoadUint64(&storage.MetricsWithDroppedLabels))
 	})
@@ -2,13 +2,21 @@ package netstorage
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"time"
+	"unicode/utf8"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/pipeline"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/redaction"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/tenantlimits"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
 	"github.com/cespare/xxhash/v2"
 	"github.com/lithammer/go-jump-consistent-hash"
 )
@@ -23,22 +31,122 @@ type InsertCtx struct {
 	bufRowss  []bufRows
 	labelsBuf []byte
 
-	relabelCtx relabel.Ctx
+	relabelCtx   relabel.Ctx
+	redactionCtx redaction.Ctx
+	pipelineCtx  pipeline.Ctx
+
+	// The tenant, the number of rows and their size in bytes,
+	// which must be checked against rate limits before sending bufRowss to storage nodes.
+	pendingAt    auth.Token
+	pendingRows  int
+	pendingBytes int
+
+	// The acceptance window for timestamps and the maximum entry size of the tenant limitsAt.
+	// See -ingest.maxPastAge, -ingest.maxFutureSkew and -ingest.maxEntrySize.
+	hasLimits           bool
+	limitsAt            auth.Token
+	minTimestamp        int64
+	maxTimestamp        int64
+	tooOldTimestampMsg  string
+	tooNewTimestampMsg  string
+	maxEntrySize        int
+	truncateLongEntries bool
+	tooLongEntryMsg     string
+
+	rejectedRows      []rejectedRowsEntry
+	rejectedRowsTotal int
+
+	// storageRejections collects the rows rejected by vmstorage nodes from the rows pushed since the last Reset.
+	// It is nil if no rows were pushed.
+	storageRejections *storageRejections
+
+	// The id of the rows written to ctx and the rejected rows with their ids. See SetRowID.
+	trackRowIDs      bool
+	rowID            int
+	rejectedRowsByID []RejectedRow
 }
 
 type bufRows struct {
 	buf  []byte
 	rows int
+
+	// rowIDs contains ids of the rows in buf if InsertCtx tracks row ids. See InsertCtx.SetRowID.
+	rowIDs []int
+
+	// segments contain the rows in buf, which wait for the verdict from vmstorage.
+	segments []rowsSegment
+}
+
+// rowsSegment contains rows pushed by a single InsertCtx, which wait for the verdict from vmstorage.
+type rowsSegment struct {
+	// start is the index of the first row in bufRows.
+	start int
+	rows  int
+	sr    *storageRejections
+
+	// rowIDs contains ids of the rows in the segment. It is nil if InsertCtx doesn't track row ids.
+	rowIDs []int
 }
 
 func (br *bufRows) reset() {
+	// Notify the segments, which didn't receive the verdict from vmstorage, e.g. because br has been buffered on disk.
+	br.releaseSegments()
+
 	br.buf = br.buf[:0]
 	br.rows = 0
+	br.rowIDs = br.rowIDs[:0]
 }
 
-func (br *bufRows) pushTo(sn *storageNode) error {
+// addSegment registers rows with the given rowIDs, which are going to be appended to br, for tracking the verdict from vmstorage in sr.
+//
+// rowIDs may be nil if the ids of rows aren't tracked.
+func (br *bufRows) addSegment(rows int, rowIDs []int, sr *storageRejections) {
+	sr.add()
+	if len(rowIDs) > 0 {
+		// Copy rowIDs, since the caller may re-use them.
+		rowIDs = append([]int{}, rowIDs...)
+	}
+	br.segments = append(br.segments, rowsSegment{
+		start:  br.rows,
+		rows:   rows,
+		sr:     sr,
+		rowIDs: rowIDs,
+	})
+}
+
+// releaseSegments notifies br.segments that they won't receive the verdict from vmstorage.
+func (br *bufRows) releaseSegments() {
+	resolveSegments(br.segments, nil)
+	br.segments = br.segments[:0]
+}
+
+// resolveSegments notifies segments about the rows rejected by vmstorage from the packet with these segments.
+//
+// rejectedRows must be sorted by storage.RejectedRow.Idx.
+func resolveSegments(segments []rowsSegment, rejectedRows []storage.RejectedRow) {
+	for i := range segments {
+		seg := &segments[i]
+		for len(rejectedRows) > 0 && rejectedRows[0].Idx < seg.start {
+			rejectedRows = rejectedRows[1:]
+		}
+		n := 0
+		var rejectedIDs []int
+		for n < len(rejectedRows) && rejectedRows[n].Idx < seg.start+seg.rows {
+			if idx := rejectedRows[n].Idx - seg.start; idx < len(seg.rowIDs) {
+				rejectedIDs = append(rejectedIDs, seg.rowIDs[idx])
+			}
+			n++
+		}
+		seg.sr.done(rejectedRows[:n], rejectedIDs)
+		rejectedRows = rejectedRows[n:]
+		seg.sr = nil
+		seg.rowIDs = nil
+	}
+}
+
+func (br *bufRows) pushTo(sn *storageNode, sr *storageRejections) error {
 	bufLen := len(br.buf)
-	err := sn.push(br.buf, br.rows)
+	err := sn.push(br.buf, br.rows, br.rowIDs, sr)
 	br.reset()
 	if err != nil {
 		return &httpserver.ErrorWithStatusCode{
@@ -67,6 +175,21 @@ func (ctx *InsertCtx) Reset() {
 	}
 	ctx.labelsBuf = ctx.labelsBuf[:0]
 	ctx.relabelCtx.Reset()
+	ctx.redactionCtx.Reset()
+	ctx.pipelineCtx.Reset()
+
+	ctx.pendingAt = auth.Token{}
+	ctx.pendingRows = 0
+	ctx.pendingBytes = 0
+
+	ctx.hasLimits = false
+	ctx.rejectedRows = ctx.rejectedRows[:0]
+	ctx.rejectedRowsTotal = 0
+	ctx.storageRejections = nil
+
+	ctx.trackRowIDs = false
+	ctx.rowID = 0
+	ctx.rejectedRowsByID = ctx.rejectedRowsByID[:0]
 }
 
 // AddLabelBytes adds (name, value) label to ctx.Labels.
@@ -92,21 +215,78 @@ func (ctx *InsertCtx) ApplyRelabeling() {
 	ctx.Labels = ctx.relabelCtx.ApplyRelabeling(ctx.Labels)
 }
 
+// Redact applies redaction rules to the given line with the given labels and returns the result.
+//
+// The returned line is valid until the next call to Redact. See -redactionConfig for details.
+func (ctx *InsertCtx) Redact(labels []storage.Label, line []byte) []byte {
+	return ctx.redactionCtx.Redact(labels, line)
+}
+
 // WriteDataPoint writes (timestamp, value) data point with the given at and labels to ctx buffer.
+//
+// Redaction rules and ingestion pipeline are applied to labels and value before determining the storage node for them.
+// See -redactionConfig and -pipelineConfig for details.
 func (ctx *InsertCtx) WriteDataPoint(at *auth.Token, labels []storage.Label, timestamp int64, value []byte) error {
+	if redaction.HasRedaction() {
+		value = ctx.Redact(labels, value)
+	}
+	if pipeline.HasPipeline() {
+		var ok bool
+		labels, value, ok = ctx.pipelineCtx.Apply(labels, value)
+		if !ok {
+			// The line has been dropped by the pipeline.
+			return nil
+		}
+	}
 	ctx.MetricNameBuf = storage.MarshalMetricNameRaw(ctx.MetricNameBuf[:0], at.AccountID, at.ProjectID, labels)
 	storageNodeIdx := ctx.GetStorageNodeIdx(at, labels)
 	return ctx.WriteDataPointExt(at, storageNodeIdx, ctx.MetricNameBuf, timestamp, value)
 }
 
 // WriteDataPointExt writes the given metricNameRaw with (timestmap, value) to ctx buffer with the given storageNodeIdx.
+//
+// Rows with timestamps outside the acceptance window for at are rejected and reported by FlushBufs.
+// Rows with values longer than the maximum entry size for at are either truncated or rejected.
 func (ctx *InsertCtx) WriteDataPointExt(at *auth.Token, storageNodeIdx int, metricNameRaw []byte, timestamp int64, value []byte) error {
+	if !ctx.hasLimits || ctx.limitsAt != *at {
+		ctx.initLimits(at)
+	}
+	if timestamp < ctx.minTimestamp {
+		ctx.RejectRows(at, RejectReasonTooOld, ctx.tooOldTimestampMsg, 1)
+		return nil
+	}
+	if timestamp > ctx.maxTimestamp {
+		ctx.RejectRows(at, RejectReasonTooNew, ctx.tooNewTimestampMsg, 1)
+		return nil
+	}
+	if len(value) > ctx.maxEntrySize {
+		if !ctx.truncateLongEntries {
+			ctx.RejectRows(at, RejectReasonTooLong, ctx.tooLongEntryMsg, 1)
+			return nil
+		}
+		value = truncateEntry(value, ctx.maxEntrySize)
+		rowsTruncated.Get(at).Inc()
+	}
+	if ctx.pendingRows > 0 && *at != ctx.pendingAt {
+		// Rate limits are tracked per tenant, so check the rows for the previous tenant
+		// and push them to storage nodes. This guarantees that ctx bufs contain rows only for ctx.pendingAt,
+		// so only the rows for the tenant exceeding the rate limit are dropped.
+		if err := ctx.checkRateLimit(); err != nil {
+			return err
+		}
+		if err := ctx.pushBufs(); err != nil {
+			return err
+		}
+	}
 	br := &ctx.bufRowss[storageNodeIdx]
 	sn := storageNodes[storageNodeIdx]
 	bufNew := storage.MarshalMetricRow(br.buf, metricNameRaw, timestamp, value)
 	if len(bufNew) >= maxBufSizePerStorageNode {
 		// Send buf to storageNode, since it is too big.
-		if err := br.pushTo(sn); err != nil {
+		if err := ctx.checkRateLimit(); err != nil {
+			return err
+		}
+		if err := br.pushTo(sn, ctx.getStorageRejections()); err != nil {
 			return err
 		}
 		br.buf = storage.MarshalMetricRow(bufNew[:0], metricNameRaw, timestamp, value)
@@ -114,18 +294,145 @@ func (ctx *InsertCtx) WriteDataPointExt(at *auth.Token, storageNodeIdx int, metr
 		br.buf = bufNew
 	}
 	br.rows++
+	if ctx.trackRowIDs {
+		br.rowIDs = append(br.rowIDs, ctx.rowID)
+	}
+	ctx.pendingAt = *at
+	ctx.pendingRows++
+	ctx.pendingBytes += len(metricNameRaw) + len(value)
 	return nil
 }
 
+func (ctx *InsertCtx) initLimits(at *auth.Token) {
+	ctx.hasLimits = true
+	ctx.limitsAt = *at
+	limits := tenantlimits.GetLimits(at)
+	now := time.Now().UnixNano()
+	ctx.minTimestamp = math.MinInt64
+	if limits.MaxPastAge > 0 {
+		ctx.minTimestamp = now - limits.MaxPastAge.Nanoseconds()
+		ctx.tooOldTimestampMsg = fmt.Sprintf("timestamps are older than %s; see -ingest.maxPastAge", limits.MaxPastAge)
+	}
+	ctx.maxTimestamp = math.MaxInt64
+	if limits.MaxFutureSkew > 0 {
+		ctx.maxTimestamp = now + limits.MaxFutureSkew.Nanoseconds()
+		ctx.tooNewTimestampMsg = fmt.Sprintf("timestamps exceed the current time by more than %s; see -ingest.maxFutureSkew", limits.MaxFutureSkew)
+	}
+	ctx.maxEntrySize = limits.MaxEntrySize
+	ctx.truncateLongEntries = limits.TruncateLongEntries
+	ctx.tooLongEntryMsg = fmt.Sprintf("log lines are longer than %d bytes; see -ingest.maxEntrySize", limits.MaxEntrySize)
+}
+
+var rowsTruncated = tenantmetrics.NewCounterMap(`vm_rows_truncated_total`)
+
+// truncateEntry truncates value to maxSize bytes.
+//
+// The value is truncated at utf-8 rune boundary, so multi-byte runes aren't broken.
+func truncateEntry(value []byte, maxSize int) []byte {
+	n := maxSize
+	for n > 0 && n > maxSize-utf8.UTFMax && !utf8.RuneStart(value[n]) {
+		n--
+	}
+	if n <= maxSize-utf8.UTFMax {
+		// value isn't valid utf-8.
+		n = maxSize
+	}
+	return value[:n]
+}
+
+// checkRateLimit checks the pending rows in ctx bufs against the rate limits for ctx.pendingAt.
+//
+// The rows are dropped from ctx bufs if the rate limit is exceeded.
+// ctx bufs contain only the rows for ctx.pendingAt, since they are pushed to storage nodes when the tenant changes.
+func (ctx *InsertCtx) checkRateLimit() error {
+	rows := ctx.pendingRows
+	bytes := ctx.pendingBytes
+	ctx.pendingRows = 0
+	ctx.pendingBytes = 0
+	if rows == 0 {
+		return nil
+	}
+	if err := tenantlimits.CheckRateLimit(&ctx.pendingAt, rows, bytes); err != nil {
+		for i := range ctx.bufRowss {
+			ctx.bufRowss[i].reset()
+		}
+		return err
+	}
+	return nil
+}
+
+func (ctx *InsertCtx) getStorageRejections() *storageRejections {
+	if ctx.storageRejections == nil {
+		ctx.storageRejections = newStorageRejections()
+	}
+	return ctx.storageRejections
+}
+
 // FlushBufs flushes ctx bufs to remote storage nodes.
+//
+// It returns an error with http.StatusTooManyRequests status code
+// without sending the bufs if the ingestion rate limit for the tenant is exceeded.
+//
+// It returns an error with http.StatusBadRequest status code if rows were rejected in ctx or by vmstorage nodes.
+// The remaining rows are stored in this case.
 func (ctx *InsertCtx) FlushBufs() error {
+	if err := ctx.checkRateLimit(); err != nil {
+		return err
+	}
+	if err := ctx.pushBufs(); err != nil {
+		return err
+	}
+	if sr := ctx.storageRejections; sr != nil {
+		// Wait for the verdicts from vmstorage nodes for the pushed rows, so the rows rejected by vmstorage
+		// are reported to the request, which sent them.
+		ctx.storageRejections = nil
+		rejected, rejectedIDs := sr.wait(maxStorageRejectionsWait)
+		n := 0
+		for _, rr := range rejected {
+			at := auth.Token{
+				AccountID: rr.AccountID,
+				ProjectID: rr.ProjectID,
+			}
+			rowsRejectedByStorage.Get(&at).Add(rr.Rows)
+			n += rr.Rows
+		}
+		if n > 0 {
+			const msg = "new streams exceeding the limit on active streams per tenant at vmstorage; see -storage.maxActiveStreamsPerTenant"
+			ctx.addRejectedRows(rejectReasonTooManyStreams, msg, n)
+			for _, id := range rejectedIDs {
+				ctx.rejectedRowsByID = append(ctx.rejectedRowsByID, RejectedRow{
+					ID:     id,
+					Reason: rejectReasonTooManyStreams,
+					Msg:    msg,
+				})
+			}
+		}
+	}
+	return ctx.getRejectedRowsError()
+}
+
+// FlushBufsLogRejected flushes ctx bufs to remote storage nodes like FlushBufs, but logs the rejected rows
+// with the given prefix instead of returning an error for them.
+//
+// This is useful for protocols without responses, since the remaining rows are accepted and mustn't be sent again.
+func (ctx *InsertCtx) FlushBufsLogRejected(prefix string) error {
+	err := ctx.FlushBufs()
+	if !IsRejectedRowsError(err) {
+		return err
+	}
+	logger.Warnf("%s: %s", prefix, err)
+	return nil
+}
+
+// pushBufs pushes ctx bufs to storage nodes.
+func (ctx *InsertCtx) pushBufs() error {
 	var firstErr error
 	for i := range ctx.bufRowss {
 		br := &ctx.bufRowss[i]
 		if len(br.buf) == 0 {
 			continue
 		}
-		if err := br.pushTo(storageNodes[i]); err != nil && firstErr == nil {
+		if err := br.pushTo(storageNodes[i], ctx.getStorageRejections()); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
@@ -0,0 +1,104 @@
+package netstorage
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/tenantlimits"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func TestInsertCtxRateLimitPerTenant(t *testing.T) {
+	// Limit the ingestion rate only for tenant 5:6.
+	f, err := ioutil.TempFile("", "TestInsertCtxRateLimitPerTenant")
+	if err != nil {
+		t.Fatalf("cannot create tenant limits file: %s", err)
+	}
+	path := f.Name()
+	defer func() {
+		_ = os.Remove(path)
+	}()
+	if _, err := f.WriteString(`"5:6": {rate_limit_lines: 1, burst_lines: 1}`); err != nil {
+		t.Fatalf("cannot write tenant limits file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("cannot close tenant limits file: %s", err)
+	}
+	mustSetFlag(t, "ingest.tenantLimitsFile", path)
+	tenantlimits.Init()
+	defer func() {
+		mustSetFlag(t, "ingest.tenantLimitsFile", "")
+		tenantlimits.Init()
+	}()
+
+	storageNodesOrig := storageNodes
+	sn := &storageNode{
+		rowsPushed: &metrics.Counter{},
+	}
+	maxBufSizePerStorageNodeOrig := maxBufSizePerStorageNode
+	storageNodes = []*storageNode{sn}
+	maxBufSizePerStorageNode = 1024 * 1024
+	defer func() {
+		storageNodes = storageNodesOrig
+		maxBufSizePerStorageNode = maxBufSizePerStorageNodeOrig
+	}()
+
+	var ctx InsertCtx
+	ctx.Reset()
+	timestamp := time.Now().UnixNano()
+	writeRow := func(at *auth.Token, line string) error {
+		t.Helper()
+		metricNameRaw := storage.MarshalMetricNameRaw(nil, at.AccountID, at.ProjectID, []storage.Label{{
+			Name:  []byte("job"),
+			Value: []byte("foo"),
+		}})
+		return ctx.WriteDataPointExt(at, 0, metricNameRaw, timestamp, []byte(line))
+	}
+	atAllowed := &auth.Token{AccountID: 1, ProjectID: 2}
+	atLimited := &auth.Token{AccountID: 5, ProjectID: 6}
+	mustWriteRows := func(at *auth.Token, lines ...string) {
+		t.Helper()
+		for _, line := range lines {
+			if err := writeRow(at, line); err != nil {
+				t.Fatalf("unexpected error when writing %q: %s", line, err)
+			}
+		}
+	}
+
+	// The rate limit is checked for the rows of the previous tenant when switching to another tenant.
+	// The first row for tenant 5:6 exhausts its burst, while the second row exceeds the rate limit.
+	mustWriteRows(atAllowed, "allowed 0", "allowed 1")
+	mustWriteRows(atLimited, "limited 0")
+	mustWriteRows(atAllowed, "allowed 2")
+	mustWriteRows(atLimited, "limited 1")
+	err = writeRow(atAllowed, "allowed 3")
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	esc, ok := err.(*httpserver.ErrorWithStatusCode)
+	if !ok || esc.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected error; got %v; want error with %d status code", err, http.StatusTooManyRequests)
+	}
+
+	// Only the rows for tenant 5:6 exceeding the rate limit must be dropped.
+	if sn.br.rows != 4 {
+		t.Fatalf("unexpected number of rows pushed to storage node; got %d; want 4", sn.br.rows)
+	}
+	if ctx.bufRowss[0].rows != 0 {
+		t.Fatalf("unexpected number of rows left in ctx bufs; got %d; want 0", ctx.bufRowss[0].rows)
+	}
+}
+
+func mustSetFlag(t *testing.T, name, value string) {
+	t.Helper()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("cannot set -%s=%q: %s", name, value, err)
+	}
+}
@@ -4,15 +4,20 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"path/filepath"
+	"regexp"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/diskqueue"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/handshakeext"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/consts"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/handshake"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/memory"
@@ -25,8 +30,12 @@ import (
 var (
 	disableRPCCompression = flag.Bool(`rpc.disableCompression`, false, "Disable compression of RPC traffic. This reduces CPU usage at the cost of higher network bandwidth usage")
 	replicationFactor     = flag.Int("replicationFactor", 1, "Replication factor for the ingested data, i.e. how many copies to make among distinct -storageNode instances. "+
-		"Note that vmselect must run with -dedup.minScrapeInterval=1ms for data de-duplication when replicationFactor is greater than 1. "+
-		"Higher values for -dedup.minScrapeInterval at vmselect is OK")
+		"Note that vmselect must run with -dedup.exactDuplicates for data de-duplication when replicationFactor is greater than 1")
+	bufferDataPath = flag.String("bufferDataPath", "", "Optional path to a directory for buffering the data on disk when all the -storageNode instances are unavailable. "+
+		"The buffered data survives vminsert restarts and is sent to vmstorage nodes in order when they become available. "+
+		"The data is lost in this case if the flag isn't set")
+	bufferMaxDiskUsagePerNode = flagutil.NewBytes("bufferMaxDiskUsagePerNode", 0, "The maximum disk usage for the data buffered at -bufferDataPath per each -storageNode. "+
+		"The oldest buffered data is dropped when the limit is reached. There is no limit if it is set to 0")
 )
 
 func (sn *storageNode) isBroken() bool {
@@ -43,13 +52,21 @@ func (sn *storageNode) isBroken() bool {
 // if sn is currently unavailable or overloaded.
 //
 // rows is the number of rows in the buf.
-func (sn *storageNode) push(buf []byte, rows int) error {
+//
+// sr is notified about the rows rejected by vmstorage from buf if sn reports rejected rows.
+// sr is notified without rejected rows if buf is re-routed or buffered on disk.
+func (sn *storageNode) push(buf []byte, rows int, rowIDs []int, sr *storageRejections) error {
 	if len(buf) > maxBufSizePerStorageNode {
 		logger.Panicf("BUG: len(buf)=%d cannot exceed %d", len(buf), maxBufSizePerStorageNode)
 	}
 	sn.rowsPushed.Add(rows)
 
 	if sn.isBroken() {
+		if sn.q != nil && getHealthyStorageNodesCount() == 0 {
+			// All the vmstorage nodes are unavailable. Buffer buf on disk instead of dropping it.
+			sn.mustWriteToQueue(buf, rows)
+			return nil
+		}
 		// The vmstorage node is temporarily broken. Re-route buf to healthy vmstorage nodes.
 		if err := addToReroutedBufMayBlock(buf, rows); err != nil {
 			return fmt.Errorf("%d rows dropped because the current vsmtorage is unavailable and %w", rows, err)
@@ -61,6 +78,9 @@ func (sn *storageNode) push(buf []byte, rows int) error {
 	sn.brLock.Lock()
 	if len(sn.br.buf)+len(buf) <= maxBufSizePerStorageNode {
 		// Fast path: the buf contents fits sn.buf.
+		if atomic.LoadUint32(&sn.reportsRejectedRows) != 0 && atomic.LoadUint32(&sn.retrying) == 0 {
+			sn.br.addSegment(rows, rowIDs, sr)
+		}
 		sn.br.buf = append(sn.br.buf, buf...)
 		sn.br.rows += rows
 		sn.brLock.Unlock()
@@ -108,6 +128,13 @@ func (sn *storageNode) run(stopCh <-chan struct{}, snIdx int) {
 			// Do not sleep if sn.br.buf isn't empty.
 			waitCh = closedCh
 		}
+		var pollTimer *time.Timer
+		var pollCh <-chan time.Time
+		if waitCh == nil && sn.hasPendingVerdicts() {
+			// Poll verdicts soon, since push requests wait for them.
+			pollTimer = timerpool.Get(verdictsPollInterval)
+			pollCh = pollTimer.C
+		}
 		select {
 		case <-stopCh:
 			mustStop = true
@@ -115,6 +142,10 @@ func (sn *storageNode) run(stopCh <-chan struct{}, snIdx int) {
 			// in order to send the remaining bits of data.
 		case <-ticker.C:
 		case <-waitCh:
+		case <-pollCh:
+		}
+		if pollTimer != nil {
+			timerpool.Put(pollTimer)
 		}
 		sn.brLock.Lock()
 		sn.br, br = br, sn.br
@@ -126,12 +157,20 @@ func (sn *storageNode) run(stopCh <-chan struct{}, snIdx int) {
 			brLastResetTime = currentTime
 		}
 		sn.checkHealth()
+		if len(br.buf) == 0 {
+			sn.pollVerdicts()
+		}
+		if sn.q != nil {
+			sn.sendBufWithQueue(&br, snIdx, replicas)
+			continue
+		}
 		if len(br.buf) == 0 {
 			// Nothing to send.
 			continue
 		}
 		// Send br to replicas storageNodes starting from snIdx.
 		for !sendBufToReplicasNonblocking(&br, snIdx, replicas) {
+			sn.startRetrying(&br)
 			t := timerpool.Get(200 * time.Millisecond)
 			select {
 			case <-stopCh:
@@ -142,10 +181,78 @@ func (sn *storageNode) run(stopCh <-chan struct{}, snIdx int) {
 				sn.checkHealth()
 			}
 		}
+		atomic.StoreUint32(&sn.retrying, 0)
 		br.reset()
 	}
 }
 
+// startRetrying releases the rows in br and sn.br from waiting for verdicts, since they cannot be sent to vmstorage nodes now.
+//
+// This prevents from blocking push requests while vmstorage nodes are unavailable.
+// The rows pushed to sn until the retrying is finished don't wait for verdicts too.
+func (sn *storageNode) startRetrying(br *bufRows) {
+	atomic.StoreUint32(&sn.retrying, 1)
+	br.releaseSegments()
+	sn.brLock.Lock()
+	sn.br.releaseSegments()
+	sn.brLock.Unlock()
+}
+
+// sendBufWithQueue sends br to replicas storageNodes starting from snIdx.
+//
+// The data from the on-disk buffer at sn.q is sent before br in order to preserve the order of the data.
+// br is appended to sn.q if it cannot be sent or if sn.q still contains data after that.
+func (sn *storageNode) sendBufWithQueue(br *bufRows, snIdx, replicas int) {
+	sn.sendQueueNonblocking(snIdx, replicas)
+	if len(br.buf) == 0 {
+		// Nothing to send.
+		return
+	}
+	if sn.q.GetPendingBytes() > 0 || !sendBufToReplicasNonblocking(br, snIdx, replicas) {
+		sn.mustWriteToQueue(br.buf, br.rows)
+	}
+	br.reset()
+}
+
+// sendQueueNonblocking sends the data from sn.q to replicas storageNodes starting from snIdx.
+//
+// It returns after a second in order to give a chance to the data from sn.br to be buffered in sn.q.
+func (sn *storageNode) sendQueueNonblocking(snIdx, replicas int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && getHealthyStorageNodesCount() > 0 {
+		block, ok := sn.q.MustPeekBlock(sn.qBuf[:0])
+		sn.qBuf = block
+		if !ok {
+			// sn.q is empty.
+			return
+		}
+		if len(block) < 8 {
+			logger.Panicf("BUG: too short block read from the buffer for -storageNode=%q; got %d bytes; want at least 8 bytes", sn.dialer.Addr(), len(block))
+		}
+		br := bufRows{
+			rows: int(encoding.UnmarshalUint64(block)),
+			buf:  block[8:],
+		}
+		if !sendBufToReplicasNonblocking(&br, snIdx, replicas) {
+			return
+		}
+		sn.q.MustSkipBlock()
+		sn.rowsReplayed.Add(br.rows)
+	}
+}
+
+// mustWriteToQueue writes buf with the given number of rows to sn.q.
+func (sn *storageNode) mustWriteToQueue(buf []byte, rows int) {
+	bb := queueBufPool.Get()
+	bb.B = encoding.MarshalUint64(bb.B[:0], uint64(rows))
+	bb.B = append(bb.B, buf...)
+	sn.q.MustWriteBlock(bb.B)
+	queueBufPool.Put(bb)
+	sn.rowsBuffered.Add(rows)
+}
+
+var queueBufPool bytesutil.ByteBufferPool
+
 func sendBufToReplicasNonblocking(br *bufRows, snIdx, replicas int) bool {
 	usedStorageNodes := make(map[*storageNode]bool, replicas)
 	for i := 0; i < replicas; i++ {
@@ -177,12 +284,18 @@ func sendBufToReplicasNonblocking(br *bufRows, snIdx, replicas int) bool {
 				// The br has been already replicated to sn. Skip it.
 				continue
 			}
-			if !sn.sendBufRowsNonblocking(br) {
+			// Track the verdict only from the first vmstorage node, which accepts br.
+			// Other replicas may reject distinct rows. Their verdicts are ignored in order to avoid double counting.
+			if !sn.sendBufRowsNonblocking(br, i == 0) {
 				// Cannot send data to sn. Go to the next sn.
 				continue
 			}
 			// Successfully sent data to sn.
 			usedStorageNodes[sn] = true
+			if i == 0 {
+				// Release br.segments if sn doesn't report verdicts.
+				br.releaseSegments()
+			}
 			break
 		}
 	}
@@ -197,7 +310,7 @@ func (sn *storageNode) checkHealth() {
 		// The sn looks healthy.
 		return
 	}
-	bc, err := sn.dial()
+	bc, caps, err := sn.dial()
 	if err != nil {
 		if sn.lastDialErr == nil {
 			// Log the error only once.
@@ -209,10 +322,20 @@ func (sn *storageNode) checkHealth() {
 	logger.Infof("successfully dialed -storageNode=%q", sn.dialer.Addr())
 	sn.lastDialErr = nil
 	sn.bc = bc
+	sn.packetsSent = 0
+	reportsRejectedRows := uint32(0)
+	if caps&handshakeext.CapRejectedRows != 0 {
+		reportsRejectedRows = 1
+	}
+	atomic.StoreUint32(&sn.reportsRejectedRows, reportsRejectedRows)
 	atomic.StoreUint32(&sn.broken, 0)
 }
 
-func (sn *storageNode) sendBufRowsNonblocking(br *bufRows) bool {
+// sendBufRowsNonblocking sends br to sn.
+//
+// If trackVerdict is set and sn reports verdicts, then br.segments are moved to sn.pendingVerdicts
+// until the verdict for br is received from sn.
+func (sn *storageNode) sendBufRowsNonblocking(br *bufRows, trackVerdict bool) bool {
 	if sn.isBroken() {
 		return false
 	}
@@ -225,29 +348,122 @@ func (sn *storageNode) sendBufRowsNonblocking(br *bufRows) bool {
 		// sn.dial() should be called by sn.checkHealth() un unsuccessful call to sendBufToReplicasNonblocking().
 		return false
 	}
-	err := sendToConn(sn.bc, br.buf)
+	if len(br.buf) == 0 {
+		// Nothing to send.
+		return true
+	}
+	verdicts, err := sendToConn(sn.bc, br.buf, sn.verdictsBuf[:0])
+	sn.verdictsBuf = verdicts
 	if err == nil {
 		// Successfully sent buf to bc.
 		sn.rowsSent.Add(br.rows)
+		if atomic.LoadUint32(&sn.reportsRejectedRows) != 0 {
+			if trackVerdict && len(br.segments) > 0 {
+				sn.pendingVerdicts[sn.packetsSent] = append([]rowsSegment{}, br.segments...)
+				br.segments = br.segments[:0]
+				atomic.AddInt64(&sn.pendingVerdictsCount, 1)
+			}
+			sn.packetsSent++
+			sn.processVerdictsLocked(verdicts)
+		}
 		return true
 	}
 	// Couldn't flush buf to sn. Mark sn as broken.
 	logger.Warnf("cannot send %d bytes with %d rows to -storageNode=%q: %s; closing the connection to storageNode and "+
 		"re-routing this data to healthy storage nodes", len(br.buf), br.rows, sn.dialer.Addr(), err)
-	if err = sn.bc.Close(); err != nil {
+	sn.closeBrokenConnLocked()
+	return false
+}
+
+// pollVerdicts requests verdicts from sn for the sent packets, which wait for them.
+func (sn *storageNode) pollVerdicts() {
+	if !sn.hasPendingVerdicts() {
+		return
+	}
+	sn.bcLock.Lock()
+	defer sn.bcLock.Unlock()
+
+	if sn.bc == nil {
+		return
+	}
+	verdicts, err := sendToConn(sn.bc, nil, sn.verdictsBuf[:0])
+	sn.verdictsBuf = verdicts
+	if err != nil {
+		logger.Warnf("cannot poll verdicts from -storageNode=%q: %s; closing the connection to storageNode", sn.dialer.Addr(), err)
+		sn.closeBrokenConnLocked()
+		return
+	}
+	sn.processVerdictsLocked(verdicts)
+}
+
+func (sn *storageNode) hasPendingVerdicts() bool {
+	return atomic.LoadInt64(&sn.pendingVerdictsCount) > 0
+}
+
+// processVerdictsLocked resolves sn.pendingVerdicts with the verdicts received from sn.
+//
+// sn.bcLock must be locked by the caller.
+func (sn *storageNode) processVerdictsLocked(verdicts []byte) {
+	for len(verdicts) > 0 {
+		if len(verdicts) < 8 {
+			logger.Errorf("cannot unmarshal packet sequence number from %d bytes received from -storageNode=%q", len(verdicts), sn.dialer.Addr())
+			sn.releasePendingVerdictsLocked()
+			return
+		}
+		packetSeq := encoding.UnmarshalUint64(verdicts)
+		rejectedRows, tail, err := storage.UnmarshalRejectedRows(sn.rejectedRowsBuf[:0], verdicts[8:])
+		sn.rejectedRowsBuf = rejectedRows
+		if err != nil {
+			logger.Errorf("cannot unmarshal rejected rows received from -storageNode=%q: %s", sn.dialer.Addr(), err)
+			sn.releasePendingVerdictsLocked()
+			return
+		}
+		verdicts = tail
+		segments, ok := sn.pendingVerdicts[packetSeq]
+		if !ok {
+			// The packet wasn't tracked.
+			continue
+		}
+		delete(sn.pendingVerdicts, packetSeq)
+		atomic.AddInt64(&sn.pendingVerdictsCount, -1)
+		resolveSegments(segments, rejectedRows)
+	}
+}
+
+// releasePendingVerdictsLocked releases sn.pendingVerdicts, which won't receive verdicts from sn.
+//
+// sn.bcLock must be locked by the caller.
+func (sn *storageNode) releasePendingVerdictsLocked() {
+	for packetSeq, segments := range sn.pendingVerdicts {
+		resolveSegments(segments, nil)
+		delete(sn.pendingVerdicts, packetSeq)
+	}
+	atomic.StoreInt64(&sn.pendingVerdictsCount, 0)
+}
+
+// closeBrokenConnLocked closes sn.bc and marks sn as broken.
+//
+// sn.bcLock must be locked by the caller.
+func (sn *storageNode) closeBrokenConnLocked() {
+	if err := sn.bc.Close(); err != nil {
 		logger.Warnf("cannot close connection to storageNode %q: %s", sn.dialer.Addr(), err)
 	}
 	sn.bc = nil
+	// The rows from the packets sent over the closed connection are stored by vmstorage,
+	// but their verdicts are lost.
+	sn.releasePendingVerdictsLocked()
 	atomic.StoreUint32(&sn.broken, 1)
 	sn.connectionErrors.Inc()
-	return false
 }
 
-func sendToConn(bc *handshake.BufferedConn, buf []byte) error {
-	if len(buf) == 0 {
-		// Nothing to send
-		return nil
-	}
+// verdictsPollInterval is the interval for polling verdicts for the sent packets from vmstorage nodes.
+const verdictsPollInterval = 10 * time.Millisecond
+
+// sendToConn sends buf to bc and waits for `ack`.
+//
+// Empty buf is sent for polling verdicts from vmstorage if handshakeext.CapRejectedRows is negotiated.
+// It appends the verdicts received in `ack` to verdicts and returns the result.
+func sendToConn(bc *handshake.BufferedConn, buf []byte, verdicts []byte) ([]byte, error) {
 	timeoutSeconds := len(buf) / 3e5
 	if timeoutSeconds < 60 {
 		timeoutSeconds = 60
@@ -255,7 +471,7 @@ func sendToConn(bc *handshake.BufferedConn, buf []byte) error {
 	timeout := time.Duration(timeoutSeconds) * time.Second
 	deadline := time.Now().Add(timeout)
 	if err := bc.SetWriteDeadline(deadline); err != nil {
-		return fmt.Errorf("cannot set write deadline to %s: %w", deadline, err)
+		return verdicts, fmt.Errorf("cannot set write deadline to %s: %w", deadline, err)
 	}
 	// sizeBuf guarantees that the rows batch will be either fully
 	// read or fully discarded on the vmstorage side.
@@ -264,49 +480,76 @@ func sendToConn(bc *handshake.BufferedConn, buf []byte) error {
 	defer sizeBufPool.Put(sizeBuf)
 	sizeBuf.B = encoding.MarshalUint64(sizeBuf.B[:0], uint64(len(buf)))
 	if _, err := bc.Write(sizeBuf.B); err != nil {
-		return fmt.Errorf("cannot write data size %d: %w", len(buf), err)
+		return verdicts, fmt.Errorf("cannot write data size %d: %w", len(buf), err)
 	}
 	if _, err := bc.Write(buf); err != nil {
-		return fmt.Errorf("cannot write data with size %d: %w", len(buf), err)
+		return verdicts, fmt.Errorf("cannot write data with size %d: %w", len(buf), err)
 	}
 	if err := bc.Flush(); err != nil {
-		return fmt.Errorf("cannot flush data with size %d: %w", len(buf), err)
+		return verdicts, fmt.Errorf("cannot flush data with size %d: %w", len(buf), err)
 	}
 
 	// Wait for `ack` from vmstorage.
 	// This guarantees that the message has been fully received by vmstorage.
 	deadline = time.Now().Add(timeout)
 	if err := bc.SetReadDeadline(deadline); err != nil {
-		return fmt.Errorf("cannot set read deadline for reading `ack` to vmstorage: %w", err)
+		return verdicts, fmt.Errorf("cannot set read deadline for reading `ack` to vmstorage: %w", err)
 	}
 	if _, err := io.ReadFull(bc, sizeBuf.B[:1]); err != nil {
-		return fmt.Errorf("cannot read `ack` from vmstorage: %w", err)
-	}
-	if sizeBuf.B[0] != 1 {
-		return fmt.Errorf("unexpected `ack` received from vmstorage; got %d; want %d", sizeBuf.B[0], 1)
+		return verdicts, fmt.Errorf("cannot read `ack` from vmstorage: %w", err)
+	}
+	switch sizeBuf.B[0] {
+	case 1:
+		return verdicts, nil
+	case 2:
+		// The `ack` contains verdicts for the previously sent packets.
+		// vmstorage sends such `ack` only if handshakeext.CapRejectedRows is negotiated.
+		verdicts, err := readVerdicts(bc, sizeBuf, verdicts)
+		if err != nil {
+			return verdicts, fmt.Errorf("cannot read verdicts from vmstorage: %w", err)
+		}
+		return verdicts, nil
+	default:
+		return verdicts, fmt.Errorf("unexpected `ack` received from vmstorage; got %d; want 1 or 2", sizeBuf.B[0])
 	}
-	return nil
 }
 
 var sizeBufPool bytesutil.ByteBufferPool
 
-func (sn *storageNode) dial() (*handshake.BufferedConn, error) {
+func readVerdicts(bc *handshake.BufferedConn, bb *bytesutil.ByteBuffer, dst []byte) ([]byte, error) {
+	bb.B = bytesutil.Resize(bb.B, 8)
+	if _, err := io.ReadFull(bc, bb.B); err != nil {
+		return dst, fmt.Errorf("cannot read the size of verdicts: %w", err)
+	}
+	size := encoding.UnmarshalUint64(bb.B)
+	if size > consts.MaxInsertPacketSize {
+		return dst, fmt.Errorf("too big size of verdicts: %d bytes; mustn't exceed %d bytes", size, consts.MaxInsertPacketSize)
+	}
+	dstLen := len(dst)
+	dst = bytesutil.Resize(dst, dstLen+int(size))
+	if _, err := io.ReadFull(bc, dst[dstLen:]); err != nil {
+		return dst[:dstLen], fmt.Errorf("cannot read verdicts with size %d: %w", size, err)
+	}
+	return dst, nil
+}
+
+func (sn *storageNode) dial() (*handshake.BufferedConn, uint64, error) {
 	c, err := sn.dialer.Dial()
 	if err != nil {
 		sn.dialErrors.Inc()
-		return nil, err
+		return nil, 0, err
 	}
 	compressionLevel := 1
 	if *disableRPCCompression {
 		compressionLevel = 0
 	}
-	bc, err := handshake.VMInsertClient(c, compressionLevel)
+	bc, caps, err := handshakeext.VMInsertClient(c, compressionLevel, handshakeext.CapRejectedRows)
 	if err != nil {
 		_ = c.Close()
 		sn.handshakeErrors.Inc()
-		return nil, fmt.Errorf("handshake error: %w", err)
+		return nil, 0, fmt.Errorf("handshake error: %w", err)
 	}
-	return bc, nil
+	return bc, caps, nil
 }
 
 func rerouteWorker(stopCh <-chan struct{}) {
@@ -360,6 +603,17 @@ type storageNode struct {
 	// In this case the data is re-routed to the remaining healthy vmstorage nodes.
 	broken uint32
 
+	// reportsRejectedRows is set to non-zero if the given vmstorage node reports verdicts with rows rejected from every packet.
+	// See handshakeext.CapRejectedRows.
+	reportsRejectedRows uint32
+
+	// retrying is set to non-zero while sn re-tries sending the data to vmstorage nodes.
+	// The rows pushed to sn don't wait for verdicts in this case.
+	retrying uint32
+
+	// pendingVerdictsCount is the number of items in pendingVerdicts.
+	pendingVerdictsCount int64
+
 	// brLock protects br.
 	brLock sync.Mutex
 
@@ -374,6 +628,19 @@ type storageNode struct {
 	// It must be accessed under bcLock.
 	bc *handshake.BufferedConn
 
+	// packetsSent is the number of packets sent over bc. It is used as the sequence number for the next packet.
+	// It must be accessed under bcLock.
+	packetsSent uint64
+
+	// pendingVerdicts contains the segments of rows from the packets sent over bc,
+	// which wait for verdicts, by packet sequence number.
+	// It must be accessed under bcLock.
+	pendingVerdicts map[uint64][]rowsSegment
+
+	// Buffers for reading verdicts. They must be accessed under bcLock.
+	verdictsBuf     []byte
+	rejectedRowsBuf []storage.RejectedRow
+
 	dialer *netutil.TCPDialer
 
 	// last error during dial.
@@ -401,6 +668,20 @@ type storageNode struct {
 	// The number of rows rerouted to the given vmstorage node
 	// from other nodes when they were unhealthy.
 	rowsReroutedToHere *metrics.Counter
+
+	// q is an optional on-disk buffer for the data, which cannot be sent to vmstorage nodes.
+	// It is nil if -bufferDataPath isn't set.
+	q *diskqueue.Queue
+
+	// qBuf is a buffer for reading blocks from q.
+	// It must be accessed only by sn.run goroutine.
+	qBuf []byte
+
+	// The number of rows buffered in q.
+	rowsBuffered *metrics.Counter
+
+	// The number of rows sent from q to vmstorage nodes.
+	rowsReplayed *metrics.Counter
 }
 
 // storageNodes contains a list of vmstorage node clients.
@@ -428,7 +709,8 @@ func InitStorageNodes(addrs []string) {
 	storageNodes = storageNodes[:0]
 	for _, addr := range addrs {
 		sn := &storageNode{
-			dialer: netutil.NewTCPDialer("vminsert", addr),
+			dialer:          netutil.NewTCPDialer("vminsert", addr),
+			pendingVerdicts: make(map[uint64][]rowsSegment),
 
 			dialErrors:           metrics.NewCounter(fmt.Sprintf(`vm_rpc_dial_errors_total{name="vminsert", addr=%q}`, addr)),
 			handshakeErrors:      metrics.NewCounter(fmt.Sprintf(`vm_rpc_handshake_errors_total{name="vminsert", addr=%q}`, addr)),
@@ -438,6 +720,9 @@ func InitStorageNodes(addrs []string) {
 			rowsReroutedFromHere: metrics.NewCounter(fmt.Sprintf(`vm_rpc_rows_rerouted_from_here_total{name="vminsert", addr=%q}`, addr)),
 			rowsReroutedToHere:   metrics.NewCounter(fmt.Sprintf(`vm_rpc_rows_rerouted_to_here_total{name="vminsert", addr=%q}`, addr)),
 		}
+		if *bufferDataPath != "" {
+			sn.mustOpenQueue(addr)
+		}
 		_ = metrics.NewGauge(fmt.Sprintf(`vm_rpc_rows_pending{name="vminsert", addr=%q}`, addr), func() float64 {
 			sn.brLock.Lock()
 			n := sn.br.rows
@@ -480,6 +765,24 @@ func InitStorageNodes(addrs []string) {
 	}()
 }
 
+func (sn *storageNode) mustOpenQueue(addr string) {
+	path := filepath.Join(*bufferDataPath, unsupportedPathChars.ReplaceAllString(addr, "_"))
+	sn.q = diskqueue.MustOpen(path, bufferMaxDiskUsagePerNode.N)
+	sn.rowsBuffered = metrics.NewCounter(fmt.Sprintf(`vm_rpc_rows_buffered_on_disk_total{name="vminsert", addr=%q}`, addr))
+	sn.rowsReplayed = metrics.NewCounter(fmt.Sprintf(`vm_rpc_rows_replayed_from_disk_total{name="vminsert", addr=%q}`, addr))
+	_ = metrics.NewGauge(fmt.Sprintf(`vm_rpc_disk_buf_pending_bytes{name="vminsert", addr=%q}`, addr), func() float64 {
+		return float64(sn.q.GetPendingBytes())
+	})
+	_ = metrics.NewGauge(fmt.Sprintf(`vm_rpc_disk_buf_dropped_bytes_total{name="vminsert", addr=%q}`, addr), func() float64 {
+		return float64(sn.q.GetDroppedBytes())
+	})
+	if n := sn.q.GetPendingBytes(); n > 0 {
+		logger.Infof("found %d bytes of buffered data for -storageNode=%q at %q; sending it to vmstorage nodes", n, addr, path)
+	}
+}
+
+var unsupportedPathChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
 // Stop gracefully stops netstorage.
 func Stop() {
 	close(rerouteWorkerStopCh)
@@ -487,6 +790,12 @@ func Stop() {
 
 	close(storageNodesStopCh)
 	storageNodesWG.Wait()
+
+	for _, sn := range storageNodes {
+		if sn.q != nil {
+			sn.q.MustClose()
+		}
+	}
 }
 
 // addToReroutedBufMayBlock adds buf to reroutedBR.
@@ -0,0 +1,167 @@
+package netstorage
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/tenantlimits"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/handshakeext"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+)
+
+func TestFlushBufsRejectedByStorage(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start fake vmstorage: %s", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	go runFakeVMStorage(ln)
+
+	tenantlimits.Init()
+	InitStorageNodes([]string{ln.Addr().String()})
+	defer Stop()
+
+	// Wait until the vmstorage node is connected, since rows pushed before that aren't tracked for verdicts.
+	sn := storageNodes[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint32(&sn.reportsRejectedRows) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when connecting to fake vmstorage")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	at1 := &auth.Token{AccountID: 1, ProjectID: 2}
+	at2 := &auth.Token{AccountID: 3, ProjectID: 4}
+	rejected1 := rowsRejectedByStorage.Get(at1).Get()
+	rejected2 := rowsRejectedByStorage.Get(at2).Get()
+
+	var ctx InsertCtx
+	ctx.Reset()
+	timestamp := int64(fasttime.UnixTimestamp()) * 1e9
+	for i, r := range []struct {
+		at   *auth.Token
+		line string
+	}{
+		{at1, "accepted"},
+		{at1, "reject"},
+		{at2, "reject"},
+		{at2, "accepted"},
+		{at2, "reject"},
+	} {
+		metricNameRaw := storage.MarshalMetricNameRaw(nil, r.at.AccountID, r.at.ProjectID, []storage.Label{{
+			Name:  []byte("job"),
+			Value: []byte("foo"),
+		}})
+		ctx.SetRowID(i)
+		if err := ctx.WriteDataPointExt(r.at, 0, metricNameRaw, timestamp, []byte(r.line)); err != nil {
+			t.Fatalf("unexpected error when writing %q: %s", r.line, err)
+		}
+	}
+	err = ctx.FlushBufs()
+	if !IsRejectedRowsError(err) {
+		t.Fatalf("expecting rejected rows error; got %v", err)
+	}
+	if !strings.Contains(err.Error(), "rejected 3 log lines: 3 lines: new streams exceeding the limit") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var rejectedIDs []int
+	for _, rr := range ctx.GetRejectedRows() {
+		if rr.Reason != rejectReasonTooManyStreams {
+			t.Fatalf("unexpected reason for rejected row %d; got %q; want %q", rr.ID, rr.Reason, rejectReasonTooManyStreams)
+		}
+		rejectedIDs = append(rejectedIDs, rr.ID)
+	}
+	sort.Ints(rejectedIDs)
+	if !reflect.DeepEqual(rejectedIDs, []int{1, 2, 4}) {
+		t.Fatalf("unexpected ids of rejected rows; got %d; want [1 2 4]", rejectedIDs)
+	}
+
+	// The rejected rows must be attributed to their tenants.
+	if n := rowsRejectedByStorage.Get(at1).Get() - rejected1; n != 1 {
+		t.Fatalf("unexpected number of rows rejected for tenant %d:%d; got %d; want 1", at1.AccountID, at1.ProjectID, n)
+	}
+	if n := rowsRejectedByStorage.Get(at2).Get() - rejected2; n != 2 {
+		t.Fatalf("unexpected number of rows rejected for tenant %d:%d; got %d; want 2", at2.AccountID, at2.ProjectID, n)
+	}
+}
+
+// runFakeVMStorage accepts vminsert connections at ln and rejects rows with "reject" value.
+//
+// The verdict for every packet is sent in the `ack` for the next packet or in the response to verdicts polling,
+// like vmstorage does for packets added to the storage asynchronously.
+func runFakeVMStorage(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() {
+				_ = c.Close()
+			}()
+			bc, _, err := handshakeext.VMInsertServer(c, 0, handshakeext.CapRejectedRows)
+			if err != nil {
+				return
+			}
+			var verdicts []byte
+			packetSeq := uint64(0)
+			sizeBuf := make([]byte, 8)
+			for {
+				if _, err := io.ReadFull(bc, sizeBuf); err != nil {
+					return
+				}
+				buf := make([]byte, encoding.UnmarshalUint64(sizeBuf))
+				if _, err := io.ReadFull(bc, buf); err != nil {
+					return
+				}
+				ack := []byte{1}
+				if len(verdicts) > 0 {
+					ack = append([]byte{2}, encoding.MarshalUint64(nil, uint64(len(verdicts)))...)
+					ack = append(ack, verdicts...)
+					verdicts = verdicts[:0]
+				}
+				if _, err := bc.Write(ack); err != nil {
+					return
+				}
+				if err := bc.Flush(); err != nil {
+					return
+				}
+				if len(buf) == 0 {
+					// Verdicts polling.
+					continue
+				}
+				var rejectedRows []storage.RejectedRow
+				for idx := 0; len(buf) > 0; idx++ {
+					var mr storage.MetricRow
+					buf, err = mr.Unmarshal(buf)
+					if err != nil {
+						return
+					}
+					if string(mr.Value) == "reject" {
+						rejectedRows = append(rejectedRows, storage.RejectedRow{
+							Idx:       idx,
+							AccountID: encoding.UnmarshalUint32(mr.MetricNameRaw),
+							ProjectID: encoding.UnmarshalUint32(mr.MetricNameRaw[4:]),
+						})
+					}
+				}
+				verdicts = encoding.MarshalUint64(verdicts, packetSeq)
+				verdicts = storage.MarshalRejectedRows(verdicts, rejectedRows)
+				packetSeq++
+			}
+		}()
+	}
+}
@@ -0,0 +1,234 @@
+package netstorage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/timerpool"
+)
+
+// Reasons for rejecting rows.
+const (
+	RejectReasonTooOld        = "too_old"
+	RejectReasonTooNew        = "too_new"
+	RejectReasonTooLong       = "too_long"
+	RejectReasonInvalidLabels = "invalid_labels"
+	RejectReasonNoLabels      = "no_labels"
+	RejectReasonTooManyLabels = "too_many_labels"
+
+	// rejectReasonTooManyStreams is used for rows rejected by vmstorage. See storageRejections.
+	rejectReasonTooManyStreams = "too_many_streams"
+)
+
+var rowsRejected = map[string]*tenantmetrics.CounterMap{
+	RejectReasonTooOld:        tenantmetrics.NewCounterMap(`vm_rows_rejected_total{reason="too_old"}`),
+	RejectReasonTooNew:        tenantmetrics.NewCounterMap(`vm_rows_rejected_total{reason="too_new"}`),
+	RejectReasonTooLong:       tenantmetrics.NewCounterMap(`vm_rows_rejected_total{reason="too_long"}`),
+	RejectReasonInvalidLabels: tenantmetrics.NewCounterMap(`vm_rows_rejected_total{reason="invalid_labels"}`),
+	RejectReasonNoLabels:      tenantmetrics.NewCounterMap(`vm_rows_rejected_total{reason="no_labels"}`),
+	RejectReasonTooManyLabels: tenantmetrics.NewCounterMap(`vm_rows_rejected_total{reason="too_many_labels"}`),
+}
+
+var rowsRejectedByStorage = tenantmetrics.NewCounterMap(`vm_rows_rejected_by_storage_total{reason="too_many_streams"}`)
+
+// rejectedRowsEntry contains the number of rows rejected for the given reason with the given description.
+type rejectedRowsEntry struct {
+	reason string
+	msg    string
+	rows   int
+}
+
+// maxRejectedRowsEntries is the maximum number of distinct entries reported in the response for rejected rows.
+const maxRejectedRowsEntries = 100
+
+// RejectRows registers the given number of rows rejected for at because of the given reason with the given description.
+//
+// reason must be one of RejectReason* constants. The rejected rows are reported by FlushBufs.
+func (ctx *InsertCtx) RejectRows(at *auth.Token, reason, msg string, rows int) {
+	rowsRejected[reason].Get(at).Add(rows)
+	ctx.addRejectedRows(reason, msg, rows)
+	if ctx.trackRowIDs {
+		ctx.rejectedRowsByID = append(ctx.rejectedRowsByID, RejectedRow{
+			ID:     ctx.rowID,
+			Reason: reason,
+			Msg:    msg,
+		})
+	}
+}
+
+// RejectedRow describes a row rejected in InsertCtx or by vmstorage nodes.
+type RejectedRow struct {
+	// ID is the id of the row set via InsertCtx.SetRowID.
+	ID int
+
+	// Reason is the reason for rejecting the row. See RejectReason* constants.
+	Reason string
+
+	// Msg describes the reason.
+	Msg string
+}
+
+// SetRowID sets the id for the rows written to ctx until the next SetRowID call.
+//
+// ctx tracks row ids after SetRowID call until Reset, so the rejected rows may be obtained
+// with their ids via GetRejectedRows after FlushBufs.
+func (ctx *InsertCtx) SetRowID(id int) {
+	ctx.trackRowIDs = true
+	ctx.rowID = id
+}
+
+// GetRejectedRows returns the rows with ids set via SetRowID, which were rejected in ctx or by vmstorage nodes.
+//
+// The rows rejected by vmstorage nodes are returned only after FlushBufs call.
+// The returned rows are valid until ctx.Reset call.
+func (ctx *InsertCtx) GetRejectedRows() []RejectedRow {
+	return ctx.rejectedRowsByID
+}
+
+func (ctx *InsertCtx) addRejectedRows(reason, msg string, rows int) {
+	ctx.rejectedRowsTotal += rows
+	for i := range ctx.rejectedRows {
+		rr := &ctx.rejectedRows[i]
+		if rr.reason == reason && rr.msg == msg {
+			rr.rows += rows
+			return
+		}
+	}
+	if len(ctx.rejectedRows) >= maxRejectedRowsEntries {
+		return
+	}
+	ctx.rejectedRows = append(ctx.rejectedRows, rejectedRowsEntry{
+		reason: reason,
+		msg:    msg,
+		rows:   rows,
+	})
+}
+
+// storageRejections collects the rows rejected by vmstorage nodes from the rows pushed by InsertCtx.
+//
+// vmstorage nodes report verdicts with the rows rejected from every packet if handshakeext.CapRejectedRows is negotiated.
+// The pushed rows are tracked via bufRows.segments and then via storageNode.pendingVerdicts until the verdict
+// for the packet with these rows is received.
+type storageRejections struct {
+	mu sync.Mutex
+
+	// pending is the number of tracked segments without verdict plus one for InsertCtx, which waits for verdicts.
+	pending int
+
+	// rejected contains the number of rejected rows per tenant.
+	rejected []storage.RejectedRows
+
+	// rejectedIDs contains ids of the rejected rows if InsertCtx tracks row ids. See InsertCtx.SetRowID.
+	rejectedIDs []int
+
+	// doneCh is closed when pending drops to zero.
+	doneCh chan struct{}
+}
+
+func newStorageRejections() *storageRejections {
+	return &storageRejections{
+		pending: 1,
+		doneCh:  make(chan struct{}),
+	}
+}
+
+// add registers a segment of rows waiting for the verdict from vmstorage.
+func (sr *storageRejections) add() {
+	sr.mu.Lock()
+	sr.pending++
+	sr.mu.Unlock()
+}
+
+// done registers the verdict with the given rejected rows for the segment registered via add.
+//
+// rejectedIDs must contain ids of the rejected rows if the segment tracks row ids.
+func (sr *storageRejections) done(rejectedRows []storage.RejectedRow, rejectedIDs []int) {
+	sr.mu.Lock()
+	for _, row := range rejectedRows {
+		sr.addRejectedRow(row.AccountID, row.ProjectID)
+	}
+	sr.rejectedIDs = append(sr.rejectedIDs, rejectedIDs...)
+	sr.pending--
+	if sr.pending == 0 {
+		close(sr.doneCh)
+	}
+	sr.mu.Unlock()
+}
+
+func (sr *storageRejections) addRejectedRow(accountID, projectID uint32) {
+	for i := range sr.rejected {
+		rr := &sr.rejected[i]
+		if rr.AccountID == accountID && rr.ProjectID == projectID {
+			rr.Rows++
+			return
+		}
+	}
+	sr.rejected = append(sr.rejected, storage.RejectedRows{
+		AccountID: accountID,
+		ProjectID: projectID,
+		Rows:      1,
+	})
+}
+
+// wait waits until verdicts for all the registered segments are received and returns the number of rejected rows per tenant
+// together with ids of the rejected rows.
+//
+// It returns the rows rejected so far if the verdicts aren't received during the given timeout.
+func (sr *storageRejections) wait(timeout time.Duration) ([]storage.RejectedRows, []int) {
+	sr.done(nil, nil)
+	t := timerpool.Get(timeout)
+	select {
+	case <-sr.doneCh:
+	case <-t.C:
+	}
+	timerpool.Put(t)
+	sr.mu.Lock()
+	rejected := append([]storage.RejectedRows{}, sr.rejected...)
+	rejectedIDs := append([]int{}, sr.rejectedIDs...)
+	sr.mu.Unlock()
+	return rejected, rejectedIDs
+}
+
+// maxStorageRejectionsWait is the maximum duration InsertCtx.FlushBufs waits for the rows rejected by vmstorage nodes.
+//
+// The rows, which cannot be sent to vmstorage nodes immediately, are released without waiting for verdicts,
+// so this timeout is hit only if vmstorage nodes are too slow in adding the received rows to the storage.
+const maxStorageRejectionsWait = 10 * time.Second
+
+// getRejectedRowsError returns an error with http.StatusBadRequest status code describing the rows rejected in ctx.
+//
+// nil is returned if there are no rejected rows.
+func (ctx *InsertCtx) getRejectedRowsError() error {
+	if ctx.rejectedRowsTotal == 0 {
+		return nil
+	}
+	a := make([]string, 0, len(ctx.rejectedRows)+1)
+	n := 0
+	for _, rr := range ctx.rejectedRows {
+		a = append(a, fmt.Sprintf("%d lines: %s (reason=%s)", rr.rows, rr.msg, rr.reason))
+		n += rr.rows
+	}
+	if n < ctx.rejectedRowsTotal {
+		a = append(a, fmt.Sprintf("%d more lines", ctx.rejectedRowsTotal-n))
+	}
+	return &httpserver.ErrorWithStatusCode{
+		Err:        fmt.Errorf("rejected %d log lines: %s", ctx.rejectedRowsTotal, strings.Join(a, "; ")),
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// IsRejectedRowsError returns true if err is returned from InsertCtx.FlushBufs because of rejected rows.
+//
+// The remaining rows are accepted in this case, so they mustn't be sent again.
+func IsRejectedRowsError(err error) bool {
+	var esc *httpserver.ErrorWithStatusCode
+	return errors.As(err, &esc) && esc.StatusCode == http.StatusBadRequest
+}
@@ -0,0 +1,104 @@
+package netstorage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestResolveSegments(t *testing.T) {
+	sr1 := newStorageRejections()
+	sr2 := newStorageRejections()
+	var br bufRows
+
+	// Rows are pushed by two requests, while the rows at 3..4 aren't tracked, e.g. re-routed rows.
+	br.addSegment(3, nil, sr1)
+	br.rows += 3
+	br.rows += 2
+	br.addSegment(4, nil, sr2)
+	br.rows += 4
+	br.addSegment(1, nil, sr1)
+	br.rows++
+
+	rejectedRows := []storage.RejectedRow{
+		{Idx: 0, AccountID: 1},
+		{Idx: 2, AccountID: 2},
+		{Idx: 3, AccountID: 1},
+		{Idx: 4, AccountID: 1},
+		{Idx: 6, AccountID: 2, ProjectID: 3},
+		{Idx: 9, AccountID: 1},
+	}
+	resolveSegments(br.segments, rejectedRows)
+	rejectedExpected := []storage.RejectedRows{
+		{AccountID: 1, Rows: 2},
+		{AccountID: 2, Rows: 1},
+	}
+	if rejected, _ := sr1.wait(time.Second); !reflect.DeepEqual(rejected, rejectedExpected) {
+		t.Fatalf("unexpected rejected rows for the first request;\ngot\n%+v\nwant\n%+v", rejected, rejectedExpected)
+	}
+	rejectedExpected = []storage.RejectedRows{
+		{AccountID: 2, ProjectID: 3, Rows: 1},
+	}
+	if rejected, _ := sr2.wait(time.Second); !reflect.DeepEqual(rejected, rejectedExpected) {
+		t.Fatalf("unexpected rejected rows for the second request;\ngot\n%+v\nwant\n%+v", rejected, rejectedExpected)
+	}
+}
+
+func TestResolveSegmentsRowIDs(t *testing.T) {
+	sr := newStorageRejections()
+	var br bufRows
+	rowIDs := []int{10, 11, 12}
+	br.addSegment(3, rowIDs, sr)
+	br.rows += 3
+	br.addSegment(2, []int{20, 21}, sr)
+	br.rows += 2
+
+	// The ids must be copied by addSegment.
+	rowIDs[0] = 0
+
+	resolveSegments(br.segments, []storage.RejectedRow{
+		{Idx: 0, AccountID: 1},
+		{Idx: 2, AccountID: 1},
+		{Idx: 4, AccountID: 1},
+	})
+	_, rejectedIDs := sr.wait(time.Second)
+	if !reflect.DeepEqual(rejectedIDs, []int{10, 12, 21}) {
+		t.Fatalf("unexpected ids of rejected rows; got %d; want [10 12 21]", rejectedIDs)
+	}
+}
+
+func TestBufRowsResetWithoutVerdict(t *testing.T) {
+	sr := newStorageRejections()
+	var br bufRows
+	br.addSegment(2, nil, sr)
+	br.rows += 2
+
+	// Rows buffered on disk are reported without rejections.
+	br.reset()
+	if len(br.segments) != 0 {
+		t.Fatalf("unexpected segments left after reset: %d", len(br.segments))
+	}
+	select {
+	case <-sr.doneCh:
+		t.Fatalf("storageRejections mustn't be done before wait call")
+	default:
+	}
+	if rejected, _ := sr.wait(time.Second); len(rejected) != 0 {
+		t.Fatalf("unexpected rejected rows; got %+v; want nothing", rejected)
+	}
+}
+
+func TestStorageRejectionsWaitTimeout(t *testing.T) {
+	sr := newStorageRejections()
+	sr.add()
+	sr.add()
+	sr.done([]storage.RejectedRow{{Idx: 1, AccountID: 5}}, nil)
+	rejectedExpected := []storage.RejectedRows{
+		{AccountID: 5, Rows: 1},
+	}
+	if rejected, _ := sr.wait(10 * time.Millisecond); !reflect.DeepEqual(rejected, rejectedExpected) {
+		t.Fatalf("unexpected rejected rows;\ngot\n%+v\nwant\n%+v", rejected, rejectedExpected)
+	}
+}
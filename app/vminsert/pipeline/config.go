@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// stageConfig is a single stage in -pipelineConfig file.
+type stageConfig struct {
+	// Type is the extractor type: regex, logfmt or json.
+	Type string `yaml:"type"`
+
+	// Regex is the regular expression for regex extractor. Named capture groups become fields.
+	Regex string `yaml:"regex,omitempty"`
+
+	// Labels maps label names to field names, which must be extracted into these labels.
+	// Nested json fields may be referred via dots, e.g. `kubernetes.pod_name`.
+	// All the named capture groups except of LineField become labels for regex extractor if Labels is empty.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// LineField is the name of the field, which must replace the log line.
+	LineField string `yaml:"line_field,omitempty"`
+
+	// Replacement replaces all the Regex matches in the log line. It may refer to capture groups via $1 or ${name}.
+	Replacement *string `yaml:"replacement,omitempty"`
+
+	// Drop instructs dropping log lines matching the stage.
+	Drop bool `yaml:"drop,omitempty"`
+}
+
+type pipeline struct {
+	stages []*stage
+}
+
+// stage is parsed stageConfig.
+type stage struct {
+	typ         string
+	re          *regexp.Regexp
+	labels      []labelExtractor
+	lineField   string
+	replacement []byte
+	drop        bool
+
+	// jsonPaths contains the paths to fields for json extractor.
+	jsonPaths []jsonPath
+}
+
+type labelExtractor struct {
+	name  []byte
+	field string
+}
+
+type jsonPath struct {
+	field string
+	keys  []string
+}
+
+func parsePipeline(data []byte) (*pipeline, error) {
+	var scs []stageConfig
+	if err := yaml.UnmarshalStrict(data, &scs); err != nil {
+		return nil, err
+	}
+	stages := make([]*stage, len(scs))
+	for i := range scs {
+		st, err := newStage(&scs[i])
+		if err != nil {
+			return nil, fmt.Errorf("error in stage #%d: %w", i+1, err)
+		}
+		stages[i] = st
+	}
+	return &pipeline{
+		stages: stages,
+	}, nil
+}
+
+func newStage(sc *stageConfig) (*stage, error) {
+	st := &stage{
+		typ:       sc.Type,
+		lineField: sc.LineField,
+		drop:      sc.Drop,
+	}
+	switch sc.Type {
+	case "regex":
+		if sc.Regex == "" {
+			return nil, fmt.Errorf("missing `regex` for regex extractor")
+		}
+		re, err := regexp.Compile(sc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `regex` %q: %w", sc.Regex, err)
+		}
+		st.re = re
+	case "logfmt", "json":
+		if sc.Regex != "" {
+			return nil, fmt.Errorf("`regex` cannot be set for %s extractor", sc.Type)
+		}
+		if sc.Replacement != nil {
+			return nil, fmt.Errorf("`replacement` cannot be set for %s extractor", sc.Type)
+		}
+	case "":
+		return nil, fmt.Errorf("missing `type`; supported values: regex, logfmt, json")
+	default:
+		return nil, fmt.Errorf("unsupported `type`: %q; supported values: regex, logfmt, json", sc.Type)
+	}
+	if sc.Drop {
+		if len(sc.Labels) > 0 || sc.LineField != "" || sc.Replacement != nil {
+			return nil, fmt.Errorf("`labels`, `line_field` and `replacement` cannot be set together with `drop`")
+		}
+		return st, nil
+	}
+	if sc.LineField != "" && sc.Replacement != nil {
+		return nil, fmt.Errorf("`line_field` and `replacement` cannot be set simultaneously")
+	}
+	if sc.Replacement != nil {
+		st.replacement = []byte(*sc.Replacement)
+	}
+
+	labels := sc.Labels
+	if len(labels) == 0 && st.re != nil {
+		labels = make(map[string]string)
+		for _, name := range st.re.SubexpNames() {
+			if name != "" && name != sc.LineField {
+				labels[name] = name
+			}
+		}
+	}
+	for name, fieldName := range labels {
+		if !isValidLabelName(name) {
+			return nil, fmt.Errorf("invalid label name %q; it must match [a-zA-Z_][a-zA-Z0-9_]*", name)
+		}
+		if fieldName == "" {
+			return nil, fmt.Errorf("missing field name for label %q", name)
+		}
+		st.labels = append(st.labels, labelExtractor{
+			name:  []byte(name),
+			field: fieldName,
+		})
+	}
+	// Sort labels in order to get deterministic results.
+	sort.Slice(st.labels, func(i, j int) bool {
+		return string(st.labels[i].name) < string(st.labels[j].name)
+	})
+	if len(st.labels) == 0 && st.lineField == "" && st.replacement == nil {
+		return nil, fmt.Errorf("at least one of `labels`, `line_field`, `replacement` or `drop` must be set")
+	}
+
+	if st.re != nil {
+		for _, le := range st.labels {
+			if !hasSubexp(st.re, le.field) {
+				return nil, fmt.Errorf("`regex` %q has no named capture group %q for label %q", sc.Regex, le.field, le.name)
+			}
+		}
+		if st.lineField != "" && !hasSubexp(st.re, st.lineField) {
+			return nil, fmt.Errorf("`regex` %q has no named capture group %q for `line_field`", sc.Regex, st.lineField)
+		}
+	}
+	if st.typ == "json" {
+		for _, le := range st.labels {
+			st.addJSONPath(le.field)
+		}
+		if st.lineField != "" {
+			st.addJSONPath(st.lineField)
+		}
+	}
+	return st, nil
+}
+
+func (st *stage) addJSONPath(field string) {
+	for _, path := range st.jsonPaths {
+		if path.field == field {
+			return
+		}
+	}
+	st.jsonPaths = append(st.jsonPaths, jsonPath{
+		field: field,
+		keys:  strings.Split(field, "."),
+	})
+}
+
+func hasSubexp(re *regexp.Regexp, name string) bool {
+	for _, s := range re.SubexpNames() {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidLabelName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"strconv"
+	"strings"
+)
+
+// visitLogfmtFields calls f for each key=value pair in logfmt line s.
+//
+// Unquoted values are appended to dst before passing them to f. The value passed to f is valid
+// until dst is modified by the caller. The updated dst is returned.
+// false is returned if s doesn't contain key=value pairs or if s contains invalid quoted values.
+func visitLogfmtFields(dst []byte, s string, f func(key string, value []byte)) ([]byte, bool) {
+	n := 0
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if len(s) == 0 {
+			return dst, n > 0
+		}
+		i := strings.IndexAny(s, "= \t")
+		if i < 0 {
+			// Skip the trailing key without value.
+			return dst, n > 0
+		}
+		if s[i] != '=' {
+			// Skip key without value.
+			s = s[i:]
+			continue
+		}
+		key := s[:i]
+		s = s[i+1:]
+		bufLen := len(dst)
+		if strings.HasPrefix(s, `"`) {
+			j := indexClosingQuote(s)
+			if j < 0 {
+				return dst, false
+			}
+			value, err := strconv.Unquote(s[:j+1])
+			if err != nil {
+				return dst, false
+			}
+			dst = append(dst, value...)
+			s = s[j+1:]
+		} else {
+			j := strings.IndexAny(s, " \t")
+			if j < 0 {
+				j = len(s)
+			}
+			dst = append(dst, s[:j]...)
+			s = s[j:]
+		}
+		if len(key) > 0 {
+			f(key, dst[bufLen:])
+			n++
+		}
+	}
+}
+
+// indexClosingQuote returns the index of the closing quote in s, which starts with a quote.
+//
+// -1 is returned if s doesn't contain the closing quote.
+func indexClosingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
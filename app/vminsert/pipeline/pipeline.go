@@ -0,0 +1,267 @@
+package pipeline
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/valyala/fastjson"
+)
+
+var pipelineConfig = flag.String("pipelineConfig", "", "Optional path to a file with ingestion pipeline stages, which are applied to all the ingested log lines after -relabelConfig. "+
+	"Stages may extract labels from log lines with regex, logfmt and json extractors, rewrite and drop log lines. The file is re-read on SIGHUP")
+
+// Init must be called after flag.Parse and before using the pipeline package.
+func Init() {
+	p, err := loadPipelineConfig()
+	if err != nil {
+		logger.Fatalf("cannot load pipelineConfig: %s", err)
+	}
+	pipelineGlobal.Store(p)
+	if len(*pipelineConfig) == 0 {
+		return
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -pipelineConfig=%q...", *pipelineConfig)
+			p, err := loadPipelineConfig()
+			if err != nil {
+				logger.Errorf("cannot load the updated pipelineConfig: %s; preserving the previous config", err)
+				continue
+			}
+			pipelineGlobal.Store(p)
+			logger.Infof("successfully reloaded -pipelineConfig=%q", *pipelineConfig)
+		}
+	}()
+}
+
+var pipelineGlobal atomic.Value
+
+func getPipeline() *pipeline {
+	return pipelineGlobal.Load().(*pipeline)
+}
+
+func loadPipelineConfig() (*pipeline, error) {
+	if len(*pipelineConfig) == 0 {
+		return &pipeline{}, nil
+	}
+	data, err := ioutil.ReadFile(*pipelineConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -pipelineConfig=%q: %w", *pipelineConfig, err)
+	}
+	p, err := parsePipeline(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -pipelineConfig=%q: %w", *pipelineConfig, err)
+	}
+	return p, nil
+}
+
+// HasPipeline returns true if there is ingestion pipeline.
+func HasPipeline() bool {
+	return len(getPipeline().stages) > 0
+}
+
+// Ctx holds ingestion pipeline context.
+type Ctx struct {
+	labels []storage.Label
+	buf    []byte
+	fields []field
+
+	p fastjson.Parser
+}
+
+type field struct {
+	name  string
+	value []byte
+}
+
+// Reset resets ctx.
+func (ctx *Ctx) Reset() {
+	labels := ctx.labels
+	for i := range labels {
+		label := &labels[i]
+		label.Name = nil
+		label.Value = nil
+	}
+	ctx.labels = ctx.labels[:0]
+	ctx.buf = ctx.buf[:0]
+	ctx.resetFields()
+}
+
+func (ctx *Ctx) resetFields() {
+	fields := ctx.fields
+	for i := range fields {
+		fields[i] = field{}
+	}
+	ctx.fields = ctx.fields[:0]
+}
+
+// Apply applies ingestion pipeline stages to the log line with the given labels.
+//
+// It returns labels and line after applying the stages. The given labels aren't modified.
+// false is returned if the line must be dropped.
+//
+// The returned labels and line are valid until the next call to Apply.
+func (ctx *Ctx) Apply(labels []storage.Label, line []byte) ([]storage.Label, []byte, bool) {
+	p := getPipeline()
+	if len(p.stages) == 0 {
+		return labels, line, true
+	}
+	ctx.labels = append(ctx.labels[:0], labels...)
+	ctx.buf = ctx.buf[:0]
+	for _, st := range p.stages {
+		ctx.resetFields()
+		if !st.extractFields(ctx, line) {
+			continue
+		}
+		if st.drop {
+			linesDropped.Inc()
+			return nil, nil, false
+		}
+		for _, le := range st.labels {
+			value := ctx.getField(le.field)
+			if len(value) == 0 {
+				// Skip labels with empty values.
+				continue
+			}
+			bufLen := len(ctx.buf)
+			ctx.buf = append(ctx.buf, value...)
+			ctx.setLabel(le.name, ctx.buf[bufLen:])
+		}
+		if st.lineField != "" {
+			if value := ctx.getField(st.lineField); len(value) > 0 {
+				bufLen := len(ctx.buf)
+				ctx.buf = append(ctx.buf, value...)
+				line = ctx.buf[bufLen:]
+			}
+		}
+		if st.replacement != nil {
+			line = st.re.ReplaceAll(line, st.replacement)
+		}
+	}
+	return ctx.labels, line, true
+}
+
+var linesDropped = metrics.NewCounter(`vm_pipeline_lines_dropped_total`)
+
+func (ctx *Ctx) getField(name string) []byte {
+	for _, f := range ctx.fields {
+		if f.name == name {
+			return f.value
+		}
+	}
+	return nil
+}
+
+func (ctx *Ctx) setLabel(name, value []byte) {
+	for i := range ctx.labels {
+		label := &ctx.labels[i]
+		if string(label.Name) == string(name) {
+			label.Value = value
+			return
+		}
+	}
+	ctx.labels = append(ctx.labels, storage.Label{
+		Name:  name,
+		Value: value,
+	})
+}
+
+func (ctx *Ctx) addField(name string, value []byte) {
+	ctx.fields = append(ctx.fields, field{
+		name:  name,
+		value: value,
+	})
+}
+
+// extractFields extracts fields needed by st from line into ctx.fields.
+//
+// It returns false if line doesn't match st.
+func (st *stage) extractFields(ctx *Ctx, line []byte) bool {
+	switch st.typ {
+	case "regex":
+		return st.extractRegexFields(ctx, line)
+	case "logfmt":
+		return st.extractLogfmtFields(ctx, line)
+	case "json":
+		return st.extractJSONFields(ctx, line)
+	default:
+		logger.Panicf("BUG: unexpected stage type %q", st.typ)
+		return false
+	}
+}
+
+func (st *stage) extractRegexFields(ctx *Ctx, line []byte) bool {
+	a := st.re.FindSubmatchIndex(line)
+	if a == nil {
+		return false
+	}
+	for i, name := range st.re.SubexpNames() {
+		if name == "" || a[2*i] < 0 {
+			continue
+		}
+		ctx.addField(name, line[a[2*i]:a[2*i+1]])
+	}
+	return true
+}
+
+func (st *stage) extractLogfmtFields(ctx *Ctx, line []byte) bool {
+	bufLen := len(ctx.buf)
+	var ok bool
+	ctx.buf, ok = visitLogfmtFields(ctx.buf, bytesutil.ToUnsafeString(line), func(key string, value []byte) {
+		if st.needsField(key) {
+			ctx.addField(key, value)
+		}
+	})
+	if !ok {
+		ctx.buf = ctx.buf[:bufLen]
+		ctx.resetFields()
+	}
+	return ok
+}
+
+func (st *stage) extractJSONFields(ctx *Ctx, line []byte) bool {
+	v, err := ctx.p.ParseBytes(line)
+	if err != nil {
+		return false
+	}
+	if v.Type() != fastjson.TypeObject {
+		return false
+	}
+	for _, path := range st.jsonPaths {
+		fv := v.Get(path.keys...)
+		if fv == nil {
+			continue
+		}
+		bufLen := len(ctx.buf)
+		switch fv.Type() {
+		case fastjson.TypeNull:
+			continue
+		case fastjson.TypeString:
+			ctx.buf = append(ctx.buf, fv.GetStringBytes()...)
+		default:
+			ctx.buf = fv.MarshalTo(ctx.buf)
+		}
+		ctx.addField(path.field, ctx.buf[bufLen:])
+	}
+	return true
+}
+
+func (st *stage) needsField(name string) bool {
+	if name == st.lineField {
+		return true
+	}
+	for _, le := range st.labels {
+		if le.field == name {
+			return true
+		}
+	}
+	return false
+}
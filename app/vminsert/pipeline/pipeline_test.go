@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+)
+
+func TestApply(t *testing.T) {
+	f := func(config, line, resultExpected string) {
+		t.Helper()
+		p, err := parsePipeline([]byte(config))
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", config, err)
+		}
+		pipelineGlobal.Store(p)
+		labels := []storage.Label{
+			{
+				Name:  []byte("job"),
+				Value: []byte("app"),
+			},
+			{
+				Name:  []byte("level"),
+				Value: []byte("unknown"),
+			},
+		}
+		var ctx Ctx
+		labelsResult, lineResult, ok := ctx.Apply(labels, []byte(line))
+		result := "dropped"
+		if ok {
+			result = fmt.Sprintf("%s %q", labelsString(labelsResult), lineResult)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+		// The original labels mustn't be modified.
+		if s := labelsString(labels); s != `{job="app",level="unknown"}` {
+			t.Fatalf("unexpected modification of the original labels: %s", s)
+		}
+	}
+
+	// Empty pipeline
+	f(``, `foo bar`, `{job="app",level="unknown"} "foo bar"`)
+
+	// Regex extractor
+	f(`
+- type: regex
+  regex: 'level=(?P<level>\w+) .*user=(?P<user>\w+)'
+`, `level=ERROR msg="cannot open file" user=john`, `{job="app",level="ERROR",user="john"} "level=ERROR msg=\"cannot open file\" user=john"`)
+	f(`
+- type: regex
+  regex: 'level=(?P<level>\w+)'
+  labels:
+    severity: level
+`, `level=warn foo`, `{job="app",level="unknown",severity="warn"} "level=warn foo"`)
+	f(`
+- type: regex
+  regex: 'level=(?P<level>\w+)'
+`, `foo bar`, `{job="app",level="unknown"} "foo bar"`)
+	f(`
+- type: regex
+  regex: 'password=\S+'
+  replacement: 'password=***'
+`, `user=john password=secret ok`, `{job="app",level="unknown"} "user=john password=*** ok"`)
+	f(`
+- type: regex
+  regex: '^(?P<ts>\S+) (?P<msg>.*)$'
+  line_field: msg
+`, `2020-10-20T10:00:00Z foo bar`, `{job="app",level="unknown",ts="2020-10-20T10:00:00Z"} "foo bar"`)
+
+	// Logfmt extractor
+	f(`
+- type: logfmt
+  labels:
+    level: level
+    svc: service
+  line_field: msg
+`, `ts=123 level=info service="api gateway" msg="request \"foo\" served"`, `{job="app",level="info",svc="api gateway"} "request \"foo\" served"`)
+	f(`
+- type: logfmt
+  labels:
+    level: level
+`, `not a logfmt line`, `{job="app",level="unknown"} "not a logfmt line"`)
+
+	// JSON extractor
+	f(`
+- type: json
+  labels:
+    service: service
+    pod: kubernetes.pod_name
+    code: status
+  line_field: message
+`, `{"service":"api","kubernetes":{"pod_name":"api-0"},"status":500,"message":"internal error"}`,
+		`{job="app",level="unknown",code="500",pod="api-0",service="api"} "internal error"`)
+	f(`
+- type: json
+  labels:
+    service: service
+`, `{"service":null}`, `{job="app",level="unknown"} "{\"service\":null}"`)
+	f(`
+- type: json
+  labels:
+    service: service
+`, `foo`, `{job="app",level="unknown"} "foo"`)
+
+	// Drop
+	f(`
+- type: regex
+  regex: 'GET /health'
+  drop: true
+`, `GET /health 200`, `dropped`)
+	f(`
+- type: regex
+  regex: 'GET /health'
+  drop: true
+`, `GET /api 200`, `{job="app",level="unknown"} "GET /api 200"`)
+
+	// Multiple stages
+	f(`
+- type: json
+  labels:
+    level: level
+  line_field: log
+- type: logfmt
+  labels:
+    user: user
+- type: regex
+  regex: 'debug'
+  drop: true
+`, `{"level":"info","log":"user=alice action=login"}`, `{job="app",level="info",user="alice"} "user=alice action=login"`)
+	f(`
+- type: json
+  line_field: log
+- type: regex
+  regex: 'debug'
+  drop: true
+`, `{"log":"debug message"}`, `dropped`)
+}
+
+func TestParsePipelineFailure(t *testing.T) {
+	f := func(config string) {
+		t.Helper()
+		if _, err := parsePipeline([]byte(config)); err == nil {
+			t.Fatalf("expecting non-nil error for %q", config)
+		}
+	}
+	f(`foo`)
+	f(`- unknown_field: foo`)
+	f(`- labels: {level: level}`)
+	f(`- {type: xml, labels: {level: level}}`)
+	f(`- {type: regex, labels: {level: level}}`)
+	f(`- {type: regex, regex: '(', replacement: ''}`)
+	f(`- {type: regex, regex: '(?P<level>\w+)', labels: {level: lvl}}`)
+	f(`- {type: regex, regex: '(?P<level>\w+)', line_field: msg}`)
+	f(`- {type: regex, regex: 'foo'}`)
+	f(`- {type: regex, regex: 'foo', replacement: 'bar', line_field: foo}`)
+	f(`- {type: regex, regex: 'foo', drop: true, replacement: 'bar'}`)
+	f(`- {type: logfmt}`)
+	f(`- {type: logfmt, regex: 'foo', labels: {level: level}}`)
+	f(`- {type: json, replacement: 'foo'}`)
+	f(`- {type: json, labels: {"bad-label": level}}`)
+	f(`- {type: json, labels: {level: ""}}`)
+}
+
+func TestVisitLogfmtFields(t *testing.T) {
+	f := func(s, resultExpected string, okExpected bool) {
+		t.Helper()
+		var result string
+		_, ok := visitLogfmtFields(nil, s, func(key string, value []byte) {
+			result += fmt.Sprintf("%s=%q;", key, value)
+		})
+		if ok != okExpected {
+			t.Fatalf("unexpected ok for %q; got %v; want %v", s, ok, okExpected)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+	f(``, ``, false)
+	f(`foo bar`, ``, false)
+	f(`foo=bar`, `foo="bar";`, true)
+	f(`  foo=bar baz  x= y="a b\"c" =z flag`, `foo="bar";x="";y="a b\"c";`, true)
+	f(`foo="bar`, ``, false)
+}
+
+func labelsString(labels []storage.Label) string {
+	s := "{"
+	for i, label := range labels {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("%s=%q", label.Name, label.Value)
+	}
+	return s + "}"
+}
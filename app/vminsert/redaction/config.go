@@ -0,0 +1,232 @@
+package redaction
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// ruleConfig is a single rule in -redactionConfig file.
+type ruleConfig struct {
+	// Name is the rule name used in metrics. It defaults to Detector or to rule_N.
+	Name string `yaml:"name,omitempty"`
+
+	// Regex is the regular expression for the data to redact.
+	Regex string `yaml:"regex,omitempty"`
+
+	// Detector is the name of built-in detector. It cannot be set together with Regex.
+	Detector string `yaml:"detector,omitempty"`
+
+	// Replacement replaces all the matches in the log line. It may refer to capture groups via $1 or ${name}.
+	// It defaults to [REDACTED].
+	Replacement *string `yaml:"replacement,omitempty"`
+
+	// Match is an optional label selector such as `{job="nginx",env=~"prod|staging"}`.
+	// The rule is applied only to streams matching the selector.
+	Match string `yaml:"match,omitempty"`
+}
+
+type rules struct {
+	rules []*rule
+}
+
+// rule is parsed ruleConfig.
+type rule struct {
+	name        string
+	re          *regexp.Regexp
+	replacement []byte
+
+	// validate is an optional function for filtering out false positive matches.
+	validate func(b []byte) bool
+
+	lfs []labelFilter
+
+	matches *metrics.Counter
+}
+
+const defaultReplacement = "[REDACTED]"
+
+// detector is a built-in detector for sensitive data.
+type detector struct {
+	regex       string
+	replacement string
+	validate    func(b []byte) bool
+}
+
+var detectors = map[string]*detector{
+	"credit_card": {
+		regex:       `\b(?:\d[ -]?){12,18}\d\b`,
+		replacement: defaultReplacement,
+		validate:    isValidCreditCardNumber,
+	},
+	"email": {
+		regex:       `[a-zA-Z0-9._%+-]+@[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*\.[a-zA-Z]{2,}`,
+		replacement: defaultReplacement,
+	},
+	"bearer_token": {
+		regex:       `(?i)(\bbearer\s+)[a-zA-Z0-9\-._~+/]+=*`,
+		replacement: "${1}" + defaultReplacement,
+	},
+	"jwt": {
+		regex:       `\beyJ[a-zA-Z0-9_-]+\.eyJ[a-zA-Z0-9_-]+\.[a-zA-Z0-9_-]*`,
+		replacement: defaultReplacement,
+	},
+	"aws_access_key": {
+		regex:       `\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`,
+		replacement: defaultReplacement,
+	},
+}
+
+func parseRules(data []byte) (*rules, error) {
+	var rcs []ruleConfig
+	if err := yaml.UnmarshalStrict(data, &rcs); err != nil {
+		return nil, err
+	}
+	rs := make([]*rule, len(rcs))
+	names := make(map[string]bool, len(rcs))
+	for i := range rcs {
+		r, err := newRule(&rcs[i], i)
+		if err != nil {
+			return nil, fmt.Errorf("error in rule #%d: %w", i+1, err)
+		}
+		if names[r.name] {
+			return nil, fmt.Errorf("duplicate rule name %q", r.name)
+		}
+		names[r.name] = true
+		rs[i] = r
+	}
+	return &rules{
+		rules: rs,
+	}, nil
+}
+
+func newRule(rc *ruleConfig, idx int) (*rule, error) {
+	var r rule
+	replacement := defaultReplacement
+	switch {
+	case rc.Regex != "" && rc.Detector != "":
+		return nil, fmt.Errorf("`regex` and `detector` cannot be set simultaneously")
+	case rc.Regex != "":
+		re, err := regexp.Compile(rc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `regex` %q: %w", rc.Regex, err)
+		}
+		r.re = re
+		r.name = "rule_" + strconv.Itoa(idx+1)
+	case rc.Detector != "":
+		d := detectors[rc.Detector]
+		if d == nil {
+			return nil, fmt.Errorf("unknown `detector`: %q; supported detectors: credit_card, email, bearer_token, jwt, aws_access_key", rc.Detector)
+		}
+		r.re = regexp.MustCompile(d.regex)
+		r.validate = d.validate
+		r.name = rc.Detector
+		replacement = d.replacement
+	default:
+		return nil, fmt.Errorf("missing `regex` or `detector`")
+	}
+	if rc.Name != "" {
+		r.name = rc.Name
+	}
+	if rc.Replacement != nil {
+		replacement = *rc.Replacement
+	}
+	r.replacement = []byte(replacement)
+	if rc.Match != "" {
+		lfs, err := parseLabelSelector(rc.Match)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse `match` %q: %w", rc.Match, err)
+		}
+		r.lfs = lfs
+	}
+	r.matches = metrics.GetOrCreateCounter(fmt.Sprintf(`vm_redaction_matches_total{rule=%q}`, r.name))
+	return &r, nil
+}
+
+// labelFilter is a label filter from label selector.
+type labelFilter struct {
+	name       string
+	value      string
+	re         *regexp.Regexp
+	isNegative bool
+}
+
+func parseLabelSelector(s string) ([]labelFilter, error) {
+	e, err := logql.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	me, ok := e.(*logql.MetricExpr)
+	if !ok {
+		return nil, fmt.Errorf("expecting label selector such as `{job=\"nginx\"}`; got %q", s)
+	}
+	lfs := make([]labelFilter, len(me.LabelFilters))
+	for i := range me.LabelFilters {
+		src := &me.LabelFilters[i]
+		lf := &lfs[i]
+		lf.name = src.Label
+		if lf.name == "__name__" {
+			lf.name = ""
+		}
+		lf.value = src.Value
+		lf.isNegative = src.IsNegative
+		if src.IsRegexp {
+			re, err := logql.CompileRegexpAnchored(src.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regexp in %s=~%q: %w", src.Label, src.Value, err)
+			}
+			lf.re = re
+		}
+	}
+	return lfs, nil
+}
+
+// match returns true if labels match lf.
+//
+// Missing labels are treated as labels with empty values.
+func (lf *labelFilter) match(labels []storage.Label) bool {
+	var value []byte
+	for i := range labels {
+		label := &labels[i]
+		if string(label.Name) == lf.name {
+			value = label.Value
+			break
+		}
+	}
+	var ok bool
+	if lf.re != nil {
+		ok = lf.re.Match(value)
+	} else {
+		ok = string(value) == lf.value
+	}
+	return ok != lf.isNegative
+}
+
+// isValidCreditCardNumber returns true if b contains a number passing Luhn check.
+//
+// Spaces and dashes in b are ignored.
+func isValidCreditCardNumber(b []byte) bool {
+	sum := 0
+	digits := 0
+	for i := len(b) - 1; i >= 0; i-- {
+		c := b[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
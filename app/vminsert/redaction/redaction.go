@@ -0,0 +1,131 @@
+package redaction
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+)
+
+var redactionConfig = flag.String("redactionConfig", "", "Optional path to a file with redaction rules, which are applied to all the ingested log lines "+
+	"before -pipelineConfig. Each rule contains either regex or built-in detector (credit_card, email, bearer_token, jwt, aws_access_key) "+
+	"with optional replacement and optional label selector for limiting the rule to the matching streams. The file is re-read on SIGHUP")
+
+// Init must be called after flag.Parse and before using the redaction package.
+func Init() {
+	rs, err := loadRedactionConfig()
+	if err != nil {
+		logger.Fatalf("cannot load redactionConfig: %s", err)
+	}
+	rulesGlobal.Store(rs)
+	if len(*redactionConfig) == 0 {
+		return
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -redactionConfig=%q...", *redactionConfig)
+			rs, err := loadRedactionConfig()
+			if err != nil {
+				logger.Errorf("cannot load the updated redactionConfig: %s; preserving the previous config", err)
+				continue
+			}
+			rulesGlobal.Store(rs)
+			logger.Infof("successfully reloaded -redactionConfig=%q", *redactionConfig)
+		}
+	}()
+}
+
+var rulesGlobal atomic.Value
+
+func getRules() *rules {
+	return rulesGlobal.Load().(*rules)
+}
+
+func loadRedactionConfig() (*rules, error) {
+	if len(*redactionConfig) == 0 {
+		return &rules{}, nil
+	}
+	data, err := ioutil.ReadFile(*redactionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -redactionConfig=%q: %w", *redactionConfig, err)
+	}
+	rs, err := parseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -redactionConfig=%q: %w", *redactionConfig, err)
+	}
+	return rs, nil
+}
+
+// HasRedaction returns true if there are redaction rules.
+func HasRedaction() bool {
+	return len(getRules().rules) > 0
+}
+
+// Ctx holds redaction context.
+type Ctx struct {
+	bufs [2][]byte
+}
+
+// Reset resets ctx.
+func (ctx *Ctx) Reset() {
+	ctx.bufs[0] = ctx.bufs[0][:0]
+	ctx.bufs[1] = ctx.bufs[1][:0]
+}
+
+// Redact applies redaction rules matching the given labels to line and returns the result.
+//
+// The returned line is valid until the next call to Redact.
+func (ctx *Ctx) Redact(labels []storage.Label, line []byte) []byte {
+	rs := getRules()
+	n := 0
+	for _, r := range rs.rules {
+		if !r.matchLabels(labels) {
+			continue
+		}
+		if !r.re.Match(line) {
+			// Fast path - nothing to redact.
+			continue
+		}
+		// Alternate between bufs, since line may refer to the buf from the previous rule.
+		dst, matches := r.redact(ctx.bufs[n%2][:0], line)
+		ctx.bufs[n%2] = dst
+		n++
+		if matches > 0 {
+			r.matches.Add(matches)
+		}
+		line = dst
+	}
+	return line
+}
+
+func (r *rule) matchLabels(labels []storage.Label) bool {
+	for i := range r.lfs {
+		if !r.lfs[i].match(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// redact appends line with all the r matches replaced to dst and returns the result
+// together with the number of replaced matches.
+func (r *rule) redact(dst, line []byte) ([]byte, int) {
+	matches := 0
+	lastEnd := 0
+	for _, a := range r.re.FindAllSubmatchIndex(line, -1) {
+		if r.validate != nil && !r.validate(line[a[0]:a[1]]) {
+			continue
+		}
+		dst = append(dst, line[lastEnd:a[0]]...)
+		dst = r.re.Expand(dst, r.replacement, line, a)
+		lastEnd = a[1]
+		matches++
+	}
+	dst = append(dst, line[lastEnd:]...)
+	return dst, matches
+}
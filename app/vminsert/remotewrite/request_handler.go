@@ -1,10 +1,13 @@
 package remotewrite
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/pipeline"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/redaction"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/lokipb"
 	importerParser "github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/importer"
@@ -38,16 +41,33 @@ func insertRows(at *auth.Token, timeseries []lokipb.Stream) error {
 	ctx.Reset() // This line is required for initializing ctx internals.
 	rowsTotal := 0
 	hasRelabeling := relabel.HasRelabeling()
+	hasPipeline := pipeline.HasPipeline()
+	hasRedaction := redaction.HasRedaction()
 
+	maxLabels := storage.GetMaxLabelsPerTimeseries()
 	var err error
 	var tail []byte
 	for i := range timeseries {
 		ts := &timeseries[i]
 		ctx.Labels = ctx.Labels[:0]
 
+		// Rejected streams are reported in the response, while the remaining streams are accepted.
+		if !strings.HasPrefix(ts.Labels, "{") {
+			ctx.RejectRows(at, netstorage.RejectReasonInvalidLabels, fmt.Sprintf("stream %q: labels must start with `{`", ts.Labels), len(ts.Entries))
+			continue
+		}
 		noEscapes := strings.IndexByte(ts.Labels, '\\') < 0
 		tail, ctx.Labels, err = importerParser.UnmarshalTags(ctx.Labels, bytesutil.ToUnsafeBytes(ts.Labels[1:]), noEscapes)
-		if len(tail) > 0 || err != nil {
+		if err != nil {
+			ctx.RejectRows(at, netstorage.RejectReasonInvalidLabels, fmt.Sprintf("stream %q: cannot parse labels: %s", ts.Labels, err), len(ts.Entries))
+			continue
+		}
+		if len(tail) > 0 {
+			ctx.RejectRows(at, netstorage.RejectReasonInvalidLabels, fmt.Sprintf("stream %q: unexpected data after labels: %q", ts.Labels, tail), len(ts.Entries))
+			continue
+		}
+		if countNonEmptyLabels(ctx.Labels) == 0 {
+			ctx.RejectRows(at, netstorage.RejectReasonNoLabels, fmt.Sprintf("stream %q: missing labels with non-empty values", ts.Labels), len(ts.Entries))
 			continue
 		}
 
@@ -55,18 +75,39 @@ func insertRows(at *auth.Token, timeseries []lokipb.Stream) error {
 			ctx.ApplyRelabeling()
 		}
 		if len(ctx.Labels) == 0 {
-			// Skip metric without labels.
+			// Skip stream dropped by relabeling.
+			continue
+		}
+		if n := countNonEmptyLabels(ctx.Labels); n > maxLabels {
+			// Reject the stream instead of silently dropping superfluous labels.
+			ctx.RejectRows(at, netstorage.RejectReasonTooManyLabels, fmt.Sprintf("stream %q: %d labels exceed -maxLabelsPerTimeseries=%d", ts.Labels, n, maxLabels),
+				len(ts.Entries))
+			continue
+		}
+		entries := ts.Entries
+		if hasPipeline {
+			// Slow path - the pipeline may change labels for each entry, so the storage node must be determined per each entry.
+			for i := range entries {
+				r := &entries[i]
+				if err := ctx.WriteDataPoint(at, ctx.Labels, r.Timestamp.UnixNano(), bytesutil.ToUnsafeBytes(r.Line)); err != nil {
+					return err
+				}
+			}
+			rowsTotal += len(entries)
 			continue
 		}
 		storageNodeIdx := ctx.GetStorageNodeIdx(at, ctx.Labels)
 		ctx.MetricNameBuf = ctx.MetricNameBuf[:0]
-		entries := ts.Entries
 		for i := range entries {
 			r := &entries[i]
 			if len(ctx.MetricNameBuf) == 0 {
 				ctx.MetricNameBuf = storage.MarshalMetricNameRaw(ctx.MetricNameBuf[:0], at.AccountID, at.ProjectID, ctx.Labels)
 			}
-			if err := ctx.WriteDataPointExt(at, storageNodeIdx, ctx.MetricNameBuf, r.Timestamp.UnixNano()/1e6, bytesutil.ToUnsafeBytes(r.Line)); err != nil {
+			line := bytesutil.ToUnsafeBytes(r.Line)
+			if hasRedaction {
+				line = ctx.Redact(ctx.Labels, line)
+			}
+			if err := ctx.WriteDataPointExt(at, storageNodeIdx, ctx.MetricNameBuf, r.Timestamp.UnixNano(), line); err != nil {
 				return err
 			}
 		}
@@ -76,3 +117,13 @@ func insertRows(at *auth.Token, timeseries []lokipb.Stream) error {
 	rowsPerInsert.Update(float64(rowsTotal))
 	return ctx.FlushBufs()
 }
+
+func countNonEmptyLabels(labels []storage.Label) int {
+	n := 0
+	for i := range labels {
+		if len(labels[i].Value) > 0 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,162 @@
+package remotewrite
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/pipeline"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/redaction"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/tenantlimits"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/handshakeext"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/lokipb"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+)
+
+func TestInsertRowsRejectedStreams(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot start fake vmstorage: %s", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	linesCh := make(chan string, 100)
+	go runFakeVMStorage(ln, linesCh)
+
+	relabel.Init()
+	redaction.Init()
+	pipeline.Init()
+	tenantlimits.Init()
+	netstorage.InitStorageNodes([]string{ln.Addr().String()})
+	defer netstorage.Stop()
+
+	var tooManyLabels []string
+	for i := 0; i <= storage.GetMaxLabelsPerTimeseries(); i++ {
+		tooManyLabels = append(tooManyLabels, fmt.Sprintf(`label_%d="value"`, i))
+	}
+	now := time.Now()
+	newStream := func(labels string, lines ...string) lokipb.Stream {
+		var entries []lokipb.Entry
+		for _, line := range lines {
+			entries = append(entries, lokipb.Entry{
+				Timestamp: now,
+				Line:      line,
+			})
+		}
+		return lokipb.Stream{
+			Labels:  labels,
+			Entries: entries,
+		}
+	}
+	streams := []lokipb.Stream{
+		newStream(`{job="foo"}`, "accepted 1", "accepted 2"),
+		newStream(`job="bar"`, "no braces"),
+		newStream(`{job="bar",}x`, "unexpected tail"),
+		newStream(`{job=""}`, "empty labels 1", "empty labels 2"),
+		newStream("{"+strings.Join(tooManyLabels, ",")+"}", "too many labels"),
+		newStream(`{job="baz"}`, "accepted 3"),
+	}
+	at := &auth.Token{
+		AccountID: 12,
+		ProjectID: 34,
+	}
+	err = insertRows(at, streams)
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if !netstorage.IsRejectedRowsError(err) {
+		t.Fatalf("expecting rejected rows error; got %s", err)
+	}
+	esc := err.(*httpserver.ErrorWithStatusCode)
+	if esc.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status code; got %d; want %d", esc.StatusCode, http.StatusBadRequest)
+	}
+	body := err.Error()
+	for _, s := range []string{
+		"rejected 5 log lines: ",
+		"1 lines: stream \"job=\\\"bar\\\"\": labels must start with `{` (reason=invalid_labels)",
+		"1 lines: stream \"{job=\\\"bar\\\",}x\": unexpected data after labels: \"x\" (reason=invalid_labels)",
+		"2 lines: stream \"{job=\\\"\\\"}\": missing labels with non-empty values (reason=no_labels)",
+		fmt.Sprintf("1 lines: stream %q: %d labels exceed -maxLabelsPerTimeseries=%d (reason=too_many_labels)",
+			streams[4].Labels, storage.GetMaxLabelsPerTimeseries()+1, storage.GetMaxLabelsPerTimeseries()),
+	} {
+		if !strings.Contains(body, s) {
+			t.Fatalf("missing %q in the response body %q", s, body)
+		}
+	}
+
+	// The remaining streams must be accepted.
+	var lines []string
+	for len(lines) < 3 {
+		select {
+		case line := <-linesCh:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout when waiting for accepted lines; got %q", lines)
+		}
+	}
+	sort.Strings(lines)
+	linesExpected := []string{"accepted 1", "accepted 2", "accepted 3"}
+	if strings.Join(lines, "\n") != strings.Join(linesExpected, "\n") {
+		t.Fatalf("unexpected accepted lines;\ngot\n%q\nwant\n%q", lines, linesExpected)
+	}
+	select {
+	case line := <-linesCh:
+		t.Fatalf("unexpected line received by vmstorage: %q", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// runFakeVMStorage accepts vminsert connections at ln and sends the received lines to linesCh.
+func runFakeVMStorage(ln net.Listener, linesCh chan<- string) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() {
+				_ = c.Close()
+			}()
+			bc, _, err := handshakeext.VMInsertServer(c, 0, 0)
+			if err != nil {
+				return
+			}
+			sizeBuf := make([]byte, 8)
+			for {
+				if _, err := io.ReadFull(bc, sizeBuf); err != nil {
+					return
+				}
+				buf := make([]byte, encoding.UnmarshalUint64(sizeBuf))
+				if _, err := io.ReadFull(bc, buf); err != nil {
+					return
+				}
+				for len(buf) > 0 {
+					var mr storage.MetricRow
+					buf, err = mr.Unmarshal(buf)
+					if err != nil {
+						return
+					}
+					linesCh <- string(mr.Value)
+				}
+				if _, err := bc.Write([]byte{1}); err != nil {
+					return
+				}
+				if err := bc.Flush(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
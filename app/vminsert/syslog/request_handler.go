@@ -0,0 +1,153 @@
+package syslog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/netstorage"
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vminsert/relabel"
+	parser "github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/syslog"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/writeconcurrencylimiter"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	tenantIDs = flagutil.NewArray("syslog.tenantID", "Tenant in the form accountID[:projectID] for logs received via the corresponding -syslogListenAddr. "+
+		"The default tenant is 0:0")
+	extraLabels = flagutil.NewArray("syslog.extraLabels", "Extra labels in the form name1=value1;name2=value2 to add to logs received via the corresponding -syslogListenAddr")
+)
+
+var (
+	rowsInserted  = tenantmetrics.NewCounterMap(`vm_rows_inserted_total{type="syslog"}`)
+	rowsPerInsert = metrics.NewHistogram(`vm_rows_per_insert{type="syslog"}`)
+)
+
+var servers []*Server
+
+// MustStart starts syslog servers on the given addrs.
+//
+// The tenant and extra labels for the i-th addr are taken from the i-th -syslog.tenantID and -syslog.extraLabels.
+//
+// MustStop must be called when the servers are no longer needed.
+func MustStart(addrs []string) {
+	for i, addr := range addrs {
+		l, err := newListener(getArg(*tenantIDs, i), getArg(*extraLabels, i))
+		if err != nil {
+			logger.Fatalf("invalid config for -syslogListenAddr=%q: %s", addr, err)
+		}
+		s := MustStartServer(addr, l.insertHandler, l.insertDatagramHandler)
+		servers = append(servers, s)
+	}
+}
+
+// MustStop stops syslog servers started with MustStart.
+func MustStop() {
+	for _, s := range servers {
+		s.MustStop()
+	}
+	servers = nil
+}
+
+func getArg(args []string, idx int) string {
+	if idx >= len(args) {
+		return ""
+	}
+	return args[idx]
+}
+
+// listener holds settings for a single -syslogListenAddr.
+type listener struct {
+	at          auth.Token
+	extraLabels []storage.Label
+}
+
+func newListener(tenantID, extraLabels string) (*listener, error) {
+	var l listener
+	if tenantID != "" {
+		at, err := auth.NewToken(tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse -syslog.tenantID=%q: %w", tenantID, err)
+		}
+		l.at = *at
+	}
+	for _, kv := range strings.Split(extraLabels, ";") {
+		if kv == "" {
+			continue
+		}
+		n := strings.IndexByte(kv, '=')
+		if n <= 0 {
+			return nil, fmt.Errorf("missing `=` in -syslog.extraLabels item %q; it must have the form name=value", kv)
+		}
+		l.extraLabels = append(l.extraLabels, storage.Label{
+			Name:  []byte(kv[:n]),
+			Value: []byte(kv[n+1:]),
+		})
+	}
+	return &l, nil
+}
+
+func (l *listener) insertHandler(r io.Reader) error {
+	// Syslog connections may be open for long periods of time,
+	// so limit the concurrency per each batch instead of per connection.
+	return parser.ParseStream(r, func(rows []parser.Row) error {
+		return writeconcurrencylimiter.Do(func() error {
+			return l.insertRows(rows)
+		})
+	})
+}
+
+func (l *listener) insertDatagramHandler(data []byte) error {
+	return parser.ParseDatagram(data, func(rows []parser.Row) error {
+		return writeconcurrencylimiter.Do(func() error {
+			return l.insertRows(rows)
+		})
+	})
+}
+
+var (
+	hostnameLabel = []byte("hostname")
+	appNameLabel  = []byte("app_name")
+	facilityLabel = []byte("facility")
+	severityLabel = []byte("severity")
+)
+
+func (l *listener) insertRows(rows []parser.Row) error {
+	ctx := netstorage.GetInsertCtx()
+	defer netstorage.PutInsertCtx(ctx)
+
+	ctx.Reset() // This line is required for initializing ctx internals.
+	rowsTotal := 0
+	hasRelabeling := relabel.HasRelabeling()
+	for i := range rows {
+		r := &rows[i]
+		ctx.Labels = ctx.Labels[:0]
+		ctx.AddLabel(hostnameLabel, r.Hostname)
+		ctx.AddLabel(appNameLabel, r.AppName)
+		ctx.AddLabel(facilityLabel, []byte(r.FacilityName()))
+		ctx.AddLabel(severityLabel, []byte(r.SeverityName()))
+		for j := range l.extraLabels {
+			label := &l.extraLabels[j]
+			ctx.AddLabel(label.Name, label.Value)
+		}
+		if hasRelabeling {
+			ctx.ApplyRelabeling()
+		}
+		if len(ctx.Labels) == 0 {
+			// Skip message without labels.
+			continue
+		}
+		if err := ctx.WriteDataPoint(&l.at, ctx.Labels, r.Timestamp, r.Message); err != nil {
+			return err
+		}
+		rowsTotal++
+	}
+	rowsInserted.Get(&l.at).Add(rowsTotal)
+	rowsPerInsert.Update(float64(rowsTotal))
+	return ctx.FlushBufsLogRejected("syslog")
+}
@@ -0,0 +1,363 @@
+package tenantlimits
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/httpserver"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/procutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/tenantmetrics"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	tenantLimitsFile = flag.String("ingest.tenantLimitsFile", "", "Optional path to a file with per-tenant overrides for -ingest.* limits. "+
+		"The file must contain a map from tenant in the form accountID[:projectID] to limits, e.g. '\"1:0\": {rate_limit_lines: 1000, burst_bytes: 10485760}'. "+
+		"Supported limits are rate_limit_lines, burst_lines, rate_limit_bytes, burst_bytes, max_past_age, max_future_skew, max_entry_size and truncate_long_entries. "+
+		"The file is re-read on SIGHUP")
+	rateLimitLines = flag.Int("ingest.rateLimitLines", 0, "The maximum ingestion rate in log lines per second per tenant. "+
+		"There is no limit if it is set to 0. See also -ingest.burstLines and -ingest.tenantLimitsFile")
+	burstLines = flag.Int("ingest.burstLines", 0, "The maximum number of log lines per tenant, which may be ingested at once in excess of -ingest.rateLimitLines. "+
+		"It is equal to -ingest.rateLimitLines if it is set to 0")
+	rateLimitBytes = flagutil.NewBytes("ingest.rateLimitBytes", 0, "The maximum ingestion rate in bytes per second per tenant. "+
+		"The size of log lines together with their labels is counted. There is no limit if it is set to 0. See also -ingest.burstBytes and -ingest.tenantLimitsFile")
+	burstBytes = flagutil.NewBytes("ingest.burstBytes", 0, "The maximum number of bytes per tenant, which may be ingested at once in excess of -ingest.rateLimitBytes. "+
+		"It is equal to -ingest.rateLimitBytes if it is set to 0")
+	maxPastAge = flag.Duration("ingest.maxPastAge", 0, "Log lines with timestamps older than the given duration are rejected. "+
+		"There is no limit if it is set to 0. See also -ingest.maxFutureSkew and -ingest.tenantLimitsFile")
+	maxFutureSkew = flag.Duration("ingest.maxFutureSkew", 0, "Log lines with timestamps exceeding the current time by more than the given duration are rejected. "+
+		"There is no limit if it is set to 0. See also -ingest.maxPastAge and -ingest.tenantLimitsFile")
+	maxEntrySize = flagutil.NewBytes("ingest.maxEntrySize", 256*1024, "The maximum size of a log line in bytes. Longer log lines are rejected "+
+		"unless -ingest.truncateLongEntries is set. The size cannot exceed 4MB, which is used if it is set to 0. See also -ingest.tenantLimitsFile")
+	truncateLongEntries = flag.Bool("ingest.truncateLongEntries", false, "Whether to truncate log lines longer than -ingest.maxEntrySize instead of rejecting them. "+
+		"See also -ingest.tenantLimitsFile")
+)
+
+var (
+	rowsRateLimited  = tenantmetrics.NewCounterMap(`vm_rows_rate_limited_total`)
+	bytesRateLimited = tenantmetrics.NewCounterMap(`vm_bytes_rate_limited_total`)
+)
+
+// Init must be called after flag.Parse and before using the tenantlimits package.
+func Init() {
+	o, err := loadTenantLimitsFile()
+	if err != nil {
+		logger.Fatalf("cannot load tenant limits: %s", err)
+	}
+	overridesGlobal.Store(o)
+	if len(*tenantLimitsFile) == 0 {
+		return
+	}
+	sighupCh := procutil.NewSighupChan()
+	go func() {
+		for range sighupCh {
+			logger.Infof("received SIGHUP; reloading -ingest.tenantLimitsFile=%q...", *tenantLimitsFile)
+			o, err := loadTenantLimitsFile()
+			if err != nil {
+				logger.Errorf("cannot load the updated tenant limits: %s; preserving the previous limits", err)
+				continue
+			}
+			overridesGlobal.Store(o)
+			logger.Infof("successfully reloaded -ingest.tenantLimitsFile=%q", *tenantLimitsFile)
+		}
+	}()
+}
+
+// Limits contains ingestion limits for a tenant.
+type Limits struct {
+	RateLimitLines int
+	BurstLines     int
+	RateLimitBytes int
+	BurstBytes     int
+
+	MaxPastAge    time.Duration
+	MaxFutureSkew time.Duration
+
+	// MaxEntrySize is always in the range (0 ... storage.MaxEntrySize].
+	MaxEntrySize        int
+	TruncateLongEntries bool
+}
+
+// GetLimits returns ingestion limits for the given at.
+func GetLimits(at *auth.Token) Limits {
+	return getOverrides().getLimits(at)
+}
+
+// CheckRateLimit verifies whether the given number of lines with the given size in bytes may be ingested for the given at.
+//
+// It returns an error with http.StatusTooManyRequests status code if the ingestion rate limit for at is exceeded.
+func CheckRateLimit(at *auth.Token, lines, bytes int) error {
+	o := getOverrides()
+	rl := getRateLimiter(at)
+	if rl.allow(o, at, lines, bytes, time.Now()) {
+		return nil
+	}
+	rowsRateLimited.Get(at).Add(lines)
+	bytesRateLimited.Get(at).Add(bytes)
+	limits := o.getLimits(at)
+	return &httpserver.ErrorWithStatusCode{
+		Err: fmt.Errorf("cannot ingest %d log lines with %d bytes for tenant %d:%d, since this exceeds the ingestion rate limit "+
+			"of %d lines/s with %d lines burst and %d bytes/s with %d bytes burst; retry later",
+			lines, bytes, at.AccountID, at.ProjectID, limits.RateLimitLines, limits.BurstLines, limits.RateLimitBytes, limits.BurstBytes),
+		StatusCode: http.StatusTooManyRequests,
+	}
+}
+
+type rateLimiter struct {
+	mu sync.Mutex
+
+	// o is the overrides used for initializing lines and bytes.
+	o *overrides
+
+	lines tokenBucket
+	bytes tokenBucket
+}
+
+func (rl *rateLimiter) allow(o *overrides, at *auth.Token, lines, bytes int, now time.Time) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.o != o {
+		// Initialize or update the limits.
+		limits := o.getLimits(at)
+		rl.lines.setLimits(limits.RateLimitLines, limits.BurstLines, now)
+		rl.bytes.setLimits(limits.RateLimitBytes, limits.BurstBytes, now)
+		rl.o = o
+	}
+	rl.lines.refill(now)
+	rl.bytes.refill(now)
+	if !rl.lines.has(lines) || !rl.bytes.has(bytes) {
+		return false
+	}
+	rl.lines.take(lines)
+	rl.bytes.take(bytes)
+	return true
+}
+
+func getRateLimiter(at *auth.Token) *rateLimiter {
+	rateLimitersLock.Lock()
+	rl := rateLimiters[*at]
+	if rl == nil {
+		rl = &rateLimiter{}
+		rateLimiters[*at] = rl
+	}
+	rateLimitersLock.Unlock()
+	return rl
+}
+
+var (
+	rateLimiters     = make(map[auth.Token]*rateLimiter)
+	rateLimitersLock sync.Mutex
+)
+
+// tokenBucket implements token bucket algorithm.
+//
+// Zero rate means there is no limit.
+type tokenBucket struct {
+	rate       float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func (tb *tokenBucket) setLimits(rate, burst int, now time.Time) {
+	if burst <= 0 {
+		burst = rate
+	}
+	if tb.lastRefill.IsZero() || tb.rate <= 0 {
+		// Start with full bucket.
+		tb.tokens = float64(burst)
+		tb.lastRefill = now
+	}
+	tb.rate = float64(rate)
+	tb.burst = float64(burst)
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+	d := now.Sub(tb.lastRefill).Seconds()
+	tb.lastRefill = now
+	if d <= 0 {
+		return
+	}
+	tb.tokens += d * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// has returns true if tb has enough tokens for n.
+//
+// n bigger than tb.burst is allowed if tb is full. Subsequent calls are rejected
+// in this case until the debt is repaid.
+func (tb *tokenBucket) has(n int) bool {
+	if tb.rate <= 0 {
+		return true
+	}
+	need := float64(n)
+	if need > tb.burst {
+		need = tb.burst
+	}
+	return tb.tokens >= need
+}
+
+func (tb *tokenBucket) take(n int) {
+	if tb.rate <= 0 {
+		return
+	}
+	tb.tokens -= float64(n)
+}
+
+// tenantLimits contains per-tenant overrides for -ingest.* flags.
+//
+// Nil fields are set to the corresponding flag values.
+type tenantLimits struct {
+	RateLimitLines *int `yaml:"rate_limit_lines,omitempty"`
+	BurstLines     *int `yaml:"burst_lines,omitempty"`
+	RateLimitBytes *int `yaml:"rate_limit_bytes,omitempty"`
+	BurstBytes     *int `yaml:"burst_bytes,omitempty"`
+
+	MaxPastAge    *time.Duration `yaml:"max_past_age,omitempty"`
+	MaxFutureSkew *time.Duration `yaml:"max_future_skew,omitempty"`
+
+	MaxEntrySize        *int  `yaml:"max_entry_size,omitempty"`
+	TruncateLongEntries *bool `yaml:"truncate_long_entries,omitempty"`
+}
+
+type overrides struct {
+	m map[auth.Token]*tenantLimits
+}
+
+func (o *overrides) getLimits(at *auth.Token) Limits {
+	limits := Limits{
+		RateLimitLines: *rateLimitLines,
+		BurstLines:     *burstLines,
+		RateLimitBytes: rateLimitBytes.N,
+		BurstBytes:     burstBytes.N,
+		MaxPastAge:     *maxPastAge,
+		MaxFutureSkew:  *maxFutureSkew,
+
+		MaxEntrySize:        maxEntrySize.N,
+		TruncateLongEntries: *truncateLongEntries,
+	}
+	tl := o.m[*at]
+	if tl != nil {
+		setIntIfNotNil(&limits.RateLimitLines, tl.RateLimitLines)
+		setIntIfNotNil(&limits.BurstLines, tl.BurstLines)
+		setIntIfNotNil(&limits.RateLimitBytes, tl.RateLimitBytes)
+		setIntIfNotNil(&limits.BurstBytes, tl.BurstBytes)
+		setDurationIfNotNil(&limits.MaxPastAge, tl.MaxPastAge)
+		setDurationIfNotNil(&limits.MaxFutureSkew, tl.MaxFutureSkew)
+		setIntIfNotNil(&limits.MaxEntrySize, tl.MaxEntrySize)
+		if tl.TruncateLongEntries != nil {
+			limits.TruncateLongEntries = *tl.TruncateLongEntries
+		}
+	}
+	if limits.MaxEntrySize <= 0 || limits.MaxEntrySize > storage.MaxEntrySize {
+		// vmstorage drops longer entries.
+		limits.MaxEntrySize = storage.MaxEntrySize
+	}
+	return limits
+}
+
+func setIntIfNotNil(dst *int, src *int) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setDurationIfNotNil(dst *time.Duration, src *time.Duration) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+var overridesGlobal atomic.Value
+
+func getOverrides() *overrides {
+	return overridesGlobal.Load().(*overrides)
+}
+
+func loadTenantLimitsFile() (*overrides, error) {
+	if len(*tenantLimitsFile) == 0 {
+		return &overrides{}, nil
+	}
+	data, err := ioutil.ReadFile(*tenantLimitsFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -ingest.tenantLimitsFile=%q: %w", *tenantLimitsFile, err)
+	}
+	o, err := parseTenantLimits(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -ingest.tenantLimitsFile=%q: %w", *tenantLimitsFile, err)
+	}
+	return o, nil
+}
+
+// parseTenantLimits parses per-tenant limits from data.
+//
+// The data must contain a map from tenant in the form accountID[:projectID] to tenantLimits.
+func parseTenantLimits(data []byte) (*overrides, error) {
+	var tls map[string]*tenantLimits
+	if err := yaml.UnmarshalStrict(data, &tls); err != nil {
+		return nil, err
+	}
+	m := make(map[auth.Token]*tenantLimits, len(tls))
+	for tenant, tl := range tls {
+		at, err := auth.NewToken(tenant)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant %q: %w", tenant, err)
+		}
+		if _, ok := m[*at]; ok {
+			return nil, fmt.Errorf("duplicate limits for tenant %q", tenant)
+		}
+		if tl == nil {
+			tl = &tenantLimits{}
+		}
+		if err := tl.validate(); err != nil {
+			return nil, fmt.Errorf("invalid limits for tenant %q: %w", tenant, err)
+		}
+		m[*at] = tl
+	}
+	return &overrides{
+		m: m,
+	}, nil
+}
+
+func (tl *tenantLimits) validate() error {
+	fields := []struct {
+		name  string
+		value *int
+	}{
+		{"rate_limit_lines", tl.RateLimitLines},
+		{"burst_lines", tl.BurstLines},
+		{"rate_limit_bytes", tl.RateLimitBytes},
+		{"burst_bytes", tl.BurstBytes},
+		{"max_entry_size", tl.MaxEntrySize},
+	}
+	for _, f := range fields {
+		if f.value != nil && *f.value < 0 {
+			return fmt.Errorf("%s cannot be negative; got %d", f.name, *f.value)
+		}
+	}
+	if tl.MaxPastAge != nil && *tl.MaxPastAge < 0 {
+		return fmt.Errorf("max_past_age cannot be negative; got %s", *tl.MaxPastAge)
+	}
+	if tl.MaxFutureSkew != nil && *tl.MaxFutureSkew < 0 {
+		return fmt.Errorf("max_future_skew cannot be negative; got %s", *tl.MaxFutureSkew)
+	}
+	if tl.MaxEntrySize != nil && *tl.MaxEntrySize > storage.MaxEntrySize {
+		return fmt.Errorf("max_entry_size cannot exceed %d; got %d", storage.MaxEntrySize, *tl.MaxEntrySize)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package tenantlimits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1603195200, 0)
+	var tb tokenBucket
+	tb.setLimits(10, 20, now)
+
+	// The bucket is full initially.
+	if !tb.has(20) {
+		t.Fatalf("expecting full bucket")
+	}
+	tb.take(15)
+	if tb.has(10) {
+		t.Fatalf("unexpected tokens left: %v", tb.tokens)
+	}
+	if !tb.has(5) {
+		t.Fatalf("missing tokens; got %v; want 5", tb.tokens)
+	}
+
+	// The bucket is refilled with the given rate up to burst.
+	tb.refill(now.Add(500 * time.Millisecond))
+	if !tb.has(10) || tb.has(11) {
+		t.Fatalf("unexpected tokens after refill; got %v; want 10", tb.tokens)
+	}
+	tb.refill(now.Add(time.Hour))
+	if tb.tokens != 20 {
+		t.Fatalf("unexpected tokens after long refill; got %v; want 20", tb.tokens)
+	}
+
+	// Requests bigger than burst are allowed only for full bucket.
+	if !tb.has(100) {
+		t.Fatalf("expecting request bigger than burst to be allowed for full bucket")
+	}
+	tb.take(100)
+	tb.refill(now.Add(time.Hour + 5*time.Second))
+	if tb.has(1) {
+		t.Fatalf("expecting rejection until the debt is repaid; tokens=%v", tb.tokens)
+	}
+	tb.refill(now.Add(time.Hour + 9*time.Second))
+	if !tb.has(10) {
+		t.Fatalf("expecting repaid debt; tokens=%v", tb.tokens)
+	}
+
+	// Decreased burst limits the tokens.
+	tb.setLimits(10, 5, now.Add(time.Hour+9*time.Second))
+	if tb.tokens != 5 {
+		t.Fatalf("unexpected tokens after burst decrease; got %v; want 5", tb.tokens)
+	}
+
+	// Zero rate means no limit.
+	var tbUnlimited tokenBucket
+	tbUnlimited.setLimits(0, 0, now)
+	tbUnlimited.take(1e9)
+	if !tbUnlimited.has(1e9) {
+		t.Fatalf("unexpected rejection for unlimited bucket")
+	}
+
+	// Burst defaults to rate.
+	var tbDefaultBurst tokenBucket
+	tbDefaultBurst.setLimits(10, 0, now)
+	if tbDefaultBurst.burst != 10 || tbDefaultBurst.tokens != 10 {
+		t.Fatalf("unexpected burst; got %v; want 10", tbDefaultBurst.burst)
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	o, err := parseTenantLimits([]byte(`
+"1:2":
+  rate_limit_lines: 10
+  rate_limit_bytes: 1000
+  burst_bytes: 2000
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now := time.Unix(1603195200, 0)
+	at := &auth.Token{AccountID: 1, ProjectID: 2}
+	var rl rateLimiter
+	if !rl.allow(o, at, 10, 1500, now) {
+		t.Fatalf("unexpected rejection")
+	}
+	// Lines limit is exceeded.
+	if rl.allow(o, at, 1, 1, now) {
+		t.Fatalf("expecting rejection because of lines limit")
+	}
+	// Rejected requests don't consume tokens.
+	now = now.Add(time.Second)
+	if !rl.allow(o, at, 10, 10, now) {
+		t.Fatalf("unexpected rejection")
+	}
+	// Bytes limit is exceeded.
+	now = now.Add(time.Second)
+	if !rl.allow(o, at, 1, 1000, now) {
+		t.Fatalf("unexpected rejection")
+	}
+	if rl.allow(o, at, 1, 1001, now) {
+		t.Fatalf("expecting rejection because of bytes limit")
+	}
+
+	// Updated limits are applied.
+	o, err = parseTenantLimits([]byte(`"1:2": {rate_limit_lines: 0, rate_limit_bytes: 0}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !rl.allow(o, at, 1e6, 1e9, now) {
+		t.Fatalf("unexpected rejection after removing the limits")
+	}
+}
+
+func TestParseTenantLimitsSuccess(t *testing.T) {
+	o, err := parseTenantLimits([]byte(`
+"1:0":
+  rate_limit_lines: 1000
+  burst_lines: 2000
+"2":
+  rate_limit_bytes: 1048576
+42:
+  burst_bytes: 10
+"3:4":
+"5:6":
+  max_past_age: 24h
+  max_future_skew: 5m
+"7":
+  max_entry_size: 1048576
+  truncate_long_entries: true
+"8":
+  max_entry_size: 0
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f := func(at *auth.Token, limitsExpected Limits) {
+		t.Helper()
+		limits := o.getLimits(at)
+		if limitsExpected.MaxEntrySize == 0 {
+			limitsExpected.MaxEntrySize = maxEntrySize.N
+		}
+		if limits != limitsExpected {
+			t.Fatalf("unexpected limits for %d:%d;\ngot\n%+v\nwant\n%+v", at.AccountID, at.ProjectID, limits, limitsExpected)
+		}
+	}
+	f(&auth.Token{AccountID: 1}, Limits{RateLimitLines: 1000, BurstLines: 2000})
+	f(&auth.Token{AccountID: 2}, Limits{RateLimitBytes: 1048576})
+	f(&auth.Token{AccountID: 42}, Limits{BurstBytes: 10})
+	f(&auth.Token{AccountID: 3, ProjectID: 4}, Limits{})
+	f(&auth.Token{AccountID: 5, ProjectID: 6}, Limits{MaxPastAge: 24 * time.Hour, MaxFutureSkew: 5 * time.Minute})
+	f(&auth.Token{AccountID: 5}, Limits{})
+	f(&auth.Token{AccountID: 7}, Limits{MaxEntrySize: 1048576, TruncateLongEntries: true})
+	f(&auth.Token{AccountID: 8}, Limits{MaxEntrySize: storage.MaxEntrySize})
+}
+
+func TestParseTenantLimitsFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		if _, err := parseTenantLimits([]byte(s)); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+	f(`foo`)
+	f(`"foo": {rate_limit_lines: 10}`)
+	f(`"1": {unknown_limit: 10}`)
+	f(`"1": {rate_limit_lines: -1}`)
+	f(`"1": {rate_limit_lines: foo}`)
+	f("\"1\": {rate_limit_lines: 10}\n\"1:0\": {burst_lines: 10}")
+	f(`"1": {max_past_age: foo}`)
+	f(`"1": {max_future_skew: -1h}`)
+	f(`"1": {max_entry_size: -1}`)
+	f(`"1": {max_entry_size: 4194305}`)
+	f(`"1": {truncate_long_entries: foo}`)
+}
@@ -48,7 +48,7 @@ func StreamExportPrometheusLine(qw422016 *qt422016.Writer, xb *exportBlock) {
 //line app/vmselect/loki/export.qtpl:14
 		qw422016.N().S(` `)
 //line app/vmselect/loki/export.qtpl:15
-		qw422016.N().DL(ts)
+		qw422016.N().DL(ts / 1e6)
 //line app/vmselect/loki/export.qtpl:15
 		qw422016.N().S(`
 `)
@@ -81,11 +81,12 @@ func FederateHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter,
 	if err != nil {
 		return err
 	}
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(start, end)
 	sq := &storage.SearchQuery{
 		AccountID:    at.AccountID,
 		ProjectID:    at.ProjectID,
-		MinTimestamp: start,
-		MaxTimestamp: end,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  tagFilterss,
 	}
 	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, 2, deadline)
@@ -150,11 +151,12 @@ func ExportNativeHandler(startTime time.Time, at *auth.Token, w http.ResponseWri
 	if err != nil {
 		return err
 	}
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(start, end)
 	sq := &storage.SearchQuery{
 		AccountID:    at.AccountID,
 		ProjectID:    at.ProjectID,
-		MinTimestamp: start,
-		MaxTimestamp: end,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  tagFilterss,
 	}
 	w.Header().Set("Content-Type", "VictoriaMetrics/native")
@@ -163,8 +165,8 @@ func ExportNativeHandler(startTime time.Time, at *auth.Token, w http.ResponseWri
 
 	// Marshal tr
 	trBuf := make([]byte, 0, 16)
-	trBuf = encoding.MarshalInt64(trBuf, start)
-	trBuf = encoding.MarshalInt64(trBuf, end)
+	trBuf = encoding.MarshalInt64(trBuf, sq.MinTimestamp)
+	trBuf = encoding.MarshalInt64(trBuf, sq.MaxTimestamp)
 	_, _ = bw.Write(trBuf)
 
 	// Marshal native blocks.
@@ -310,11 +312,12 @@ func exportHandler(at *auth.Token, w http.ResponseWriter, r *http.Request, match
 	if err != nil {
 		return err
 	}
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(start, end)
 	sq := &storage.SearchQuery{
 		AccountID:    at.AccountID,
 		ProjectID:    at.ProjectID,
-		MinTimestamp: start,
-		MaxTimestamp: end,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  tagFilterss,
 	}
 	w.Header().Set("Content-Type", contentType)
@@ -361,7 +364,7 @@ func exportHandler(at *auth.Token, w http.ResponseWriter, r *http.Request, match
 				}
 				xb := exportBlockPool.Get().(*exportBlock)
 				xb.mn = mn
-				xb.timestamps, xb.datas = b.AppendRowsWithTimeRangeFilter(xb.timestamps[:0], xb.datas[:0], tr)
+				xb.timestamps, _, xb.datas = b.AppendRowsWithTimeRangeFilter(xb.timestamps[:0], nil, xb.datas[:0], tr)
 				for i := 0; i < len(xb.timestamps); i++ {
 					xb.values = append(xb.values, 1)
 				}
@@ -562,11 +565,12 @@ func labelValuesWithMatches(at *auth.Token, labelName string, matches []string,
 	if start >= end {
 		end = start + defaultStep
 	}
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(start, end)
 	sq := &storage.SearchQuery{
 		AccountID:    at.AccountID,
 		ProjectID:    at.ProjectID,
-		MinTimestamp: start,
-		MaxTimestamp: end,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  tagFilterss,
 	}
 	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, 0, deadline)
@@ -740,11 +744,12 @@ func labelsWithMatches(at *auth.Token, matches []string, start, end int64, deadl
 	if start >= end {
 		end = start + defaultStep
 	}
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(start, end)
 	sq := &storage.SearchQuery{
 		AccountID:    at.AccountID,
 		ProjectID:    at.ProjectID,
-		MinTimestamp: start,
-		MaxTimestamp: end,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  tagFilterss,
 	}
 	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, 0, deadline)
@@ -837,11 +842,12 @@ func SeriesHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 	if start >= end {
 		end = start + defaultStep
 	}
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(start, end)
 	sq := &storage.SearchQuery{
 		AccountID:    at.AccountID,
 		ProjectID:    at.ProjectID,
-		MinTimestamp: start,
-		MaxTimestamp: end,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  tagFilterss,
 	}
 	rss, isPartial, err := netstorage.ProcessSearchQuery(at, sq, 0, deadline)
@@ -995,9 +1001,14 @@ func QueryHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r
 	}
 	if queryOffset > 0 {
 		for i := range result {
+			offset := queryOffset
+			if len(result[i].Datas) > 0 {
+				// Log streams contain timestamps in nanoseconds.
+				offset *= 1e6
+			}
 			timestamps := result[i].Timestamps
 			for j := range timestamps {
-				timestamps[j] += queryOffset
+				timestamps[j] += offset
 			}
 		}
 	}
@@ -1108,16 +1119,19 @@ func TailHandler(startTime time.Time, at *auth.Token, w http.ResponseWriter, r *
 		if err != nil {
 			return fmt.Errorf("error when executing query=%q on the time range (start=%d, end=%d, limit=%d): %w", query, start, end, limit, err)
 		}
+		// Log lines are returned with nanosecond timestamps, while start and end are in milliseconds.
+		// The filter contains nanosecond timestamps for the last returned line per stream,
+		// so lines from the same millisecond aren't returned twice.
 		for _, rs := range result {
 			lastTs = rs.Timestamps[len(rs.Timestamps)-1]
-			if lastTs > start {
-				start = lastTs
+			if lastTs/1e6 > start {
+				start = lastTs / 1e6
 			}
 			filter[rs.MetricNameHash] = lastTs
 			limit -= int64(len(rs.Timestamps))
 		}
 		for hashKey, lastTs := range filter {
-			if end-lastTs > defaultStep {
+			if end-lastTs/1e6 > defaultStep {
 				delete(filter, hashKey)
 			}
 		}
@@ -114,7 +114,7 @@ func StreamStreamsQueryResponse(qw422016 *qt422016.Writer, rs []netstorage.Resul
 //line app/vmselect/loki/query_response.qtpl:41
 		qw422016.N().S(`,"value": ["`)
 //line app/vmselect/loki/query_response.qtpl:42
-		qw422016.N().DL(rs[0].Timestamps[0] * 1e6)
+		qw422016.N().DL(rs[0].Timestamps[0])
 //line app/vmselect/loki/query_response.qtpl:42
 		qw422016.N().S(`",`)
 //line app/vmselect/loki/query_response.qtpl:42
@@ -136,7 +136,7 @@ func StreamStreamsQueryResponse(qw422016 *qt422016.Writer, rs []netstorage.Resul
 //line app/vmselect/loki/query_response.qtpl:48
 			qw422016.N().S(`,"value": ["`)
 //line app/vmselect/loki/query_response.qtpl:49
-			qw422016.N().DL(r.Timestamps[0] * 1e6)
+			qw422016.N().DL(r.Timestamps[0])
 //line app/vmselect/loki/query_response.qtpl:49
 			qw422016.N().S(`",`)
 //line app/vmselect/loki/query_response.qtpl:49
@@ -193,7 +193,7 @@ func streamdatasWithTimestamps(qw422016 *qt422016.Writer, values [][]byte, times
 //line app/vmselect/loki/util.qtpl:50
 	qw422016.N().S(`["`)
 //line app/vmselect/loki/util.qtpl:51
-	qw422016.N().DL(timestamps[0] * 1e6)
+	qw422016.N().DL(timestamps[0])
 //line app/vmselect/loki/util.qtpl:51
 	qw422016.N().S(`",`)
 //line app/vmselect/loki/util.qtpl:51
@@ -218,7 +218,7 @@ func streamdatasWithTimestamps(qw422016 *qt422016.Writer, values [][]byte, times
 //line app/vmselect/loki/util.qtpl:62
 			qw422016.N().S(`,["`)
 //line app/vmselect/loki/util.qtpl:63
-			qw422016.N().DL(timestamps[i] * 1e6)
+			qw422016.N().DL(timestamps[i])
 //line app/vmselect/loki/util.qtpl:63
 			qw422016.N().S(`",`)
 //line app/vmselect/loki/util.qtpl:63
@@ -38,6 +38,9 @@ var (
 	minScrapeInterval = flag.Duration("dedup.minScrapeInterval", 0, "Remove superflouos samples from time series if they are located closer to each other than this duration. "+
 		"This may be useful for reducing overhead when multiple identically configured Prometheus instances write data to the same VictoriaMetrics. "+
 		"Deduplication is disabled if the -dedup.minScrapeInterval is 0")
+	exactDedup = flag.Bool("dedup.exactDuplicates", false, "Remove log lines with identical timestamps and contents from the same stream. "+
+		"This may be useful for removing duplicates from replicated writes (see -replicationFactor at vminsert) and from retries of log shippers. "+
+		"Unlike -dedup.minScrapeInterval, this keeps distinct log lines with the same timestamp")
 	storageNodes = flagutil.NewArray("storageNode", "Addresses of vmstorage nodes; usage: -storageNode=vmstorage-host1:8401 -storageNode=vmstorage-host2:8401")
 )
 
@@ -66,6 +69,7 @@ func main() {
 	logger.Infof("starting netstorage at storageNodes %s", *storageNodes)
 	startTime := time.Now()
 	storage.SetMinScrapeIntervalForDeduplication(*minScrapeInterval)
+	storage.SetExactDeduplication(*exactDedup)
 	if len(*storageNodes) == 0 {
 		logger.Fatalf("missing -storageNode arg")
 	}
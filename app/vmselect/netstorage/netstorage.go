@@ -375,10 +375,11 @@ func mergeSortBlocks(dst *Result, sbh sortBlocksHeap) {
 		}
 		sbNext := sbh[0]
 		tsNext := sbNext.Timestamps[sbNext.NextIdx]
+		seqNext := sbNext.seq(sbNext.NextIdx)
 		idxNext := len(top.Timestamps)
-		if top.Timestamps[idxNext-1] > tsNext {
+		if !top.rowLessOrEqual(idxNext-1, tsNext, seqNext) {
 			idxNext = top.NextIdx
-			for top.Timestamps[idxNext] <= tsNext {
+			for top.rowLessOrEqual(idxNext, tsNext, seqNext) {
 				idxNext++
 			}
 		}
@@ -411,12 +412,36 @@ type sortBlock struct {
 	Timestamps []int64
 	Values     [][]byte
 	NextIdx    int
+
+	// Seqs contains ingestion sequence numbers for Values.
+	// It is empty if Values aren't fetched.
+	Seqs []uint64
 }
 
 func (sb *sortBlock) reset() {
 	sb.Timestamps = sb.Timestamps[:0]
 	sb.Values = sb.Values[:0]
 	sb.NextIdx = 0
+	sb.Seqs = sb.Seqs[:0]
+}
+
+// seq returns ingestion sequence number for the row at idx.
+func (sb *sortBlock) seq(idx int) uint64 {
+	if len(sb.Seqs) == 0 {
+		return 0
+	}
+	return sb.Seqs[idx]
+}
+
+// rowLessOrEqual returns true if the row at idx doesn't go after the row with the given timestamp and seq.
+//
+// Rows with identical timestamps are ordered by ingestion sequence numbers.
+func (sb *sortBlock) rowLessOrEqual(idx int, timestamp int64, seq uint64) bool {
+	ts := sb.Timestamps[idx]
+	if ts != timestamp {
+		return ts < timestamp
+	}
+	return sb.seq(idx) <= seq
 }
 
 func (sb *sortBlock) unpackFrom(tmpBlock *storage.Block, tbf *tmpBlocksFile, addr tmpBlockAddr, tr storage.TimeRange, at *auth.Token) error {
@@ -425,7 +450,7 @@ func (sb *sortBlock) unpackFrom(tmpBlock *storage.Block, tbf *tmpBlocksFile, add
 	if err := tmpBlock.UnmarshalData(false); err != nil {
 		return fmt.Errorf("cannot unmarshal block: %w", err)
 	}
-	sb.Timestamps, sb.Values = tmpBlock.AppendRowsWithTimeRangeFilter(sb.Timestamps[:0], sb.Values[:0], tr)
+	sb.Timestamps, sb.Seqs, sb.Values = tmpBlock.AppendRowsWithTimeRangeFilter(sb.Timestamps[:0], sb.Seqs[:0], sb.Values[:0], tr)
 	skippedRows := tmpBlock.RowsCount() - len(sb.Timestamps)
 	metricRowsSkipped.Add(skippedRows)
 	return nil
@@ -440,7 +465,12 @@ func (sbh sortBlocksHeap) Len() int {
 func (sbh sortBlocksHeap) Less(i, j int) bool {
 	a := sbh[i]
 	b := sbh[j]
-	return a.Timestamps[a.NextIdx] < b.Timestamps[b.NextIdx]
+	tsA := a.Timestamps[a.NextIdx]
+	tsB := b.Timestamps[b.NextIdx]
+	if tsA != tsB {
+		return tsA < tsB
+	}
+	return a.seq(a.NextIdx) < b.seq(b.NextIdx)
 }
 
 func (sbh sortBlocksHeap) Swap(i, j int) {
@@ -1229,10 +1259,10 @@ func (sn *storageNode) deleteMetrics(requestData []byte, deadline searchutils.De
 		deletedCount += n
 		return nil
 	}
-	if err := sn.execOnConn("deleteMetrics_v3", f, deadline); err != nil {
+	if err := sn.execOnConn("deleteMetrics_v5", f, deadline); err != nil {
 		// Try again before giving up.
 		// There is no need in zeroing deletedCount.
-		if err = sn.execOnConn("deleteMetrics_v3", f, deadline); err != nil {
+		if err = sn.execOnConn("deleteMetrics_v5", f, deadline); err != nil {
 			return deletedCount, err
 		}
 	}
@@ -1290,10 +1320,10 @@ func (sn *storageNode) getTagValueSuffixes(accountID, projectID uint32, tr stora
 		suffixes = ss
 		return nil
 	}
-	if err := sn.execOnConn("tagValueSuffixes_v1", f, deadline); err != nil {
+	if err := sn.execOnConn("tagValueSuffixes_v2", f, deadline); err != nil {
 		// Try again before giving up.
 		suffixes = nil
-		if err = sn.execOnConn("tagValueSuffixes_v1", f, deadline); err != nil {
+		if err = sn.execOnConn("tagValueSuffixes_v2", f, deadline); err != nil {
 			return nil, err
 		}
 	}
@@ -1370,9 +1400,9 @@ func (sn *storageNode) processSearchQuery(requestData []byte, fetchData uint8, p
 		blocksRead = n
 		return nil
 	}
-	if err := sn.execOnConn("search_v5", f, deadline); err != nil && blocksRead == 0 {
+	if err := sn.execOnConn("search_v8", f, deadline); err != nil && blocksRead == 0 {
 		// Try again before giving up if zero blocks read on the previous attempt.
-		if err = sn.execOnConn("search_v5", f, deadline); err != nil {
+		if err = sn.execOnConn("search_v8", f, deadline); err != nil {
 			return err
 		}
 	}
@@ -1774,7 +1804,9 @@ func (sn *storageNode) getSeriesCountOnConn(bc *handshake.BufferedConn, accountI
 }
 
 // maxMetricBlockSize is the maximum size of serialized MetricBlock.
-const maxMetricBlockSize = 1024 * 1024
+//
+// Blocks with log lines up to storage.MaxEntrySize may be much bigger than 1MB.
+const maxMetricBlockSize = storage.MaxMetricBlockSize
 
 // maxErrorMessageSize is the maximum size of error message received
 // from vmstorage.
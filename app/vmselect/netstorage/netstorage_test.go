@@ -0,0 +1,158 @@
+package netstorage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/handshake"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func TestProcessSearchQueryLargeEntry(t *testing.T) {
+	path := "TestProcessSearchQueryLargeEntry"
+	defer func() {
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatalf("cannot remove storage %q: %s", path, err)
+		}
+	}()
+
+	// Store incompressible log line of 1MB together with small lines in the same stream.
+	largeLine := make([]byte, 1024*1024)
+	rand.Read(largeLine)
+	linesExpected := [][]byte{[]byte("small line 1"), largeLine, []byte("small line 2")}
+	metricNameRaw := storage.MarshalMetricNameRaw(nil, 12, 34, []storage.Label{{
+		Name:  []byte("job"),
+		Value: []byte("large"),
+	}})
+	startTimestamp := time.Now().UnixNano()
+	mrs := make([]storage.MetricRow, len(linesExpected))
+	for i, line := range linesExpected {
+		mrs[i] = storage.MetricRow{
+			MetricNameRaw: metricNameRaw,
+			Timestamp:     startTimestamp + int64(i),
+			Value:         line,
+		}
+	}
+	st, err := storage.OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot open storage %q: %s", path, err)
+	}
+	if err := st.AddRows(mrs, 64); err != nil {
+		t.Fatalf("cannot add rows: %s", err)
+	}
+
+	// Re-open the storage in order to flush the added rows to parts.
+	st.MustClose()
+	st, err = storage.OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot re-open storage %q: %s", path, err)
+	}
+	tfs := storage.NewTagFilters(12, 34)
+	if err := tfs.Add([]byte("job"), []byte("large"), false, false); err != nil {
+		t.Fatalf("cannot add tag filter: %s", err)
+	}
+	tr := storage.TimeRange{
+		MinTimestamp: startTimestamp,
+		MaxTimestamp: startTimestamp + int64(len(linesExpected)),
+	}
+	var dataBufs [][]byte
+	var sr storage.Search
+	sr.Init(st, []*storage.TagFilters{tfs}, tr, nil, 1e3, 1<<64-1)
+	for sr.NextMetricBlock() {
+		var mb storage.MetricBlock
+		mb.MetricName = sr.MetricBlockRef.MetricName
+		sr.MetricBlockRef.BlockRef.MustReadBlock(&mb.Block, 2)
+		dataBufs = append(dataBufs, mb.Marshal(nil))
+	}
+	if err := sr.Error(); err != nil {
+		t.Fatalf("search error: %s", err)
+	}
+	sr.MustClose()
+	st.MustClose()
+
+	// Send the found blocks from vmstorage to vmselect.
+	serverConn, clientConn := net.Pipe()
+	serverErrCh := make(chan error, 1)
+	go func() {
+		serverErrCh <- sendMetricBlocks(serverConn, dataBufs)
+	}()
+	bc, err := handshake.VMSelectClient(clientConn, 0)
+	if err != nil {
+		t.Fatalf("cannot perform vmselect handshake: %s", err)
+	}
+	defer func() {
+		_ = bc.Close()
+	}()
+	sn := &storageNode{
+		metricBlocksRead: &metrics.Counter{},
+		metricRowsRead:   &metrics.Counter{},
+	}
+	var lines [][]byte
+	blocksRead, err := sn.processSearchQueryOnConn(bc, []byte("request"), 2, func(mb *storage.MetricBlock) error {
+		if err := mb.Block.UnmarshalData(true); err != nil {
+			return fmt.Errorf("cannot unmarshal block data: %w", err)
+		}
+		_, _, values := mb.Block.AppendRowsWithTimeRangeFilter(nil, nil, nil, tr)
+		for _, v := range values {
+			lines = append(lines, append([]byte{}, v...))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot process search query: %s", err)
+	}
+	if err := <-serverErrCh; err != nil {
+		t.Fatalf("cannot send metric blocks: %s", err)
+	}
+	if blocksRead != len(dataBufs) {
+		t.Fatalf("unexpected number of blocks read; got %d; want %d", blocksRead, len(dataBufs))
+	}
+	if len(lines) != len(linesExpected) {
+		t.Fatalf("unexpected number of lines; got %d; want %d", len(lines), len(linesExpected))
+	}
+	for i, line := range lines {
+		if !bytes.Equal(line, linesExpected[i]) {
+			t.Fatalf("unexpected line #%d with length %d; want line with length %d", i, len(line), len(linesExpected[i]))
+		}
+	}
+}
+
+// sendMetricBlocks responds to a single search query at c with the given marshaled metric blocks.
+func sendMetricBlocks(c net.Conn, dataBufs [][]byte) error {
+	bc, err := handshake.VMSelectServer(c, 0)
+	if err != nil {
+		return fmt.Errorf("cannot perform vmselect handshake: %w", err)
+	}
+	defer func() {
+		_ = bc.Close()
+	}()
+
+	// Read the request and fetchData.
+	if _, err := readBytes(nil, bc, 1024); err != nil {
+		return fmt.Errorf("cannot read request: %w", err)
+	}
+	if _, err := io.ReadFull(bc, make([]byte, 1)); err != nil {
+		return fmt.Errorf("cannot read fetchData: %w", err)
+	}
+
+	// Send empty error message, the blocks and the 'end of response' marker.
+	if err := writeBytes(bc, nil); err != nil {
+		return fmt.Errorf("cannot send empty error message: %w", err)
+	}
+	for _, dataBuf := range dataBufs {
+		if err := writeBytes(bc, dataBuf); err != nil {
+			return fmt.Errorf("cannot send MetricBlock: %w", err)
+		}
+	}
+	if err := writeBytes(bc, nil); err != nil {
+		return fmt.Errorf("cannot send 'end of response' marker: %w", err)
+	}
+	return bc.Flush()
+}
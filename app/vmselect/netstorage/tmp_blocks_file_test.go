@@ -53,11 +53,13 @@ func testTmpBlocksFile() error {
 	createBlock := func() *storage.Block {
 		rowsCount := rand.Intn(8000) + 1
 		var timestamps []int64
+		var seqs []uint64
 		var values [][]byte
 		ts := int64(rand.Intn(1023434))
 		for i := 0; i < rowsCount; i++ {
 			ts += int64(rand.Intn(1000) + 1)
 			timestamps = append(timestamps, ts)
+			seqs = append(seqs, uint64(i))
 			values = append(values, []byte{byte(i*i + rand.Intn(20))})
 		}
 		tsid := &storage.TSID{
@@ -65,7 +67,7 @@ func testTmpBlocksFile() error {
 		}
 		precisionBits := uint8(rand.Intn(63) + 1)
 		var b storage.Block
-		b.Init(tsid, timestamps, values, precisionBits)
+		b.Init(tsid, timestamps, seqs, values, precisionBits)
 		_, _, _ = b.MarshalData(0, 0)
 		return &b
 	}
@@ -122,11 +124,14 @@ func testTmpBlocksFile() error {
 							if b1.RowsCount() != b.RowsCount() {
 								return fmt.Errorf("unexpected number of rows in tbf block; got %d; want %d", b1.RowsCount(), b.RowsCount())
 							}
-							timestamps1, values1 := b1.AppendRowsWithTimeRangeFilter(nil, nil, tr)
-							timestamps, values := b.AppendRowsWithTimeRangeFilter(nil, nil, tr)
+							timestamps1, seqs1, values1 := b1.AppendRowsWithTimeRangeFilter(nil, nil, nil, tr)
+							timestamps, seqs, values := b.AppendRowsWithTimeRangeFilter(nil, nil, nil, tr)
 							if !reflect.DeepEqual(timestamps1, timestamps) {
 								return fmt.Errorf("unexpected timestamps; got\n%v\nwant\n%v", timestamps1, timestamps)
 							}
+							if !reflect.DeepEqual(seqs1, seqs) {
+								return fmt.Errorf("unexpected seqs; got\n%v\nwant\n%v", seqs1, seqs)
+							}
 							if !reflect.DeepEqual(values1, values) {
 								return fmt.Errorf("unexpected values; got\n%v\nwant\n%v", values1, values)
 							}
@@ -496,6 +496,52 @@ func binaryOpNotMatch(bfa *binaryOpFuncArg) ([]*timeseries, error) {
 	return rvs, nil
 }
 
+// getLineFilters returns MetricExpr and line filters for be if it contains
+// a chain of line filters applied to a MetricExpr, i.e. `{...} |= "a" !~ "b"`.
+//
+// nil MetricExpr is returned if be cannot be converted to line filters.
+func getLineFilters(be *logql.BinaryOpExpr) (*logql.MetricExpr, []storage.LineFilter) {
+	var lfs []storage.LineFilter
+	var e logql.Expr = be
+	for {
+		switch t := e.(type) {
+		case *logql.MetricExpr:
+			if t.IsEmpty() {
+				return nil, nil
+			}
+			// Return line filters in the order they are written in the query.
+			for i, j := 0, len(lfs)-1; i < j; i, j = i+1, j-1 {
+				lfs[i], lfs[j] = lfs[j], lfs[i]
+			}
+			return t, lfs
+		case *logql.BinaryOpExpr:
+			se, ok := t.Right.(*logql.StringExpr)
+			if !ok || t.Bool || t.GroupModifier.Op != "" || t.JoinModifier.Op != "" {
+				return nil, nil
+			}
+			lf := storage.LineFilter{
+				Value: []byte(se.S),
+			}
+			switch strings.ToLower(t.Op) {
+			case "|=":
+			case "!=":
+				lf.IsNegative = true
+			case "|~":
+				lf.IsRegexp = true
+			case "!~":
+				lf.IsNegative = true
+				lf.IsRegexp = true
+			default:
+				return nil, nil
+			}
+			lfs = append(lfs, lf)
+			e = t.Left
+		default:
+			return nil, nil
+		}
+	}
+}
+
 func createTimeseriesMapByTagSet(be *logql.BinaryOpExpr, left, right []*timeseries) (map[string][]*timeseries, map[string][]*timeseries) {
 	groupTags := be.GroupModifier.Args
 	groupOp := strings.ToLower(be.GroupModifier.Op)
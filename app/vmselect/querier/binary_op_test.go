@@ -0,0 +1,43 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+)
+
+func TestGetLineFilters(t *testing.T) {
+	f := func(q string, lfsExpected string) {
+		t.Helper()
+		e, err := logql.Parse(q)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", q, err)
+		}
+		be, ok := e.(*logql.BinaryOpExpr)
+		if !ok {
+			t.Fatalf("expecting BinaryOpExpr; got %T", e)
+		}
+		me, lfs := getLineFilters(be)
+		if me == nil {
+			if lfsExpected != "" {
+				t.Fatalf("expecting non-nil MetricExpr for %q", q)
+			}
+			return
+		}
+		var s string
+		for i := range lfs {
+			s += lfs[i].String()
+		}
+		if s != lfsExpected {
+			t.Fatalf("unexpected line filters for %q;\ngot\n%s\nwant\n%s", q, s, lfsExpected)
+		}
+	}
+	f(`{app="foo"} |= "bar"`, `{Value="bar", IsNegative: false, IsRegexp: false}`)
+	f(`{app="foo"} |~ "b.r"`, `{Value="b.r", IsNegative: false, IsRegexp: true}`)
+	f(`{app="foo"} !~ "b.r"`, `{Value="b.r", IsNegative: true, IsRegexp: true}`)
+	f(`{app="foo"} |= "bar" |~ "baz"`, `{Value="bar", IsNegative: false, IsRegexp: false}{Value="baz", IsNegative: false, IsRegexp: true}`)
+
+	// Expressions, which cannot be pushed down to vmstorage.
+	f(`{app="foo"} + 1`, ``)
+	f(`sum({app="foo"}) |= "bar"`, ``)
+}
@@ -183,7 +183,7 @@ func getTimestamps(start, end, step int64) []int64 {
 func evalExpr(ec *EvalConfig, e logql.Expr, isRoot bool) ([]*timeseries, error) {
 	if me, ok := e.(*logql.MetricExpr); ok {
 		if isRoot {
-			return evalMetricExpr(ec, me)
+			return evalMetricExpr(ec, me, nil)
 		}
 		re := &logql.RollupExpr{
 			Expr: me,
@@ -275,6 +275,13 @@ func evalExpr(ec *EvalConfig, e logql.Expr, isRoot bool) ([]*timeseries, error)
 		return rv, nil
 	}
 	if be, ok := e.(*logql.BinaryOpExpr); ok {
+		if isRoot {
+			if me, lfs := getLineFilters(be); me != nil {
+				// Fast path - push down line filters to vmstorage,
+				// so only the matching rows are sent to vmselect.
+				return evalMetricExpr(ec, me, lfs)
+			}
+		}
 		left, err := evalExpr(ec, be.Left, isRoot)
 		if err != nil {
 			return nil, err
@@ -549,6 +556,10 @@ func evalRollupFuncWithSubquery(ec *EvalConfig, name string, rf rollupFunc, expr
 	removeMetricGroup := !rollupFuncsKeepMetricGroup[name]
 	doParallel(tssSQ, func(tsSQ *timeseries, values []float64, timestamps []int64) ([]float64, []int64) {
 		values, timestamps = removeNanValues(values[:0], timestamps[:0], tsSQ.Values, tsSQ.Timestamps)
+		if len(tsSQ.Datas) > 0 {
+			// Log streams contain timestamps in nanoseconds.
+			timestampsToMsecs(timestamps)
+		}
 		preFunc(values, timestamps)
 		for _, rc := range rcs {
 			if tsm := newTimeseriesMap(name, sharedTimestamps, &tsSQ.MetricName); tsm != nil {
@@ -629,19 +640,28 @@ var (
 	errReachedLimit = fmt.Errorf("reached limit")
 )
 
-func evalMetricExpr(ec *EvalConfig, me *logql.MetricExpr) ([]*timeseries, error) {
+func evalMetricExpr(ec *EvalConfig, me *logql.MetricExpr, lfs []storage.LineFilter) ([]*timeseries, error) {
 	if me.IsEmpty() {
 		return evalNumber(ec, nan), nil
 	}
+	if len(lfs) > 0 {
+		// Validate line filters before sending them to vmstorage nodes.
+		if _, err := storage.NewLineFilters(lfs); err != nil {
+			return nil, err
+		}
+	}
 
 	tfs := toTagFilters(me.LabelFilters)
 
+	// Log lines are returned with nanosecond timestamps.
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(ec.Start, ec.End)
 	sq := &storage.SearchQuery{
 		AccountID:    ec.AuthToken.AccountID,
 		ProjectID:    ec.AuthToken.ProjectID,
-		MinTimestamp: ec.Start,
-		MaxTimestamp: ec.End,
+		MinTimestamp: minTimestamp,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  [][]storage.TagFilter{tfs},
+		LineFilters:  lfs,
 	}
 	rss, isPartial, err := netstorage.ProcessSearchQuery(ec.AuthToken, sq, 2, ec.Deadline)
 	if err != nil {
@@ -749,11 +769,12 @@ func evalRollupFuncWithMetricExpr(ec *EvalConfig, name string, rf rollupFunc,
 	} else {
 		minTimestamp -= ec.Step
 	}
+	minTimestamp, maxTimestamp := searchutils.ToNsecTimeRange(minTimestamp, ec.End)
 	sq := &storage.SearchQuery{
 		AccountID:    ec.AuthToken.AccountID,
 		ProjectID:    ec.AuthToken.ProjectID,
 		MinTimestamp: minTimestamp,
-		MaxTimestamp: ec.End,
+		MaxTimestamp: maxTimestamp,
 		TagFilterss:  [][]storage.TagFilter{tfs},
 	}
 	rss, isPartial, err := netstorage.ProcessSearchQuery(ec.AuthToken, sq, 1, ec.Deadline)
@@ -846,6 +867,7 @@ func getRollupMemoryLimiter() *memoryLimiter {
 func evalRollupWithIncrementalAggregate(name string, iafc *incrementalAggrFuncContext, rss *netstorage.Results, rcs []*rollupConfig,
 	preFunc func(values []float64, timestamps []int64), sharedTimestamps []int64, removeMetricGroup bool) ([]*timeseries, error) {
 	err := rss.RunParallel(func(rs *netstorage.Result, workerID uint) error {
+		timestampsToMsecs(rs.Timestamps)
 		preFunc(rs.Values, rs.Timestamps)
 		ts := getTimeseries()
 		defer putTimeseries(ts)
@@ -879,6 +901,7 @@ func evalRollupNoIncrementalAggregate(name string, rss *netstorage.Results, rcs
 	tss := make([]*timeseries, 0, rss.Len()*len(rcs))
 	var tssLock sync.Mutex
 	err := rss.RunParallel(func(rs *netstorage.Result, workerID uint) error {
+		timestampsToMsecs(rs.Timestamps)
 		preFunc(rs.Values, rs.Timestamps)
 		for _, rc := range rcs {
 			if tsm := newTimeseriesMap(name, sharedTimestamps, &rs.MetricName); tsm != nil {
@@ -902,6 +925,14 @@ func evalRollupNoIncrementalAggregate(name string, rss *netstorage.Results, rcs
 	return tss, nil
 }
 
+// timestampsToMsecs converts timestamps obtained from the storage from nanoseconds to milliseconds,
+// which are used in rollup calculations.
+func timestampsToMsecs(timestamps []int64) {
+	for i := range timestamps {
+		timestamps[i] /= 1e6
+	}
+}
+
 func doRollupForTimeseries(rc *rollupConfig, tsDst *timeseries, mnSrc *storage.MetricName, valuesSrc []float64, timestampsSrc []int64,
 	sharedTimestamps []int64, removeMetricGroup bool) {
 	tsDst.MetricName.CopyFrom(mnSrc)
@@ -78,6 +78,14 @@ func GetTime(r *http.Request, argKey string, defaultMs int64) (int64, error) {
 	return msecs, nil
 }
 
+// ToNsecTimeRange converts [startMs ... endMs] time range in milliseconds
+// to the time range in nanoseconds, which is used by the storage.
+//
+// The returned time range covers the whole endMs millisecond.
+func ToNsecTimeRange(startMs, endMs int64) (int64, int64) {
+	return startMs * 1e6, endMs*1e6 + 1e6 - 1
+}
+
 var (
 	// These constants were obtained from https://github.com/prometheus/prometheus/blob/91d7175eaac18b00e370965f3a8186cc40bf9f55/web/api/v1/api.go#L442
 	// See https://github.com/prometheus/client_golang/issues/614 for details.
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/app/vmstorage/retention"
 	"github.com/VictoriaMetrics/VictoriaLogs/app/vmstorage/transport"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/buildinfo"
@@ -31,7 +32,7 @@ var (
 	snapshotAuthKey   = flag.String("snapshotAuthKey", "", "authKey, which must be passed in query string to /snapshot* pages")
 	forceMergeAuthKey = flag.String("forceMergeAuthKey", "", "authKey, which must be passed in query string to /internal/force_merge pages")
 
-	finalMergeDelay = flag.Duration("finalMergeDelay", 30*time.Second, "The delay before starting final merge for per-month partition after no new data is ingested into it. "+
+	finalMergeDelay = flag.Duration("finalMergeDelay", 30*time.Second, "The delay before starting final merge for partition after no new data is ingested into it. "+
 		"Query speed and disk space usage is usually reduced after the final merge is complete. Too low delay for final merge may result in increased "+
 		"disk IO usage and CPU usage")
 	bigMergeConcurrency   = flag.Int("bigMergeConcurrency", 0, "The maximum number of CPU cores to use for big merges. Default value is used if set to 0")
@@ -39,6 +40,27 @@ var (
 	minScrapeInterval     = flag.Duration("dedup.minScrapeInterval", 0, "Remove superflouos samples from time series if they are located closer to each other than this duration. "+
 		"This may be useful for reducing overhead when multiple identically configured Prometheus instances write data to the same VictoriaMetrics. "+
 		"Deduplication is disabled if the -dedup.minScrapeInterval is 0")
+	exactDedup = flag.Bool("dedup.exactDuplicates", false, "Remove log lines with identical timestamps and contents from the same stream. "+
+		"This may be useful for removing duplicates from replicated writes (see -replicationFactor at vminsert) and from retries of log shippers. "+
+		"Unlike -dedup.minScrapeInterval, this keeps distinct log lines with the same timestamp")
+	maxActiveStreamsPerTenant = flag.Int("storage.maxActiveStreamsPerTenant", 0, "The maximum number of active streams per tenant at this vmstorage node. "+
+		"A stream is active if it received log lines during -storage.activeStreamsWindow. Log lines for new streams exceeding the limit are rejected "+
+		"and reported to vminsert, while the existing streams continue ingesting. The limit is applied independently at every vmstorage node, "+
+		"so the effective limit for the cluster is N*storage.maxActiveStreamsPerTenant, where N is the number of vmstorage nodes. "+
+		"There is no limit if it is set to 0")
+	activeStreamsWindow = flag.Duration("storage.activeStreamsWindow", time.Hour, "The duration for tracking active streams per tenant. "+
+		"See -storage.maxActiveStreamsPerTenant")
+	partitionInterval = flag.String("storage.partitionInterval", storage.PartitionIntervalMonth, "The time interval for new partitions. "+
+		"Supported values: month, day, hour. Smaller partitions allow deleting data outside -retentionPeriod with finer granularity "+
+		"and reduce the amount of data rewritten by final merges, forced merges and snapshots at the cost of higher number of partitions. "+
+		"Existing partitions keep their intervals after changing the flag value, while new partitions are created with the new interval")
+	walEnabled = flag.Bool("storage.wal", false, "Whether to write rows received from vminsert to write-ahead log before sending `ack` to vminsert. "+
+		"The write-ahead log is replayed on startup, so the acknowledged rows aren't lost on unclean shutdown before they are flushed to disk. "+
+		"See also -storage.walSyncPolicy")
+	walSyncPolicy = flag.String("storage.walSyncPolicy", storage.WALSyncInterval, "When to fsync write-ahead log if -storage.wal is set. "+
+		"Supported values: always - before sending `ack` to vminsert, interval - every -storage.walSyncInterval, never - rely on the OS. "+
+		"Rows may be lost on power loss for interval and never policies, while always policy has the highest disk IO overhead")
+	walSyncInterval = flag.Duration("storage.walSyncInterval", time.Second, "The interval for fsync of write-ahead log for -storage.walSyncPolicy=interval")
 )
 
 func main() {
@@ -50,9 +72,20 @@ func main() {
 	cgroup.UpdateGOMAXPROCSToCPUQuota()
 
 	storage.SetMinScrapeIntervalForDeduplication(*minScrapeInterval)
+	storage.SetExactDeduplication(*exactDedup)
 	storage.SetFinalMergeDelay(*finalMergeDelay)
 	storage.SetBigMergeWorkersCount(*bigMergeConcurrency)
 	storage.SetSmallMergeWorkersCount(*smallMergeConcurrency)
+	storage.SetMaxActiveStreamsPerTenant(*maxActiveStreamsPerTenant, *activeStreamsWindow)
+	if err := storage.SetPartitionInterval(*partitionInterval); err != nil {
+		logger.Fatalf("invalid -storage.partitionInterval: %s", err)
+	}
+	if *walEnabled {
+		if err := storage.SetWAL(*walSyncPolicy, *walSyncInterval); err != nil {
+			logger.Fatalf("invalid -storage.walSyncPolicy or -storage.walSyncInterval: %s", err)
+		}
+	}
+	retention.Init()
 
 	logger.Infof("opening storage at %q with -retentionPeriod=%s", *storageDataPath, retentionPeriod)
 	startTime := time.Now()
@@ -378,6 +411,21 @@ func registerStorageMetrics(strg *storage.Storage) {
 	metrics.NewGauge(`vm_deduplicated_samples_total{type="merge"}`, func() float64 {
 		return float64(m().DedupsDuringMerge)
 	})
+	metrics.NewGauge(`vm_retention_filter_deleted_rows_total`, func() float64 {
+		return float64(m().RetentionFilterRowsDeleted)
+	})
+	metrics.NewGauge(`vm_retention_filter_deleted_bytes_total`, func() float64 {
+		return float64(m().RetentionFilterBytesDeleted)
+	})
+	metrics.NewGauge(`vm_wal_size_bytes`, func() float64 {
+		return float64(m().WALSizeBytes)
+	})
+	metrics.NewGauge(`vm_wal_segments`, func() float64 {
+		return float64(m().WALSegmentsCount)
+	})
+	metrics.NewGauge(`vm_wal_replayed_rows_total`, func() float64 {
+		return float64(m().WALRowsReplayed)
+	})
 
 	metrics.NewGauge(`vm_rows_ignored_total{reason="big_timestamp"}`, func() float64 {
 		return float64(m().TooBigTimestampRows)
@@ -385,6 +433,15 @@ func registerStorageMetrics(strg *storage.Storage) {
 	metrics.NewGauge(`vm_rows_ignored_total{reason="small_timestamp"}`, func() float64 {
 		return float64(m().TooSmallTimestampRows)
 	})
+	metrics.NewGauge(`vm_rows_ignored_total{reason="too_many_streams"}`, func() float64 {
+		return float64(m().TooManyStreamsRows)
+	})
+	metrics.NewGauge(`vm_rows_ignored_total{reason="too_long_entry"}`, func() float64 {
+		return float64(m().TooLongEntryRows)
+	})
+	metrics.NewGauge(`vm_active_streams`, func() float64 {
+		return float64(m().ActiveStreams)
+	})
 
 	metrics.NewGauge(`vm_concurrent_addrows_limit_reached_total`, func() float64 {
 		return float64(m().AddRowsConcurrencyLimitReached)
@@ -436,6 +493,16 @@ func registerStorageMetrics(strg *storage.Storage) {
 		return float64(m().TimestampsBytesSaved)
 	})
 
+	metrics.NewGauge(`vm_bloom_filter_blocks_checked_total`, func() float64 {
+		return float64(m().BloomFilterBlocksChecked)
+	})
+	metrics.NewGauge(`vm_bloom_filter_blocks_skipped_total`, func() float64 {
+		return float64(m().BloomFilterBlocksSkipped)
+	})
+	metrics.NewGauge(`vm_bloom_filters_reused_total`, func() float64 {
+		return float64(m().BloomFiltersReused)
+	})
+
 	metrics.NewGauge(`vm_rows{type="storage/big"}`, func() float64 {
 		return float64(tm().BigRowsCount)
 	})
@@ -0,0 +1,186 @@
+package retention
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/auth"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"gopkg.in/yaml.v2"
+)
+
+var retentionConfig = flag.String("retentionConfig", "", "Optional path to a file with per-tenant retention overrides. "+
+	"The file must contain a map from tenant in the form accountID[:projectID] to retention config, e.g. '\"1:0\": {retention: 13}'. "+
+	"The retention has the same format as -retentionPeriod. The config may contain a list of filters with label selectors and retentions "+
+	"for the matching streams, e.g. '\"1:0\": {filters: [{match: \"{level=\\\"debug\\\"}\", retention: 3d}]}'. "+
+	"Partitions are deleted after the maximum retention across -retentionPeriod, all the tenants and filters, "+
+	"while the data with smaller retentions is deleted during background merges. The file is read only at startup")
+
+// Init must be called after flag.Parse and before opening the storage.
+//
+// Init passes retention overrides from -retentionConfig to the storage.
+func Init() {
+	cfg, err := loadRetentionConfig()
+	if err != nil {
+		logger.Fatalf("cannot load retentionConfig: %s", err)
+	}
+	storage.SetTenantRetentions(cfg.trs)
+	storage.SetRetentionFilters(cfg.rfs)
+	for _, tr := range cfg.trs {
+		logger.Infof("using retention of %d days for tenant %d:%d", tr.RetentionMsecs/msecsPerDay, tr.AccountID, tr.ProjectID)
+	}
+	for _, rf := range cfg.rfs {
+		logger.Infof("using retention of %d days for streams matching %s", rf.RetentionMsecs/msecsPerDay, rf.Filters)
+	}
+}
+
+const msecsPerDay = 24 * 3600 * 1000
+
+// config is parsed -retentionConfig.
+type config struct {
+	trs []storage.TenantRetention
+	rfs []storage.RetentionFilter
+}
+
+func loadRetentionConfig() (*config, error) {
+	if len(*retentionConfig) == 0 {
+		return &config{}, nil
+	}
+	data, err := ioutil.ReadFile(*retentionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -retentionConfig=%q: %w", *retentionConfig, err)
+	}
+	cfg, err := parseRetentionConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse -retentionConfig=%q: %w", *retentionConfig, err)
+	}
+	return cfg, nil
+}
+
+// tenantConfig contains retention config for a single tenant.
+type tenantConfig struct {
+	// Retention is the retention for the tenant in the format of -retentionPeriod.
+	Retention string `yaml:"retention,omitempty"`
+
+	// Filters contains retentions for the tenant streams matching label selectors.
+	// The first matching filter is applied to the stream.
+	Filters []filterConfig `yaml:"filters,omitempty"`
+}
+
+// filterConfig contains retention for streams matching label selector.
+type filterConfig struct {
+	// Match is a label selector such as `{level="debug"}`.
+	Match string `yaml:"match"`
+
+	// Retention is the retention for the matching streams in the format of -retentionPeriod.
+	Retention string `yaml:"retention"`
+}
+
+// parseRetentionConfig parses per-tenant retentions from data.
+//
+// The data must contain a map from tenant in the form accountID[:projectID] to tenantConfig.
+func parseRetentionConfig(data []byte) (*config, error) {
+	var tcs map[string]*tenantConfig
+	if err := yaml.UnmarshalStrict(data, &tcs); err != nil {
+		return nil, err
+	}
+	ats := make([]*auth.Token, 0, len(tcs))
+	m := make(map[auth.Token]*tenantConfig, len(tcs))
+	for tenant, tc := range tcs {
+		at, err := auth.NewToken(tenant)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse tenant %q: %w", tenant, err)
+		}
+		if _, ok := m[*at]; ok {
+			return nil, fmt.Errorf("duplicate retention config for tenant %q", tenant)
+		}
+		if tc == nil || tc.Retention == "" && len(tc.Filters) == 0 {
+			return nil, fmt.Errorf("missing `retention` and `filters` for tenant %q", tenant)
+		}
+		m[*at] = tc
+		ats = append(ats, at)
+	}
+	sort.Slice(ats, func(i, j int) bool {
+		a, b := ats[i], ats[j]
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		return a.ProjectID < b.ProjectID
+	})
+
+	var cfg config
+	for _, at := range ats {
+		tc := m[*at]
+		if tc.Retention != "" {
+			msecs, err := parseRetention(tc.Retention)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse `retention` for tenant %d:%d: %w", at.AccountID, at.ProjectID, err)
+			}
+			cfg.trs = append(cfg.trs, storage.TenantRetention{
+				AccountID:      at.AccountID,
+				ProjectID:      at.ProjectID,
+				RetentionMsecs: msecs,
+			})
+		}
+		for i := range tc.Filters {
+			rf, err := newRetentionFilter(at, &tc.Filters[i])
+			if err != nil {
+				return nil, fmt.Errorf("error in filter #%d for tenant %d:%d: %w", i+1, at.AccountID, at.ProjectID, err)
+			}
+			cfg.rfs = append(cfg.rfs, *rf)
+		}
+	}
+	return &cfg, nil
+}
+
+func newRetentionFilter(at *auth.Token, fc *filterConfig) (*storage.RetentionFilter, error) {
+	if fc.Match == "" {
+		return nil, fmt.Errorf("missing `match`")
+	}
+	e, err := logql.Parse(fc.Match)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse `match` %q: %w", fc.Match, err)
+	}
+	me, ok := e.(*logql.MetricExpr)
+	if !ok {
+		return nil, fmt.Errorf("expecting label selector such as `{level=\"debug\"}` in `match`; got %q", fc.Match)
+	}
+	tfs := storage.NewTagFilters(at.AccountID, at.ProjectID)
+	for i := range me.LabelFilters {
+		lf := &me.LabelFilters[i]
+		key := []byte(lf.Label)
+		if lf.Label == "__name__" {
+			key = nil
+		}
+		if err := tfs.Add(key, []byte(lf.Value), lf.IsNegative, lf.IsRegexp); err != nil {
+			return nil, fmt.Errorf("cannot parse label filter in `match` %q: %w", fc.Match, err)
+		}
+	}
+	if fc.Retention == "" {
+		return nil, fmt.Errorf("missing `retention`")
+	}
+	msecs, err := parseRetention(fc.Retention)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse `retention`: %w", err)
+	}
+	return &storage.RetentionFilter{
+		Filters:        tfs,
+		RetentionMsecs: msecs,
+	}, nil
+}
+
+func parseRetention(s string) (int64, error) {
+	var d flagutil.Duration
+	if err := d.Set(s); err != nil {
+		return 0, err
+	}
+	if d.Msecs <= 0 {
+		return 0, fmt.Errorf("retention must be positive; got %q", s)
+	}
+	return d.Msecs, nil
+}
@@ -0,0 +1,79 @@
+package retention
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseRetentionConfigSuccess(t *testing.T) {
+	f := func(data, resultExpected string) {
+		t.Helper()
+		cfg, err := parseRetentionConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var result string
+		for _, tr := range cfg.trs {
+			result += fmt.Sprintf("%d:%d=%dd;", tr.AccountID, tr.ProjectID, tr.RetentionMsecs/msecsPerDay)
+		}
+		for _, rf := range cfg.rfs {
+			result += fmt.Sprintf("%s=%dd;", rf.Filters, rf.RetentionMsecs/msecsPerDay)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+	f(``, ``)
+	f(`"1:0": {retention: 7d}`, `1:0=7d;`)
+	f(`
+"5": {retention: 1y}
+"1:2": {retention: 2w}
+"1:1": {retention: 12}
+`, `1:1=372d;1:2=14d;5:0=365d;`)
+
+	// Filters
+	f(`
+"1:0":
+  retention: 30d
+  filters:
+  - match: '{level="debug"}'
+    retention: 3d
+  - match: '{app=~"pay.+",env!="dev"}'
+    retention: 400d
+"2":
+  filters:
+  - match: '{level=""}'
+    retention: 1d
+`, `1:0=30d;AccountID=1, ProjectID=0 {level="debug"}=3d;AccountID=1, ProjectID=0 {app=~"pay.+", env!="dev"}=400d;`+
+		`AccountID=2, ProjectID=0 {level!~".+"}=1d;`)
+}
+
+func TestParseRetentionConfigFailure(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+		if _, err := parseRetentionConfig([]byte(data)); err == nil {
+			t.Fatalf("expecting non-nil error for %q", data)
+		}
+	}
+	f(`foo`)
+	f(`"foo": {retention: 7d}`)
+	f(`"1:0": {unknown_field: 7d}`)
+	f(`"1:0": {}`)
+	f(`"1:0":`)
+	f(`"1:0": {retention: foo}`)
+	f(`"1:0": {retention: 0}`)
+	f(`"1:0": {retention: 5m}`)
+	f(`
+"1": {retention: 7d}
+"1:0": {retention: 8d}
+`)
+
+	// Invalid filters
+	f(`"1:0": {filters: [{retention: 3d}]}`)
+	f(`"1:0": {filters: [{match: '{level="debug"}'}]}`)
+	f(`"1:0": {filters: [{match: '{level="debug"}', retention: foo}]}`)
+	f(`"1:0": {filters: [{match: 'foo{', retention: 3d}]}`)
+	f(`"1:0": {filters: [{match: 'sum(foo)', retention: 3d}]}`)
+	f(`"1:0": {filters: [{match: '{level=~"("}', retention: 3d}]}`)
+	f(`"1:0": {filters: [{match: '{level="debug"}', retention: 3d, foo: bar}]}`)
+}
@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/handshakeext"
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/consts"
@@ -149,7 +150,11 @@ func (s *Server) RunVMInsert() {
 			// There is no need in response compression, since
 			// vmstorage sends only small packets to vminsert.
 			compressionLevel := 0
-			bc, err := handshake.VMInsertServer(c, compressionLevel)
+			var caps uint64
+			if storage.HasMaxActiveStreamsPerTenant() {
+				caps |= handshakeext.CapRejectedRows
+			}
+			bc, caps, err := handshakeext.VMInsertServer(c, compressionLevel, caps)
 			if err != nil {
 				if s.isStopping() {
 					// c is stopped inside Server.MustClose
@@ -167,7 +172,7 @@ func (s *Server) RunVMInsert() {
 			}()
 
 			logger.Infof("processing vminsert conn from %s", c.RemoteAddr())
-			if err := s.processVMInsertConn(bc); err != nil {
+			if err := s.processVMInsertConn(bc, caps); err != nil {
 				if s.isStopping() {
 					return
 				}
@@ -293,10 +298,21 @@ func (s *Server) isStopping() bool {
 	return atomic.LoadUint64(&s.stopFlag) != 0
 }
 
-func (s *Server) processVMInsertConn(bc *handshake.BufferedConn) error {
+// processVMInsertConn processes packets from vminsert connection bc with the given negotiated caps.
+//
+// Packets are added to the storage asynchronously after sending `ack`.
+// If caps contain handshakeext.CapRejectedRows, then the verdicts with the rows rejected from every packet
+// are sent to vminsert in the subsequent `ack` messages. See packetVerdicts for details.
+func (s *Server) processVMInsertConn(bc *handshake.BufferedConn, caps uint64) error {
 	sizeBuf := make([]byte, 8)
 	var reqBuf []byte
+	var ackBuf []byte
 	remoteAddr := bc.RemoteAddr().String()
+	var pv *packetVerdicts
+	if caps&handshakeext.CapRejectedRows != 0 {
+		pv = &packetVerdicts{}
+	}
+	packetSeq := uint64(0)
 	for {
 		if _, err := io.ReadFull(bc, sizeBuf); err != nil {
 			if err == io.EOF {
@@ -309,32 +325,93 @@ func (s *Server) processVMInsertConn(bc *handshake.BufferedConn) error {
 		if packetSize > consts.MaxInsertPacketSize {
 			return fmt.Errorf("too big packet size: %d; shouldn't exceed %d", packetSize, consts.MaxInsertPacketSize)
 		}
+		if packetSize == 0 && pv != nil {
+			// vminsert polls the verdicts for the previously sent packets.
+			if err := sendAck(bc, pv.appendAck(ackBuf[:0])); err != nil {
+				return err
+			}
+			continue
+		}
 		reqBuf = bytesutil.Resize(reqBuf, int(packetSize))
 		if n, err := io.ReadFull(bc, reqBuf); err != nil {
 			return fmt.Errorf("cannot read packet with size %d: %w; read only %d bytes", packetSize, err, n)
 		}
-		// Send `ack` to vminsert that the packet has been received.
-		deadline := time.Now().Add(5 * time.Second)
-		if err := bc.SetWriteDeadline(deadline); err != nil {
-			return fmt.Errorf("cannot set write deadline for sending `ack` to vminsert: %w", err)
-		}
-		sizeBuf[0] = 1
-		if _, err := bc.Write(sizeBuf[:1]); err != nil {
-			return fmt.Errorf("cannot send `ack` to vminsert: %w", err)
-		}
-		if err := bc.Flush(); err != nil {
-			return fmt.Errorf("cannot flush `ack` to vminsert: %w", err)
+		// Write the packet to write-ahead log before sending `ack`, so the acknowledged rows aren't lost on unclean shutdown.
+		// vminsert re-sends the packet to another vmstorage node if the connection is closed without `ack`.
+		walRef, err := s.storage.AppendWAL(reqBuf, uint8(*precisionBits))
+		if err != nil {
+			return fmt.Errorf("cannot write packet with size %d to write-ahead log: %w", packetSize, err)
 		}
-		vminsertPacketsRead.Inc()
-
 		uw := getUnmarshalWork()
 		uw.storage = s.storage
 		uw.remoteAddr = remoteAddr
+		uw.walRef = walRef
 		uw.reqBuf, reqBuf = reqBuf, uw.reqBuf
+		ackBuf = append(ackBuf[:0], 1)
+		if pv != nil {
+			uw.verdicts = pv
+			uw.packetSeq = packetSeq
+			packetSeq++
+			ackBuf = pv.appendAck(ackBuf[:0])
+		}
+		// Send `ack` to vminsert that the packet has been received.
+		if err := sendAck(bc, ackBuf); err != nil {
+			uw.walRef.Release()
+			return err
+		}
+		vminsertPacketsRead.Inc()
 		unmarshalWorkCh <- uw
 	}
 }
 
+// packetVerdicts collects verdicts for the packets from a single vminsert connection, which are added to the storage.
+//
+// Packets are numbered in the order they are received from the connection starting from 0.
+// Every verdict consists of the packet sequence number followed by the rows rejected from the packet
+// marshaled with storage.MarshalRejectedRows. Verdicts are sent to vminsert in `ack` messages,
+// which consist of 2 byte followed by the size of the marshaled verdicts and the marshaled verdicts.
+// `ack` without verdicts consists of 1 byte. vminsert polls for verdicts with zero-size packets.
+type packetVerdicts struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// add registers the verdict with the given rejected rows for the packet with the given packetSeq.
+func (pv *packetVerdicts) add(packetSeq uint64, rejectedRows []storage.RejectedRow) {
+	pv.mu.Lock()
+	pv.buf = encoding.MarshalUint64(pv.buf, packetSeq)
+	pv.buf = storage.MarshalRejectedRows(pv.buf, rejectedRows)
+	pv.mu.Unlock()
+}
+
+// appendAck appends `ack` with the collected verdicts to dst and returns the result.
+func (pv *packetVerdicts) appendAck(dst []byte) []byte {
+	pv.mu.Lock()
+	defer pv.mu.Unlock()
+	if len(pv.buf) == 0 {
+		return append(dst, 1)
+	}
+	dst = append(dst, 2)
+	dst = encoding.MarshalUint64(dst, uint64(len(pv.buf)))
+	dst = append(dst, pv.buf...)
+	pv.buf = pv.buf[:0]
+	return dst
+}
+
+func sendAck(bc *handshake.BufferedConn, ackBuf []byte) error {
+	deadline := time.Now().Add(5 * time.Second)
+	if err := bc.SetWriteDeadline(deadline); err != nil {
+		return fmt.Errorf("cannot set write deadline for sending `ack` to vminsert: %w", err)
+	}
+	if _, err := bc.Write(ackBuf); err != nil {
+		return fmt.Errorf("cannot send `ack` to vminsert: %w", err)
+	}
+	if err := bc.Flush(); err != nil {
+		return fmt.Errorf("cannot flush `ack` to vminsert: %w", err)
+	}
+	return nil
+}
+
 var (
 	vminsertPacketsRead = metrics.NewCounter("vm_vminsert_packets_read_total")
 	vminsertMetricsRead = metrics.NewCounter("vm_vminsert_metrics_read_total")
@@ -360,6 +437,10 @@ func StartUnmarshalWorkers() {
 			defer unmarshalWorkersWG.Done()
 			for uw := range unmarshalWorkCh {
 				uw.Unmarshal()
+				uw.walRef.Release()
+				if uw.verdicts != nil {
+					uw.verdicts.add(uw.packetSeq, uw.rejectedRows)
+				}
 				putUnmarshalWork(uw)
 			}
 		}()
@@ -387,10 +468,21 @@ func putUnmarshalWork(uw *unmarshalWork) {
 var unmarshalWorkPool sync.Pool
 
 type unmarshalWork struct {
-	storage       *storage.Storage
-	remoteAddr    string
-	mrs           []storage.MetricRow
-	reqBuf        []byte
+	storage    *storage.Storage
+	remoteAddr string
+	walRef     storage.WALRef
+	mrs        []storage.MetricRow
+	reqBuf     []byte
+
+	// verdicts must be set if the rows rejected by the storage must be reported to vminsert.
+	// The rejected rows are collected in rejectedRows and then are registered in verdicts for packetSeq.
+	verdicts     *packetVerdicts
+	packetSeq    uint64
+	rejectedRows []storage.RejectedRow
+
+	// rowsFlushed is the number of rows from reqBuf, which were already passed to the storage.
+	rowsFlushed int
+
 	lastResetTime uint64
 }
 
@@ -405,6 +497,11 @@ func (uw *unmarshalWork) reset() {
 	}
 	uw.storage = nil
 	uw.remoteAddr = ""
+	uw.walRef = storage.WALRef{}
+	uw.verdicts = nil
+	uw.packetSeq = 0
+	uw.rejectedRows = uw.rejectedRows[:0]
+	uw.rowsFlushed = 0
 	uw.mrs = uw.mrs[:0]
 	uw.reqBuf = uw.reqBuf[:0]
 }
@@ -442,7 +539,21 @@ func (uw *unmarshalWork) Unmarshal() {
 func (uw *unmarshalWork) flushRows() {
 	vminsertMetricsRead.Add(len(uw.mrs))
 	err := uw.storage.AddRows(uw.mrs, uint8(*precisionBits))
+	rowsFlushed := uw.rowsFlushed
+	uw.rowsFlushed += len(uw.mrs)
 	uw.mrs = uw.mrs[:0]
+	var tmse *storage.TooManyStreamsError
+	if errors.As(err, &tmse) {
+		// Report the rejected rows to vminsert instead of logging them.
+		// The rejected rows are counted in vm_rows_ignored_total{reason="too_many_streams"} metric in any case.
+		if uw.verdicts != nil {
+			for _, rr := range tmse.Rows {
+				rr.Idx += rowsFlushed
+				uw.rejectedRows = append(uw.rejectedRows, rr)
+			}
+		}
+		return
+	}
 	if err != nil {
 		logger.Errorf("cannot store metrics obtained from %s: %s", uw.remoteAddr, err)
 	}
@@ -477,6 +588,7 @@ type vmselectRequestCtx struct {
 
 	sq   storage.SearchQuery
 	tfss []*storage.TagFilters
+	lfs  *storage.LineFilters
 	sr   storage.Search
 	mb   storage.MetricBlock
 
@@ -635,11 +747,11 @@ func (s *Server) processVMSelectRequest(ctx *vmselectRequestCtx) error {
 	ctx.deadline = fasttime.UnixTimestamp() + uint64(timeout)
 
 	switch rpcName {
-	case "search_v5":
+	case "search_v8":
 		return s.processVMSelectSearchQuery(ctx)
 	case "labelValues_v2":
 		return s.processVMSelectLabelValues(ctx)
-	case "tagValueSuffixes_v1":
+	case "tagValueSuffixes_v2":
 		return s.processVMSelectTagValueSuffixes(ctx)
 	case "labelEntries_v2":
 		return s.processVMSelectLabelEntries(ctx)
@@ -649,7 +761,7 @@ func (s *Server) processVMSelectRequest(ctx *vmselectRequestCtx) error {
 		return s.processVMSelectSeriesCount(ctx)
 	case "tsdbStatus_v2":
 		return s.processVMSelectTSDBStatus(ctx)
-	case "deleteMetrics_v3":
+	case "deleteMetrics_v5":
 		return s.processVMSelectDeleteMetrics(ctx)
 	default:
 		return fmt.Errorf("unsupported rpcName: %q", ctx.dataBuf)
@@ -988,6 +1100,9 @@ func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 	if err := ctx.setupTfss(); err != nil {
 		return ctx.writeErrorMessage(err)
 	}
+	if err := ctx.setupLfs(); err != nil {
+		return ctx.writeErrorMessage(err)
+	}
 	tr := storage.TimeRange{
 		MinTimestamp: ctx.sq.MinTimestamp,
 		MaxTimestamp: ctx.sq.MaxTimestamp,
@@ -995,7 +1110,31 @@ func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 	if err := checkTimeRange(s.storage, tr); err != nil {
 		return ctx.writeErrorMessage(err)
 	}
-	ctx.sr.Init(s.storage, ctx.tfss, tr, *maxMetricsPerSearch, ctx.deadline)
+	// Hide the data outside the tenant retention, since it may be still present until the next background merge.
+	// The data for streams matching retention filters is trimmed per stream below.
+	retentionDeadline := s.storage.GetRetentionDeadline(ctx.sq.AccountID, ctx.sq.ProjectID)
+	if tr.MinTimestamp < retentionDeadline {
+		tr.MinTimestamp = retentionDeadline
+	}
+	if tr.MinTimestamp > tr.MaxTimestamp {
+		// Fast path - the whole time range is outside the tenant retention.
+		if err := ctx.writeString(""); err != nil {
+			return fmt.Errorf("cannot send empty error message: %w", err)
+		}
+		if err := ctx.writeString(""); err != nil {
+			return fmt.Errorf("cannot send 'end of response' marker")
+		}
+		return nil
+	}
+	srd := s.storage.NewSearchRetentionDeadlines(ctx.sq.AccountID, ctx.sq.ProjectID)
+
+	// Line filters are applied only to the fetched data below,
+	// so blocks may be skipped via bloom filters only in this case.
+	var lfs *storage.LineFilters
+	if fetchData == 2 {
+		lfs = ctx.lfs
+	}
+	ctx.sr.Init(s.storage, ctx.tfss, tr, lfs, *maxMetricsPerSearch, ctx.deadline)
 	defer ctx.sr.MustClose()
 	if err := ctx.sr.Error(); err != nil {
 		return ctx.writeErrorMessage(err)
@@ -1014,6 +1153,32 @@ func (s *Server) processVMSelectSearchQuery(ctx *vmselectRequestCtx) error {
 		vmselectMetricBlocksRead.Inc()
 		vmselectMetricRowsRead.Add(ctx.mb.Block.RowsCount())
 
+		minTimestamp := tr.MinTimestamp
+		if srd != nil {
+			if deadline := srd.GetForBlock(&ctx.mb.Block); deadline > minTimestamp {
+				minTimestamp = deadline
+			}
+		}
+		ok, err := ctx.mb.Block.TrimRowsBefore(minTimestamp)
+		if err != nil {
+			return fmt.Errorf("cannot trim rows outside the retention: %w", err)
+		}
+		if !ok {
+			continue
+		}
+
+		if fetchData == 2 && ctx.lfs.Len() > 0 {
+			// Apply line filters here in order to avoid sending non-matching rows to vmselect.
+			ok, err := ctx.mb.Block.FilterLines(ctx.lfs)
+			if err != nil {
+				return fmt.Errorf("cannot apply line filters: %w", err)
+			}
+			if !ok {
+				vmselectMetricBlocksFiltered.Inc()
+				continue
+			}
+		}
+
 		ctx.dataBuf = ctx.mb.Marshal(ctx.dataBuf[:0])
 		if err := ctx.writeDataBufBytes(); err != nil {
 			return fmt.Errorf("cannot send MetricBlock: %w", err)
@@ -1036,7 +1201,7 @@ func checkTimeRange(s *storage.Storage, tr storage.TimeRange) error {
 		return nil
 	}
 	retentionPeriod := s.RetentionMonths()
-	minAllowedTimestamp := (int64(fasttime.UnixTimestamp()) - int64(retentionPeriod)*3600*24*30) * 1000
+	minAllowedTimestamp := (int64(fasttime.UnixTimestamp()) - int64(retentionPeriod)*3600*24*30) * 1e9
 	if tr.MinTimestamp > minAllowedTimestamp {
 		return nil
 	}
@@ -1057,6 +1222,7 @@ var (
 	vmselectSearchQueryRequests      = metrics.NewCounter("vm_vmselect_search_query_requests_total")
 	vmselectMetricBlocksRead         = metrics.NewCounter("vm_vmselect_metric_blocks_read_total")
 	vmselectMetricRowsRead           = metrics.NewCounter("vm_vmselect_metric_rows_read_total")
+	vmselectMetricBlocksFiltered     = metrics.NewCounter("vm_vmselect_metric_blocks_filtered_total")
 )
 
 func (ctx *vmselectRequestCtx) setupTfss() error {
@@ -1075,3 +1241,12 @@ func (ctx *vmselectRequestCtx) setupTfss() error {
 	ctx.tfss = tfss
 	return nil
 }
+
+func (ctx *vmselectRequestCtx) setupLfs() error {
+	lfs, err := storage.NewLineFilters(ctx.sq.LineFilters)
+	if err != nil {
+		return err
+	}
+	ctx.lfs = lfs
+	return nil
+}
@@ -10,8 +10,10 @@ require (
 	github.com/gogo/protobuf v1.3.1
 	github.com/golang/snappy v0.0.2
 	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/compress v1.11.1
 	github.com/lithammer/go-jump-consistent-hash v1.0.1
 	github.com/valyala/fastjson v1.6.1
 	github.com/valyala/histogram v1.1.2
 	github.com/valyala/quicktemplate v1.6.3
+	gopkg.in/yaml.v2 v2.3.0
 )
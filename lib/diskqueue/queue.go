@@ -0,0 +1,484 @@
+package diskqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/cespare/xxhash/v2"
+)
+
+// defaultChunkSize is the maximum size of a single chunk file.
+//
+// Blocks bigger than the chunk size occupy a separate chunk file.
+const defaultChunkSize = 32 * 1024 * 1024
+
+// blockHeaderSize is the size of the header preceding every block in chunk files.
+//
+// The header contains the block size and the xxhash64 checksum of the block contents.
+const blockHeaderSize = 16
+
+const metainfoFilename = "metainfo.json"
+
+// Queue is an on-disk FIFO queue of blocks.
+//
+// Blocks are appended to chunk files, which are named after the queue offset of their first byte.
+// Fully read chunk files are deleted. The reader offset is persisted in metainfo.json,
+// so the queue survives restarts. Blocks read during the last second before unclean shutdown
+// may be returned again after the restart.
+//
+// Queue methods may be called from concurrently running goroutines.
+type Queue struct {
+	path            string
+	maxPendingBytes uint64
+	chunkSize       uint64
+
+	flockF *os.File
+
+	mu sync.Mutex
+
+	// chunks contains offsets of the existing chunk files in ascending order.
+	// The reader reads from the first chunk, while the writer appends to the last chunk.
+	chunks []uint64
+
+	writer       *os.File
+	writerOffset uint64
+
+	reader       *os.File
+	readerOffset uint64
+
+	// peekedOffset and peekedSize describe the block returned by the last MustPeekBlock call.
+	peekedOffset uint64
+	peekedSize   uint64
+
+	lastMetainfoFlushTime time.Time
+
+	droppedBytes uint64
+
+	headerBuf []byte
+}
+
+// MustOpen opens the queue at the given path.
+//
+// If maxPendingBytes is positive, then the oldest data is dropped when the queue size exceeds maxPendingBytes.
+//
+// MustClose must be called on the returned queue when it is no longer needed.
+func MustOpen(path string, maxPendingBytes int) *Queue {
+	q, err := openQueue(path, maxPendingBytes)
+	if err != nil {
+		logger.Panicf("FATAL: cannot open queue at %q: %s", path, err)
+	}
+	return q
+}
+
+func openQueue(path string, maxPendingBytes int) (*Queue, error) {
+	if maxPendingBytes < 0 {
+		maxPendingBytes = 0
+	}
+	chunkSize := uint64(defaultChunkSize)
+	if maxPendingBytes > 0 && uint64(maxPendingBytes)/4 < chunkSize {
+		chunkSize = uint64(maxPendingBytes) / 4
+		if chunkSize == 0 {
+			chunkSize = 1
+		}
+	}
+	if err := fs.MkdirAllIfNotExist(path); err != nil {
+		return nil, fmt.Errorf("cannot create directory: %w", err)
+	}
+
+	// Protect from concurrent opens.
+	flockF, err := fs.CreateFlockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		path:            path,
+		maxPendingBytes: uint64(maxPendingBytes),
+		chunkSize:       chunkSize,
+		flockF:          flockF,
+	}
+	if err := q.init(); err != nil {
+		_ = flockF.Close()
+		return nil, err
+	}
+	q.lastMetainfoFlushTime = time.Now()
+	return q, nil
+}
+
+func (q *Queue) init() error {
+	readerOffset, err := readMetainfo(q.path)
+	if err != nil {
+		return err
+	}
+	chunks, err := readChunkOffsets(q.path)
+	if err != nil {
+		return err
+	}
+
+	// Remove fully read chunks.
+	for len(chunks) > 1 && chunks[1] <= readerOffset {
+		q.mustRemoveChunk(chunks[0])
+		chunks = chunks[1:]
+	}
+	if len(chunks) == 0 {
+		chunks = append(chunks, readerOffset)
+		if err := createChunk(q.chunkPath(readerOffset)); err != nil {
+			return err
+		}
+	}
+	if readerOffset < chunks[0] {
+		logger.Warnf("the queue at %q misses %d bytes of data starting from the offset %d; skipping them",
+			q.path, chunks[0]-readerOffset, readerOffset)
+		readerOffset = chunks[0]
+	}
+
+	// The last chunk may contain partially written block after unclean shutdown. Truncate it.
+	lastChunk := chunks[len(chunks)-1]
+	lastChunkPath := q.chunkPath(lastChunk)
+	validSize, fileSize, err := getValidChunkSize(lastChunkPath)
+	if err != nil {
+		return err
+	}
+	if validSize < fileSize {
+		logger.Warnf("truncating the chunk %q from %d to %d bytes, since it contains incomplete or corrupted data", lastChunkPath, fileSize, validSize)
+		if err := os.Truncate(lastChunkPath, int64(validSize)); err != nil {
+			return fmt.Errorf("cannot truncate %q: %w", lastChunkPath, err)
+		}
+	}
+	writerOffset := lastChunk + validSize
+	if readerOffset > writerOffset {
+		readerOffset = writerOffset
+	}
+	writer, err := os.OpenFile(lastChunkPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("cannot open chunk for writing: %w", err)
+	}
+
+	q.chunks = chunks
+	q.writer = writer
+	q.writerOffset = writerOffset
+	q.readerOffset = readerOffset
+	return nil
+}
+
+// MustClose closes q.
+func (q *Queue) MustClose() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.mustWriteMetainfo()
+	mustCloseFile(q.writer)
+	q.writer = nil
+	if q.reader != nil {
+		mustCloseFile(q.reader)
+		q.reader = nil
+	}
+	mustCloseFile(q.flockF)
+	q.flockF = nil
+}
+
+// GetPendingBytes returns the number of bytes in q, which weren't read yet.
+func (q *Queue) GetPendingBytes() uint64 {
+	q.mu.Lock()
+	n := q.writerOffset - q.readerOffset
+	q.mu.Unlock()
+	return n
+}
+
+// GetDroppedBytes returns the number of bytes dropped from q
+// because of maxPendingBytes limit or data corruption.
+func (q *Queue) GetDroppedBytes() uint64 {
+	q.mu.Lock()
+	n := q.droppedBytes
+	q.mu.Unlock()
+	return n
+}
+
+// MustWriteBlock appends block to q.
+//
+// The oldest data is dropped from q if its size exceeds maxPendingBytes passed to MustOpen.
+func (q *Queue) MustWriteBlock(block []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	size := uint64(blockHeaderSize + len(block))
+	if q.maxPendingBytes > 0 && size > q.maxPendingBytes {
+		q.droppedBytes += size
+		logger.Errorf("dropping block with size %d bytes, since it exceeds the maximum size of the queue at %q: %d bytes", size, q.path, q.maxPendingBytes)
+		return
+	}
+	lastChunk := q.chunks[len(q.chunks)-1]
+	if q.writerOffset > lastChunk && q.writerOffset-lastChunk+size > q.chunkSize {
+		q.mustStartNextChunk()
+	}
+	q.headerBuf = encoding.MarshalUint64(q.headerBuf[:0], uint64(len(block)))
+	q.headerBuf = encoding.MarshalUint64(q.headerBuf, xxhash.Sum64(block))
+	mustWriteData(q.writer, q.headerBuf)
+	mustWriteData(q.writer, block)
+	q.writerOffset += size
+
+	for q.maxPendingBytes > 0 && q.writerOffset-q.readerOffset > q.maxPendingBytes && len(q.chunks) > 1 {
+		dropped := q.chunks[1] - q.readerOffset
+		logger.Warnf("dropping %d bytes of the oldest data from the queue at %q, since its size exceeds %d bytes", dropped, q.path, q.maxPendingBytes)
+		q.droppedBytes += dropped
+		q.readerOffset = q.chunks[1]
+		q.mustSwitchToNextReaderChunk()
+	}
+}
+
+// MustPeekBlock appends the oldest block from q to dst and returns the result.
+//
+// The block isn't removed from q until MustSkipBlock is called.
+// False is returned if q is empty.
+func (q *Queue) MustPeekBlock(dst []byte) ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.readerOffset == q.writerOffset {
+			return dst, false
+		}
+		chunkEnd := q.getReaderChunkEnd()
+		if q.readerOffset == chunkEnd {
+			q.mustSwitchToNextReaderChunk()
+			continue
+		}
+		dstLen := len(dst)
+		var err error
+		dst, err = q.readBlock(dst, chunkEnd)
+		if err != nil {
+			// Skip the rest of the chunk, since it is impossible to locate the next block in it.
+			logger.Errorf("skipping %d bytes at offset %d in the queue at %q: %s", chunkEnd-q.readerOffset, q.readerOffset, q.path, err)
+			q.droppedBytes += chunkEnd - q.readerOffset
+			q.readerOffset = chunkEnd
+			dst = dst[:dstLen]
+			continue
+		}
+		q.peekedOffset = q.readerOffset
+		q.peekedSize = uint64(blockHeaderSize + len(dst) - dstLen)
+		return dst, true
+	}
+}
+
+// MustSkipBlock removes from q the block returned by the last MustPeekBlock call.
+//
+// It is no-op if the block has been already dropped from q because of maxPendingBytes limit.
+func (q *Queue) MustSkipBlock() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.peekedSize == 0 || q.peekedOffset != q.readerOffset {
+		return
+	}
+	q.readerOffset += q.peekedSize
+	q.peekedSize = 0
+	if q.readerOffset == q.getReaderChunkEnd() && len(q.chunks) > 1 {
+		// Remove the fully read chunk.
+		q.mustSwitchToNextReaderChunk()
+		return
+	}
+	if time.Since(q.lastMetainfoFlushTime) > time.Second {
+		q.mustWriteMetainfo()
+	}
+}
+
+func (q *Queue) readBlock(dst []byte, chunkEnd uint64) ([]byte, error) {
+	if q.reader == nil {
+		r, err := os.Open(q.chunkPath(q.chunks[0]))
+		if err != nil {
+			logger.Panicf("FATAL: cannot open chunk for reading: %s", err)
+		}
+		q.reader = r
+	}
+	if chunkEnd-q.readerOffset < blockHeaderSize {
+		return dst, fmt.Errorf("incomplete block header")
+	}
+	offset := int64(q.readerOffset - q.chunks[0])
+	q.headerBuf = bytesutil.Resize(q.headerBuf, blockHeaderSize)
+	if _, err := q.reader.ReadAt(q.headerBuf, offset); err != nil {
+		return dst, fmt.Errorf("cannot read block header: %w", err)
+	}
+	size := encoding.UnmarshalUint64(q.headerBuf)
+	checksum := encoding.UnmarshalUint64(q.headerBuf[8:])
+	if size > chunkEnd-q.readerOffset-blockHeaderSize {
+		return dst, fmt.Errorf("too big block size: %d bytes", size)
+	}
+	dstLen := len(dst)
+	dst = bytesutil.Resize(dst, dstLen+int(size))
+	if _, err := q.reader.ReadAt(dst[dstLen:], offset+blockHeaderSize); err != nil {
+		return dst, fmt.Errorf("cannot read block with size %d bytes: %w", size, err)
+	}
+	if xxhash.Sum64(dst[dstLen:]) != checksum {
+		return dst, fmt.Errorf("checksum mismatch for block with size %d bytes", size)
+	}
+	return dst, nil
+}
+
+func (q *Queue) getReaderChunkEnd() uint64 {
+	if len(q.chunks) > 1 {
+		return q.chunks[1]
+	}
+	return q.writerOffset
+}
+
+func (q *Queue) mustStartNextChunk() {
+	mustCloseFile(q.writer)
+	path := q.chunkPath(q.writerOffset)
+	if err := createChunk(path); err != nil {
+		logger.Panicf("FATAL: %s", err)
+	}
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		logger.Panicf("FATAL: cannot open chunk for writing: %s", err)
+	}
+	q.writer = w
+	q.chunks = append(q.chunks, q.writerOffset)
+}
+
+func (q *Queue) mustSwitchToNextReaderChunk() {
+	if len(q.chunks) < 2 {
+		logger.Panicf("BUG: cannot switch to the next chunk in the queue at %q, since the current chunk is the last one", q.path)
+	}
+	if q.reader != nil {
+		mustCloseFile(q.reader)
+		q.reader = nil
+	}
+	// Persist the reader offset before removing the chunk,
+	// so the reader offset always points to existing data after restart.
+	q.mustWriteMetainfo()
+	q.mustRemoveChunk(q.chunks[0])
+	q.chunks = append(q.chunks[:0], q.chunks[1:]...)
+	q.peekedSize = 0
+}
+
+func (q *Queue) mustRemoveChunk(offset uint64) {
+	path := q.chunkPath(offset)
+	if err := os.Remove(path); err != nil {
+		logger.Panicf("FATAL: cannot remove chunk: %s", err)
+	}
+}
+
+func (q *Queue) chunkPath(offset uint64) string {
+	return fmt.Sprintf("%s/%016X", q.path, offset)
+}
+
+type metainfo struct {
+	ReaderOffset uint64
+}
+
+func (q *Queue) mustWriteMetainfo() {
+	data, err := json.Marshal(&metainfo{
+		ReaderOffset: q.readerOffset,
+	})
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal metainfo: %s", err)
+	}
+	path := q.path + "/" + metainfoFilename
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		logger.Panicf("FATAL: cannot create metainfo file: %s", err)
+	}
+	mustWriteData(f, data)
+	if err := f.Sync(); err != nil {
+		logger.Panicf("FATAL: cannot sync %q: %s", tmpPath, err)
+	}
+	mustCloseFile(f)
+	if err := os.Rename(tmpPath, path); err != nil {
+		logger.Panicf("FATAL: cannot rename %q to %q: %s", tmpPath, path, err)
+	}
+	fs.MustSyncPath(q.path)
+	q.lastMetainfoFlushTime = time.Now()
+}
+
+func readMetainfo(path string) (uint64, error) {
+	metainfoPath := path + "/" + metainfoFilename
+	data, err := ioutil.ReadFile(metainfoPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("cannot read metainfo: %w", err)
+	}
+	var mi metainfo
+	if err := json.Unmarshal(data, &mi); err != nil {
+		return 0, fmt.Errorf("cannot parse %q: %w", metainfoPath, err)
+	}
+	return mi.ReaderOffset, nil
+}
+
+func readChunkOffsets(path string) ([]uint64, error) {
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory: %w", err)
+	}
+	var chunks []uint64
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || len(name) != 16 {
+			continue
+		}
+		offset, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, offset)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i] < chunks[j]
+	})
+	return chunks, nil
+}
+
+// getValidChunkSize returns the size of the valid blocks prefix in the chunk at the given path.
+func getValidChunkSize(path string) (uint64, uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot read chunk: %w", err)
+	}
+	src := data
+	for len(src) >= blockHeaderSize {
+		size := encoding.UnmarshalUint64(src)
+		checksum := encoding.UnmarshalUint64(src[8:])
+		if size > uint64(len(src)-blockHeaderSize) {
+			break
+		}
+		block := src[blockHeaderSize : blockHeaderSize+size]
+		if xxhash.Sum64(block) != checksum {
+			break
+		}
+		src = src[blockHeaderSize+size:]
+	}
+	return uint64(len(data) - len(src)), uint64(len(data)), nil
+}
+
+func createChunk(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create chunk: %w", err)
+	}
+	mustCloseFile(f)
+	return nil
+}
+
+func mustWriteData(w io.Writer, data []byte) {
+	if _, err := w.Write(data); err != nil {
+		logger.Panicf("FATAL: cannot write %d bytes: %s", len(data), err)
+	}
+}
+
+func mustCloseFile(f *os.File) {
+	if err := f.Close(); err != nil {
+		logger.Panicf("FATAL: cannot close %q: %s", f.Name(), err)
+	}
+}
@@ -0,0 +1,202 @@
+package diskqueue
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestQueueWriteRead(t *testing.T) {
+	path := "queue-write-read"
+	mustDeleteDir(path)
+	defer mustDeleteDir(path)
+
+	q := MustOpen(path, 0)
+	if _, ok := q.MustPeekBlock(nil); ok {
+		t.Fatalf("unexpected block in empty queue")
+	}
+	var blocks []string
+	for i := 0; i < 100; i++ {
+		block := fmt.Sprintf("block #%d", i)
+		q.MustWriteBlock([]byte(block))
+		blocks = append(blocks, block)
+	}
+	if n := q.GetPendingBytes(); n == 0 {
+		t.Fatalf("expecting non-zero pending bytes")
+	}
+
+	// Read the first half of blocks.
+	mustReadBlocks(t, q, blocks[:50])
+
+	// Peeked block mustn't be removed from the queue until MustSkipBlock is called.
+	block, ok := q.MustPeekBlock(nil)
+	if !ok || string(block) != blocks[50] {
+		t.Fatalf("unexpected block; got %q; want %q", block, blocks[50])
+	}
+
+	// Re-open the queue and make sure the remaining blocks are read in order.
+	q.MustClose()
+	q = MustOpen(path, 0)
+	mustReadBlocks(t, q, blocks[50:])
+	if n := q.GetPendingBytes(); n != 0 {
+		t.Fatalf("unexpected pending bytes; got %d; want 0", n)
+	}
+	if _, ok := q.MustPeekBlock(nil); ok {
+		t.Fatalf("unexpected block in empty queue")
+	}
+	if n := q.GetDroppedBytes(); n != 0 {
+		t.Fatalf("unexpected dropped bytes; got %d; want 0", n)
+	}
+	q.MustClose()
+}
+
+func TestQueueMultipleChunks(t *testing.T) {
+	path := "queue-multiple-chunks"
+	mustDeleteDir(path)
+	defer mustDeleteDir(path)
+
+	q := MustOpen(path, 0)
+	q.chunkSize = 100
+	var blocks []string
+	for i := 0; i < 100; i++ {
+		block := fmt.Sprintf("block #%d", i)
+		q.MustWriteBlock([]byte(block))
+		blocks = append(blocks, block)
+	}
+	if len(q.chunks) < 10 {
+		t.Fatalf("expecting at least 10 chunks; got %d", len(q.chunks))
+	}
+	mustReadBlocks(t, q, blocks[:50])
+	q.MustClose()
+
+	q = MustOpen(path, 0)
+	mustReadBlocks(t, q, blocks[50:])
+
+	// Fully read chunks must be removed.
+	if n := len(mustReadChunkOffsets(path)); n != 1 {
+		t.Fatalf("unexpected number of chunks left; got %d; want 1", n)
+	}
+	q.MustClose()
+}
+
+func TestQueueMaxPendingBytes(t *testing.T) {
+	path := "queue-max-pending-bytes"
+	mustDeleteDir(path)
+	defer mustDeleteDir(path)
+
+	const maxPendingBytes = 1000
+	q := MustOpen(path, maxPendingBytes)
+	var blocks []string
+	for i := 0; i < 1000; i++ {
+		block := fmt.Sprintf("block #%04d", i)
+		q.MustWriteBlock([]byte(block))
+		blocks = append(blocks, block)
+		if n := q.GetPendingBytes(); n > maxPendingBytes {
+			t.Fatalf("pending bytes exceed the limit: %d bytes", n)
+		}
+	}
+	pendingBytes := q.GetPendingBytes()
+	droppedBytes := q.GetDroppedBytes()
+	if pendingBytes+droppedBytes != uint64(len(blocks)*(blockHeaderSize+len(blocks[0]))) {
+		t.Fatalf("unexpected pending bytes + dropped bytes; got %d + %d", pendingBytes, droppedBytes)
+	}
+
+	// The newest blocks must be preserved.
+	n := int(pendingBytes) / (blockHeaderSize + len(blocks[0]))
+	mustReadBlocks(t, q, blocks[len(blocks)-n:])
+
+	// Too big block must be dropped.
+	q.MustWriteBlock(make([]byte, maxPendingBytes))
+	if n := q.GetPendingBytes(); n != 0 {
+		t.Fatalf("unexpected pending bytes; got %d; want 0", n)
+	}
+	if n := q.GetDroppedBytes(); n != droppedBytes+blockHeaderSize+maxPendingBytes {
+		t.Fatalf("unexpected dropped bytes; got %d; want %d", n, droppedBytes+blockHeaderSize+maxPendingBytes)
+	}
+	q.MustClose()
+}
+
+func TestQueueDroppedPeekedBlock(t *testing.T) {
+	path := "queue-dropped-peeked-block"
+	mustDeleteDir(path)
+	defer mustDeleteDir(path)
+
+	q := MustOpen(path, 100)
+	q.MustWriteBlock([]byte("foo"))
+	block, ok := q.MustPeekBlock(nil)
+	if !ok || string(block) != "foo" {
+		t.Fatalf("unexpected block; got %q; want %q", block, "foo")
+	}
+
+	// The peeked block is dropped because of the limit, so MustSkipBlock mustn't skip the next block.
+	for i := 0; i < 10; i++ {
+		q.MustWriteBlock([]byte("bar"))
+	}
+	if n := q.GetDroppedBytes(); n == 0 {
+		t.Fatalf("expecting non-zero dropped bytes")
+	}
+	q.MustSkipBlock()
+	block, ok = q.MustPeekBlock(nil)
+	if !ok || string(block) != "bar" {
+		t.Fatalf("unexpected block; got %q; want %q", block, "bar")
+	}
+	q.MustClose()
+}
+
+func TestQueueCorruptedTail(t *testing.T) {
+	path := "queue-corrupted-tail"
+	mustDeleteDir(path)
+	defer mustDeleteDir(path)
+
+	q := MustOpen(path, 0)
+	q.MustWriteBlock([]byte("foo"))
+	q.MustWriteBlock([]byte("bar"))
+	q.MustClose()
+
+	// Simulate partially written block after unclean shutdown.
+	chunks := mustReadChunkOffsets(path)
+	chunkPath := fmt.Sprintf("%s/%016X", path, chunks[len(chunks)-1])
+	f, err := os.OpenFile(chunkPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("cannot open chunk: %s", err)
+	}
+	if _, err := f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 10, 1, 2}); err != nil {
+		t.Fatalf("cannot write to chunk: %s", err)
+	}
+	_ = f.Close()
+
+	q = MustOpen(path, 0)
+	q.MustWriteBlock([]byte("baz"))
+	mustReadBlocks(t, q, []string{"foo", "bar", "baz"})
+	q.MustClose()
+}
+
+func mustReadBlocks(t *testing.T, q *Queue, blocksExpected []string) {
+	t.Helper()
+	var buf []byte
+	for _, blockExpected := range blocksExpected {
+		block, ok := q.MustPeekBlock(buf[:0])
+		if !ok {
+			t.Fatalf("missing block %q", blockExpected)
+		}
+		if string(block) != blockExpected {
+			t.Fatalf("unexpected block; got %q; want %q", block, blockExpected)
+		}
+		q.MustSkipBlock()
+		buf = block
+	}
+}
+
+func mustReadChunkOffsets(path string) []uint64 {
+	chunks, err := readChunkOffsets(path)
+	if err != nil {
+		panic(err)
+	}
+	return chunks
+}
+
+func mustDeleteDir(path string) {
+	if err := os.RemoveAll(path); err != nil {
+		panic(fmt.Errorf("cannot remove %q: %w", path, err))
+	}
+}
@@ -15,6 +15,10 @@ type MarshalType = encoding.MarshalType
 const (
 	// MarshalTypeZSTDBytesArray is used for marshaling bytes array
 	MarshalTypeZSTDBytesArray = MarshalType(7)
+
+	// MarshalTypeZSTDBytesArrayWithSeqs is used for marshaling bytes array
+	// together with per-item ingestion sequence numbers.
+	MarshalTypeZSTDBytesArrayWithSeqs = MarshalType(8)
 )
 
 // CheckMarshalType verifies whether the mt is valid.
@@ -46,6 +50,46 @@ func UnmarshalValues(dst [][]byte, src []byte, mt MarshalType, itemsCount int) (
 	return dst, nil
 }
 
+// MarshalValuesWithSeqs marshals values together with the corresponding seqs,
+// appends the marshaled result to dst and returns the dst.
+//
+// seqs must have the same length as values.
+func MarshalValuesWithSeqs(dst []byte, values [][]byte, seqs []uint64) (result []byte, mt MarshalType) {
+	if len(seqs) != len(values) {
+		logger.Panicf("BUG: the number of seqs must match the number of values; got %d vs %d", len(seqs), len(values))
+	}
+	if len(values) == 0 {
+		logger.Panicf("BUG: values must contain at least one item")
+	}
+	bb := bbPool.Get()
+
+	// Seqs are usually increasing within blocks, so store them as deltas.
+	prevSeq := uint64(0)
+	for _, seq := range seqs {
+		bb.B = encoding.MarshalVarInt64(bb.B, int64(seq-prevSeq))
+		prevSeq = seq
+	}
+	for i := 0; i < len(values); i++ {
+		bb.B = encoding.MarshalBytes(bb.B, values[i])
+	}
+	dst = encoding.CompressZSTDLevel(dst, bb.B, getCompressLevel(len(bb.B)))
+
+	bbPool.Put(bb)
+	return dst, MarshalTypeZSTDBytesArrayWithSeqs
+}
+
+// UnmarshalValuesWithSeqs unmarshals values and seqs from src, appends them to dstValues and dstSeqs
+// and returns the results.
+//
+// Zero seqs are returned for values marshaled without seqs.
+func UnmarshalValuesWithSeqs(dstValues [][]byte, dstSeqs []uint64, src []byte, mt MarshalType, itemsCount int) ([][]byte, []uint64, error) {
+	dstValues, dstSeqs, err := unmarshalBytesArrayWithSeqs(dstValues, dstSeqs, true, src, mt, itemsCount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot unmarshal %d values from len(src)=%d bytes: %w", itemsCount, len(src), err)
+	}
+	return dstValues, dstSeqs, nil
+}
+
 func marshalBytesArray(dst []byte, a [][]byte) (result []byte, mt MarshalType) {
 	if len(a) == 0 {
 		logger.Panicf("BUG: a must contain at least one item")
@@ -62,11 +106,19 @@ func marshalBytesArray(dst []byte, a [][]byte) (result []byte, mt MarshalType) {
 }
 
 func unmarshalBytesArray(dst [][]byte, src []byte, mt MarshalType, itemsCount int) ([][]byte, error) {
+	dst, _, err := unmarshalBytesArrayWithSeqs(dst, nil, false, src, mt, itemsCount)
+	return dst, err
+}
+
+// unmarshalBytesArrayWithSeqs unmarshals bytes array from src to dst.
+//
+// Seqs are appended to dstSeqs only if withSeqs is set.
+func unmarshalBytesArrayWithSeqs(dst [][]byte, dstSeqs []uint64, withSeqs bool, src []byte, mt MarshalType, itemsCount int) ([][]byte, []uint64, error) {
 	// Extend dst capacity in order to eliminate memory allocations below.
 	dst = decimalext.ExtendBytesArrayCapacity(dst, itemsCount)
 
 	switch mt {
-	case MarshalTypeZSTDBytesArray:
+	case MarshalTypeZSTDBytesArray, MarshalTypeZSTDBytesArrayWithSeqs:
 		bb := bbPool.Get()
 		defer bbPool.Put(bb)
 
@@ -74,22 +126,42 @@ func unmarshalBytesArray(dst [][]byte, src []byte, mt MarshalType, itemsCount in
 
 		bb.B, err = encoding.DecompressZSTD(bb.B[:0], src)
 		if err != nil {
-			return nil, fmt.Errorf("cannot decompress zstd data: %w", err)
+			return nil, nil, fmt.Errorf("cannot decompress zstd data: %w", err)
+		}
+
+		tail := bb.B
+		if mt == MarshalTypeZSTDBytesArrayWithSeqs {
+			seq := uint64(0)
+			for i := 0; i < itemsCount; i++ {
+				var delta int64
+				tail, delta, err = encoding.UnmarshalVarInt64(tail)
+				if err != nil {
+					return nil, nil, fmt.Errorf("cannot unmarshal seq #%d: %w", i, err)
+				}
+				seq += uint64(delta)
+				if withSeqs {
+					dstSeqs = append(dstSeqs, seq)
+				}
+			}
+		} else if withSeqs {
+			for i := 0; i < itemsCount; i++ {
+				dstSeqs = append(dstSeqs, 0)
+			}
 		}
 
 		var b []byte
 		for i := 0; i < itemsCount; i++ {
-			bb.B, b, err = encoding.UnmarshalBytes(bb.B)
+			tail, b, err = encoding.UnmarshalBytes(tail)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			dst = append(dst, append([]byte(nil), b...))
 		}
 	default:
-		return nil, fmt.Errorf("unknown MarshalType=%d", mt)
+		return nil, nil, fmt.Errorf("unknown MarshalType=%d", mt)
 	}
 
-	return dst, nil
+	return dst, dstSeqs, nil
 }
 
 var bbPool bytesutil.ByteBufferPool
@@ -0,0 +1,118 @@
+package handshakeext
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/consts"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/handshake"
+)
+
+const (
+	// vminsertProtocolHello is sent by vminsert after handshake.VMInsertClient.
+	//
+	// It is bigger than consts.MaxInsertPacketSize, so vmstorage with the previous protocol
+	// closes the connection instead of treating the hello as a packet size.
+	vminsertProtocolHello = 0x766c696e73657274 // "vlinsert"
+
+	// vminsertProtocolVersion is the version of vminsert-vmstorage protocol.
+	//
+	// Version 1 sends log timestamps in nanoseconds.
+	vminsertProtocolVersion = 1
+)
+
+// Capabilities, which may be negotiated during vminsert handshake.
+const (
+	// CapRejectedRows means that vmstorage reports rows rejected from every packet in `ack` to vminsert.
+	//
+	// vminsert sets it if it understands such `ack`. vmstorage sets it if it may reject rows.
+	CapRejectedRows = 1 << 0
+)
+
+// VMInsertClient performs client-side handshake for vminsert protocol.
+//
+// It performs handshake.VMInsertClient and then verifies that vmstorage supports vminsertProtocolVersion.
+// caps must contain Cap* capabilities supported by vminsert. The capabilities supported by both vminsert and vmstorage are returned.
+func VMInsertClient(c net.Conn, compressionLevel int, caps uint64) (*handshake.BufferedConn, uint64, error) {
+	bc, err := handshake.VMInsertClient(c, compressionLevel)
+	if err != nil {
+		return nil, 0, err
+	}
+	var buf []byte
+	buf = encoding.MarshalUint64(buf, vminsertProtocolHello)
+	buf = encoding.MarshalUint64(buf, vminsertProtocolVersion)
+	buf = encoding.MarshalUint64(buf, caps)
+	if err := writeData(bc, buf); err != nil {
+		return nil, 0, fmt.Errorf("cannot write protocol version: %w", err)
+	}
+	if err := bc.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, 0, fmt.Errorf("cannot set read deadline: %w", err)
+	}
+	buf = buf[:8]
+	if _, err := io.ReadFull(bc, buf); err != nil {
+		return nil, 0, fmt.Errorf("cannot read response on protocol version %d: %w; "+
+			"probably vmstorage must be upgraded to the version with nanosecond timestamps", vminsertProtocolVersion, err)
+	}
+	serverCaps := encoding.UnmarshalUint64(buf)
+	if err := bc.SetReadDeadline(time.Time{}); err != nil {
+		return nil, 0, fmt.Errorf("cannot reset read deadline: %w", err)
+	}
+	return bc, caps & serverCaps, nil
+}
+
+// VMInsertServer performs server-side handshake for vminsert protocol.
+//
+// It performs handshake.VMInsertServer and then verifies that vminsert uses vminsertProtocolVersion.
+// caps must contain Cap* capabilities supported by vmstorage. The capabilities supported by both vminsert and vmstorage are returned.
+func VMInsertServer(c net.Conn, compressionLevel int, caps uint64) (*handshake.BufferedConn, uint64, error) {
+	bc, err := handshake.VMInsertServer(c, compressionLevel)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := bc.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return nil, 0, fmt.Errorf("cannot set read deadline: %w", err)
+	}
+	buf := make([]byte, 24)
+	if _, err := io.ReadFull(bc, buf[:8]); err != nil {
+		return nil, 0, fmt.Errorf("cannot read protocol hello: %w", err)
+	}
+	if hello := encoding.UnmarshalUint64(buf[:8]); hello != vminsertProtocolHello {
+		if hello <= consts.MaxInsertPacketSize {
+			return nil, 0, fmt.Errorf("vminsert uses the previous protocol with millisecond timestamps; vminsert must be upgraded")
+		}
+		return nil, 0, fmt.Errorf("unexpected protocol hello; got 0x%x; want 0x%x", hello, uint64(vminsertProtocolHello))
+	}
+	if _, err := io.ReadFull(bc, buf[8:]); err != nil {
+		return nil, 0, fmt.Errorf("cannot read protocol version: %w", err)
+	}
+	if version := encoding.UnmarshalUint64(buf[8:]); version != vminsertProtocolVersion {
+		return nil, 0, fmt.Errorf("unsupported protocol version; got %d; want %d", version, vminsertProtocolVersion)
+	}
+	clientCaps := encoding.UnmarshalUint64(buf[16:])
+	if err := bc.SetReadDeadline(time.Time{}); err != nil {
+		return nil, 0, fmt.Errorf("cannot reset read deadline: %w", err)
+	}
+	if err := writeData(bc, encoding.MarshalUint64(buf[:0], caps)); err != nil {
+		return nil, 0, fmt.Errorf("cannot write response on protocol version: %w", err)
+	}
+	return bc, caps & clientCaps, nil
+}
+
+func writeData(bc *handshake.BufferedConn, data []byte) error {
+	if err := bc.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return fmt.Errorf("cannot set write deadline: %w", err)
+	}
+	if _, err := bc.Write(data); err != nil {
+		return err
+	}
+	if err := bc.Flush(); err != nil {
+		return err
+	}
+	if err := bc.SetWriteDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("cannot reset write deadline: %w", err)
+	}
+	return nil
+}
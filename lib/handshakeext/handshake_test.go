@@ -0,0 +1,116 @@
+package handshakeext
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/handshake"
+)
+
+func TestVMInsertHandshakeSuccess(t *testing.T) {
+	f := func(clientCaps, serverCaps, capsExpected uint64) {
+		t.Helper()
+		c, s := net.Pipe()
+		ch := make(chan error, 1)
+		go func() {
+			_, caps, err := VMInsertServer(s, 0, serverCaps)
+			if err == nil && caps != capsExpected {
+				err = fmt.Errorf("unexpected caps; got %d; want %d", caps, capsExpected)
+			}
+			ch <- err
+		}()
+		bc, caps, err := VMInsertClient(c, 0, clientCaps)
+		if err != nil {
+			t.Fatalf("unexpected error on the client side: %s", err)
+		}
+		if bc == nil {
+			t.Fatalf("expecting non-nil conn")
+		}
+		if caps != capsExpected {
+			t.Fatalf("unexpected caps on the client side; got %d; want %d", caps, capsExpected)
+		}
+		if err := waitResult(ch); err != nil {
+			t.Fatalf("unexpected error on the server side: %s", err)
+		}
+	}
+	f(0, 0, 0)
+	f(CapRejectedRows, 0, 0)
+	f(0, CapRejectedRows, 0)
+	f(CapRejectedRows, CapRejectedRows, CapRejectedRows)
+}
+
+func TestVMInsertHandshakePreviousClient(t *testing.T) {
+	c, s := net.Pipe()
+	ch := make(chan error, 1)
+	go func() {
+		// The previous vminsert sends packet size right after handshake.VMInsertClient.
+		bc, err := handshake.VMInsertClient(c, 0)
+		if err != nil {
+			ch <- err
+			return
+		}
+		if _, err := bc.Write(encoding.MarshalUint64(nil, 123)); err != nil {
+			ch <- err
+			return
+		}
+		ch <- bc.Flush()
+	}()
+	_, _, err := VMInsertServer(s, 0, CapRejectedRows)
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if !strings.Contains(err.Error(), "vminsert must be upgraded") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := waitResult(ch); err != nil {
+		t.Fatalf("unexpected error on the client side: %s", err)
+	}
+}
+
+func TestVMInsertHandshakePreviousServer(t *testing.T) {
+	c, s := net.Pipe()
+	ch := make(chan error, 1)
+	go func() {
+		// The previous vmstorage closes the connection on too big packet size.
+		bc, err := handshake.VMInsertServer(s, 0)
+		if err != nil {
+			ch <- err
+			return
+		}
+		// Read the whole hello, since net.Pipe is unbuffered and the client blocks on write otherwise.
+		sizeBuf := make([]byte, 24)
+		if _, err := io.ReadFull(bc, sizeBuf); err != nil {
+			ch <- err
+			return
+		}
+		if n := encoding.UnmarshalUint64(sizeBuf[:8]); n != vminsertProtocolHello {
+			ch <- fmt.Errorf("unexpected packet size; got %d; want %d", n, uint64(vminsertProtocolHello))
+			return
+		}
+		ch <- bc.Close()
+	}()
+	_, _, err := VMInsertClient(c, 0, CapRejectedRows)
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if !strings.Contains(err.Error(), "vmstorage must be upgraded") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := waitResult(ch); err != nil {
+		t.Fatalf("unexpected error on the server side: %s", err)
+	}
+}
+
+func waitResult(ch <-chan error) error {
+	select {
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("timeout")
+	case err := <-ch:
+		return err
+	}
+}
@@ -0,0 +1,127 @@
+package otlppb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Protobuf wire types. See https://developers.google.com/protocol-buffers/docs/encoding#structure
+const (
+	wireTypeVarint          = 0
+	wireTypeFixed64         = 1
+	wireTypeLengthDelimited = 2
+	wireTypeStartGroup      = 3
+	wireTypeEndGroup        = 4
+	wireTypeFixed32         = 5
+)
+
+var (
+	errIntOverflow    = fmt.Errorf("proto: integer overflow")
+	errInvalidLength  = fmt.Errorf("proto: negative length found during unmarshaling")
+	errUnexpectedType = fmt.Errorf("proto: unexpected wire type")
+)
+
+// readTag reads field tag from src and returns field number, wire type and the remaining tail.
+func readTag(src []byte) (int32, int, []byte, error) {
+	tag, tail, err := readVarint(src)
+	if err != nil {
+		return 0, 0, tail, err
+	}
+	fieldNum := int32(tag >> 3)
+	wireType := int(tag & 0x7)
+	if fieldNum <= 0 {
+		return 0, 0, tail, fmt.Errorf("proto: illegal tag %d (wire type %d)", fieldNum, wireType)
+	}
+	return fieldNum, wireType, tail, nil
+}
+
+// readVarint reads varint-encoded value from src.
+func readVarint(src []byte) (uint64, []byte, error) {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		if shift >= 64 {
+			return 0, src, errIntOverflow
+		}
+		if len(src) == 0 {
+			return 0, src, io.ErrUnexpectedEOF
+		}
+		b := src[0]
+		src = src[1:]
+		v |= uint64(b&0x7F) << shift
+		if b < 0x80 {
+			return v, src, nil
+		}
+	}
+}
+
+// readFixed64 reads little-endian 64-bit value from src.
+func readFixed64(src []byte) (uint64, []byte, error) {
+	if len(src) < 8 {
+		return 0, src, io.ErrUnexpectedEOF
+	}
+	return binary.LittleEndian.Uint64(src), src[8:], nil
+}
+
+// readBytes reads length-delimited value from src.
+//
+// The returned value refers to src.
+func readBytes(src []byte) ([]byte, []byte, error) {
+	n, tail, err := readVarint(src)
+	if err != nil {
+		return nil, tail, err
+	}
+	if int(n) < 0 {
+		return nil, tail, errInvalidLength
+	}
+	if uint64(len(tail)) < n {
+		return nil, tail, io.ErrUnexpectedEOF
+	}
+	return tail[:n], tail[n:], nil
+}
+
+// skipField skips the value with the given wireType at the start of src.
+func skipField(src []byte, wireType int) ([]byte, error) {
+	switch wireType {
+	case wireTypeVarint:
+		_, tail, err := readVarint(src)
+		return tail, err
+	case wireTypeFixed64:
+		if len(src) < 8 {
+			return src, io.ErrUnexpectedEOF
+		}
+		return src[8:], nil
+	case wireTypeLengthDelimited:
+		_, tail, err := readBytes(src)
+		return tail, err
+	case wireTypeFixed32:
+		if len(src) < 4 {
+			return src, io.ErrUnexpectedEOF
+		}
+		return src[4:], nil
+	case wireTypeStartGroup:
+		for {
+			fieldNum, wt, tail, err := readTag(src)
+			if err != nil {
+				return tail, err
+			}
+			if wt == wireTypeEndGroup {
+				return tail, nil
+			}
+			src, err = skipField(tail, wt)
+			if err != nil {
+				return src, fmt.Errorf("cannot skip field #%d in group: %w", fieldNum, err)
+			}
+		}
+	default:
+		return src, fmt.Errorf("proto: illegal wire type %d", wireType)
+	}
+}
+
+// checkWireType verifies whether wireType matches wireTypeExpected for the given field.
+func checkWireType(field string, wireType, wireTypeExpected int) error {
+	if wireType != wireTypeExpected {
+		return fmt.Errorf("%w %d for field %s; want %d", errUnexpectedType, wireType, field, wireTypeExpected)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+// Code generated manually from opentelemetry/proto/collector/logs/v1/logs_service.proto,
+// opentelemetry/proto/logs/v1/logs.proto and opentelemetry/proto/common/v1/common.proto
+
+package otlppb
+
+import (
+	"fmt"
+	"math"
+)
+
+// ExportLogsServiceRequest is the request sent by OTLP exporters to /v1/logs.
+type ExportLogsServiceRequest struct {
+	ResourceLogs []ResourceLogs `protobuf:"bytes,1,rep,name=resource_logs" json:"resourceLogs"`
+}
+
+// ResourceLogs is a collection of logs from a single resource.
+type ResourceLogs struct {
+	Resource  Resource    `protobuf:"bytes,1,opt,name=resource" json:"resource"`
+	ScopeLogs []ScopeLogs `protobuf:"bytes,2,rep,name=scope_logs" json:"scopeLogs"`
+}
+
+// Resource is the entity producing logs.
+type Resource struct {
+	Attributes []KeyValue `protobuf:"bytes,1,rep,name=attributes" json:"attributes"`
+}
+
+// ScopeLogs is a collection of logs produced by a single instrumentation scope.
+type ScopeLogs struct {
+	LogRecords []LogRecord `protobuf:"bytes,2,rep,name=log_records" json:"logRecords"`
+}
+
+// LogRecord is a single log record.
+type LogRecord struct {
+	TimeUnixNano         uint64     `protobuf:"fixed64,1,opt,name=time_unix_nano" json:"timeUnixNano"`
+	ObservedTimeUnixNano uint64     `protobuf:"fixed64,11,opt,name=observed_time_unix_nano" json:"observedTimeUnixNano"`
+	SeverityNumber       int32      `protobuf:"varint,2,opt,name=severity_number" json:"severityNumber"`
+	SeverityText         string     `protobuf:"bytes,3,opt,name=severity_text" json:"severityText"`
+	Body                 AnyValue   `protobuf:"bytes,5,opt,name=body" json:"body"`
+	Attributes           []KeyValue `protobuf:"bytes,6,rep,name=attributes" json:"attributes"`
+}
+
+// KeyValue is a key-value pair used for attributes.
+type KeyValue struct {
+	Key   string   `protobuf:"bytes,1,opt,name=key" json:"key"`
+	Value AnyValue `protobuf:"bytes,2,opt,name=value" json:"value"`
+}
+
+// AnyValueType is the type of the value stored in AnyValue.
+type AnyValueType int
+
+// AnyValue types.
+const (
+	AnyValueTypeEmpty AnyValueType = iota
+	AnyValueTypeString
+	AnyValueTypeBool
+	AnyValueTypeInt
+	AnyValueTypeDouble
+	AnyValueTypeArray
+	AnyValueTypeKeyValueList
+	AnyValueTypeBytes
+)
+
+// AnyValue holds one of the supported value types according to Type.
+type AnyValue struct {
+	Type AnyValueType
+
+	StringValue  string     `protobuf:"bytes,1,opt,name=string_value" json:"stringValue"`
+	BoolValue    bool       `protobuf:"varint,2,opt,name=bool_value" json:"boolValue"`
+	IntValue     int64      `protobuf:"varint,3,opt,name=int_value" json:"intValue"`
+	DoubleValue  float64    `protobuf:"fixed64,4,opt,name=double_value" json:"doubleValue"`
+	ArrayValue   []AnyValue `protobuf:"bytes,5,opt,name=array_value" json:"arrayValue"`
+	KeyValueList []KeyValue `protobuf:"bytes,6,opt,name=kvlist_value" json:"kvlistValue"`
+	BytesValue   []byte     `protobuf:"bytes,7,opt,name=bytes_value" json:"bytesValue"`
+}
+
+// Reset resets m.
+func (m *ExportLogsServiceRequest) Reset() {
+	for i := range m.ResourceLogs {
+		m.ResourceLogs[i] = ResourceLogs{}
+	}
+	m.ResourceLogs = m.ResourceLogs[:0]
+}
+
+// Unmarshal unmarshals m from protobuf-encoded src.
+func (m *ExportLogsServiceRequest) Unmarshal(src []byte) (err error) {
+	for len(src) > 0 {
+		var fieldNum int32
+		var wireType int
+		fieldNum, wireType, src, err = readTag(src)
+		if err != nil {
+			return fmt.Errorf("proto: ExportLogsServiceRequest: %w", err)
+		}
+		switch fieldNum {
+		case 1:
+			var data []byte
+			if data, src, err = readMessage("ResourceLogs", src, wireType); err != nil {
+				return err
+			}
+			m.ResourceLogs = append(m.ResourceLogs, ResourceLogs{})
+			if err := m.ResourceLogs[len(m.ResourceLogs)-1].Unmarshal(data); err != nil {
+				return err
+			}
+		default:
+			if src, err = skipField(src, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unmarshal unmarshals m from protobuf-encoded src.
+func (m *ResourceLogs) Unmarshal(src []byte) (err error) {
+	for len(src) > 0 {
+		var fieldNum int32
+		var wireType int
+		fieldNum, wireType, src, err = readTag(src)
+		if err != nil {
+			return fmt.Errorf("proto: ResourceLogs: %w", err)
+		}
+		switch fieldNum {
+		case 1:
+			var data []byte
+			if data, src, err = readMessage("Resource", src, wireType); err != nil {
+				return err
+			}
+			if err := m.Resource.Unmarshal(data); err != nil {
+				return err
+			}
+		case 2, 1000:
+			// Field 1000 contains deprecated InstrumentationLibraryLogs, which are wire-compatible with ScopeLogs.
+			var data []byte
+			if data, src, err = readMessage("ScopeLogs", src, wireType); err != nil {
+				return err
+			}
+			m.ScopeLogs = append(m.ScopeLogs, ScopeLogs{})
+			if err := m.ScopeLogs[len(m.ScopeLogs)-1].Unmarshal(data); err != nil {
+				return err
+			}
+		default:
+			if src, err = skipField(src, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unmarshal unmarshals m from protobuf-encoded src.
+func (m *Resource) Unmarshal(src []byte) (err error) {
+	for len(src) > 0 {
+		var fieldNum int32
+		var wireType int
+		fieldNum, wireType, src, err = readTag(src)
+		if err != nil {
+			return fmt.Errorf("proto: Resource: %w", err)
+		}
+		switch fieldNum {
+		case 1:
+			if m.Attributes, src, err = appendKeyValue(m.Attributes, "Attributes", src, wireType); err != nil {
+				return err
+			}
+		default:
+			if src, err = skipField(src, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unmarshal unmarshals m from protobuf-encoded src.
+func (m *ScopeLogs) Unmarshal(src []byte) (err error) {
+	for len(src) > 0 {
+		var fieldNum int32
+		var wireType int
+		fieldNum, wireType, src, err = readTag(src)
+		if err != nil {
+			return fmt.Errorf("proto: ScopeLogs: %w", err)
+		}
+		switch fieldNum {
+		case 2:
+			var data []byte
+			if data, src, err = readMessage("LogRecords", src, wireType); err != nil {
+				return err
+			}
+			m.LogRecords = append(m.LogRecords, LogRecord{})
+			if err := m.LogRecords[len(m.LogRecords)-1].Unmarshal(data); err != nil {
+				return err
+			}
+		default:
+			if src, err = skipField(src, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unmarshal unmarshals m from protobuf-encoded src.
+func (m *LogRecord) Unmarshal(src []byte) (err error) {
+	for len(src) > 0 {
+		var fieldNum int32
+		var wireType int
+		fieldNum, wireType, src, err = readTag(src)
+		if err != nil {
+			return fmt.Errorf("proto: LogRecord: %w", err)
+		}
+		switch fieldNum {
+		case 1:
+			if err := checkWireType("TimeUnixNano", wireType, wireTypeFixed64); err != nil {
+				return err
+			}
+			if m.TimeUnixNano, src, err = readFixed64(src); err != nil {
+				return err
+			}
+		case 11:
+			if err := checkWireType("ObservedTimeUnixNano", wireType, wireTypeFixed64); err != nil {
+				return err
+			}
+			if m.ObservedTimeUnixNano, src, err = readFixed64(src); err != nil {
+				return err
+			}
+		case 2:
+			if err := checkWireType("SeverityNumber", wireType, wireTypeVarint); err != nil {
+				return err
+			}
+			var v uint64
+			if v, src, err = readVarint(src); err != nil {
+				return err
+			}
+			m.SeverityNumber = int32(v)
+		case 3:
+			var data []byte
+			if data, src, err = readMessage("SeverityText", src, wireType); err != nil {
+				return err
+			}
+			m.SeverityText = string(data)
+		case 5:
+			var data []byte
+			if data, src, err = readMessage("Body", src, wireType); err != nil {
+				return err
+			}
+			if err := m.Body.Unmarshal(data); err != nil {
+				return err
+			}
+		case 6:
+			if m.Attributes, src, err = appendKeyValue(m.Attributes, "Attributes", src, wireType); err != nil {
+				return err
+			}
+		default:
+			if src, err = skipField(src, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unmarshal unmarshals m from protobuf-encoded src.
+func (m *KeyValue) Unmarshal(src []byte) (err error) {
+	for len(src) > 0 {
+		var fieldNum int32
+		var wireType int
+		fieldNum, wireType, src, err = readTag(src)
+		if err != nil {
+			return fmt.Errorf("proto: KeyValue: %w", err)
+		}
+		switch fieldNum {
+		case 1:
+			var data []byte
+			if data, src, err = readMessage("Key", src, wireType); err != nil {
+				return err
+			}
+			m.Key = string(data)
+		case 2:
+			var data []byte
+			if data, src, err = readMessage("Value", src, wireType); err != nil {
+				return err
+			}
+			if err := m.Value.Unmarshal(data); err != nil {
+				return err
+			}
+		default:
+			if src, err = skipField(src, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Unmarshal unmarshals m from protobuf-encoded src.
+func (m *AnyValue) Unmarshal(src []byte) (err error) {
+	for len(src) > 0 {
+		var fieldNum int32
+		var wireType int
+		fieldNum, wireType, src, err = readTag(src)
+		if err != nil {
+			return fmt.Errorf("proto: AnyValue: %w", err)
+		}
+		switch fieldNum {
+		case 1:
+			var data []byte
+			if data, src, err = readMessage("StringValue", src, wireType); err != nil {
+				return err
+			}
+			m.Type = AnyValueTypeString
+			m.StringValue = string(data)
+		case 2:
+			if err := checkWireType("BoolValue", wireType, wireTypeVarint); err != nil {
+				return err
+			}
+			var v uint64
+			if v, src, err = readVarint(src); err != nil {
+				return err
+			}
+			m.Type = AnyValueTypeBool
+			m.BoolValue = v != 0
+		case 3:
+			if err := checkWireType("IntValue", wireType, wireTypeVarint); err != nil {
+				return err
+			}
+			var v uint64
+			if v, src, err = readVarint(src); err != nil {
+				return err
+			}
+			m.Type = AnyValueTypeInt
+			m.IntValue = int64(v)
+		case 4:
+			if err := checkWireType("DoubleValue", wireType, wireTypeFixed64); err != nil {
+				return err
+			}
+			var v uint64
+			if v, src, err = readFixed64(src); err != nil {
+				return err
+			}
+			m.Type = AnyValueTypeDouble
+			m.DoubleValue = math.Float64frombits(v)
+		case 5:
+			// ArrayValue message contains `repeated AnyValue values = 1`.
+			var data []byte
+			if data, src, err = readMessage("ArrayValue", src, wireType); err != nil {
+				return err
+			}
+			m.Type = AnyValueTypeArray
+			m.ArrayValue = m.ArrayValue[:0]
+			for len(data) > 0 {
+				var fn int32
+				var wt int
+				if fn, wt, data, err = readTag(data); err != nil {
+					return fmt.Errorf("proto: ArrayValue: %w", err)
+				}
+				if fn != 1 {
+					if data, err = skipField(data, wt); err != nil {
+						return err
+					}
+					continue
+				}
+				var item []byte
+				if item, data, err = readMessage("Values", data, wt); err != nil {
+					return err
+				}
+				m.ArrayValue = append(m.ArrayValue, AnyValue{})
+				if err := m.ArrayValue[len(m.ArrayValue)-1].Unmarshal(item); err != nil {
+					return err
+				}
+			}
+		case 6:
+			// KeyValueList message contains `repeated KeyValue values = 1`.
+			var data []byte
+			if data, src, err = readMessage("KeyValueList", src, wireType); err != nil {
+				return err
+			}
+			m.Type = AnyValueTypeKeyValueList
+			m.KeyValueList = m.KeyValueList[:0]
+			for len(data) > 0 {
+				var fn int32
+				var wt int
+				if fn, wt, data, err = readTag(data); err != nil {
+					return fmt.Errorf("proto: KeyValueList: %w", err)
+				}
+				if fn != 1 {
+					if data, err = skipField(data, wt); err != nil {
+						return err
+					}
+					continue
+				}
+				if m.KeyValueList, data, err = appendKeyValue(m.KeyValueList, "Values", data, wt); err != nil {
+					return err
+				}
+			}
+		case 7:
+			var data []byte
+			if data, src, err = readMessage("BytesValue", src, wireType); err != nil {
+				return err
+			}
+			m.Type = AnyValueTypeBytes
+			m.BytesValue = append(m.BytesValue[:0], data...)
+		default:
+			if src, err = skipField(src, wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readMessage reads length-delimited value for the given field from src.
+func readMessage(field string, src []byte, wireType int) ([]byte, []byte, error) {
+	if err := checkWireType(field, wireType, wireTypeLengthDelimited); err != nil {
+		return nil, src, err
+	}
+	data, tail, err := readBytes(src)
+	if err != nil {
+		return nil, tail, fmt.Errorf("cannot read field %s: %w", field, err)
+	}
+	return data, tail, nil
+}
+
+// appendKeyValue reads KeyValue message for the given field from src and appends it to dst.
+func appendKeyValue(dst []KeyValue, field string, src []byte, wireType int) ([]KeyValue, []byte, error) {
+	data, tail, err := readMessage(field, src, wireType)
+	if err != nil {
+		return dst, tail, err
+	}
+	dst = append(dst, KeyValue{})
+	if err := dst[len(dst)-1].Unmarshal(data); err != nil {
+		return dst, tail, err
+	}
+	return dst, tail, nil
+}
@@ -0,0 +1,146 @@
+package otlppb
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestExportLogsServiceRequestUnmarshal(t *testing.T) {
+	// Marshal the request manually.
+	kv := func(key string, value []byte) []byte {
+		var b []byte
+		b = appendBytesField(b, 1, []byte(key))
+		b = appendBytesField(b, 2, value)
+		return b
+	}
+	stringValue := func(s string) []byte {
+		return appendBytesField(nil, 1, []byte(s))
+	}
+
+	var resource []byte
+	resource = appendBytesField(resource, 1, kv("service.name", stringValue("api")))
+	resource = appendVarintField(resource, 2, 3) // dropped_attributes_count must be skipped
+
+	var logRecord []byte
+	logRecord = appendFixed64Field(logRecord, 1, 1603195200123456789)
+	logRecord = appendFixed64Field(logRecord, 11, 1603195201000000000)
+	logRecord = appendVarintField(logRecord, 2, 9)
+	logRecord = appendBytesField(logRecord, 3, []byte("Information"))
+	logRecord = appendBytesField(logRecord, 5, stringValue("foo bar"))
+	logRecord = appendBytesField(logRecord, 6, kv("bool", appendVarintField(nil, 2, 1)))
+	logRecord = appendBytesField(logRecord, 6, kv("int", appendVarintField(nil, 3, uint64(math.MaxUint64)))) // -1
+	logRecord = appendBytesField(logRecord, 6, kv("double", appendFixed64Field(nil, 4, math.Float64bits(1.5))))
+	logRecord = appendBytesField(logRecord, 6, kv("bytes", appendBytesField(nil, 7, []byte{1, 2})))
+	logRecord = appendBytesField(logRecord, 6, kv("array", appendBytesField(nil, 5, appendBytesField(nil, 1, stringValue("x")))))
+	logRecord = appendBytesField(logRecord, 6, kv("kvlist", appendBytesField(nil, 6, appendBytesField(nil, 1, kv("a", stringValue("b"))))))
+	logRecord = appendBytesField(logRecord, 9, []byte("trace_id must be skipped"))
+	logRecord = appendFixed32Field(logRecord, 8, 1)
+
+	var scopeLogs []byte
+	scopeLogs = appendBytesField(scopeLogs, 1, []byte("scope must be skipped"))
+	scopeLogs = appendBytesField(scopeLogs, 2, logRecord)
+
+	var resourceLogs []byte
+	resourceLogs = appendBytesField(resourceLogs, 1, resource)
+	resourceLogs = appendBytesField(resourceLogs, 2, scopeLogs)
+	// Deprecated instrumentation_library_logs
+	resourceLogs = appendBytesField(resourceLogs, 1000, appendBytesField(nil, 2, appendBytesField(nil, 5, stringValue("old"))))
+
+	data := appendBytesField(nil, 1, resourceLogs)
+
+	var req ExportLogsServiceRequest
+	if err := req.Unmarshal(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	reqExpected := ExportLogsServiceRequest{
+		ResourceLogs: []ResourceLogs{{
+			Resource: Resource{
+				Attributes: []KeyValue{{
+					Key:   "service.name",
+					Value: AnyValue{Type: AnyValueTypeString, StringValue: "api"},
+				}},
+			},
+			ScopeLogs: []ScopeLogs{
+				{
+					LogRecords: []LogRecord{{
+						TimeUnixNano:         1603195200123456789,
+						ObservedTimeUnixNano: 1603195201000000000,
+						SeverityNumber:       9,
+						SeverityText:         "Information",
+						Body:                 AnyValue{Type: AnyValueTypeString, StringValue: "foo bar"},
+						Attributes: []KeyValue{
+							{Key: "bool", Value: AnyValue{Type: AnyValueTypeBool, BoolValue: true}},
+							{Key: "int", Value: AnyValue{Type: AnyValueTypeInt, IntValue: -1}},
+							{Key: "double", Value: AnyValue{Type: AnyValueTypeDouble, DoubleValue: 1.5}},
+							{Key: "bytes", Value: AnyValue{Type: AnyValueTypeBytes, BytesValue: []byte{1, 2}}},
+							{Key: "array", Value: AnyValue{Type: AnyValueTypeArray, ArrayValue: []AnyValue{
+								{Type: AnyValueTypeString, StringValue: "x"},
+							}}},
+							{Key: "kvlist", Value: AnyValue{Type: AnyValueTypeKeyValueList, KeyValueList: []KeyValue{
+								{Key: "a", Value: AnyValue{Type: AnyValueTypeString, StringValue: "b"}},
+							}}},
+						},
+					}},
+				},
+				{
+					LogRecords: []LogRecord{{
+						Body: AnyValue{Type: AnyValueTypeString, StringValue: "old"},
+					}},
+				},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(&req, &reqExpected) {
+		t.Fatalf("unexpected request;\ngot\n%+v\nwant\n%+v", &req, &reqExpected)
+	}
+
+	// Truncated data must result in error.
+	for i := 1; i < len(data); i++ {
+		var req ExportLogsServiceRequest
+		if err := req.Unmarshal(data[:i]); err == nil {
+			t.Fatalf("expecting non-nil error when unmarshaling the first %d bytes", i)
+		}
+	}
+
+	// Unexpected wire type must result in error.
+	if err := req.Unmarshal(appendVarintField(nil, 1, 123)); err == nil {
+		t.Fatalf("expecting non-nil error for unexpected wire type")
+	}
+}
+
+func appendTag(dst []byte, fieldNum, wireType int) []byte {
+	return appendUvarint(dst, uint64(fieldNum<<3|wireType))
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(dst, b[:n]...)
+}
+
+func appendVarintField(dst []byte, fieldNum int, v uint64) []byte {
+	dst = appendTag(dst, fieldNum, wireTypeVarint)
+	return appendUvarint(dst, v)
+}
+
+func appendFixed64Field(dst []byte, fieldNum int, v uint64) []byte {
+	dst = appendTag(dst, fieldNum, wireTypeFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(dst, b[:]...)
+}
+
+func appendFixed32Field(dst []byte, fieldNum int, v uint32) []byte {
+	dst = appendTag(dst, fieldNum, wireTypeFixed32)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(dst, b[:]...)
+}
+
+func appendBytesField(dst []byte, fieldNum int, data []byte) []byte {
+	dst = appendTag(dst, fieldNum, wireTypeLengthDelimited)
+	dst = appendUvarint(dst, uint64(len(data)))
+	return append(dst, data...)
+}
@@ -0,0 +1,214 @@
+package contentencoding
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Reader reads uncompressed data from the request body with the given Content-Encoding.
+//
+// It returns an error if the uncompressed data exceeds the size passed to GetReader.
+// This protects from compression bombs.
+type Reader struct {
+	r io.Reader
+
+	zr *gzip.Reader
+	zd *zstd.Decoder
+
+	// zdMaxMemory is the memory limit zd is created with.
+	zdMaxMemory uint64
+
+	bytesRead int64
+	maxSize   int64
+}
+
+// GetReader returns Reader for uncompressed data from r according to contentEncoding.
+//
+// The following encodings are supported: identity (or empty), gzip and zstd.
+//
+// Reader.Read returns an error if more than maxSize bytes of uncompressed data is read.
+//
+// Call PutReader when the returned Reader is no longer needed.
+func GetReader(r io.Reader, contentEncoding string, maxSize int64) (*Reader, error) {
+	zr := getReader()
+	zr.maxSize = maxSize
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		zr.r = r
+	case "gzip", "x-gzip":
+		if err := zr.initGzip(r); err != nil {
+			PutReader(zr)
+			return nil, fmt.Errorf("cannot read gzipped data: %w", err)
+		}
+	case "zstd":
+		if err := zr.initZstd(r, maxSize); err != nil {
+			PutReader(zr)
+			return nil, fmt.Errorf("cannot read zstd-compressed data: %w", err)
+		}
+	default:
+		PutReader(zr)
+		unsupportedEncodings.Inc()
+		return nil, fmt.Errorf("unsupported Content-Encoding: %q; supported values: gzip, zstd, identity", contentEncoding)
+	}
+	return zr, nil
+}
+
+// PutReader returns zr to the pool.
+//
+// zr cannot be used after returning to the pool.
+func PutReader(zr *Reader) {
+	if zr.zd != nil {
+		putZstdDecoder(zr.zd, zr.zdMaxMemory)
+		zr.zd = nil
+		zr.zdMaxMemory = 0
+	}
+	zr.r = nil
+	zr.bytesRead = 0
+	zr.maxSize = 0
+	readerPool.Put(zr)
+}
+
+// Read implements io.Reader.
+func (zr *Reader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.bytesRead += int64(n)
+	if zr.bytesRead > zr.maxSize {
+		tooBigRequests.Inc()
+		return n, fmt.Errorf("too big uncompressed request; it mustn't exceed %d bytes", zr.maxSize)
+	}
+	return n, err
+}
+
+func (zr *Reader) initGzip(r io.Reader) error {
+	if zr.zr == nil {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		zr.zr = gr
+	} else if err := zr.zr.Reset(r); err != nil {
+		return err
+	}
+	zr.r = zr.zr
+	return nil
+}
+
+func (zr *Reader) initZstd(r io.Reader, maxSize int64) error {
+	// zstd.Decoder treats data without frames as an empty stream, so verify the frame magic explicitly.
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return fmt.Errorf("cannot read zstd frame magic: %w", err)
+	}
+	if !bytes.Equal(magic[:], zstdFrameMagic) {
+		return fmt.Errorf("unexpected zstd frame magic; got %X; want %X", magic[:], zstdFrameMagic)
+	}
+	maxMemory := getZstdMaxMemory(maxSize)
+	zd, err := getZstdDecoder(io.MultiReader(bytes.NewReader(magic[:]), r), maxMemory)
+	if err != nil {
+		return err
+	}
+	zr.zd = zd
+	zr.zdMaxMemory = maxMemory
+	zr.r = zd
+	return nil
+}
+
+// zstdMaxWindowSize is the maximum window size for zstd-compressed data.
+//
+// zstd.Decoder allocates memory for the whole window, so bigger windows aren't accepted in order to protect from compression bombs.
+// 8MB is the maximum window size, which must be supported by decoders according to zstd format specification.
+const zstdMaxWindowSize = 8 * 1024 * 1024
+
+// zstdMaxBlockSize is the maximum size of zstd block.
+//
+// zstd.Decoder keeps up to a block of data past the window.
+const zstdMaxBlockSize = 128 * 1024
+
+// getZstdMaxMemory returns memory limit for zstd.Decoder reading up to maxSize bytes of uncompressed data.
+//
+// The limit bounds both the window size and the size of data decoded at once,
+// so frames with windows exceeding maxSize are rejected.
+func getZstdMaxMemory(maxSize int64) uint64 {
+	if maxSize < zstd.MinWindowSize {
+		return zstd.MinWindowSize
+	}
+	if maxSize > zstdMaxWindowSize+zstdMaxBlockSize {
+		return zstdMaxWindowSize + zstdMaxBlockSize
+	}
+	return uint64(maxSize)
+}
+
+var zstdFrameMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func getZstdDecoder(r io.Reader, maxMemory uint64) (*zstd.Decoder, error) {
+	select {
+	case zd := <-getZstdDecoderPoolCh(maxMemory):
+		if err := zd.Reset(r); err != nil {
+			zd.Close()
+			return nil, err
+		}
+		return zd, nil
+	default:
+		// Use a single goroutine for decoding, since the data is decoded
+		// by many concurrent requests anyway.
+		return zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxMemory))
+	}
+}
+
+func putZstdDecoder(zd *zstd.Decoder, maxMemory uint64) {
+	// Release the reference to the request body.
+	if err := zd.Reset(&bytes.Buffer{}); err != nil {
+		zd.Close()
+		return
+	}
+	select {
+	case getZstdDecoderPoolCh(maxMemory) <- zd:
+	default:
+		// zstd.Decoder holds a background goroutine, so it must be closed
+		// instead of leaving it in sync.Pool, which may be cleaned by GC.
+		zd.Close()
+	}
+}
+
+// getZstdDecoderPoolCh returns the pool for zstd.Decoder with the given maxMemory.
+//
+// The memory limit cannot be changed for the created zstd.Decoder, so decoders are pooled per limit.
+// The number of distinct limits is small, since they are derived from command-line flags.
+func getZstdDecoderPoolCh(maxMemory uint64) chan *zstd.Decoder {
+	zstdDecoderPoolsLock.Lock()
+	ch := zstdDecoderPools[maxMemory]
+	if ch == nil {
+		ch = make(chan *zstd.Decoder, runtime.GOMAXPROCS(-1))
+		zstdDecoderPools[maxMemory] = ch
+	}
+	zstdDecoderPoolsLock.Unlock()
+	return ch
+}
+
+var (
+	zstdDecoderPoolsLock sync.Mutex
+	zstdDecoderPools     = make(map[uint64]chan *zstd.Decoder)
+)
+
+func getReader() *Reader {
+	v := readerPool.Get()
+	if v == nil {
+		return &Reader{}
+	}
+	return v.(*Reader)
+}
+
+var readerPool sync.Pool
+
+var (
+	tooBigRequests       = metrics.NewCounter(`vm_protoparser_too_big_uncompressed_requests_total`)
+	unsupportedEncodings = metrics.NewCounter(`vm_protoparser_unsupported_content_encoding_requests_total`)
+)
@@ -0,0 +1,170 @@
+package contentencoding
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestReaderSuccess(t *testing.T) {
+	f := func(contentEncoding string, data []byte) {
+		t.Helper()
+		zr, err := GetReader(bytes.NewReader(compress(t, contentEncoding, data)), contentEncoding, int64(len(data)))
+		if err != nil {
+			t.Fatalf("unexpected error in GetReader: %s", err)
+		}
+		defer PutReader(zr)
+		result, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("unexpected error when reading data: %s", err)
+		}
+		if !bytes.Equal(result, data) {
+			t.Fatalf("unexpected data read;\ngot\n%q\nwant\n%q", result, data)
+		}
+	}
+	data := []byte(`loki{job="foo"} "line 1"` + "\n" + `loki{job="bar"} "line 2"`)
+	f("", data)
+	f("identity", data)
+	f("gzip", data)
+	f("GZIP", data)
+	f("zstd", data)
+}
+
+func TestReaderTooBig(t *testing.T) {
+	f := func(contentEncoding string) {
+		t.Helper()
+		data := bytes.Repeat([]byte("a"), 1024*1024)
+		zr, err := GetReader(bytes.NewReader(compress(t, contentEncoding, data)), contentEncoding, int64(len(data)-1))
+		if err != nil {
+			t.Fatalf("unexpected error in GetReader: %s", err)
+		}
+		defer PutReader(zr)
+		if _, err := ioutil.ReadAll(zr); err == nil {
+			t.Fatalf("expecting non-nil error for too big data")
+		}
+	}
+	f("")
+	f("gzip")
+	f("zstd")
+}
+
+func TestReaderFailure(t *testing.T) {
+	f := func(contentEncoding string, data []byte) {
+		t.Helper()
+		zr, err := GetReader(bytes.NewReader(data), contentEncoding, 1024)
+		if err == nil {
+			_, err = ioutil.ReadAll(zr)
+			PutReader(zr)
+		}
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f("br", []byte("foo"))
+	f("gzip", []byte("foo"))
+	f("zstd", []byte("foo"))
+	f("zstd", []byte("foobar"))
+	f("zstd", nil)
+
+	// Truncated zstd frame
+	data := compress(t, "zstd", []byte("foobar"))
+	f("zstd", data[:len(data)-2])
+}
+
+func TestReaderZstdPool(t *testing.T) {
+	// Decoders returned to the pool must decode subsequent requests.
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf("request %d", i))
+		zr, err := GetReader(bytes.NewReader(compress(t, "zstd", data)), "zstd", 1024)
+		if err != nil {
+			t.Fatalf("unexpected error in GetReader: %s", err)
+		}
+		result, err := ioutil.ReadAll(zr)
+		PutReader(zr)
+		if err != nil {
+			t.Fatalf("unexpected error when reading data: %s", err)
+		}
+		if !bytes.Equal(result, data) {
+			t.Fatalf("unexpected data read;\ngot\n%q\nwant\n%q", result, data)
+		}
+	}
+}
+
+func TestReaderZstdWindowSize(t *testing.T) {
+	f := func(windowSize int, maxSize int64, resultExpected bool) {
+		t.Helper()
+		data := bytes.Repeat([]byte("foobar "), 150*1024)
+		var bb bytes.Buffer
+		zw, err := zstd.NewWriter(&bb, zstd.WithWindowSize(windowSize))
+		if err != nil {
+			t.Fatalf("cannot create zstd writer: %s", err)
+		}
+		if _, err := zw.Write(data); err != nil {
+			t.Fatalf("cannot write zstd data: %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("cannot close zstd writer: %s", err)
+		}
+		zr, err := GetReader(&bb, "zstd", maxSize)
+		if err != nil {
+			t.Fatalf("unexpected error in GetReader: %s", err)
+		}
+		defer PutReader(zr)
+		result, err := ioutil.ReadAll(zr)
+		if !resultExpected {
+			if err == nil {
+				t.Fatalf("expecting non-nil error for windowSize=%d, maxSize=%d", windowSize, maxSize)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for windowSize=%d, maxSize=%d: %s", windowSize, maxSize, err)
+		}
+		if !bytes.Equal(result, data) {
+			t.Fatalf("unexpected data read for windowSize=%d, maxSize=%d", windowSize, maxSize)
+		}
+	}
+
+	// The window fits the limits.
+	f(1024*1024, 1024*1024*1024, true)
+	f(zstdMaxWindowSize, 1024*1024*1024, true)
+
+	// The window exceeds zstdMaxWindowSize.
+	f(2*zstdMaxWindowSize, 1024*1024*1024, false)
+
+	// The window exceeds maxSize.
+	f(4*1024*1024, 2*1024*1024, false)
+}
+
+func compress(t *testing.T, contentEncoding string, data []byte) []byte {
+	t.Helper()
+	var bb bytes.Buffer
+	switch contentEncoding {
+	case "gzip", "GZIP":
+		zw := gzip.NewWriter(&bb)
+		if _, err := zw.Write(data); err != nil {
+			t.Fatalf("cannot write gzipped data: %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("cannot close gzip writer: %s", err)
+		}
+	case "zstd":
+		zw, err := zstd.NewWriter(&bb)
+		if err != nil {
+			t.Fatalf("cannot create zstd writer: %s", err)
+		}
+		if _, err := zw.Write(data); err != nil {
+			t.Fatalf("cannot write zstd data: %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("cannot close zstd writer: %s", err)
+		}
+	default:
+		bb.Write(data)
+	}
+	return bb.Bytes()
+}
@@ -0,0 +1,203 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/valyala/fastjson"
+)
+
+// Rows contains log entries parsed from Elasticsearch bulk request documents.
+type Rows struct {
+	Rows []Row
+
+	labelsPool []storage.Label
+	buf        []byte
+}
+
+// Reset resets rs.
+func (rs *Rows) Reset() {
+	// Reset items, so they can be GC'ed
+	for i := range rs.Rows {
+		rs.Rows[i].reset()
+	}
+	rs.Rows = rs.Rows[:0]
+
+	for i := range rs.labelsPool {
+		label := &rs.labelsPool[i]
+		label.Name = nil
+		label.Value = nil
+	}
+	rs.labelsPool = rs.labelsPool[:0]
+	rs.buf = rs.buf[:0]
+}
+
+// Row is a single log entry obtained from Elasticsearch document.
+type Row struct {
+	// Timestamp is unix timestamp in nanoseconds.
+	Timestamp int64
+
+	Labels  []storage.Label
+	Message []byte
+}
+
+func (r *Row) reset() {
+	r.Timestamp = 0
+	r.Labels = nil
+	r.Message = nil
+}
+
+// field is a document field, which may refer to nested objects via dots, e.g. `host.name`.
+type field struct {
+	name string
+	path []string
+}
+
+func newField(name string) field {
+	return field{
+		name: name,
+		path: strings.Split(name, "."),
+	}
+}
+
+// get returns the value for f from the document v.
+//
+// nil is returned if the field is missing.
+func (f *field) get(v *fastjson.Value) *fastjson.Value {
+	if fv := v.Get(f.name); fv != nil || len(f.path) == 1 {
+		return fv
+	}
+	return v.Get(f.path...)
+}
+
+// streamField is a document field, which is converted into stream label.
+type streamField struct {
+	field
+	labelName []byte
+}
+
+// config determines how documents are converted into log entries.
+type config struct {
+	streamFields   []streamField
+	messageField   field
+	timestampField field
+}
+
+func newConfig(streamFields []string, messageField, timestampField string) *config {
+	var cfg config
+	for _, name := range streamFields {
+		cfg.streamFields = append(cfg.streamFields, streamField{
+			field:     newField(name),
+			labelName: protoparserutil.AppendSanitizedLabelName(nil, name),
+		})
+	}
+	cfg.messageField = newField(messageField)
+	cfg.timestampField = newField(timestampField)
+	return &cfg
+}
+
+// unmarshalDocument converts the document v into a log entry according to cfg and appends it to rs.Rows.
+//
+// line must contain the original document. It is used as the message if the document has no cfg.messageField.
+// Messages and label values are copied to rs, so v and line may be modified after the call.
+func (rs *Rows) unmarshalDocument(cfg *config, v *fastjson.Value, line []byte, now int64) error {
+	if v.Type() != fastjson.TypeObject {
+		return fmt.Errorf("the document must be JSON object; got %s", v.Type())
+	}
+	timestamp := now
+	if tv := cfg.timestampField.get(v); tv != nil {
+		ts, err := parseTimestamp(tv)
+		if err != nil {
+			return fmt.Errorf("cannot parse field %q: %w", cfg.timestampField.name, err)
+		}
+		timestamp = ts
+	}
+
+	labelsStart := len(rs.labelsPool)
+	for i := range cfg.streamFields {
+		sf := &cfg.streamFields[i]
+		fv := sf.get(v)
+		if fv == nil {
+			continue
+		}
+		bufLen := len(rs.buf)
+		rs.buf = appendScalarValue(rs.buf, fv)
+		if len(rs.buf) == bufLen {
+			// Skip empty values and values, which cannot be converted to label values.
+			continue
+		}
+		rs.labelsPool = append(rs.labelsPool, storage.Label{
+			Name:  sf.labelName,
+			Value: rs.buf[bufLen:],
+		})
+	}
+	labels := rs.labelsPool[labelsStart:]
+	if len(labels) == 0 {
+		return fmt.Errorf("the document has no non-empty fields from -elasticsearch.streamFields")
+	}
+
+	bufLen := len(rs.buf)
+	if mv := cfg.messageField.get(v); mv != nil {
+		if mv.Type() == fastjson.TypeString {
+			rs.buf = append(rs.buf, mv.GetStringBytes()...)
+		} else {
+			rs.buf = mv.MarshalTo(rs.buf)
+		}
+	} else {
+		rs.buf = append(rs.buf, line...)
+	}
+	message := rs.buf[bufLen:]
+
+	rs.Rows = append(rs.Rows, Row{
+		Timestamp: timestamp,
+		Labels:    labels[:len(labels):len(labels)],
+		Message:   message[:len(message):len(message)],
+	})
+	return nil
+}
+
+// appendScalarValue appends string representation for the scalar value v to dst.
+//
+// Nothing is appended for null, objects and arrays.
+func appendScalarValue(dst []byte, v *fastjson.Value) []byte {
+	switch v.Type() {
+	case fastjson.TypeString:
+		return append(dst, v.GetStringBytes()...)
+	case fastjson.TypeNumber, fastjson.TypeTrue, fastjson.TypeFalse:
+		return v.MarshalTo(dst)
+	default:
+		return dst
+	}
+}
+
+// parseTimestamp parses timestamp from v and returns it in nanoseconds.
+//
+// Strings must be in RFC 3339 format, while numbers must contain milliseconds since unix epoch
+// like the default `strict_date_optional_time||epoch_millis` format in Elasticsearch.
+func parseTimestamp(v *fastjson.Value) (int64, error) {
+	switch v.Type() {
+	case fastjson.TypeString:
+		s := bytesutil.ToUnsafeString(v.GetStringBytes())
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0, err
+		}
+		return t.UnixNano(), nil
+	case fastjson.TypeNumber:
+		if n, err := v.Int64(); err == nil {
+			// Fast path - integer milliseconds.
+			return n * 1e6, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return 0, err
+		}
+		return int64(f * 1e6), nil
+	default:
+		return 0, fmt.Errorf("unsupported timestamp type %s; it must be string or number", v.Type())
+	}
+}
@@ -0,0 +1,84 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestRowsUnmarshalDocumentSuccess(t *testing.T) {
+	const now = 123
+	cfg := newConfig([]string{"host.name", "kubernetes.pod", "level", "code", "missing", "nested"}, "message", "@timestamp")
+	f := func(s, rowExpected string) {
+		t.Helper()
+		var p fastjson.Parser
+		v, err := p.Parse(s)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s, err)
+		}
+		var rs Rows
+		if err := rs.unmarshalDocument(cfg, v, []byte(s), now); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(rs.Rows) != 1 {
+			t.Fatalf("unexpected number of rows; got %d; want 1", len(rs.Rows))
+		}
+		row := rowString(&rs.Rows[0])
+		if row != rowExpected {
+			t.Fatalf("unexpected row for %q;\ngot\n%s\nwant\n%s", s, row, rowExpected)
+		}
+	}
+
+	// Missing message and timestamp
+	f(`{"level":"info"}`, `{level="info"} 123 "{\"level\":\"info\"}"`)
+
+	// Message and timestamp
+	f(`{"@timestamp":"2020-10-20T12:00:00.123456789Z","level":"info","message":"foo\nbar"}`, `{level="info"} 1603195200123456789 "foo\nbar"`)
+	f(`{"@timestamp":1603195200123,"level":"info","message":123}`, `{level="info"} 1603195200123000000 "123"`)
+
+	// Labels from nested and dotted fields
+	f(`{"host":{"name":"h1"},"kubernetes.pod":"p1","level":"","code":200,"nested":{"a":"b"},"message":"x"}`, `{host_name="h1",kubernetes_pod="p1",code="200"} 123 "x"`)
+
+	// The whole document is used as message
+	f(`{"level":"info","msg":"foo"}`, `{level="info"} 123 "{\"level\":\"info\",\"msg\":\"foo\"}"`)
+}
+
+func TestRowsUnmarshalDocumentFailure(t *testing.T) {
+	cfg := newConfig([]string{"level"}, "message", "@timestamp")
+	f := func(s string) {
+		t.Helper()
+		var p fastjson.Parser
+		v, err := p.Parse(s)
+		if err != nil {
+			t.Fatalf("cannot parse %q: %s", s, err)
+		}
+		var rs Rows
+		if err := rs.unmarshalDocument(cfg, v, []byte(s), time.Now().UnixNano()); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+		if len(rs.Rows) != 0 {
+			t.Fatalf("expecting zero rows; got %d rows", len(rs.Rows))
+		}
+	}
+	f(`"foo"`)
+	f(`[]`)
+	f(`{"@timestamp":"foobar","level":"info"}`)
+	f(`{"@timestamp":"2020-10-20","level":"info"}`)
+	f(`{"@timestamp":true,"level":"info"}`)
+
+	// Missing stream fields
+	f(`{"message":"foo"}`)
+	f(`{"level":"","message":"foo"}`)
+	f(`{"level":null,"message":"foo"}`)
+}
+
+func rowString(r *Row) string {
+	var labels []string
+	for _, label := range r.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+	}
+	return fmt.Sprintf("{%s} %d %q", strings.Join(labels, ","), r.Timestamp, r.Message)
+}
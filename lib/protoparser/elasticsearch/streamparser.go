@@ -0,0 +1,320 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/valyala/fastjson"
+)
+
+var (
+	streamFields = flagutil.NewArray("elasticsearch.streamFields", "Document fields to use as stream labels for logs ingested via Elasticsearch bulk API. "+
+		"Nested fields may be referred via dots, e.g. host.name. Unsupported chars in label names are replaced with underscores. "+
+		"Documents without these fields are rejected")
+	messageField = flag.String("elasticsearch.messageField", "message", "Document field to use as log line for logs ingested via Elasticsearch bulk API. "+
+		"The whole document is used as log line if the field is missing")
+	timestampField = flag.String("elasticsearch.timestampField", "@timestamp", "Document field with log timestamp for logs ingested via Elasticsearch bulk API. "+
+		"The timestamp must be in RFC3339 format or in milliseconds since unix epoch. The current time is used if the field is missing")
+)
+
+// The maximum size of messages and label values passed to a single callback call.
+const maxBatchSize = 256 * 1024
+
+// Item is the result for a single action from Elasticsearch bulk request.
+type Item struct {
+	// Action is the action name such as `index` or `create`.
+	Action string
+
+	// Status is http status code for the action.
+	Status int
+
+	// ErrorType and ErrorReason are set for failed actions.
+	ErrorType   string
+	ErrorReason string
+}
+
+// RejectedRowsError may be returned from ParseStream callback if some of the passed rows are rejected,
+// while the remaining rows are accepted.
+//
+// ParseStream reports errors for the items with the rejected rows and continues parsing the request in this case.
+type RejectedRowsError struct {
+	Rows []RejectedRow
+}
+
+// RejectedRow describes a row rejected by ParseStream callback.
+type RejectedRow struct {
+	// Idx is the index of the rejected row in rows passed to the callback.
+	Idx int
+
+	// Reason describes why the row is rejected.
+	Reason string
+}
+
+// Error implements error interface.
+func (e *RejectedRowsError) Error() string {
+	return fmt.Sprintf("rejected %d rows", len(e.Rows))
+}
+
+// ParseStream parses Elasticsearch bulk request from r and calls callback for the parsed rows.
+//
+// See https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+//
+// Documents for `index` and `create` actions are converted into rows according to -elasticsearch.* flags.
+// Other actions aren't supported. The results for all the actions are appended to dstItems.
+//
+// The callback can be called multiple times for streamed data from r.
+// It may return RejectedRowsError for reporting the rejected rows in the corresponding items.
+//
+// callback shouldn't hold rows after returning.
+func ParseStream(dstItems []Item, r io.Reader, callback func(rows []Row) error) ([]Item, error) {
+	cfg := newConfig(*streamFields, *messageField, *timestampField)
+	ctx := getStreamContext(r)
+	defer putStreamContext(ctx)
+	return ctx.parse(dstItems, cfg, callback)
+}
+
+func (ctx *streamContext) parse(dstItems []Item, cfg *config, callback func(rows []Row) error) ([]Item, error) {
+	now := time.Now().UnixNano()
+	for {
+		line, err := ctx.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return dstItems, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		action, hasDocument, err := parseAction(&ctx.p, line)
+		if err != nil {
+			return dstItems, fmt.Errorf("cannot parse action %q: %w", line, err)
+		}
+		if hasDocument {
+			line, err = ctx.readLine()
+			if err == io.EOF {
+				return dstItems, fmt.Errorf("missing document for %q action", action)
+			}
+			if err != nil {
+				return dstItems, err
+			}
+		}
+		if action != "index" && action != "create" {
+			dstItems = append(dstItems, Item{
+				Action:      action,
+				Status:      400,
+				ErrorType:   "illegal_argument_exception",
+				ErrorReason: fmt.Sprintf("unsupported action %q; only `index` and `create` actions are supported", action),
+			})
+			continue
+		}
+		if err := ctx.unmarshalDocument(cfg, line, now); err != nil {
+			invalidDocuments.Inc()
+			dstItems = append(dstItems, Item{
+				Action:      action,
+				Status:      400,
+				ErrorType:   "mapper_parsing_exception",
+				ErrorReason: err.Error(),
+			})
+			continue
+		}
+		ctx.rowItems = append(ctx.rowItems, len(dstItems))
+		dstItems = append(dstItems, Item{
+			Action: action,
+			Status: 201,
+		})
+		if len(ctx.rows.buf) >= maxBatchSize {
+			if err := ctx.flush(dstItems, callback); err != nil {
+				return dstItems, err
+			}
+		}
+	}
+	if err := ctx.flush(dstItems, callback); err != nil {
+		return dstItems, err
+	}
+	return dstItems, nil
+}
+
+func (ctx *streamContext) unmarshalDocument(cfg *config, line []byte, now int64) error {
+	v, err := ctx.p.ParseBytes(line)
+	if err != nil {
+		return fmt.Errorf("cannot parse document: %w", err)
+	}
+	return ctx.rows.unmarshalDocument(cfg, v, line, now)
+}
+
+// flush passes the collected rows to callback and updates dstItems for the rows rejected by callback.
+func (ctx *streamContext) flush(dstItems []Item, callback func(rows []Row) error) error {
+	rows := ctx.rows.Rows
+	if len(rows) == 0 {
+		return nil
+	}
+	rowsRead.Add(len(rows))
+	err := callback(rows)
+	var rre *RejectedRowsError
+	if errors.As(err, &rre) {
+		for _, rr := range rre.Rows {
+			if rr.Idx < 0 || rr.Idx >= len(ctx.rowItems) {
+				logger.Panicf("BUG: unexpected index of the rejected row: %d; it must be in the range [0..%d)", rr.Idx, len(ctx.rowItems))
+			}
+			item := &dstItems[ctx.rowItems[rr.Idx]]
+			item.Status = 400
+			item.ErrorType = "illegal_argument_exception"
+			item.ErrorReason = rr.Reason
+		}
+		err = nil
+	}
+	ctx.rows.Reset()
+	ctx.rowItems = ctx.rowItems[:0]
+	return err
+}
+
+// parseAction parses action line from bulk request.
+//
+// It returns the action name and whether the action is followed by a document line.
+func parseAction(p *fastjson.Parser, line []byte) (string, bool, error) {
+	v, err := p.ParseBytes(line)
+	if err != nil {
+		return "", false, err
+	}
+	o, err := v.Object()
+	if err != nil {
+		return "", false, err
+	}
+	if o.Len() != 1 {
+		return "", false, fmt.Errorf("action must contain a single key; got %d keys", o.Len())
+	}
+	var action string
+	o.Visit(func(k []byte, v *fastjson.Value) {
+		action = string(k)
+	})
+	switch action {
+	case "index", "create", "update":
+		return action, true, nil
+	case "delete":
+		return action, false, nil
+	default:
+		return "", false, fmt.Errorf("unknown action %q", action)
+	}
+}
+
+// readLine reads the next line from ctx.br.
+//
+// The returned line is valid until the next readLine call.
+func (ctx *streamContext) readLine() ([]byte, error) {
+	ctx.line = ctx.line[:0]
+	for {
+		line, err := ctx.br.ReadSlice('\n')
+		ctx.line = append(ctx.line, line...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErrors.Inc()
+				return nil, fmt.Errorf("cannot read bulk request: %w", err)
+			}
+			if len(ctx.line) == 0 {
+				return nil, io.EOF
+			}
+		}
+		return bytes.TrimSpace(ctx.line), nil
+	}
+}
+
+type streamContext struct {
+	br   *bufio.Reader
+	line []byte
+	p    fastjson.Parser
+	rows Rows
+
+	// rowItems contains indexes of items for rows.
+	rowItems []int
+}
+
+func (ctx *streamContext) reset() {
+	ctx.br.Reset(nil)
+	ctx.line = ctx.line[:0]
+	ctx.rows.Reset()
+	ctx.rowItems = ctx.rowItems[:0]
+}
+
+var (
+	readErrors       = metrics.NewCounter(`vm_protoparser_read_errors_total{type="elasticsearch"}`)
+	rowsRead         = metrics.NewCounter(`vm_protoparser_rows_read_total{type="elasticsearch"}`)
+	invalidDocuments = metrics.NewCounter(`vm_rows_invalid_total{type="elasticsearch"}`)
+)
+
+func getStreamContext(r io.Reader) *streamContext {
+	if v := streamContextPool.Get(); v != nil {
+		ctx := v.(*streamContext)
+		ctx.br.Reset(r)
+		return ctx
+	}
+	return &streamContext{
+		br: bufio.NewReaderSize(r, 64*1024),
+	}
+}
+
+func putStreamContext(ctx *streamContext) {
+	ctx.reset()
+	streamContextPool.Put(ctx)
+}
+
+var streamContextPool sync.Pool
+
+// MarshalResponse appends Elasticsearch-compatible bulk response for items to dst.
+//
+// took is the request processing duration.
+func MarshalResponse(dst []byte, took time.Duration, items []Item) []byte {
+	type itemError struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	type itemResult struct {
+		Status int        `json:"status"`
+		Result string     `json:"result,omitempty"`
+		Error  *itemError `json:"error,omitempty"`
+	}
+	resp := struct {
+		Took   int64                    `json:"took"`
+		Errors bool                     `json:"errors"`
+		Items  []map[string]*itemResult `json:"items"`
+	}{
+		Took:  took.Milliseconds(),
+		Items: make([]map[string]*itemResult, 0, len(items)),
+	}
+	for i := range items {
+		item := &items[i]
+		ir := &itemResult{
+			Status: item.Status,
+		}
+		if item.ErrorType != "" {
+			resp.Errors = true
+			ir.Error = &itemError{
+				Type:   item.ErrorType,
+				Reason: item.ErrorReason,
+			}
+		} else {
+			ir.Result = "created"
+		}
+		resp.Items = append(resp.Items, map[string]*itemResult{
+			item.Action: ir,
+		})
+	}
+	data, err := json.Marshal(&resp)
+	if err != nil {
+		logger.Panicf("BUG: cannot marshal bulk response: %s", err)
+	}
+	return append(dst, data...)
+}
@@ -0,0 +1,179 @@
+package elasticsearch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStreamSuccess(t *testing.T) {
+	cfg := newConfig([]string{"host"}, "message", "@timestamp")
+	f := func(s string, rowsExpected []string, itemsExpected []Item) {
+		t.Helper()
+		var rows []string
+		ctx := getStreamContext(strings.NewReader(s))
+		defer putStreamContext(ctx)
+		items, err := ctx.parse(nil, cfg, func(rs []Row) error {
+			for i := range rs {
+				rows = append(rows, rowString(&rs[i]))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(rows, "\n") != strings.Join(rowsExpected, "\n") {
+			t.Fatalf("unexpected rows;\ngot\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+		if len(items) != len(itemsExpected) {
+			t.Fatalf("unexpected number of items; got %d; want %d", len(items), len(itemsExpected))
+		}
+		for i := range items {
+			item := &items[i]
+			itemExpected := &itemsExpected[i]
+			if item.Action != itemExpected.Action || item.Status != itemExpected.Status || item.ErrorType != itemExpected.ErrorType {
+				t.Fatalf("unexpected item #%d; got %+v; want %+v", i, item, itemExpected)
+			}
+		}
+	}
+
+	f("", nil, nil)
+	f("\n\r\n", nil, nil)
+
+	f(`{"index":{"_index":"logs"}}
+{"@timestamp":"2020-10-20T12:00:00Z","host":"h1","message":"foo"}
+{"create":{}}
+{"@timestamp":"2020-10-20T12:00:01Z","host":"h2","message":"bar"}`, []string{
+		`{host="h1"} 1603195200000000000 "foo"`,
+		`{host="h2"} 1603195201000000000 "bar"`,
+	}, []Item{
+		{Action: "index", Status: 201},
+		{Action: "create", Status: 201},
+	})
+
+	// Unsupported actions and invalid documents
+	f(`{"delete":{"_id":"1"}}
+{"update":{"_id":"1"}}
+{"doc":{"message":"foo"}}
+{"index":{}}
+{"@timestamp":"foobar","message":"bar"}
+{"index":{}}
+foobar
+{"index":{}}
+{"message":"without host"}
+{"index":{}}
+{"@timestamp":"2020-10-20T12:00:00Z","host":"h1","message":"baz"}
+`, []string{
+		`{host="h1"} 1603195200000000000 "baz"`,
+	}, []Item{
+		{Action: "delete", Status: 400, ErrorType: "illegal_argument_exception"},
+		{Action: "update", Status: 400, ErrorType: "illegal_argument_exception"},
+		{Action: "index", Status: 400, ErrorType: "mapper_parsing_exception"},
+		{Action: "index", Status: 400, ErrorType: "mapper_parsing_exception"},
+		{Action: "index", Status: 400, ErrorType: "mapper_parsing_exception"},
+		{Action: "index", Status: 201},
+	})
+}
+
+func TestParseStreamFailure(t *testing.T) {
+	cfg := newConfig(nil, "message", "@timestamp")
+	f := func(s string) {
+		t.Helper()
+		ctx := getStreamContext(strings.NewReader(s))
+		defer putStreamContext(ctx)
+		_, err := ctx.parse(nil, cfg, func(rs []Row) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+
+	// Invalid action
+	f(`foobar`)
+	f(`[]`)
+	f(`{}`)
+	f(`{"index":{},"create":{}}`)
+	f(`{"foobar":{}}`)
+
+	// Missing document
+	f(`{"index":{}}`)
+	f("{\"index\":{}}\n")
+}
+
+func TestParseStreamRejectedRows(t *testing.T) {
+	cfg := newConfig([]string{"host"}, "message", "@timestamp")
+	s := `{"index":{}}
+{"host":"h1","message":"foo"}
+{"index":{}}
+{"message":"without host"}
+{"create":{}}
+{"host":"h1","message":"reject"}
+{"index":{}}
+{"host":"h2","message":"bar"}
+`
+	ctx := getStreamContext(strings.NewReader(s))
+	defer putStreamContext(ctx)
+	var messages []string
+	items, err := ctx.parse(nil, cfg, func(rs []Row) error {
+		var rre RejectedRowsError
+		for i := range rs {
+			if string(rs[i].Message) == "reject" {
+				rre.Rows = append(rre.Rows, RejectedRow{
+					Idx:    i,
+					Reason: "rejected by callback",
+				})
+				continue
+			}
+			messages = append(messages, string(rs[i].Message))
+		}
+		if len(rre.Rows) > 0 {
+			return &rre
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(messages, ",") != "foo,bar" {
+		t.Fatalf("unexpected messages; got %q; want %q", messages, []string{"foo", "bar"})
+	}
+	itemsExpected := []Item{
+		{Action: "index", Status: 201},
+		{Action: "index", Status: 400, ErrorType: "mapper_parsing_exception"},
+		{Action: "create", Status: 400, ErrorType: "illegal_argument_exception", ErrorReason: "rejected by callback"},
+		{Action: "index", Status: 201},
+	}
+	if len(items) != len(itemsExpected) {
+		t.Fatalf("unexpected number of items; got %d; want %d", len(items), len(itemsExpected))
+	}
+	for i := range items {
+		item := &items[i]
+		itemExpected := &itemsExpected[i]
+		if item.Action != itemExpected.Action || item.Status != itemExpected.Status || item.ErrorType != itemExpected.ErrorType {
+			t.Fatalf("unexpected item #%d; got %+v; want %+v", i, item, itemExpected)
+		}
+		if itemExpected.ErrorReason != "" && item.ErrorReason != itemExpected.ErrorReason {
+			t.Fatalf("unexpected error reason for item #%d; got %q; want %q", i, item.ErrorReason, itemExpected.ErrorReason)
+		}
+	}
+}
+
+func TestMarshalResponse(t *testing.T) {
+	f := func(items []Item, respExpected string) {
+		t.Helper()
+		resp := MarshalResponse(nil, 12*time.Millisecond, items)
+		if string(resp) != respExpected {
+			t.Fatalf("unexpected response;\ngot\n%s\nwant\n%s", resp, respExpected)
+		}
+	}
+	f(nil, `{"took":12,"errors":false,"items":[]}`)
+	f([]Item{
+		{Action: "index", Status: 201},
+		{Action: "create", Status: 201},
+	}, `{"took":12,"errors":false,"items":[{"index":{"status":201,"result":"created"}},{"create":{"status":201,"result":"created"}}]}`)
+	f([]Item{
+		{Action: "index", Status: 201},
+		{Action: "delete", Status: 400, ErrorType: "illegal_argument_exception", ErrorReason: `unsupported action "delete"`},
+	}, `{"took":12,"errors":true,"items":[{"index":{"status":201,"result":"created"}},{"delete":{"status":400,"error":{"type":"illegal_argument_exception","reason":"unsupported action \"delete\""}}}]}`)
+}
@@ -0,0 +1,435 @@
+package fluentforward
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+)
+
+// kind is the kind of msgpack value.
+type kind int
+
+const (
+	kindNil kind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindString
+	kindArray
+	kindMap
+	kindExt
+)
+
+var kindNames = [...]string{
+	kindNil:    "nil",
+	kindBool:   "bool",
+	kindInt:    "int",
+	kindUint:   "uint",
+	kindFloat:  "float",
+	kindString: "string",
+	kindArray:  "array",
+	kindMap:    "map",
+	kindExt:    "ext",
+}
+
+func (k kind) String() string {
+	return kindNames[k]
+}
+
+// value is the header of msgpack value.
+//
+// Array and map items follow the header in the decoder.
+type value struct {
+	kind kind
+
+	// b is set for kindBool.
+	b bool
+
+	// i is set for kindInt.
+	i int64
+
+	// u is set for kindUint.
+	u uint64
+
+	// f is set for kindFloat.
+	f float64
+
+	// n is the number of items for kindArray and the number of key-value pairs for kindMap.
+	n int
+
+	// data is set for kindString and kindExt. Both str and bin msgpack types are represented as kindString.
+	data []byte
+
+	// extType is set for kindExt.
+	extType int8
+}
+
+// maxNestingDepth is the maximum nesting depth for arrays and maps, which may be converted to JSON.
+const maxNestingDepth = 64
+
+// decoder decodes msgpack values from b.
+//
+// See https://github.com/msgpack/msgpack/blob/master/spec.md
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if len(d.b) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c, nil
+}
+
+func (d *decoder) readBytes(n uint64) ([]byte, error) {
+	if uint64(len(d.b)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := d.b[:n]
+	d.b = d.b[n:]
+	return data, nil
+}
+
+// readUint reads big-endian unsigned integer with the given size in bytes.
+func (d *decoder) readUint(size int) (uint64, error) {
+	data, err := d.readBytes(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(data[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(data)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(data)), nil
+	default:
+		return binary.BigEndian.Uint64(data), nil
+	}
+}
+
+// readValue reads the header of the next value into v.
+//
+// io.ErrUnexpectedEOF is returned if d.b doesn't contain the whole header.
+func (d *decoder) readValue(v *value) error {
+	c, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	*v = value{}
+	switch {
+	case c <= 0x7f:
+		v.kind = kindInt
+		v.i = int64(c)
+		return nil
+	case c >= 0xe0:
+		v.kind = kindInt
+		v.i = int64(int8(c))
+		return nil
+	case c <= 0x8f:
+		v.kind = kindMap
+		v.n = int(c & 0x0f)
+		return nil
+	case c <= 0x9f:
+		v.kind = kindArray
+		v.n = int(c & 0x0f)
+		return nil
+	case c <= 0xbf:
+		return d.readData(v, kindString, uint64(c&0x1f))
+	}
+	switch c {
+	case 0xc0:
+		v.kind = kindNil
+		return nil
+	case 0xc2, 0xc3:
+		v.kind = kindBool
+		v.b = c == 0xc3
+		return nil
+	case 0xc4, 0xc5, 0xc6:
+		// bin 8, bin 16, bin 32
+		n, err := d.readUint(1 << (c - 0xc4))
+		if err != nil {
+			return err
+		}
+		return d.readData(v, kindString, n)
+	case 0xc7, 0xc8, 0xc9:
+		// ext 8, ext 16, ext 32
+		n, err := d.readUint(1 << (c - 0xc7))
+		if err != nil {
+			return err
+		}
+		return d.readExt(v, n)
+	case 0xca:
+		n, err := d.readUint(4)
+		if err != nil {
+			return err
+		}
+		v.kind = kindFloat
+		v.f = float64(math.Float32frombits(uint32(n)))
+		return nil
+	case 0xcb:
+		n, err := d.readUint(8)
+		if err != nil {
+			return err
+		}
+		v.kind = kindFloat
+		v.f = math.Float64frombits(n)
+		return nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		// uint 8, uint 16, uint 32, uint 64
+		n, err := d.readUint(1 << (c - 0xcc))
+		if err != nil {
+			return err
+		}
+		v.kind = kindUint
+		v.u = n
+		return nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		// int 8, int 16, int 32, int 64
+		size := 1 << (c - 0xd0)
+		n, err := d.readUint(size)
+		if err != nil {
+			return err
+		}
+		v.kind = kindInt
+		switch size {
+		case 1:
+			v.i = int64(int8(n))
+		case 2:
+			v.i = int64(int16(n))
+		case 4:
+			v.i = int64(int32(n))
+		default:
+			v.i = int64(n)
+		}
+		return nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		// fixext 1, fixext 2, fixext 4, fixext 8, fixext 16
+		return d.readExt(v, 1<<(c-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		// str 8, str 16, str 32
+		n, err := d.readUint(1 << (c - 0xd9))
+		if err != nil {
+			return err
+		}
+		return d.readData(v, kindString, n)
+	case 0xdc, 0xdd:
+		// array 16, array 32
+		n, err := d.readUint(2 << (c - 0xdc))
+		if err != nil {
+			return err
+		}
+		v.kind = kindArray
+		v.n = int(n)
+		return nil
+	case 0xde, 0xdf:
+		// map 16, map 32
+		n, err := d.readUint(2 << (c - 0xde))
+		if err != nil {
+			return err
+		}
+		v.kind = kindMap
+		v.n = int(n)
+		return nil
+	default:
+		return fmt.Errorf("invalid msgpack type 0x%02x", c)
+	}
+}
+
+func (d *decoder) readData(v *value, k kind, n uint64) error {
+	data, err := d.readBytes(n)
+	if err != nil {
+		return err
+	}
+	v.kind = k
+	v.data = data
+	return nil
+}
+
+func (d *decoder) readExt(v *value, n uint64) error {
+	c, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	v.extType = int8(c)
+	return d.readData(v, kindExt, n)
+}
+
+// skip skips the next value including all the nested items.
+func (d *decoder) skip() error {
+	var v value
+	pending := 1
+	for pending > 0 {
+		pending--
+		if err := d.readValue(&v); err != nil {
+			return err
+		}
+		switch v.kind {
+		case kindArray:
+			pending += v.n
+		case kindMap:
+			pending += 2 * v.n
+		}
+	}
+	return nil
+}
+
+// readArrayLen reads the header of array and returns the number of its items.
+func (d *decoder) readArrayLen() (int, error) {
+	var v value
+	if err := d.readValue(&v); err != nil {
+		return 0, err
+	}
+	if v.kind != kindArray {
+		return 0, fmt.Errorf("unexpected msgpack type %s; want array", v.kind)
+	}
+	return v.n, nil
+}
+
+// readString reads str or bin value.
+func (d *decoder) readString() ([]byte, error) {
+	var v value
+	if err := d.readValue(&v); err != nil {
+		return nil, err
+	}
+	if v.kind != kindString {
+		return nil, fmt.Errorf("unexpected msgpack type %s; want string", v.kind)
+	}
+	return v.data, nil
+}
+
+// readEventTime reads Fluent EventTime and returns it in nanoseconds.
+//
+// Both integer timestamps in seconds and EventTime ext type are supported.
+// See https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1#eventtime-ext-format
+func (d *decoder) readEventTime() (int64, error) {
+	var v value
+	if err := d.readValue(&v); err != nil {
+		return 0, err
+	}
+	switch v.kind {
+	case kindInt:
+		return v.i * 1e9, nil
+	case kindUint:
+		return int64(v.u) * 1e9, nil
+	case kindFloat:
+		return int64(v.f * 1e9), nil
+	case kindExt:
+		if v.extType != 0 || len(v.data) != 8 {
+			return 0, fmt.Errorf("unexpected ext type %d with %d bytes for EventTime; want ext type 0 with 8 bytes", v.extType, len(v.data))
+		}
+		secs := binary.BigEndian.Uint32(v.data[:4])
+		nsecs := binary.BigEndian.Uint32(v.data[4:])
+		return int64(secs)*1e9 + int64(nsecs), nil
+	default:
+		return 0, fmt.Errorf("unexpected msgpack type %s for EventTime", v.kind)
+	}
+}
+
+// appendValue appends string representation of value with the header v to dst.
+//
+// Strings are appended as is, while arrays and maps are appended in JSON.
+// Nothing is appended for nil.
+func (d *decoder) appendValue(dst []byte, v *value) ([]byte, error) {
+	switch v.kind {
+	case kindNil, kindExt:
+		return dst, nil
+	case kindBool:
+		return strconv.AppendBool(dst, v.b), nil
+	case kindInt:
+		return strconv.AppendInt(dst, v.i, 10), nil
+	case kindUint:
+		return strconv.AppendUint(dst, v.u, 10), nil
+	case kindFloat:
+		return strconv.AppendFloat(dst, v.f, 'g', -1, 64), nil
+	case kindString:
+		return append(dst, v.data...), nil
+	default:
+		return d.appendJSON(dst, v, 0)
+	}
+}
+
+// appendJSON appends JSON representation of value with the header v to dst.
+func (d *decoder) appendJSON(dst []byte, v *value, depth int) ([]byte, error) {
+	switch v.kind {
+	case kindNil, kindExt:
+		return append(dst, "null"...), nil
+	case kindFloat:
+		if math.IsNaN(v.f) || math.IsInf(v.f, 0) {
+			// NaN and Inf cannot be represented as JSON numbers.
+			return protoparserutil.AppendJSONString(dst, strconv.FormatFloat(v.f, 'g', -1, 64)), nil
+		}
+		return d.appendValue(dst, v)
+	case kindString:
+		return protoparserutil.AppendJSONString(dst, bytesutil.ToUnsafeString(v.data)), nil
+	case kindArray, kindMap:
+		if depth >= maxNestingDepth {
+			return dst, fmt.Errorf("too deep nesting of arrays and maps; it mustn't exceed %d levels", maxNestingDepth)
+		}
+		if v.kind == kindArray {
+			dst = append(dst, '[')
+		} else {
+			dst = append(dst, '{')
+		}
+		n := v.n
+		var item value
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			if err := d.readValue(&item); err != nil {
+				return dst, err
+			}
+			var err error
+			if v.kind == kindMap {
+				if item.kind == kindString {
+					dst = protoparserutil.AppendJSONString(dst, bytesutil.ToUnsafeString(item.data))
+				} else {
+					// JSON object keys must be strings.
+					key, err := d.appendJSON(nil, &item, depth+1)
+					if err != nil {
+						return dst, err
+					}
+					dst = protoparserutil.AppendJSONString(dst, bytesutil.ToUnsafeString(key))
+				}
+				dst = append(dst, ':')
+				if err := d.readValue(&item); err != nil {
+					return dst, err
+				}
+			}
+			if dst, err = d.appendJSON(dst, &item, depth+1); err != nil {
+				return dst, err
+			}
+		}
+		if v.kind == kindArray {
+			return append(dst, ']'), nil
+		}
+		return append(dst, '}'), nil
+	default:
+		return d.appendValue(dst, v)
+	}
+}
+
+// appendString appends msgpack string s to dst.
+func appendString(dst []byte, s []byte) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		dst = append(dst, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		dst = append(dst, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		dst = append(dst, 0xda, byte(n>>8), byte(n))
+	default:
+		dst = append(dst, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, s...)
+}
@@ -0,0 +1,224 @@
+package fluentforward
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestDecoderAppendValue(t *testing.T) {
+	f := func(data []byte, resultExpected string) {
+		t.Helper()
+		d := decoder{b: data}
+		var v value
+		if err := d.readValue(&v); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result, err := d.appendValue(nil, &v)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result; got %s; want %s", result, resultExpected)
+		}
+		if len(d.b) > 0 {
+			t.Fatalf("unexpected tail left after decoding: %X", d.b)
+		}
+
+		// Verify that skip consumes the same data.
+		d = decoder{b: data}
+		if err := d.skip(); err != nil {
+			t.Fatalf("cannot skip value: %s", err)
+		}
+		if len(d.b) > 0 {
+			t.Fatalf("unexpected tail left after skip: %X", d.b)
+		}
+
+		// Truncated data must result in error.
+		for i := 0; i < len(data); i++ {
+			d := decoder{b: data[:i]}
+			if err := d.skip(); err != io.ErrUnexpectedEOF {
+				t.Fatalf("unexpected error when skipping the first %d bytes; got %v; want %v", i, err, io.ErrUnexpectedEOF)
+			}
+		}
+	}
+
+	f(appendNil(nil), "")
+	f(appendBool(nil, true), "true")
+	f(appendBool(nil, false), "false")
+	f(appendInt(nil, 0), "0")
+	f(appendInt(nil, 127), "127")
+	f(appendInt(nil, -32), "-32")
+	f(appendInt(nil, -33), "-33")
+	f(appendInt(nil, 300), "300")
+	f(appendInt(nil, -300), "-300")
+	f(appendInt(nil, 70000), "70000")
+	f(appendInt(nil, -70000), "-70000")
+	f(appendInt(nil, math.MaxInt64), "9223372036854775807")
+	f(appendInt(nil, math.MinInt64), "-9223372036854775808")
+	f(append([]byte{0xcf}, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), "18446744073709551615")
+	f(appendFloat(nil, 1.5), "1.5")
+	f([]byte{0xca, 0x3f, 0xc0, 0, 0}, "1.5")
+	f(appendStr(nil, ""), "")
+	f(appendStr(nil, "foo bar"), "foo bar")
+	f(appendStr(nil, string(make([]byte, 300))), string(make([]byte, 300)))
+	f(appendBin(nil, []byte("bin")), "bin")
+	f(append([]byte{0xd6, 1}, 1, 2, 3, 4), "")
+
+	// Arrays and maps are converted to JSON
+	f(appendArrayLen(nil, 0), "[]")
+	f(appendMapLen(nil, 0), "{}")
+	var b []byte
+	b = appendArrayLen(b, 5)
+	b = appendInt(b, 1)
+	b = appendStr(b, `a"b`)
+	b = appendNil(b)
+	b = appendFloat(b, math.NaN())
+	b = appendMapLen(b, 2)
+	b = appendStr(b, "x")
+	b = appendArrayLen(b, 1)
+	b = appendBool(b, true)
+	b = appendInt(b, 42)
+	b = appendStr(b, "y")
+	f(b, `[1,"a\"b",null,"NaN",{"x":[true],"42":"y"}]`)
+}
+
+func TestDecoderAppendValueTooDeep(t *testing.T) {
+	var b []byte
+	for i := 0; i < maxNestingDepth+1; i++ {
+		b = appendArrayLen(b, 1)
+	}
+	b = appendNil(b)
+	d := decoder{b: b}
+	var v value
+	if err := d.readValue(&v); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := d.appendValue(nil, &v); err == nil {
+		t.Fatalf("expecting non-nil error for too deep nesting")
+	}
+}
+
+func TestDecoderReadEventTime(t *testing.T) {
+	f := func(data []byte, tsExpected int64) {
+		t.Helper()
+		d := decoder{b: data}
+		ts, err := d.readEventTime()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if ts != tsExpected {
+			t.Fatalf("unexpected timestamp; got %d; want %d", ts, tsExpected)
+		}
+	}
+	f(appendInt(nil, 1603195200), 1603195200000000000)
+	f(appendFloat(nil, 1603195200.5), 1603195200500000000)
+	f(appendEventTime(nil, 1603195200, 123456789), 1603195200123456789)
+
+	// ext 8 with EventTime
+	b := appendEventTime(nil, 1603195200, 1)
+	f(append([]byte{0xc7, 8}, b[1:]...), 1603195200000000001)
+
+	// Invalid EventTime
+	for _, data := range [][]byte{appendStr(nil, "foo"), {0xd6, 0, 1, 2, 3, 4}, {0xd7, 1, 0, 0, 0, 0, 0, 0, 0, 0}} {
+		d := decoder{b: data}
+		if _, err := d.readEventTime(); err == nil {
+			t.Fatalf("expecting non-nil error for %X", data)
+		}
+	}
+}
+
+func TestAppendString(t *testing.T) {
+	f := func(n int) {
+		t.Helper()
+		s := make([]byte, n)
+		d := decoder{b: appendString(nil, s)}
+		result, err := d.readString()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(result) != n || len(d.b) > 0 {
+			t.Fatalf("unexpected string length; got %d; want %d", len(result), n)
+		}
+	}
+	f(0)
+	f(31)
+	f(32)
+	f(255)
+	f(256)
+	f(65535)
+	f(65536)
+}
+
+func appendNil(dst []byte) []byte {
+	return append(dst, 0xc0)
+}
+
+func appendBool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, 0xc3)
+	}
+	return append(dst, 0xc2)
+}
+
+func appendInt(dst []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 127:
+		return append(dst, byte(v))
+	case v < 0 && v >= -32:
+		return append(dst, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return append(dst, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return append(dst, 0xd1, byte(v>>8), byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(v))
+		return append(append(dst, 0xd2), b[:]...)
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(v))
+		return append(append(dst, 0xd3), b[:]...)
+	}
+}
+
+func appendFloat(dst []byte, v float64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+	return append(append(dst, 0xcb), b[:]...)
+}
+
+func appendStr(dst []byte, s string) []byte {
+	return appendString(dst, []byte(s))
+}
+
+func appendBin(dst []byte, data []byte) []byte {
+	dst = append(dst, 0xc6)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(data)))
+	dst = append(dst, b[:]...)
+	return append(dst, data...)
+}
+
+func appendArrayLen(dst []byte, n int) []byte {
+	if n <= 15 {
+		return append(dst, 0x90|byte(n))
+	}
+	return append(dst, 0xdc, byte(n>>8), byte(n))
+}
+
+func appendMapLen(dst []byte, n int) []byte {
+	if n <= 15 {
+		return append(dst, 0x80|byte(n))
+	}
+	return append(dst, 0xde, byte(n>>8), byte(n))
+}
+
+func appendEventTime(dst []byte, secs, nsecs uint32) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], secs)
+	binary.BigEndian.PutUint32(b[4:], nsecs)
+	dst = append(dst, 0xd7, 0)
+	return append(dst, b[:]...)
+}
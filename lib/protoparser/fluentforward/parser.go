@@ -0,0 +1,396 @@
+package fluentforward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Rows contains log entries parsed from Fluent Forward messages.
+type Rows struct {
+	Rows []Row
+
+	labelsPool []storage.Label
+	buf        []byte
+	fieldsBuf  []byte
+	valueBuf   []byte
+}
+
+// Reset resets rs.
+func (rs *Rows) Reset() {
+	// Reset items, so they can be GC'ed
+	for i := range rs.Rows {
+		rs.Rows[i].reset()
+	}
+	rs.Rows = rs.Rows[:0]
+
+	for i := range rs.labelsPool {
+		label := &rs.labelsPool[i]
+		label.Name = nil
+		label.Value = nil
+	}
+	rs.labelsPool = rs.labelsPool[:0]
+	rs.buf = rs.buf[:0]
+	rs.fieldsBuf = rs.fieldsBuf[:0]
+	rs.valueBuf = rs.valueBuf[:0]
+}
+
+// Row is a single log entry obtained from Fluent Forward event.
+type Row struct {
+	// Timestamp is unix timestamp in nanoseconds.
+	Timestamp int64
+
+	Labels  []storage.Label
+	Message []byte
+}
+
+func (r *Row) reset() {
+	r.Timestamp = 0
+	r.Labels = nil
+	r.Message = nil
+}
+
+// config determines how Fluent records are converted into log entries.
+type config struct {
+	labelFields    []string
+	labelNames     [][]byte
+	messageField   string
+	maxMessageSize int
+}
+
+func newConfig(labelFields []string, messageField string, maxMessageSize int) *config {
+	cfg := &config{
+		labelFields:    labelFields,
+		messageField:   messageField,
+		maxMessageSize: maxMessageSize,
+	}
+	for _, name := range labelFields {
+		cfg.labelNames = append(cfg.labelNames, protoparserutil.AppendSanitizedLabelName(nil, name))
+	}
+	return cfg
+}
+
+// labelName returns label name for the given record key.
+//
+// nil is returned if key isn't a label field.
+func (cfg *config) labelName(key []byte) []byte {
+	for i, lf := range cfg.labelFields {
+		if lf == string(key) {
+			return cfg.labelNames[i]
+		}
+	}
+	return nil
+}
+
+var tagLabel = []byte("tag")
+
+// Unmarshal unmarshals a single Forward protocol message from msg according to cfg and appends the parsed events to rs.Rows.
+//
+// Message, Forward, PackedForward and CompressedPackedForward modes are supported.
+// See https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+//
+// The Fluent tag is stored in `tag` label, while record keys from cfg become the remaining stream labels.
+// The message field from cfg becomes log line, while the remaining record keys are appended to log line in logfmt format.
+//
+// The chunk id from message options is returned if the sender expects ack for the message.
+// Invalid messages are logged and skipped. The chunk id is returned for them too if it can be read,
+// so the sender doesn't re-send them forever.
+//
+// msg shouldn't be modified while rs is in use.
+func (rs *Rows) Unmarshal(msg []byte, cfg *config, now int64) []byte {
+	rowsLen := len(rs.Rows)
+	labelsLen := len(rs.labelsPool)
+	chunk, err := rs.unmarshalMessage(msg, cfg, now)
+	if err != nil {
+		rs.Rows = rs.Rows[:rowsLen]
+		rs.labelsPool = rs.labelsPool[:labelsLen]
+		logger.Errorf("cannot unmarshal Fluent Forward message with size %d bytes: %s", len(msg), err)
+		invalidLines.Inc()
+	}
+	return chunk
+}
+
+var invalidLines = metrics.NewCounter(`vm_rows_invalid_total{type="fluentforward"}`)
+
+func (rs *Rows) unmarshalMessage(msg []byte, cfg *config, now int64) ([]byte, error) {
+	d := decoder{b: msg}
+	n, err := d.readArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if n < 2 || n > 4 {
+		return nil, fmt.Errorf("unexpected number of items in message; got %d; want 2..4", n)
+	}
+	tag, err := d.readString()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tag: %w", err)
+	}
+
+	// Determine the mode by the second item.
+	entriesStart := d.b
+	var v value
+	if err := d.readValue(&v); err != nil {
+		return nil, err
+	}
+	var entries []byte
+	entriesCount := -1
+	isPacked := false
+	optionIdx := 2
+	switch v.kind {
+	case kindArray:
+		// Forward mode: [tag, [[time, record], ...], option]
+		entriesStart = d.b
+		for i := 0; i < v.n; i++ {
+			if err := d.skip(); err != nil {
+				return nil, err
+			}
+		}
+		entries = entriesStart[:len(entriesStart)-len(d.b)]
+		entriesCount = v.n
+	case kindString:
+		// PackedForward mode: [tag, <concatenated [time, record] entries>, option]
+		entries = v.data
+		isPacked = true
+	default:
+		// Message mode: [tag, time, record, option]
+		d.b = entriesStart
+		if n < 3 {
+			return nil, fmt.Errorf("missing record in Message mode")
+		}
+		if err := d.skip(); err != nil {
+			return nil, err
+		}
+		if err := d.skip(); err != nil {
+			return nil, err
+		}
+		entries = entriesStart[:len(entriesStart)-len(d.b)]
+		optionIdx = 3
+	}
+	if n > optionIdx+1 {
+		return nil, fmt.Errorf("unexpected number of items in message; got %d; want %d", n, optionIdx+1)
+	}
+
+	var chunk []byte
+	isGzip := false
+	if n == optionIdx+1 {
+		chunk, isGzip, err = readOption(&d)
+		if err != nil {
+			return chunk, fmt.Errorf("cannot read option: %w", err)
+		}
+	}
+	if isGzip {
+		if !isPacked {
+			return chunk, fmt.Errorf("`compressed` option is supported only in PackedForward mode")
+		}
+		bufLen := len(rs.buf)
+		rs.buf, err = appendGunzipped(rs.buf, entries, cfg.maxMessageSize)
+		if err != nil {
+			return chunk, err
+		}
+		entries = rs.buf[bufLen:]
+	}
+
+	d = decoder{b: entries}
+	if optionIdx == 3 {
+		// Message mode contains a single event without array header.
+		return chunk, rs.unmarshalEvent(&d, tag, cfg, now)
+	}
+	for i := 0; i != entriesCount && len(d.b) > 0; i++ {
+		n, err := d.readArrayLen()
+		if err != nil {
+			return chunk, fmt.Errorf("cannot read entry: %w", err)
+		}
+		if n != 2 {
+			return chunk, fmt.Errorf("unexpected number of items in entry; got %d; want 2", n)
+		}
+		if err := rs.unmarshalEvent(&d, tag, cfg, now); err != nil {
+			return chunk, err
+		}
+	}
+	return chunk, nil
+}
+
+// readOption reads message option and returns chunk id and whether entries are gzip-compressed.
+//
+// The chunk id is returned on error if it has been already read.
+func readOption(d *decoder) ([]byte, bool, error) {
+	var v value
+	if err := d.readValue(&v); err != nil {
+		return nil, false, err
+	}
+	if v.kind == kindNil {
+		return nil, false, nil
+	}
+	if v.kind != kindMap {
+		return nil, false, fmt.Errorf("unexpected msgpack type %s; want map", v.kind)
+	}
+	var chunk []byte
+	isGzip := false
+	for i := 0; i < v.n; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return chunk, false, err
+		}
+		switch string(key) {
+		case "chunk":
+			if chunk, err = d.readString(); err != nil {
+				return nil, false, fmt.Errorf("cannot read `chunk`: %w", err)
+			}
+		case "compressed":
+			compressed, err := d.readString()
+			if err != nil {
+				return chunk, false, fmt.Errorf("cannot read `compressed`: %w", err)
+			}
+			switch string(compressed) {
+			case "gzip":
+				isGzip = true
+			case "text":
+			default:
+				return chunk, false, fmt.Errorf("unsupported `compressed`=%q; supported values: gzip, text", compressed)
+			}
+		default:
+			if err := d.skip(); err != nil {
+				return chunk, false, err
+			}
+		}
+	}
+	return chunk, isGzip, nil
+}
+
+// appendGunzipped appends gunzipped data to dst.
+//
+// data may contain multiple concatenated gzip streams.
+func appendGunzipped(dst, data []byte, maxSize int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return dst, fmt.Errorf("cannot read gzip header: %w", err)
+	}
+	bb := bytes.NewBuffer(dst)
+	n, err := bb.ReadFrom(io.LimitReader(zr, int64(maxSize)+1))
+	if err != nil {
+		return dst, fmt.Errorf("cannot decompress entries: %w", err)
+	}
+	if n > int64(maxSize) {
+		return dst, fmt.Errorf("too big decompressed entries; they mustn't exceed -fluentForward.maxMessageSize=%d bytes", maxSize)
+	}
+	return bb.Bytes(), nil
+}
+
+// unmarshalEvent unmarshals time and record from d and appends the corresponding row to rs.Rows.
+func (rs *Rows) unmarshalEvent(d *decoder, tag []byte, cfg *config, now int64) error {
+	if len(d.b) > 0 && (d.b[0]&0xf0 == 0x90 || d.b[0] == 0xdc || d.b[0] == 0xdd) {
+		// Fluent Bit may send [time, metadata] pair instead of time.
+		n, err := d.readArrayLen()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("missing time in [time, metadata] pair")
+		}
+		ts, err := d.readEventTime()
+		if err != nil {
+			return fmt.Errorf("cannot read event time: %w", err)
+		}
+		for i := 1; i < n; i++ {
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+		return rs.unmarshalRecord(d, tag, ts, cfg, now)
+	}
+	ts, err := d.readEventTime()
+	if err != nil {
+		return fmt.Errorf("cannot read event time: %w", err)
+	}
+	return rs.unmarshalRecord(d, tag, ts, cfg, now)
+}
+
+func (rs *Rows) unmarshalRecord(d *decoder, tag []byte, ts int64, cfg *config, now int64) error {
+	var v value
+	if err := d.readValue(&v); err != nil {
+		return fmt.Errorf("cannot read record: %w", err)
+	}
+	if v.kind != kindMap {
+		return fmt.Errorf("unexpected msgpack type %s for record; want map", v.kind)
+	}
+	if ts == 0 {
+		ts = now
+	}
+	r := Row{
+		Timestamp: ts,
+	}
+	labelsStart := len(rs.labelsPool)
+	rs.labelsPool = append(rs.labelsPool, storage.Label{
+		Name:  tagLabel,
+		Value: tag,
+	})
+	rs.fieldsBuf = rs.fieldsBuf[:0]
+	var message []byte
+	n := v.n
+	for i := 0; i < n; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return fmt.Errorf("cannot read record key: %w", err)
+		}
+		if err := d.readValue(&v); err != nil {
+			return fmt.Errorf("cannot read value for record key %q: %w", key, err)
+		}
+		if string(key) == cfg.messageField {
+			bufLen := len(rs.buf)
+			if rs.buf, err = d.appendValue(rs.buf, &v); err != nil {
+				return fmt.Errorf("cannot read value for record key %q: %w", key, err)
+			}
+			message = rs.buf[bufLen:]
+			continue
+		}
+		if labelName := cfg.labelName(key); labelName != nil {
+			bufLen := len(rs.buf)
+			if rs.buf, err = d.appendValue(rs.buf, &v); err != nil {
+				return fmt.Errorf("cannot read value for record key %q: %w", key, err)
+			}
+			if len(rs.buf) == bufLen {
+				// Skip labels with empty values.
+				continue
+			}
+			rs.labelsPool = append(rs.labelsPool, storage.Label{
+				Name:  labelName,
+				Value: rs.buf[bufLen:],
+			})
+			continue
+		}
+		if v.kind == kindNil {
+			continue
+		}
+		if rs.valueBuf, err = d.appendValue(rs.valueBuf[:0], &v); err != nil {
+			return fmt.Errorf("cannot read value for record key %q: %w", key, err)
+		}
+		if len(rs.fieldsBuf) > 0 {
+			rs.fieldsBuf = append(rs.fieldsBuf, ' ')
+		}
+		rs.fieldsBuf = protoparserutil.AppendLogfmtValue(rs.fieldsBuf, bytesutil.ToUnsafeString(key))
+		rs.fieldsBuf = append(rs.fieldsBuf, '=')
+		rs.fieldsBuf = protoparserutil.AppendLogfmtValue(rs.fieldsBuf, bytesutil.ToUnsafeString(rs.valueBuf))
+	}
+	if len(rs.fieldsBuf) > 0 {
+		// Render the remaining fields into the message.
+		bufLen := len(rs.buf)
+		if len(message) > 0 {
+			rs.buf = append(rs.buf, message...)
+			rs.buf = append(rs.buf, ' ')
+		}
+		rs.buf = append(rs.buf, rs.fieldsBuf...)
+		message = rs.buf[bufLen:]
+	}
+	labels := rs.labelsPool[labelsStart:]
+	r.Labels = labels[:len(labels):len(labels)]
+	r.Message = message[:len(message):len(message)]
+	rs.Rows = append(rs.Rows, r)
+	return nil
+}
@@ -0,0 +1,151 @@
+package fluentforward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRowsUnmarshal(t *testing.T) {
+	const now = 123
+	cfg := newConfig([]string{"host", "kubernetes.pod"}, "log", 1024)
+	f := func(msg []byte, chunkExpected string, rowsExpected []string) {
+		t.Helper()
+		var rs Rows
+		chunk := rs.Unmarshal(msg, cfg, now)
+		if string(chunk) != chunkExpected {
+			t.Fatalf("unexpected chunk; got %q; want %q", chunk, chunkExpected)
+		}
+		var rows []string
+		for i := range rs.Rows {
+			rows = append(rows, rowString(&rs.Rows[i]))
+		}
+		if strings.Join(rows, "\n") != strings.Join(rowsExpected, "\n") {
+			t.Fatalf("unexpected rows;\ngot\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+	}
+
+	ts := appendEventTime(nil, 1603195200, 123456789)
+	rec1 := newRecord("log", "foo", "host", "h1", "level", "info")
+	rec2 := newRecord("kubernetes.pod", "p1", "log", "bar")
+
+	// Message mode
+	f(newMessage(ts, rec1), "", []string{
+		`{tag="app",host="h1"} 1603195200123456789 "foo level=info"`,
+	})
+	f(newMessage(appendInt(nil, 1603195200), rec2, newRecord("chunk", "c1")), "c1", []string{
+		`{tag="app",kubernetes_pod="p1"} 1603195200000000000 "bar"`,
+	})
+
+	// Zero time is replaced with now
+	f(newMessage(appendInt(nil, 0), newRecord("log", "foo")), "", []string{
+		`{tag="app"} 123 "foo"`,
+	})
+
+	// Forward mode
+	var entries []byte
+	entries = appendArrayLen(entries, 2)
+	entries = append(entries, newEntry(ts, rec1)...)
+	entries = append(entries, newEntry(ts, rec2)...)
+	f(newMessage(entries, newRecord("chunk", "c2", "size", "2")), "c2", []string{
+		`{tag="app",host="h1"} 1603195200123456789 "foo level=info"`,
+		`{tag="app",kubernetes_pod="p1"} 1603195200123456789 "bar"`,
+	})
+
+	// PackedForward mode
+	packed := append(newEntry(ts, rec1), newEntry(ts, rec2)...)
+	f(newMessage(appendBin(nil, packed)), "", []string{
+		`{tag="app",host="h1"} 1603195200123456789 "foo level=info"`,
+		`{tag="app",kubernetes_pod="p1"} 1603195200123456789 "bar"`,
+	})
+
+	// CompressedPackedForward mode with multiple gzip streams
+	compressed := append(gzipData(newEntry(ts, rec1)), gzipData(newEntry(ts, rec2))...)
+	f(newMessage(appendBin(nil, compressed), newRecord("compressed", "gzip", "chunk", "c3")), "c3", []string{
+		`{tag="app",host="h1"} 1603195200123456789 "foo level=info"`,
+		`{tag="app",kubernetes_pod="p1"} 1603195200123456789 "bar"`,
+	})
+
+	// [time, metadata] pair instead of time
+	var tsMeta []byte
+	tsMeta = appendArrayLen(tsMeta, 2)
+	tsMeta = append(tsMeta, ts...)
+	tsMeta = append(tsMeta, newRecord("foo", "bar")...)
+	f(newMessage(appendBin(nil, newEntry(tsMeta, rec2))), "", []string{
+		`{tag="app",kubernetes_pod="p1"} 1603195200123456789 "bar"`,
+	})
+
+	// Non-string values
+	var rec []byte
+	rec = appendMapLen(rec, 5)
+	rec = appendStr(rec, "log")
+	rec = appendBin(rec, []byte("bin log"))
+	rec = appendStr(rec, "status")
+	rec = appendInt(rec, 200)
+	rec = appendStr(rec, "nil")
+	rec = appendNil(rec)
+	rec = appendStr(rec, "kubernetes")
+	rec = append(rec, newRecord("ns", "default")...)
+	rec = appendStr(rec, "host")
+	rec = appendInt(rec, 42)
+	f(newMessage(ts, rec), "", []string{
+		`{tag="app",host="42"} 1603195200123456789 "bin log status=200 kubernetes=\"{\\\"ns\\\":\\\"default\\\"}\""`,
+	})
+
+	// Invalid messages are skipped, while their chunk ids are returned
+	f(appendStr(nil, "foo"), "", nil)
+	f(newMessage(), "", nil)
+	f(newMessage(ts), "", nil)
+	f(newMessage(appendStr(nil, "foo"), newRecord("chunk", "c4")), "c4", nil)
+	f(newMessage(ts, appendStr(nil, "foo"), newRecord("chunk", "c5")), "c5", nil)
+	f(newMessage(entries, newRecord("compressed", "gzip", "chunk", "c6")), "c6", nil)
+	f(newMessage(appendBin(nil, []byte("foo")), newRecord("chunk", "c7", "compressed", "gzip")), "c7", nil)
+	f(newMessage(appendBin(nil, compressed), newRecord("chunk", "c8", "compressed", "zstd")), "c8", nil)
+	f(newMessage(appendBin(nil, gzipData(make([]byte, 2048))), newRecord("chunk", "c9", "compressed", "gzip")), "c9", nil)
+
+	// Valid entries are dropped together with invalid entries in the same message
+	packed = append(newEntry(ts, rec1), newEntry(appendStr(nil, "foo"), rec2)...)
+	f(newMessage(appendBin(nil, packed)), "", nil)
+}
+
+func newMessage(items ...[]byte) []byte {
+	var b []byte
+	b = appendArrayLen(b, len(items)+1)
+	b = appendStr(b, "app")
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func newEntry(ts, record []byte) []byte {
+	b := appendArrayLen(nil, 2)
+	b = append(b, ts...)
+	return append(b, record...)
+}
+
+func newRecord(kvs ...string) []byte {
+	b := appendMapLen(nil, len(kvs)/2)
+	for _, kv := range kvs {
+		b = appendStr(b, kv)
+	}
+	return b
+}
+
+func gzipData(data []byte) []byte {
+	var bb bytes.Buffer
+	zw := gzip.NewWriter(&bb)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+	return bb.Bytes()
+}
+
+func rowString(r *Row) string {
+	var labels []string
+	for _, label := range r.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+	}
+	return fmt.Sprintf("{%s} %d %q", strings.Join(labels, ","), r.Timestamp, r.Message)
+}
@@ -0,0 +1,214 @@
+package fluentforward
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	labelFields = flagutil.NewArray("fluentForward.labelFields", "Record keys to use as stream labels for logs received via -fluentForwardListenAddr, "+
+		"e.g. -fluentForward.labelFields=hostname -fluentForward.labelFields=container_name. The Fluent tag is always stored in `tag` label. "+
+		"The remaining record keys are appended to the log line in logfmt format")
+	messageField   = flag.String("fluentForward.messageField", "log", "Record key to use as log line for logs received via -fluentForwardListenAddr")
+	maxMessageSize = flagutil.NewBytes("fluentForward.maxMessageSize", 64*1024*1024, "The maximum size in bytes of a single Fluent Forward message. "+
+		"This limit is applied to decompressed entries in CompressedPackedForward mode too")
+)
+
+// The size of a single read from the connection.
+const readBlockSize = 64 * 1024
+
+// ParseStream parses Fluent Forward messages from r and calls callback for the parsed rows.
+//
+// Acks for messages with `chunk` option are written to w after the callback for these messages returns without error.
+// See https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1#response
+//
+// The callback can be called multiple times for streamed data from r.
+// Messages are passed to the callback in the order they are read from r.
+//
+// callback shouldn't hold rows after returning.
+func ParseStream(r io.Reader, w io.Writer, callback func(rows []Row) error) error {
+	cfg := getConfig()
+	ctx := getStreamContext(r)
+	defer putStreamContext(ctx)
+	for ctx.Read() {
+		now := time.Now().UnixNano()
+		start := 0
+		acks := 0
+		for _, end := range ctx.msgEnds {
+			if chunk := ctx.rows.Unmarshal(ctx.buf[start:end], cfg, now); chunk != nil {
+				ctx.ackBuf = appendAck(ctx.ackBuf, chunk)
+				acks++
+			}
+			start = end
+		}
+		rowsRead.Add(len(ctx.rows.Rows))
+		if len(ctx.rows.Rows) > 0 {
+			if err := callback(ctx.rows.Rows); err != nil {
+				return err
+			}
+		}
+		if acks > 0 {
+			if _, err := w.Write(ctx.ackBuf); err != nil {
+				return fmt.Errorf("cannot send ack: %w", err)
+			}
+			acksSent.Add(acks)
+		}
+	}
+	return ctx.Error()
+}
+
+// appendAck appends msgpack-encoded ack response for the given chunk id to dst.
+func appendAck(dst, chunk []byte) []byte {
+	// {"ack": chunk}
+	dst = append(dst, 0x81, 0xa3, 'a', 'c', 'k')
+	return appendString(dst, chunk)
+}
+
+var (
+	configOnce sync.Once
+	configInst *config
+)
+
+func getConfig() *config {
+	configOnce.Do(func() {
+		configInst = newConfig(*labelFields, *messageField, maxMessageSize.N)
+	})
+	return configInst
+}
+
+// Read reads the next batch of complete messages into ctx.
+//
+// It doesn't wait for more messages if the already read messages may be processed,
+// since Fluent senders wait for acks before sending the next messages.
+func (ctx *streamContext) Read() bool {
+	readCalls.Inc()
+	ctx.rows.Reset()
+	ctx.ackBuf = ctx.ackBuf[:0]
+	if n := len(ctx.msgEnds); n > 0 {
+		// Drop the already processed messages.
+		tail := ctx.buf[ctx.msgEnds[n-1]:]
+		ctx.buf = append(ctx.buf[:0], tail...)
+		ctx.msgEnds = ctx.msgEnds[:0]
+	}
+	if ctx.err != nil {
+		return false
+	}
+	for {
+		if err := ctx.findMessages(); err != nil {
+			readErrors.Inc()
+			ctx.err = fmt.Errorf("cannot parse Fluent Forward message: %w", err)
+			return len(ctx.msgEnds) > 0
+		}
+		if len(ctx.msgEnds) > 0 {
+			return true
+		}
+		if len(ctx.buf) > maxMessageSize.N {
+			readErrors.Inc()
+			ctx.err = fmt.Errorf("too big message; it mustn't exceed -fluentForward.maxMessageSize=%d bytes", maxMessageSize.N)
+			return false
+		}
+		if err := ctx.readMore(); err != nil {
+			if err == io.EOF && len(ctx.buf) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != io.EOF {
+				readErrors.Inc()
+				err = fmt.Errorf("cannot read Fluent Forward message: %w", err)
+			}
+			ctx.err = err
+			return false
+		}
+	}
+}
+
+// findMessages appends the ends of complete messages in ctx.buf to ctx.msgEnds.
+func (ctx *streamContext) findMessages() error {
+	d := decoder{b: ctx.buf}
+	for len(d.b) > 0 {
+		if err := d.skip(); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// Incomplete message.
+				return nil
+			}
+			return err
+		}
+		ctx.msgEnds = append(ctx.msgEnds, len(ctx.buf)-len(d.b))
+	}
+	return nil
+}
+
+// readMore appends the next block of data from ctx.r to ctx.buf.
+func (ctx *streamContext) readMore() error {
+	bufLen := len(ctx.buf)
+	blockSize := readBlockSize
+	if bufLen > blockSize {
+		// Read big messages in bigger blocks in order to reduce the number of re-parsing attempts.
+		blockSize = bufLen
+	}
+	ctx.buf = bytesutil.Resize(ctx.buf, bufLen+blockSize)
+	n, err := ctx.r.Read(ctx.buf[bufLen:])
+	ctx.buf = ctx.buf[:bufLen+n]
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+type streamContext struct {
+	r       io.Reader
+	buf     []byte
+	msgEnds []int
+	rows    Rows
+	ackBuf  []byte
+	err     error
+}
+
+func (ctx *streamContext) Error() error {
+	if ctx.err == io.EOF {
+		return nil
+	}
+	return ctx.err
+}
+
+func (ctx *streamContext) reset() {
+	ctx.r = nil
+	ctx.buf = ctx.buf[:0]
+	ctx.msgEnds = ctx.msgEnds[:0]
+	ctx.rows.Reset()
+	ctx.ackBuf = ctx.ackBuf[:0]
+	ctx.err = nil
+}
+
+var (
+	readCalls  = metrics.NewCounter(`vm_protoparser_read_calls_total{type="fluentforward"}`)
+	readErrors = metrics.NewCounter(`vm_protoparser_read_errors_total{type="fluentforward"}`)
+	rowsRead   = metrics.NewCounter(`vm_protoparser_rows_read_total{type="fluentforward"}`)
+	acksSent   = metrics.NewCounter(`vm_protoparser_fluentforward_acks_sent_total`)
+)
+
+func getStreamContext(r io.Reader) *streamContext {
+	v := streamContextPool.Get()
+	if v == nil {
+		v = &streamContext{}
+	}
+	ctx := v.(*streamContext)
+	ctx.r = r
+	return ctx
+}
+
+func putStreamContext(ctx *streamContext) {
+	ctx.reset()
+	streamContextPool.Put(ctx)
+}
+
+var streamContextPool sync.Pool
@@ -0,0 +1,91 @@
+package fluentforward
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseStreamSuccess(t *testing.T) {
+	f := func(data []byte, messagesExpected, acksExpected []string) {
+		t.Helper()
+		var acks []byte
+		for _, chunk := range acksExpected {
+			acks = appendAck(acks, []byte(chunk))
+		}
+		// Verify reading the data at once and byte by byte.
+		for _, r := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+			var messages []string
+			var w bytes.Buffer
+			err := ParseStream(r, &w, func(rows []Row) error {
+				for i := range rows {
+					messages = append(messages, string(rows[i].Message))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if strings.Join(messages, "|") != strings.Join(messagesExpected, "|") {
+				t.Fatalf("unexpected messages;\ngot\n%q\nwant\n%q", messages, messagesExpected)
+			}
+			if !bytes.Equal(w.Bytes(), acks) {
+				t.Fatalf("unexpected acks;\ngot\n%X\nwant\n%X", w.Bytes(), acks)
+			}
+		}
+	}
+
+	ts := appendInt(nil, 1603195200)
+	f(nil, nil, nil)
+	f(newMessage(ts, newRecord("log", "foo")), []string{"foo"}, nil)
+
+	var data []byte
+	data = append(data, newMessage(ts, newRecord("log", "foo"), newRecord("chunk", "c1"))...)
+	data = append(data, newMessage(appendBin(nil, newEntry(ts, newRecord("log", "bar"))), newRecord("chunk", "c2"))...)
+	data = append(data, newMessage(ts, newRecord("log", "baz"))...)
+	f(data, []string{"foo", "bar", "baz"}, []string{"c1", "c2"})
+
+	// Invalid messages are acked too
+	data = newMessage(ts, appendStr(nil, "foo"), newRecord("chunk", "c3"))
+	f(data, nil, []string{"c3"})
+}
+
+func TestParseStreamFailure(t *testing.T) {
+	f := func(data []byte) {
+		t.Helper()
+		var w bytes.Buffer
+		err := ParseStream(bytes.NewReader(data), &w, func(rows []Row) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	ts := appendInt(nil, 1603195200)
+	msg := newMessage(ts, newRecord("log", "foo"), newRecord("chunk", "c1"))
+
+	// Truncated message
+	f(msg[:len(msg)-1])
+
+	// Invalid msgpack
+	f([]byte{0xc1})
+}
+
+func TestParseStreamCallbackError(t *testing.T) {
+	ts := appendInt(nil, 1603195200)
+	msg := newMessage(ts, newRecord("log", "foo"), newRecord("chunk", "c1"))
+	var w bytes.Buffer
+	err := ParseStream(bytes.NewReader(msg), &w, func(rows []Row) error {
+		return fmt.Errorf("some error")
+	})
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if w.Len() > 0 {
+		t.Fatalf("unexpected ack sent after callback error: %X", w.Bytes())
+	}
+}
@@ -0,0 +1,152 @@
+package gelf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// chunkMagic is the prefix of chunked GELF messages.
+const chunkMagic = "\x1e\x0f"
+
+// chunkHeaderSize is the size of chunk header: magic bytes, 8-byte message id, sequence number and sequence count.
+const chunkHeaderSize = 12
+
+// maxChunksCount is the maximum number of chunks per message according to GELF spec.
+const maxChunksCount = 128
+
+// isChunk returns true if data is a chunk of chunked GELF message.
+func isChunk(data []byte) bool {
+	return len(data) >= len(chunkMagic) && string(data[:len(chunkMagic)]) == chunkMagic
+}
+
+// chunkAssembler reassembles chunked GELF messages received over UDP.
+type chunkAssembler struct {
+	// timeout is the maximum duration for receiving all the chunks of a message.
+	timeout time.Duration
+
+	// maxMessageSize is the maximum size of reassembled message.
+	maxMessageSize int
+
+	// maxPendingSize is the maximum total size of chunks for incomplete messages.
+	maxPendingSize int
+
+	mu          sync.Mutex
+	messages    map[uint64]*chunkedMessage
+	pendingSize int
+	lastCleanup time.Time
+}
+
+type chunkedMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	deadline time.Time
+}
+
+func newChunkAssembler(timeout time.Duration, maxMessageSize, maxPendingSize int) *chunkAssembler {
+	return &chunkAssembler{
+		timeout:        timeout,
+		maxMessageSize: maxMessageSize,
+		maxPendingSize: maxPendingSize,
+		messages:       make(map[uint64]*chunkedMessage),
+	}
+}
+
+// add adds the given chunk received at now to ca.
+//
+// It appends the reassembled message to dst and returns true when all the chunks for the message are received.
+// Incomplete messages are dropped after ca.timeout or when they exceed memory limits.
+func (ca *chunkAssembler) add(dst, chunk []byte, now time.Time) ([]byte, bool, error) {
+	if len(chunk) < chunkHeaderSize {
+		return dst, false, fmt.Errorf("too short chunk; got %d bytes; want at least %d bytes", len(chunk), chunkHeaderSize)
+	}
+	id := binary.BigEndian.Uint64(chunk[2:10])
+	seqNum := int(chunk[10])
+	seqCount := int(chunk[11])
+	data := chunk[chunkHeaderSize:]
+	if seqCount == 0 || seqCount > maxChunksCount {
+		return dst, false, fmt.Errorf("sequence count must be in the range [1..%d]; got %d", maxChunksCount, seqCount)
+	}
+	if seqNum >= seqCount {
+		return dst, false, fmt.Errorf("sequence number %d must be smaller than sequence count %d", seqNum, seqCount)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if now.Sub(ca.lastCleanup) >= time.Second {
+		ca.dropExpiredLocked(now)
+		ca.lastCleanup = now
+	}
+	m := ca.messages[id]
+	if m == nil {
+		m = &chunkedMessage{
+			chunks:   make([][]byte, seqCount),
+			deadline: now.Add(ca.timeout),
+		}
+		ca.messages[id] = m
+	}
+	if len(m.chunks) != seqCount {
+		ca.dropLocked(id, m)
+		return dst, false, fmt.Errorf("inconsistent sequence count for message %016x; got %d; want %d", id, seqCount, len(m.chunks))
+	}
+	if m.chunks[seqNum] != nil {
+		// Skip duplicate chunk.
+		return dst, false, nil
+	}
+	if m.size+len(data) > ca.maxMessageSize {
+		ca.dropLocked(id, m)
+		chunkedMessagesTooBig.Inc()
+		return dst, false, fmt.Errorf("too big chunked message %016x; it mustn't exceed -gelf.maxMessageSize=%d bytes", id, ca.maxMessageSize)
+	}
+	if ca.pendingSize+len(data) > ca.maxPendingSize {
+		ca.dropLocked(id, m)
+		chunkedMessagesPendingLimit.Inc()
+		return dst, false, fmt.Errorf("cannot store chunk for message %016x, since the total size of pending chunks exceeds -gelf.maxPendingChunksSize=%d bytes",
+			id, ca.maxPendingSize)
+	}
+	m.chunks[seqNum] = append([]byte{}, data...)
+	m.received++
+	m.size += len(data)
+	ca.pendingSize += len(data)
+	if m.received < len(m.chunks) {
+		return dst, false, nil
+	}
+	for _, data := range m.chunks {
+		dst = append(dst, data...)
+	}
+	delete(ca.messages, id)
+	ca.pendingSize -= m.size
+	return dst, true, nil
+}
+
+func (ca *chunkAssembler) dropExpiredLocked(now time.Time) {
+	for id, m := range ca.messages {
+		if now.After(m.deadline) {
+			ca.dropLocked(id, m)
+			chunkedMessagesExpired.Inc()
+		}
+	}
+}
+
+func (ca *chunkAssembler) dropLocked(id uint64, m *chunkedMessage) {
+	delete(ca.messages, id)
+	ca.pendingSize -= m.size
+}
+
+func (ca *chunkAssembler) getPendingSize() int {
+	ca.mu.Lock()
+	n := ca.pendingSize
+	ca.mu.Unlock()
+	return n
+}
+
+var (
+	chunkedMessagesExpired      = metrics.NewCounter(`vm_protoparser_gelf_chunked_messages_dropped_total{reason="timeout"}`)
+	chunkedMessagesTooBig       = metrics.NewCounter(`vm_protoparser_gelf_chunked_messages_dropped_total{reason="too_big"}`)
+	chunkedMessagesPendingLimit = metrics.NewCounter(`vm_protoparser_gelf_chunked_messages_dropped_total{reason="pending_limit"}`)
+)
@@ -0,0 +1,98 @@
+package gelf
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestChunkAssembler(t *testing.T) {
+	ca := newChunkAssembler(5*time.Second, 100, 50)
+	now := time.Unix(1000, 0)
+
+	mustAdd := func(chunk []byte, now time.Time, resultExpected string) {
+		t.Helper()
+		result, ok, err := ca.add(nil, chunk, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resultExpected == "" {
+			if ok {
+				t.Fatalf("unexpected complete message %q", result)
+			}
+			return
+		}
+		if !ok {
+			t.Fatalf("expecting complete message %q", resultExpected)
+		}
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected message; got %q; want %q", result, resultExpected)
+		}
+	}
+	mustFail := func(chunk []byte) {
+		t.Helper()
+		if _, _, err := ca.add(nil, chunk, now); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+
+	// Chunks in order
+	mustAdd(newChunk(1, 0, 2, "foo"), now, "")
+	mustAdd(newChunk(1, 1, 2, "bar"), now, "foobar")
+
+	// Chunks out of order with duplicates
+	mustAdd(newChunk(2, 2, 3, "c"), now, "")
+	mustAdd(newChunk(2, 0, 3, "a"), now, "")
+	mustAdd(newChunk(2, 0, 3, "a"), now, "")
+	mustAdd(newChunk(2, 1, 3, "b"), now, "abc")
+
+	// Single chunk
+	mustAdd(newChunk(3, 0, 1, "x"), now, "x")
+
+	// Expired message is dropped
+	mustAdd(newChunk(4, 0, 2, "old"), now, "")
+	later := now.Add(6 * time.Second)
+	mustAdd(newChunk(5, 0, 2, "new"), later, "")
+	mustAdd(newChunk(4, 1, 2, "er"), later, "")
+	if n := ca.getPendingSize(); n != len("new")+len("er") {
+		t.Fatalf("unexpected pending size; got %d; want %d", n, len("new")+len("er"))
+	}
+	mustAdd(newChunk(5, 1, 2, "er"), later, "newer")
+
+	// Invalid chunks
+	mustFail([]byte(chunkMagic))
+	mustFail(newChunk(6, 0, 0, "foo"))
+	mustFail(newChunk(6, 0, 129, "foo"))
+	mustFail(newChunk(6, 2, 2, "foo"))
+
+	// Inconsistent sequence count
+	ca = newChunkAssembler(5*time.Second, 100, 50)
+	mustAdd(newChunk(7, 0, 2, "foo"), now, "")
+	mustFail(newChunk(7, 1, 3, "bar"))
+	if n := ca.getPendingSize(); n != 0 {
+		t.Fatalf("unexpected pending size after dropping inconsistent message; got %d; want 0", n)
+	}
+
+	// Too big message
+	mustAdd(newChunk(8, 0, 3, string(make([]byte, 40))), now, "")
+	mustFail(newChunk(8, 1, 3, string(make([]byte, 70))))
+	if n := ca.getPendingSize(); n != 0 {
+		t.Fatalf("unexpected pending size after dropping too big message; got %d; want 0", n)
+	}
+
+	// Pending size limit
+	mustAdd(newChunk(9, 0, 2, string(make([]byte, 30))), now, "")
+	mustFail(newChunk(10, 0, 2, string(make([]byte, 30))))
+	if n := ca.getPendingSize(); n != 30 {
+		t.Fatalf("unexpected pending size after exceeding the limit; got %d; want 30", n)
+	}
+}
+
+func newChunk(id uint64, seqNum, seqCount byte, data string) []byte {
+	chunk := []byte(chunkMagic)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], id)
+	chunk = append(chunk, b[:]...)
+	chunk = append(chunk, seqNum, seqCount)
+	return append(chunk, data...)
+}
@@ -0,0 +1,284 @@
+package gelf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/valyala/fastjson"
+)
+
+// Rows contains parsed GELF messages.
+type Rows struct {
+	Rows []Row
+
+	labelsPool []storage.Label
+	buf        []byte
+	fieldsBuf  []byte
+	valueBuf   []byte
+	p          fastjson.Parser
+}
+
+// Reset resets rs.
+func (rs *Rows) Reset() {
+	// Reset items, so they can be GC'ed
+	for i := range rs.Rows {
+		rs.Rows[i].reset()
+	}
+	rs.Rows = rs.Rows[:0]
+
+	for i := range rs.labelsPool {
+		label := &rs.labelsPool[i]
+		label.Name = nil
+		label.Value = nil
+	}
+	rs.labelsPool = rs.labelsPool[:0]
+	rs.buf = rs.buf[:0]
+	rs.fieldsBuf = rs.fieldsBuf[:0]
+	rs.valueBuf = rs.valueBuf[:0]
+}
+
+// Row is a single GELF message.
+//
+// See https://go2docs.graylog.org/5-0/getting_in_log_data/gelf.html#GELFPayloadSpecification
+type Row struct {
+	// Timestamp is unix timestamp in nanoseconds.
+	Timestamp int64
+
+	Labels  []storage.Label
+	Message []byte
+}
+
+func (r *Row) reset() {
+	r.Timestamp = 0
+	r.Labels = nil
+	r.Message = nil
+}
+
+// config determines which GELF message fields become stream labels.
+type config struct {
+	labelFields []string
+	labelNames  [][]byte
+}
+
+// defaultLabelFields are used as stream labels if no label fields are configured.
+var defaultLabelFields = []string{"host", "level"}
+
+func newConfig(labelFields []string) *config {
+	if len(labelFields) == 0 {
+		labelFields = defaultLabelFields
+	}
+	cfg := &config{
+		labelFields: labelFields,
+	}
+	for _, name := range labelFields {
+		cfg.labelNames = append(cfg.labelNames, protoparserutil.AppendSanitizedLabelName(nil, trimFieldPrefix(name)))
+	}
+	return cfg
+}
+
+// labelName returns label name for the given field name.
+//
+// nil is returned if name isn't in the label fields allowlist.
+func (cfg *config) labelName(name []byte) []byte {
+	for i, lf := range cfg.labelFields {
+		if lf == string(name) {
+			return cfg.labelNames[i]
+		}
+	}
+	return nil
+}
+
+// trimFieldPrefix removes the leading underscore from additional field name, e.g. `_container_name` -> `container_name`.
+func trimFieldPrefix(name string) string {
+	if len(name) > 1 && name[0] == '_' {
+		return name[1:]
+	}
+	return name
+}
+
+// Unmarshal unmarshals a single uncompressed GELF message from s according to cfg and appends it to rs.Rows.
+//
+// Fields from cfg become stream labels, while `full_message` or `short_message` becomes log line.
+// The remaining fields are appended to log line in logfmt format.
+//
+// Messages without timestamps get the timestamp from now.
+// Invalid messages are logged and skipped.
+func (rs *Rows) Unmarshal(s []byte, cfg *config, now int64) {
+	if err := rs.unmarshalMessage(s, cfg, now); err != nil {
+		logger.Errorf("cannot unmarshal GELF message %q: %s", s, err)
+		invalidLines.Inc()
+	}
+}
+
+var invalidLines = metrics.NewCounter(`vm_rows_invalid_total{type="gelf"}`)
+
+func (rs *Rows) unmarshalMessage(s []byte, cfg *config, now int64) error {
+	v, err := rs.p.ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	o, err := v.Object()
+	if err != nil {
+		return err
+	}
+	r := Row{
+		Timestamp: now,
+	}
+	labelsStart := len(rs.labelsPool)
+	rs.fieldsBuf = rs.fieldsBuf[:0]
+	var shortMessage, fullMessage []byte
+	o.Visit(func(k []byte, v *fastjson.Value) {
+		if err != nil {
+			return
+		}
+		switch string(k) {
+		case "version":
+			return
+		case "timestamp":
+			r.Timestamp, err = parseTimestamp(v)
+			if err != nil {
+				err = fmt.Errorf("cannot parse `timestamp` field: %w", err)
+			}
+			return
+		case "short_message":
+			bufLen := len(rs.buf)
+			rs.buf = appendValue(rs.buf, v)
+			shortMessage = rs.buf[bufLen:]
+			return
+		case "full_message":
+			bufLen := len(rs.buf)
+			rs.buf = appendValue(rs.buf, v)
+			fullMessage = rs.buf[bufLen:]
+			return
+		}
+		if labelName := cfg.labelName(k); labelName != nil {
+			bufLen := len(rs.buf)
+			if string(k) == "level" {
+				rs.buf = appendLevel(rs.buf, v)
+			} else {
+				rs.buf = appendValue(rs.buf, v)
+			}
+			if len(rs.buf) == bufLen {
+				// Skip labels with empty values.
+				return
+			}
+			rs.labelsPool = append(rs.labelsPool, storage.Label{
+				Name:  labelName,
+				Value: rs.buf[bufLen:],
+			})
+			return
+		}
+		if v.Type() == fastjson.TypeNull {
+			return
+		}
+		if len(rs.fieldsBuf) > 0 {
+			rs.fieldsBuf = append(rs.fieldsBuf, ' ')
+		}
+		rs.fieldsBuf = protoparserutil.AppendLogfmtValue(rs.fieldsBuf, trimFieldPrefix(bytesutil.ToUnsafeString(k)))
+		rs.fieldsBuf = append(rs.fieldsBuf, '=')
+		rs.valueBuf = appendValue(rs.valueBuf[:0], v)
+		rs.fieldsBuf = protoparserutil.AppendLogfmtValue(rs.fieldsBuf, bytesutil.ToUnsafeString(rs.valueBuf))
+	})
+	if err != nil {
+		rs.labelsPool = rs.labelsPool[:labelsStart]
+		return err
+	}
+	message := fullMessage
+	if len(message) == 0 {
+		message = shortMessage
+	}
+	if len(rs.fieldsBuf) > 0 {
+		// Render the remaining fields into the message.
+		bufLen := len(rs.buf)
+		if len(message) > 0 {
+			rs.buf = append(rs.buf, message...)
+			rs.buf = append(rs.buf, ' ')
+		}
+		rs.buf = append(rs.buf, rs.fieldsBuf...)
+		message = rs.buf[bufLen:]
+	}
+	labels := rs.labelsPool[labelsStart:]
+	r.Labels = labels[:len(labels):len(labels)]
+	r.Message = message[:len(message):len(message)]
+	rs.Rows = append(rs.Rows, r)
+	return nil
+}
+
+// appendValue appends string representation of v to dst.
+//
+// Strings are appended without quotes, while nothing is appended for null.
+func appendValue(dst []byte, v *fastjson.Value) []byte {
+	switch v.Type() {
+	case fastjson.TypeString:
+		return append(dst, v.GetStringBytes()...)
+	case fastjson.TypeNull:
+		return dst
+	default:
+		return v.MarshalTo(dst)
+	}
+}
+
+// appendLevel appends the keyword for syslog severity level v to dst.
+//
+// Levels, which aren't syslog severity numbers, are appended as is.
+func appendLevel(dst []byte, v *fastjson.Value) []byte {
+	if v.Type() == fastjson.TypeNumber {
+		n, err := v.Int()
+		if err == nil && n >= 0 && n < len(levelNames) {
+			return append(dst, levelNames[n]...)
+		}
+	}
+	return appendValue(dst, v)
+}
+
+// levelNames contains keywords for syslog severity levels according to RFC 5424.
+var levelNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// parseTimestamp parses unix timestamp in seconds with optional fractional part from v and returns it in nanoseconds.
+func parseTimestamp(v *fastjson.Value) (int64, error) {
+	var s string
+	switch v.Type() {
+	case fastjson.TypeNumber:
+		s = v.String()
+	case fastjson.TypeString:
+		s = bytesutil.ToUnsafeString(v.GetStringBytes())
+	default:
+		return 0, fmt.Errorf("unsupported timestamp type %s", v.Type())
+	}
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, err
+		}
+		return int64(f * 1e9), nil
+	}
+	// Parse integer and fractional parts separately in order to avoid precision loss.
+	frac := ""
+	if n := strings.IndexByte(s, '.'); n >= 0 {
+		s, frac = s[:n], s[n+1:]
+	}
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	nsecs := int64(0)
+	for i := 0; i < 9; i++ {
+		nsecs *= 10
+		if i < len(frac) {
+			c := frac[i]
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("unexpected char %q in fractional part of timestamp", c)
+			}
+			nsecs += int64(c - '0')
+		}
+	}
+	return secs*1e9 + nsecs, nil
+}
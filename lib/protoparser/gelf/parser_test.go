@@ -0,0 +1,81 @@
+package gelf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRowsUnmarshal(t *testing.T) {
+	const now = 123
+	f := func(s string, cfg *config, rowsExpected []string) {
+		t.Helper()
+		var rs Rows
+		rs.Unmarshal([]byte(s), cfg, now)
+		var rows []string
+		for i := range rs.Rows {
+			rows = append(rows, rowString(&rs.Rows[i]))
+		}
+		if strings.Join(rows, "\n") != strings.Join(rowsExpected, "\n") {
+			t.Fatalf("unexpected rows;\ngot\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+	}
+
+	cfg := newConfig(nil)
+
+	// Invalid messages are skipped
+	f("", cfg, nil)
+	f("foobar", cfg, nil)
+	f("[]", cfg, nil)
+	f(`{"host":"foo","timestamp":"bar"}`, cfg, nil)
+	f(`{"host":"foo","timestamp":true}`, cfg, nil)
+
+	// Default label fields
+	f(`{"version":"1.1","host":"example.org","short_message":"A short message","timestamp":1385053862.3072,"level":1}`, cfg, []string{
+		`{host="example.org",level="alert"} 1385053862307200000 "A short message"`,
+	})
+	f(`{"host":"example.org","short_message":"short","full_message":"full\nmessage","level":"WARN"}`, cfg, []string{
+		`{host="example.org",level="WARN"} 123 "full\nmessage"`,
+	})
+
+	// Additional fields are rendered into the message
+	f(`{"host":"example.org","short_message":"foo","timestamp":1385053862,"_user_id":9001,"_some info":"bar baz","_empty":null,"facility":"app"}`, cfg, []string{
+		`{host="example.org"} 1385053862000000000 "foo user_id=9001 \"some info\"=\"bar baz\" facility=app"`,
+	})
+
+	// Custom label fields
+	cfg = newConfig([]string{"host", "_container.name"})
+	f(`{"host":"h1","short_message":"foo","_container.name":"nginx","level":6}`, cfg, []string{
+		`{host="h1",container_name="nginx"} 123 "foo level=6"`,
+	})
+	f(`{"short_message":"foo"}`, cfg, []string{
+		`{} 123 "foo"`,
+	})
+}
+
+func TestParseTimestamp(t *testing.T) {
+	f := func(s string, tsExpected int64) {
+		t.Helper()
+		var rs Rows
+		rs.Unmarshal([]byte(`{"timestamp":`+s+`}`), newConfig(nil), 0)
+		if len(rs.Rows) != 1 {
+			t.Fatalf("unexpected number of rows for timestamp %s; got %d; want 1", s, len(rs.Rows))
+		}
+		if ts := rs.Rows[0].Timestamp; ts != tsExpected {
+			t.Fatalf("unexpected timestamp for %s; got %d; want %d", s, ts, tsExpected)
+		}
+	}
+	f("1385053862", 1385053862000000000)
+	f("1385053862.3072", 1385053862307200000)
+	f("1385053862.123456789", 1385053862123456789)
+	f(`"1385053862.5"`, 1385053862500000000)
+	f("1.5e9", 1500000000000000000)
+}
+
+func rowString(r *Row) string {
+	var labels []string
+	for _, label := range r.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+	}
+	return fmt.Sprintf("{%s} %d %q", strings.Join(labels, ","), r.Timestamp, r.Message)
+}
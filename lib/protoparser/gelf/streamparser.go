@@ -0,0 +1,286 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	labelFields = flagutil.NewArray("gelf.labelFields", "GELF message fields to use as stream labels. Additional fields must be passed with the leading underscore, "+
+		"e.g. -gelf.labelFields=_container_name, while the underscore is removed from label names. By default `host` and `level` fields are used. "+
+		"The remaining fields are appended to the log line in logfmt format")
+	maxMessageSize = flagutil.NewBytes("gelf.maxMessageSize", 1024*1024, "The maximum size in bytes of a single uncompressed GELF message. "+
+		"This protects from compression bombs")
+	chunkTimeout         = flag.Duration("gelf.chunkTimeout", 5*time.Second, "The maximum duration for receiving all the chunks of a chunked GELF message over UDP. Incomplete messages are dropped after the timeout")
+	maxPendingChunksSize = flagutil.NewBytes("gelf.maxPendingChunksSize", 64*1024*1024, "The maximum total size in bytes of chunks for incomplete chunked GELF messages. "+
+		"Messages exceeding the limit are dropped")
+)
+
+// The maximum size of messages passed to a single callback call.
+const maxBatchSize = 256 * 1024
+
+// ParseStream parses null byte-delimited uncompressed GELF messages from TCP stream r and calls callback for the parsed rows.
+//
+// The callback can be called multiple times for streamed data from r.
+// Messages are passed to the callback in the order they are read from r.
+//
+// callback shouldn't hold rows after returning.
+func ParseStream(r io.Reader, callback func(rows []Row) error) error {
+	cfg := getConfig()
+	ctx := getStreamContext(r)
+	defer putStreamContext(ctx)
+	for ctx.Read() {
+		now := time.Now().UnixNano()
+		start := 0
+		for _, end := range ctx.msgEnds {
+			ctx.rows.Unmarshal(ctx.reqBuf[start:end], cfg, now)
+			start = end
+		}
+		rowsRead.Add(len(ctx.rows.Rows))
+		if err := callback(ctx.rows.Rows); err != nil {
+			return err
+		}
+	}
+	return ctx.Error()
+}
+
+// ParseDatagram parses GELF message from UDP datagram data and calls callback for the parsed row.
+//
+// data may contain either uncompressed, zlib-compressed or gzip-compressed message or a chunk of chunked message.
+// The callback is called after all the chunks for chunked message are received.
+//
+// callback shouldn't hold rows after returning.
+func ParseDatagram(data []byte, callback func(rows []Row) error) error {
+	ctx := getDatagramContext()
+	defer putDatagramContext(ctx)
+	if isChunk(data) {
+		var ok bool
+		var err error
+		ctx.chunksBuf, ok, err = getChunkAssembler().add(ctx.chunksBuf[:0], data, time.Now())
+		if err != nil {
+			readErrors.Inc()
+			return fmt.Errorf("cannot process GELF chunk: %w", err)
+		}
+		if !ok {
+			// Wait for the remaining chunks.
+			return nil
+		}
+		data = ctx.chunksBuf
+	}
+	var err error
+	ctx.msgBuf, err = decompress(ctx.msgBuf[:0], data)
+	if err != nil {
+		readErrors.Inc()
+		return fmt.Errorf("cannot decompress GELF message: %w", err)
+	}
+	ctx.rows.Unmarshal(ctx.msgBuf, getConfig(), time.Now().UnixNano())
+	rowsRead.Add(len(ctx.rows.Rows))
+	if len(ctx.rows.Rows) == 0 {
+		return nil
+	}
+	return callback(ctx.rows.Rows)
+}
+
+// decompress appends decompressed data to dst.
+//
+// Uncompressed data is appended as is.
+func decompress(dst, data []byte) ([]byte, error) {
+	var zr io.Reader
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return dst, fmt.Errorf("cannot read gzip header: %w", err)
+		}
+		zr = r
+	case len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return dst, fmt.Errorf("cannot read zlib header: %w", err)
+		}
+		zr = r
+	default:
+		if len(data) > maxMessageSize.N {
+			return dst, fmt.Errorf("too big message; it mustn't exceed -gelf.maxMessageSize=%d bytes", maxMessageSize.N)
+		}
+		return append(dst, data...), nil
+	}
+	bb := bytes.NewBuffer(dst)
+	n, err := bb.ReadFrom(io.LimitReader(zr, int64(maxMessageSize.N)+1))
+	if err != nil {
+		return dst, err
+	}
+	if n > int64(maxMessageSize.N) {
+		return dst, fmt.Errorf("too big uncompressed message; it mustn't exceed -gelf.maxMessageSize=%d bytes", maxMessageSize.N)
+	}
+	return bb.Bytes(), nil
+}
+
+var (
+	configOnce sync.Once
+	configInst *config
+)
+
+func getConfig() *config {
+	configOnce.Do(func() {
+		configInst = newConfig(*labelFields)
+	})
+	return configInst
+}
+
+var (
+	chunkAssemblerOnce sync.Once
+	chunkAssemblerInst *chunkAssembler
+)
+
+func getChunkAssembler() *chunkAssembler {
+	chunkAssemblerOnce.Do(func() {
+		chunkAssemblerInst = newChunkAssembler(*chunkTimeout, maxMessageSize.N, maxPendingChunksSize.N)
+	})
+	return chunkAssemblerInst
+}
+
+var _ = metrics.NewGauge(`vm_protoparser_gelf_pending_chunks_bytes`, func() float64 {
+	return float64(getChunkAssembler().getPendingSize())
+})
+
+// Read reads the next batch of messages into ctx.
+//
+// It doesn't wait for more messages if the already read messages may be processed,
+// since GELF senders may keep connections open for long periods of time.
+func (ctx *streamContext) Read() bool {
+	readCalls.Inc()
+	ctx.rows.Reset()
+	ctx.reqBuf = ctx.reqBuf[:0]
+	ctx.msgEnds = ctx.msgEnds[:0]
+	if ctx.err != nil {
+		return false
+	}
+	for len(ctx.reqBuf) < maxBatchSize {
+		if err := ctx.readMessage(); err != nil {
+			if err != io.EOF {
+				readErrors.Inc()
+				err = fmt.Errorf("cannot read GELF message: %w", err)
+			}
+			ctx.err = err
+			break
+		}
+		if ctx.br.Buffered() == 0 && len(ctx.msgEnds) > 0 {
+			break
+		}
+	}
+	return len(ctx.msgEnds) > 0
+}
+
+// readMessage reads a single null byte-delimited message from ctx.br and appends it to ctx.reqBuf.
+func (ctx *streamContext) readMessage() error {
+	start := len(ctx.reqBuf)
+	for {
+		line, err := ctx.br.ReadSlice(0)
+		ctx.reqBuf = append(ctx.reqBuf, line...)
+		if len(ctx.reqBuf)-start > maxMessageSize.N {
+			return fmt.Errorf("too big message; it mustn't exceed -gelf.maxMessageSize=%d bytes", maxMessageSize.N)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || len(ctx.reqBuf) == start) {
+			return err
+		}
+		break
+	}
+	end := len(ctx.reqBuf)
+	for end > start && (ctx.reqBuf[end-1] == 0 || ctx.reqBuf[end-1] == '\n' || ctx.reqBuf[end-1] == '\r') {
+		end--
+	}
+	ctx.reqBuf = ctx.reqBuf[:end]
+	if end > start {
+		// Skip empty messages.
+		ctx.msgEnds = append(ctx.msgEnds, end)
+	}
+	return nil
+}
+
+type streamContext struct {
+	br      *bufio.Reader
+	reqBuf  []byte
+	msgEnds []int
+	rows    Rows
+	err     error
+}
+
+func (ctx *streamContext) Error() error {
+	if ctx.err == io.EOF {
+		return nil
+	}
+	return ctx.err
+}
+
+func (ctx *streamContext) reset() {
+	ctx.br.Reset(nil)
+	ctx.reqBuf = ctx.reqBuf[:0]
+	ctx.msgEnds = ctx.msgEnds[:0]
+	ctx.rows.Reset()
+	ctx.err = nil
+}
+
+var (
+	readCalls  = metrics.NewCounter(`vm_protoparser_read_calls_total{type="gelf"}`)
+	readErrors = metrics.NewCounter(`vm_protoparser_read_errors_total{type="gelf"}`)
+	rowsRead   = metrics.NewCounter(`vm_protoparser_rows_read_total{type="gelf"}`)
+)
+
+func getStreamContext(r io.Reader) *streamContext {
+	if v := streamContextPool.Get(); v != nil {
+		ctx := v.(*streamContext)
+		ctx.br.Reset(r)
+		return ctx
+	}
+	return &streamContext{
+		br: bufio.NewReaderSize(r, 64*1024),
+	}
+}
+
+func putStreamContext(ctx *streamContext) {
+	ctx.reset()
+	streamContextPool.Put(ctx)
+}
+
+var streamContextPool sync.Pool
+
+type datagramContext struct {
+	chunksBuf []byte
+	msgBuf    []byte
+	rows      Rows
+}
+
+func (ctx *datagramContext) reset() {
+	ctx.chunksBuf = ctx.chunksBuf[:0]
+	ctx.msgBuf = ctx.msgBuf[:0]
+	ctx.rows.Reset()
+}
+
+func getDatagramContext() *datagramContext {
+	v := datagramContextPool.Get()
+	if v == nil {
+		return &datagramContext{}
+	}
+	return v.(*datagramContext)
+}
+
+func putDatagramContext(ctx *datagramContext) {
+	ctx.reset()
+	datagramContextPool.Put(ctx)
+}
+
+var datagramContextPool sync.Pool
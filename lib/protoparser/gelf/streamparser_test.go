@@ -0,0 +1,84 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseStream(t *testing.T) {
+	f := func(s string, messagesExpected []string) {
+		t.Helper()
+		var messages []string
+		err := ParseStream(strings.NewReader(s), func(rows []Row) error {
+			for i := range rows {
+				messages = append(messages, string(rows[i].Message))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(messages, "|") != strings.Join(messagesExpected, "|") {
+			t.Fatalf("unexpected messages;\ngot\n%q\nwant\n%q", messages, messagesExpected)
+		}
+	}
+
+	f("", nil)
+	f("\x00\n\x00", nil)
+	f(`{"host":"h","short_message":"foo"}`, []string{"foo"})
+	f(`{"host":"h","short_message":"foo"}`+"\x00"+`{"host":"h","short_message":"bar"}`+"\x00", []string{"foo", "bar"})
+
+	// Invalid messages are skipped
+	f("foobar\x00"+`{"host":"h","short_message":"foo"}`+"\x00", []string{"foo"})
+}
+
+func TestParseDatagram(t *testing.T) {
+	f := func(data []byte, messagesExpected []string) {
+		t.Helper()
+		var messages []string
+		err := ParseDatagram(data, func(rows []Row) error {
+			for i := range rows {
+				messages = append(messages, fmt.Sprintf("%s %s", rows[i].Labels[0].Value, rows[i].Message))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(messages, "|") != strings.Join(messagesExpected, "|") {
+			t.Fatalf("unexpected messages;\ngot\n%q\nwant\n%q", messages, messagesExpected)
+		}
+	}
+
+	msg := `{"host":"h","short_message":"foo"}`
+	f([]byte(msg), []string{"h foo"})
+
+	// zlib
+	var bb bytes.Buffer
+	zw := zlib.NewWriter(&bb)
+	_, _ = zw.Write([]byte(msg))
+	_ = zw.Close()
+	f(bb.Bytes(), []string{"h foo"})
+
+	// gzip
+	bb.Reset()
+	gw := gzip.NewWriter(&bb)
+	_, _ = gw.Write([]byte(msg))
+	_ = gw.Close()
+	compressed := append([]byte{}, bb.Bytes()...)
+	f(compressed, []string{"h foo"})
+
+	// Chunked gzip
+	n := len(compressed) / 2
+	f(newChunk(123, 1, 2, string(compressed[n:])), nil)
+	f(newChunk(123, 0, 2, string(compressed[:n])), []string{"h foo"})
+
+	// Invalid compressed data
+	if err := ParseDatagram(compressed[:10], func(rows []Row) error { return nil }); err == nil {
+		t.Fatalf("expecting non-nil error for truncated gzip data")
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
 	"github.com/VictoriaMetrics/metrics"
 )
@@ -18,16 +17,24 @@ import (
 // The callback can be called multiple times for streamed data from r.
 //
 // callback shouldn't hold rows after returning.
+//
+// ParseStream waits until callback returns for all the parsed rows and returns the first error returned by callback.
 func ParseStream(r io.Reader, callback func(rows []Row) error) error {
 	ctx := getStreamContext(r)
 	defer putStreamContext(ctx)
 	for ctx.Read() {
 		uw := getUnmarshalWork()
 		uw.callback = callback
+		uw.ctx = ctx
 		uw.reqBuf, ctx.reqBuf = ctx.reqBuf, uw.reqBuf
+		ctx.wg.Add(1)
 		common.ScheduleUnmarshalWork(uw)
 	}
-	return ctx.Error()
+	ctx.wg.Wait()
+	if err := ctx.Error(); err != nil {
+		return err
+	}
+	return ctx.callbackErr
 }
 
 func (ctx *streamContext) Read() bool {
@@ -51,6 +58,13 @@ type streamContext struct {
 	reqBuf  []byte
 	tailBuf []byte
 	err     error
+
+	// wg is used for waiting until the scheduled unmarshalWork items are processed.
+	wg sync.WaitGroup
+
+	// callbackErr is the first error returned by callback.
+	callbackErrLock sync.Mutex
+	callbackErr     error
 }
 
 func (ctx *streamContext) Error() error {
@@ -65,6 +79,7 @@ func (ctx *streamContext) reset() {
 	ctx.reqBuf = ctx.reqBuf[:0]
 	ctx.tailBuf = ctx.tailBuf[:0]
 	ctx.err = nil
+	ctx.callbackErr = nil
 }
 
 var (
@@ -105,12 +120,14 @@ var streamContextPoolCh = make(chan *streamContext, runtime.GOMAXPROCS(-1))
 type unmarshalWork struct {
 	rows     Rows
 	callback func(rows []Row) error
+	ctx      *streamContext
 	reqBuf   []byte
 }
 
 func (uw *unmarshalWork) reset() {
 	uw.rows.Reset()
 	uw.callback = nil
+	uw.ctx = nil
 	uw.reqBuf = uw.reqBuf[:0]
 }
 
@@ -129,12 +146,16 @@ func (uw *unmarshalWork) Unmarshal() {
 		}
 	}
 
+	ctx := uw.ctx
 	if err := uw.callback(rows); err != nil {
-		logger.Errorf("error when processing imported data: %s", err)
-		putUnmarshalWork(uw)
-		return
+		ctx.callbackErrLock.Lock()
+		if ctx.callbackErr == nil {
+			ctx.callbackErr = fmt.Errorf("error when processing imported data: %w", err)
+		}
+		ctx.callbackErrLock.Unlock()
 	}
 	putUnmarshalWork(uw)
+	ctx.wg.Done()
 }
 
 func getUnmarshalWork() *unmarshalWork {
@@ -2,6 +2,7 @@ package importer
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"sort"
 	"sync"
@@ -98,6 +99,20 @@ func appendRowCopies(dst, src []Row) []Row {
 	return dst
 }
 
+func TestParseStreamCallbackError(t *testing.T) {
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	errExpected := errors.New("callback error")
+	bb := bytes.NewBufferString("foo 123 \"bar\"")
+	err := ParseStream(bb, func(rows []Row) error {
+		return errExpected
+	})
+	if !errors.Is(err, errExpected) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errExpected)
+	}
+}
+
 func copyBytes(s []byte) []byte {
 	return append([]byte(nil), s...)
 }
@@ -0,0 +1,325 @@
+package jsonline
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/valyala/fastjson"
+)
+
+// TimeFormat is the format of the timestamp field.
+type TimeFormat int
+
+// Supported timestamp formats.
+const (
+	TimeFormatRFC3339 TimeFormat = iota
+	TimeFormatUnixS
+	TimeFormatUnixMs
+	TimeFormatUnixNs
+)
+
+// ParseTimeFormat parses timestamp format from s.
+//
+// Supported values are `rfc3339`, `unix_s`, `unix_ms` and `unix_ns`.
+func ParseTimeFormat(s string) (TimeFormat, error) {
+	switch s {
+	case "rfc3339":
+		return TimeFormatRFC3339, nil
+	case "unix_s":
+		return TimeFormatUnixS, nil
+	case "unix_ms":
+		return TimeFormatUnixMs, nil
+	case "unix_ns":
+		return TimeFormatUnixNs, nil
+	default:
+		return 0, fmt.Errorf("unsupported time format %q; supported values: rfc3339, unix_s, unix_ms, unix_ns", s)
+	}
+}
+
+// Config determines how JSON lines are converted into log entries.
+type Config struct {
+	streamFields     []string
+	streamLabelNames [][]byte
+	messageField     string
+	timeField        string
+	timeFormat       TimeFormat
+}
+
+// NewConfig returns new Config.
+//
+// streamFields are used as stream labels. Nested fields are referred via dots, e.g. `kubernetes.pod`.
+// messageField is used as log line, while timeField contains log timestamp in timeFormat.
+func NewConfig(streamFields []string, messageField, timeField string, timeFormat TimeFormat) *Config {
+	cfg := &Config{
+		streamFields: streamFields,
+		messageField: messageField,
+		timeField:    timeField,
+		timeFormat:   timeFormat,
+	}
+	for _, sf := range streamFields {
+		cfg.streamLabelNames = append(cfg.streamLabelNames, protoparserutil.AppendSanitizedLabelName(nil, sf))
+	}
+	return cfg
+}
+
+// labelName returns label name for the given field name.
+//
+// nil is returned if name isn't a stream field.
+func (cfg *Config) labelName(name []byte) []byte {
+	for i, sf := range cfg.streamFields {
+		if sf == string(name) {
+			return cfg.streamLabelNames[i]
+		}
+	}
+	return nil
+}
+
+// Rows contains log entries parsed from JSON lines.
+type Rows struct {
+	Rows []Row
+
+	labelsPool []storage.Label
+	buf        []byte
+	fieldsBuf  []byte
+	valueBuf   []byte
+	keyBuf     []byte
+	p          fastjson.Parser
+}
+
+// Reset resets rs.
+func (rs *Rows) Reset() {
+	// Reset items, so they can be GC'ed
+	for i := range rs.Rows {
+		rs.Rows[i].reset()
+	}
+	rs.Rows = rs.Rows[:0]
+
+	for i := range rs.labelsPool {
+		label := &rs.labelsPool[i]
+		label.Name = nil
+		label.Value = nil
+	}
+	rs.labelsPool = rs.labelsPool[:0]
+	rs.buf = rs.buf[:0]
+	rs.fieldsBuf = rs.fieldsBuf[:0]
+	rs.valueBuf = rs.valueBuf[:0]
+	rs.keyBuf = rs.keyBuf[:0]
+}
+
+// Row is a single log entry obtained from JSON line.
+type Row struct {
+	// Timestamp is unix timestamp in nanoseconds.
+	Timestamp int64
+
+	Labels  []storage.Label
+	Message []byte
+}
+
+func (r *Row) reset() {
+	r.Timestamp = 0
+	r.Labels = nil
+	r.Message = nil
+}
+
+// Unmarshal unmarshals newline-delimited JSON documents from s according to cfg and appends them to rs.Rows.
+//
+// Stream fields from cfg become stream labels, while the remaining fields except of message and time fields
+// are appended to the message in logfmt format. Nested objects are flattened with dots.
+//
+// Lines without timestamps get the timestamp from now.
+// Invalid lines are logged and skipped.
+func (rs *Rows) Unmarshal(s []byte, cfg *Config, now int64) {
+	for len(s) > 0 {
+		var line []byte
+		n := bytes.IndexByte(s, '\n')
+		if n < 0 {
+			line = s
+			s = s[len(s):]
+		} else {
+			line = s[:n]
+			s = s[n+1:]
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := rs.unmarshalLine(line, cfg, now); err != nil {
+			logger.Errorf("cannot unmarshal JSON line %q: %s", line, err)
+			invalidLines.Inc()
+		}
+	}
+}
+
+var invalidLines = metrics.NewCounter(`vm_rows_invalid_total{type="jsonline"}`)
+
+func (rs *Rows) unmarshalLine(line []byte, cfg *Config, now int64) error {
+	v, err := rs.p.ParseBytes(line)
+	if err != nil {
+		return err
+	}
+	o, err := v.Object()
+	if err != nil {
+		return err
+	}
+	r := Row{
+		Timestamp: now,
+	}
+	labelsStart := len(rs.labelsPool)
+	rs.fieldsBuf = rs.fieldsBuf[:0]
+	var message []byte
+	rs.keyBuf = rs.keyBuf[:0]
+	err = rs.visitFields(o, func(key []byte, v *fastjson.Value) error {
+		if string(key) == cfg.timeField {
+			ts, err := parseTimestamp(v, cfg.timeFormat)
+			if err != nil {
+				return fmt.Errorf("cannot parse field %q: %w", key, err)
+			}
+			r.Timestamp = ts
+			return nil
+		}
+		if string(key) == cfg.messageField {
+			bufLen := len(rs.buf)
+			rs.buf = appendValue(rs.buf, v)
+			message = rs.buf[bufLen:]
+			return nil
+		}
+		if labelName := cfg.labelName(key); labelName != nil {
+			bufLen := len(rs.buf)
+			rs.buf = appendValue(rs.buf, v)
+			if len(rs.buf) == bufLen {
+				// Skip labels with empty values.
+				return nil
+			}
+			rs.labelsPool = append(rs.labelsPool, storage.Label{
+				Name:  labelName,
+				Value: rs.buf[bufLen:],
+			})
+			return nil
+		}
+		if v.Type() == fastjson.TypeNull {
+			return nil
+		}
+		if len(rs.fieldsBuf) > 0 {
+			rs.fieldsBuf = append(rs.fieldsBuf, ' ')
+		}
+		rs.fieldsBuf = protoparserutil.AppendLogfmtValue(rs.fieldsBuf, bytesutil.ToUnsafeString(key))
+		rs.fieldsBuf = append(rs.fieldsBuf, '=')
+		rs.valueBuf = appendValue(rs.valueBuf[:0], v)
+		rs.fieldsBuf = protoparserutil.AppendLogfmtValue(rs.fieldsBuf, bytesutil.ToUnsafeString(rs.valueBuf))
+		return nil
+	})
+	if err != nil {
+		rs.labelsPool = rs.labelsPool[:labelsStart]
+		return err
+	}
+	labels := rs.labelsPool[labelsStart:]
+	if len(labels) == 0 {
+		return fmt.Errorf("the line has no non-empty stream fields %q", cfg.streamFields)
+	}
+	if len(rs.fieldsBuf) > 0 {
+		// Render the remaining fields into the message.
+		bufLen := len(rs.buf)
+		if len(message) > 0 {
+			rs.buf = append(rs.buf, message...)
+			rs.buf = append(rs.buf, ' ')
+		}
+		rs.buf = append(rs.buf, rs.fieldsBuf...)
+		message = rs.buf[bufLen:]
+	}
+	r.Labels = labels[:len(labels):len(labels)]
+	r.Message = message[:len(message):len(message)]
+	rs.Rows = append(rs.Rows, r)
+	return nil
+}
+
+// visitFields calls f for all the fields in o. Nested objects are flattened with dots in keys.
+func (rs *Rows) visitFields(o *fastjson.Object, f func(key []byte, v *fastjson.Value) error) error {
+	var err error
+	prefixLen := len(rs.keyBuf)
+	o.Visit(func(k []byte, v *fastjson.Value) {
+		if err != nil {
+			return
+		}
+		rs.keyBuf = rs.keyBuf[:prefixLen]
+		if prefixLen > 0 {
+			rs.keyBuf = append(rs.keyBuf, '.')
+		}
+		rs.keyBuf = append(rs.keyBuf, k...)
+		if v.Type() == fastjson.TypeObject {
+			err = rs.visitFields(v.GetObject(), f)
+		} else {
+			err = f(rs.keyBuf, v)
+		}
+	})
+	rs.keyBuf = rs.keyBuf[:prefixLen]
+	return err
+}
+
+// appendValue appends string representation of v to dst.
+//
+// Strings are appended without quotes, while nothing is appended for null.
+func appendValue(dst []byte, v *fastjson.Value) []byte {
+	switch v.Type() {
+	case fastjson.TypeString:
+		return append(dst, v.GetStringBytes()...)
+	case fastjson.TypeNull:
+		return dst
+	default:
+		return v.MarshalTo(dst)
+	}
+}
+
+// parseTimestamp parses timestamp from v in the given format and returns it in nanoseconds.
+//
+// Unix timestamps may be represented either as numbers or as strings.
+func parseTimestamp(v *fastjson.Value, format TimeFormat) (int64, error) {
+	var s string
+	switch v.Type() {
+	case fastjson.TypeString:
+		s = bytesutil.ToUnsafeString(v.GetStringBytes())
+	case fastjson.TypeNumber:
+		if format == TimeFormatRFC3339 {
+			return 0, fmt.Errorf("expecting RFC3339 string; got number %s", v)
+		}
+		s = v.String()
+	default:
+		return 0, fmt.Errorf("unsupported timestamp type %s", v.Type())
+	}
+	if format == TimeFormatRFC3339 {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0, err
+		}
+		return t.UnixNano(), nil
+	}
+	var multiplier int64
+	switch format {
+	case TimeFormatUnixS:
+		multiplier = 1e9
+	case TimeFormatUnixMs:
+		multiplier = 1e6
+	default:
+		multiplier = 1
+	}
+	if !strings.ContainsAny(s, ".eE") {
+		// Fast path - integer timestamp.
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return n * multiplier, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(f * float64(multiplier)), nil
+}
@@ -0,0 +1,94 @@
+package jsonline
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseTimeFormat(t *testing.T) {
+	f := func(s string, tfExpected TimeFormat) {
+		t.Helper()
+		tf, err := ParseTimeFormat(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if tf != tfExpected {
+			t.Fatalf("unexpected time format for %q; got %d; want %d", s, tf, tfExpected)
+		}
+	}
+	f("rfc3339", TimeFormatRFC3339)
+	f("unix_s", TimeFormatUnixS)
+	f("unix_ms", TimeFormatUnixMs)
+	f("unix_ns", TimeFormatUnixNs)
+
+	if _, err := ParseTimeFormat("foobar"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func TestRowsUnmarshal(t *testing.T) {
+	const now = 123
+	f := func(s string, cfg *Config, rowsExpected []string) {
+		t.Helper()
+		var rs Rows
+		rs.Unmarshal([]byte(s), cfg, now)
+		var rows []string
+		for i := range rs.Rows {
+			rows = append(rows, rowString(&rs.Rows[i]))
+		}
+		if strings.Join(rows, "\n") != strings.Join(rowsExpected, "\n") {
+			t.Fatalf("unexpected rows;\ngot\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+
+		// Try unmarshaling again
+		rs.Reset()
+		rs.Unmarshal([]byte(s), cfg, now)
+		if len(rs.Rows) != len(rowsExpected) {
+			t.Fatalf("unexpected number of rows on the second unmarshal; got %d; want %d", len(rs.Rows), len(rowsExpected))
+		}
+	}
+
+	cfg := NewConfig([]string{"app", "kubernetes.pod"}, "msg", "ts", TimeFormatRFC3339)
+
+	f("", cfg, nil)
+	f("\n\r\n", cfg, nil)
+
+	// Invalid lines are skipped
+	f("foobar\n[]\n{\"msg\":\"without stream fields\"}\n{\"app\":\"\"}\n{\"app\":\"x\",\"ts\":\"foo\"}\n{\"app\":\"x\",\"ts\":1}", cfg, nil)
+
+	// Stream fields, message and timestamp
+	f(`{"ts":"2020-10-20T12:00:00.123456789Z","app":"nginx","kubernetes":{"pod":"p1"},"msg":"GET /"}
+{"app":"nginx","msg":"foo"}`, cfg, []string{
+		`{app="nginx",kubernetes_pod="p1"} 1603195200123456789 "GET /"`,
+		`{app="nginx"} 123 "foo"`,
+	})
+
+	// Remaining fields are rendered into the message
+	f(`{"app":"nginx","msg":"GET /","status":200,"took":0.5,"ok":true,"user":{"name":"John Doe","id":null},"tags":["a","b"],"empty":""}
+{"app":"nginx","status":500}`, cfg, []string{
+		`{app="nginx"} 123 "GET / status=200 took=0.5 ok=true user.name=\"John Doe\" tags=\"[\\\"a\\\",\\\"b\\\"]\" empty=\"\""`,
+		`{app="nginx"} 123 "status=500"`,
+	})
+
+	// Unix timestamps
+	f(`{"app":"x","ts":1603195200}
+{"app":"x","ts":"1603195200.5"}`, NewConfig([]string{"app"}, "msg", "ts", TimeFormatUnixS), []string{
+		`{app="x"} 1603195200000000000 ""`,
+		`{app="x"} 1603195200500000000 ""`,
+	})
+	f(`{"app":"x","ts":1603195200123}`, NewConfig([]string{"app"}, "msg", "ts", TimeFormatUnixMs), []string{
+		`{app="x"} 1603195200123000000 ""`,
+	})
+	f(`{"app":"x","ts":"1603195200123456789"}`, NewConfig([]string{"app"}, "msg", "ts", TimeFormatUnixNs), []string{
+		`{app="x"} 1603195200123456789 ""`,
+	})
+}
+
+func rowString(r *Row) string {
+	var labels []string
+	for _, label := range r.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+	}
+	return fmt.Sprintf("{%s} %d %q", strings.Join(labels, ","), r.Timestamp, r.Message)
+}
@@ -0,0 +1,74 @@
+package jsonline
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
+)
+
+func TestParseStream(t *testing.T) {
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	cfg := NewConfig([]string{"app"}, "message", "time", TimeFormatUnixNs)
+	f := func(s string, rowsExpected []string) {
+		t.Helper()
+		bb := bytes.NewBufferString(s)
+		var result []string
+		var lock sync.Mutex
+		doneCh := make(chan struct{})
+		err := ParseStream(bb, cfg, func(rows []Row) error {
+			lock.Lock()
+			// Convert rows to strings, since rows may contain garbage after returning from the callback to ParseStream.
+			for i := range rows {
+				result = append(result, rowString(&rows[i]))
+			}
+			if len(result) == len(rowsExpected) {
+				close(doneCh)
+			}
+			lock.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		select {
+		case <-doneCh:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+		sort.Strings(result)
+		if strings.Join(result, "\n") != strings.Join(rowsExpected, "\n") {
+			t.Fatalf("unexpected rows parsed; got\n%s\nwant\n%s", strings.Join(result, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+	}
+
+	f(`{"app":"foo","time":123,"message":"bar"}`, []string{
+		`{app="foo"} 123 "bar"`,
+	})
+	f(`{"app":"foo","time":2,"message":"bar","level":"info"}`+"\n"+`{"app":"baz","time":4}`, []string{
+		`{app="baz"} 4 ""`,
+		`{app="foo"} 2 "bar level=info"`,
+	})
+}
+
+func TestParseStreamCallbackError(t *testing.T) {
+	common.StartUnmarshalWorkers()
+	defer common.StopUnmarshalWorkers()
+
+	cfg := NewConfig([]string{"app"}, "message", "time", TimeFormatUnixNs)
+	errExpected := errors.New("callback error")
+	bb := bytes.NewBufferString(`{"app":"foo","time":123,"message":"bar"}`)
+	err := ParseStream(bb, cfg, func(rows []Row) error {
+		return errExpected
+	})
+	if !errors.Is(err, errExpected) {
+		t.Fatalf("unexpected error; got %v; want %v", err, errExpected)
+	}
+}
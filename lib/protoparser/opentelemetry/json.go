@@ -0,0 +1,233 @@
+package opentelemetry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/otlppb"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/valyala/fastjson"
+)
+
+// unmarshalJSONRequest unmarshals OTLP logs export request in JSON format from data into req.
+//
+// See https://github.com/open-telemetry/opentelemetry-proto/blob/main/docs/specification.md#json-protobuf-encoding
+//
+// Both lowerCamelCase and original snake_case field names are accepted.
+func unmarshalJSONRequest(p *fastjson.Parser, req *otlppb.ExportLogsServiceRequest, data []byte) error {
+	v, err := p.ParseBytes(data)
+	if err != nil {
+		return fmt.Errorf("cannot parse JSON: %w", err)
+	}
+	if v.Type() != fastjson.TypeObject {
+		return fmt.Errorf("the request must be JSON object; got %s", v.Type())
+	}
+	for i, rlv := range getArray(v, "resourceLogs", "resource_logs") {
+		req.ResourceLogs = append(req.ResourceLogs, otlppb.ResourceLogs{})
+		rl := &req.ResourceLogs[len(req.ResourceLogs)-1]
+		if err := unmarshalJSONResourceLogs(rl, rlv); err != nil {
+			return fmt.Errorf("cannot unmarshal resourceLogs #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func unmarshalJSONResourceLogs(rl *otlppb.ResourceLogs, v *fastjson.Value) error {
+	if rv := v.Get("resource"); rv != nil {
+		attrs, err := unmarshalJSONKeyValues(rl.Resource.Attributes[:0], getArray(rv, "attributes"))
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal resource attributes: %w", err)
+		}
+		rl.Resource.Attributes = attrs
+	}
+	slvs := getArray(v, "scopeLogs", "scope_logs")
+	if slvs == nil {
+		// Old exporters send deprecated instrumentationLibraryLogs instead of scopeLogs.
+		slvs = getArray(v, "instrumentationLibraryLogs", "instrumentation_library_logs")
+	}
+	for i, slv := range slvs {
+		rl.ScopeLogs = append(rl.ScopeLogs, otlppb.ScopeLogs{})
+		sl := &rl.ScopeLogs[len(rl.ScopeLogs)-1]
+		for j, lrv := range getArray(slv, "logRecords", "log_records") {
+			sl.LogRecords = append(sl.LogRecords, otlppb.LogRecord{})
+			lr := &sl.LogRecords[len(sl.LogRecords)-1]
+			if err := unmarshalJSONLogRecord(lr, lrv); err != nil {
+				return fmt.Errorf("cannot unmarshal logRecords #%d at scopeLogs #%d: %w", j, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func unmarshalJSONLogRecord(lr *otlppb.LogRecord, v *fastjson.Value) error {
+	var err error
+	if lr.TimeUnixNano, err = getUint64(v, "timeUnixNano", "time_unix_nano"); err != nil {
+		return err
+	}
+	if lr.ObservedTimeUnixNano, err = getUint64(v, "observedTimeUnixNano", "observed_time_unix_nano"); err != nil {
+		return err
+	}
+	if sv := getValue(v, "severityNumber", "severity_number"); sv != nil {
+		if sv.Type() == fastjson.TypeString {
+			// Enum values may be encoded as names.
+			name := string(sv.GetStringBytes())
+			n, ok := severityNumbers[name]
+			if !ok {
+				return fmt.Errorf("unknown severityNumber %q", name)
+			}
+			lr.SeverityNumber = n
+		} else {
+			n, err := sv.Int()
+			if err != nil {
+				return fmt.Errorf("cannot parse severityNumber: %w", err)
+			}
+			lr.SeverityNumber = int32(n)
+		}
+	}
+	lr.SeverityText = string(getValue(v, "severityText", "severity_text").GetStringBytes())
+	if bv := v.Get("body"); bv != nil {
+		if err := unmarshalJSONAnyValue(&lr.Body, bv); err != nil {
+			return fmt.Errorf("cannot unmarshal body: %w", err)
+		}
+	}
+	if lr.Attributes, err = unmarshalJSONKeyValues(lr.Attributes[:0], getArray(v, "attributes")); err != nil {
+		return fmt.Errorf("cannot unmarshal attributes: %w", err)
+	}
+	return nil
+}
+
+func unmarshalJSONKeyValues(dst []otlppb.KeyValue, a []*fastjson.Value) ([]otlppb.KeyValue, error) {
+	for _, kvv := range a {
+		dst = append(dst, otlppb.KeyValue{})
+		kv := &dst[len(dst)-1]
+		kv.Key = string(kvv.GetStringBytes("key"))
+		if vv := kvv.Get("value"); vv != nil {
+			if err := unmarshalJSONAnyValue(&kv.Value, vv); err != nil {
+				return dst, fmt.Errorf("cannot unmarshal value for key %q: %w", kv.Key, err)
+			}
+		}
+	}
+	return dst, nil
+}
+
+func unmarshalJSONAnyValue(av *otlppb.AnyValue, v *fastjson.Value) error {
+	o, err := v.Object()
+	if err != nil {
+		return err
+	}
+	o.Visit(func(k []byte, v *fastjson.Value) {
+		if err != nil {
+			return
+		}
+		switch string(k) {
+		case "stringValue", "string_value":
+			av.Type = otlppb.AnyValueTypeString
+			av.StringValue = string(v.GetStringBytes())
+		case "boolValue", "bool_value":
+			av.Type = otlppb.AnyValueTypeBool
+			av.BoolValue = v.GetBool()
+		case "intValue", "int_value":
+			// 64-bit integers are encoded as strings in JSON.
+			av.Type = otlppb.AnyValueTypeInt
+			if v.Type() == fastjson.TypeString {
+				av.IntValue, err = strconv.ParseInt(bytesutil.ToUnsafeString(v.GetStringBytes()), 10, 64)
+			} else {
+				av.IntValue, err = v.Int64()
+			}
+		case "doubleValue", "double_value":
+			av.Type = otlppb.AnyValueTypeDouble
+			av.DoubleValue, err = parseJSONDouble(v)
+		case "bytesValue", "bytes_value":
+			av.Type = otlppb.AnyValueTypeBytes
+			av.BytesValue, err = base64.StdEncoding.DecodeString(string(v.GetStringBytes()))
+		case "arrayValue", "array_value":
+			av.Type = otlppb.AnyValueTypeArray
+			av.ArrayValue = av.ArrayValue[:0]
+			for _, itemv := range getArray(v, "values") {
+				av.ArrayValue = append(av.ArrayValue, otlppb.AnyValue{})
+				if err = unmarshalJSONAnyValue(&av.ArrayValue[len(av.ArrayValue)-1], itemv); err != nil {
+					return
+				}
+			}
+		case "kvlistValue", "kvlist_value":
+			av.Type = otlppb.AnyValueTypeKeyValueList
+			av.KeyValueList, err = unmarshalJSONKeyValues(av.KeyValueList[:0], getArray(v, "values"))
+		}
+		if err != nil {
+			err = fmt.Errorf("cannot unmarshal %s: %w", k, err)
+		}
+	})
+	return err
+}
+
+func parseJSONDouble(v *fastjson.Value) (float64, error) {
+	if v.Type() != fastjson.TypeString {
+		return v.Float64()
+	}
+	// Special values are encoded as strings in JSON.
+	s := bytesutil.ToUnsafeString(v.GetStringBytes())
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	default:
+		return strconv.ParseFloat(s, 64)
+	}
+}
+
+// getValue returns the value for the first found key from keys in v.
+func getValue(v *fastjson.Value, keys ...string) *fastjson.Value {
+	for _, key := range keys {
+		if fv := v.Get(key); fv != nil {
+			return fv
+		}
+	}
+	return nil
+}
+
+// getArray returns array value for the first found key from keys in v.
+func getArray(v *fastjson.Value, keys ...string) []*fastjson.Value {
+	return getValue(v, keys...).GetArray()
+}
+
+// getUint64 returns uint64 value for the first found key from keys in v.
+//
+// 64-bit integers may be encoded either as strings or as numbers in JSON.
+func getUint64(v *fastjson.Value, keys ...string) (uint64, error) {
+	fv := getValue(v, keys...)
+	if fv == nil {
+		return 0, nil
+	}
+	var n uint64
+	var err error
+	if fv.Type() == fastjson.TypeString {
+		n, err = strconv.ParseUint(bytesutil.ToUnsafeString(fv.GetStringBytes()), 10, 64)
+	} else {
+		n, err = fv.Uint64()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s: %w", keys[0], err)
+	}
+	return n, nil
+}
+
+var severityNumbers = func() map[string]int32 {
+	m := map[string]int32{
+		"SEVERITY_NUMBER_UNSPECIFIED": 0,
+	}
+	for i, name := range severityNames {
+		for j := 0; j < 4; j++ {
+			suffix := ""
+			if j > 0 {
+				suffix = strconv.Itoa(j + 1)
+			}
+			m["SEVERITY_NUMBER_"+name+suffix] = int32(4*i + j + 1)
+		}
+	}
+	return m
+}()
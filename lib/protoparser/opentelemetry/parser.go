@@ -0,0 +1,217 @@
+package opentelemetry
+
+import (
+	"encoding/base64"
+	"math"
+	"strconv"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/otlppb"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/protoparserutil"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/storage"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+)
+
+// Rows contains log entries obtained from OTLP logs export request.
+type Rows struct {
+	Rows []Row
+
+	labelsPool []storage.Label
+	buf        []byte
+	valueBuf   []byte
+}
+
+// Reset resets rs.
+func (rs *Rows) Reset() {
+	// Reset items, so they can be GC'ed
+	for i := range rs.Rows {
+		rs.Rows[i].reset()
+	}
+	rs.Rows = rs.Rows[:0]
+
+	for i := range rs.labelsPool {
+		label := &rs.labelsPool[i]
+		label.Name = nil
+		label.Value = nil
+	}
+	rs.labelsPool = rs.labelsPool[:0]
+	rs.buf = rs.buf[:0]
+}
+
+// Row is a single log entry obtained from OTLP log record.
+type Row struct {
+	// Timestamp is unix timestamp in nanoseconds.
+	Timestamp int64
+
+	Labels  []storage.Label
+	Message []byte
+}
+
+func (r *Row) reset() {
+	r.Timestamp = 0
+	r.Labels = nil
+	r.Message = nil
+}
+
+var severityLabelName = []byte("severity")
+
+// addRequest appends log entries from req to rs.Rows.
+//
+// Resource attributes are converted into stream labels with sanitized names, while log record severity
+// is stored in `severity` label. The log record body becomes the message followed by log record attributes
+// in logfmt format.
+//
+// now is used as the timestamp for log records without timestamps.
+func (rs *Rows) addRequest(req *otlppb.ExportLogsServiceRequest, now int64) {
+	for i := range req.ResourceLogs {
+		rl := &req.ResourceLogs[i]
+		resourceLabelsStart := len(rs.labelsPool)
+		hasSeverityLabel := false
+		for j := range rl.Resource.Attributes {
+			kv := &rl.Resource.Attributes[j]
+			bufLen := len(rs.buf)
+			rs.buf = appendAnyValue(rs.buf, &kv.Value)
+			if len(rs.buf) == bufLen {
+				// Skip labels with empty values.
+				continue
+			}
+			value := rs.buf[bufLen:]
+			bufLen = len(rs.buf)
+			rs.buf = protoparserutil.AppendSanitizedLabelName(rs.buf, kv.Key)
+			name := rs.buf[bufLen:]
+			if string(name) == string(severityLabelName) {
+				hasSeverityLabel = true
+			}
+			rs.labelsPool = append(rs.labelsPool, storage.Label{
+				Name:  name,
+				Value: value,
+			})
+		}
+		resourceLabels := rs.labelsPool[resourceLabelsStart:]
+		for j := range rl.ScopeLogs {
+			sl := &rl.ScopeLogs[j]
+			for k := range sl.LogRecords {
+				rs.addLogRecord(&sl.LogRecords[k], resourceLabels, hasSeverityLabel, now)
+			}
+		}
+	}
+}
+
+func (rs *Rows) addLogRecord(lr *otlppb.LogRecord, resourceLabels []storage.Label, hasSeverityLabel bool, now int64) {
+	labels := resourceLabels
+	if severity := getSeverity(lr); severity != "" && !hasSeverityLabel {
+		labelsStart := len(rs.labelsPool)
+		rs.labelsPool = append(rs.labelsPool, resourceLabels...)
+		rs.labelsPool = append(rs.labelsPool, storage.Label{
+			Name:  severityLabelName,
+			Value: []byte(severity),
+		})
+		labels = rs.labelsPool[labelsStart:]
+	}
+
+	bufLen := len(rs.buf)
+	rs.buf = appendAnyValue(rs.buf, &lr.Body)
+	for i := range lr.Attributes {
+		kv := &lr.Attributes[i]
+		if len(rs.buf) > bufLen {
+			rs.buf = append(rs.buf, ' ')
+		}
+		rs.buf = protoparserutil.AppendLogfmtValue(rs.buf, kv.Key)
+		rs.buf = append(rs.buf, '=')
+		rs.valueBuf = appendAnyValue(rs.valueBuf[:0], &kv.Value)
+		rs.buf = protoparserutil.AppendLogfmtValue(rs.buf, bytesutil.ToUnsafeString(rs.valueBuf))
+	}
+	message := rs.buf[bufLen:]
+
+	timestamp := int64(lr.TimeUnixNano)
+	if timestamp == 0 {
+		timestamp = int64(lr.ObservedTimeUnixNano)
+	}
+	if timestamp == 0 {
+		timestamp = now
+	}
+	rs.Rows = append(rs.Rows, Row{
+		Timestamp: timestamp,
+		Labels:    labels[:len(labels):len(labels)],
+		Message:   message[:len(message):len(message)],
+	})
+}
+
+// getSeverity returns severity for lr.
+//
+// SeverityText is preferred over SeverityNumber.
+func getSeverity(lr *otlppb.LogRecord) string {
+	if lr.SeverityText != "" {
+		return lr.SeverityText
+	}
+	n := int(lr.SeverityNumber)
+	if n <= 0 || n > 4*len(severityNames) {
+		return ""
+	}
+	return severityNames[(n-1)/4]
+}
+
+// severityNames contains short names for severity number ranges.
+//
+// See https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/logs/data-model.md#field-severitynumber
+var severityNames = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+// appendAnyValue appends string representation of v to dst.
+//
+// Arrays and key-value lists are represented as JSON.
+func appendAnyValue(dst []byte, v *otlppb.AnyValue) []byte {
+	switch v.Type {
+	case otlppb.AnyValueTypeString:
+		return append(dst, v.StringValue...)
+	case otlppb.AnyValueTypeBool:
+		return strconv.AppendBool(dst, v.BoolValue)
+	case otlppb.AnyValueTypeInt:
+		return strconv.AppendInt(dst, v.IntValue, 10)
+	case otlppb.AnyValueTypeDouble:
+		return strconv.AppendFloat(dst, v.DoubleValue, 'g', -1, 64)
+	case otlppb.AnyValueTypeBytes:
+		return append(dst, base64.StdEncoding.EncodeToString(v.BytesValue)...)
+	case otlppb.AnyValueTypeArray, otlppb.AnyValueTypeKeyValueList:
+		return appendAnyValueJSON(dst, v)
+	default:
+		return dst
+	}
+}
+
+// appendAnyValueJSON appends JSON representation of v to dst.
+func appendAnyValueJSON(dst []byte, v *otlppb.AnyValue) []byte {
+	switch v.Type {
+	case otlppb.AnyValueTypeString, otlppb.AnyValueTypeBytes:
+		return protoparserutil.AppendJSONString(dst, string(appendAnyValue(nil, v)))
+	case otlppb.AnyValueTypeDouble:
+		if math.IsNaN(v.DoubleValue) || math.IsInf(v.DoubleValue, 0) {
+			// NaN and Inf cannot be represented as JSON numbers.
+			return protoparserutil.AppendJSONString(dst, string(appendAnyValue(nil, v)))
+		}
+		return appendAnyValue(dst, v)
+	case otlppb.AnyValueTypeArray:
+		dst = append(dst, '[')
+		for i := range v.ArrayValue {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendAnyValueJSON(dst, &v.ArrayValue[i])
+		}
+		return append(dst, ']')
+	case otlppb.AnyValueTypeKeyValueList:
+		dst = append(dst, '{')
+		for i := range v.KeyValueList {
+			kv := &v.KeyValueList[i]
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = protoparserutil.AppendJSONString(dst, kv.Key)
+			dst = append(dst, ':')
+			dst = appendAnyValueJSON(dst, &kv.Value)
+		}
+		return append(dst, '}')
+	case otlppb.AnyValueTypeEmpty:
+		return append(dst, "null"...)
+	default:
+		return appendAnyValue(dst, v)
+	}
+}
@@ -0,0 +1,107 @@
+package opentelemetry
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/otlppb"
+	"github.com/valyala/fastjson"
+)
+
+func TestRowsAddRequestJSONSuccess(t *testing.T) {
+	const now = 123
+	f := func(s string, rowsExpected []string) {
+		t.Helper()
+		var p fastjson.Parser
+		var req otlppb.ExportLogsServiceRequest
+		if err := unmarshalJSONRequest(&p, &req, []byte(s)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var rs Rows
+		rs.addRequest(&req, now)
+		var rows []string
+		for i := range rs.Rows {
+			rows = append(rows, rowString(&rs.Rows[i]))
+		}
+		if strings.Join(rows, "\n") != strings.Join(rowsExpected, "\n") {
+			t.Fatalf("unexpected rows;\ngot\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(rowsExpected, "\n"))
+		}
+	}
+
+	f(`{}`, nil)
+	f(`{"resourceLogs":[]}`, nil)
+
+	// Resource attributes, body and severity
+	f(`{"resourceLogs":[{
+		"resource":{"attributes":[
+			{"key":"service.name","value":{"stringValue":"api"}},
+			{"key":"host.id","value":{"intValue":"42"}},
+			{"key":"empty","value":{"stringValue":""}}
+		]},
+		"scopeLogs":[{"logRecords":[
+			{"timeUnixNano":"1603195200123456789","severityNumber":9,"body":{"stringValue":"foo"}},
+			{"observedTimeUnixNano":1603195201000000000,"severityText":"warning","body":{"stringValue":"bar"}},
+			{"severityNumber":"SEVERITY_NUMBER_ERROR3"},
+			{"body":{"kvlistValue":{"values":[{"key":"a","value":{"arrayValue":{"values":[{"intValue":1},{"doubleValue":"NaN"},{"stringValue":"x\"y"},{}]}}}]}}}
+		]}]
+	}]}`, []string{
+		`{service_name="api",host_id="42",severity="INFO"} 1603195200123456789 "foo"`,
+		`{service_name="api",host_id="42",severity="warning"} 1603195201000000000 "bar"`,
+		`{service_name="api",host_id="42",severity="ERROR"} 123 ""`,
+		`{service_name="api",host_id="42"} 123 "{\"a\":[1,\"NaN\",\"x\\\"y\",null]}"`,
+	})
+
+	// Log record attributes
+	f(`{"resource_logs":[{
+		"resource":{"attributes":[{"key":"job","value":{"stringValue":"app"}}]},
+		"instrumentationLibraryLogs":[{"logRecords":[
+			{"body":{"stringValue":"GET /"},"attributes":[
+				{"key":"status","value":{"intValue":200}},
+				{"key":"ok","value":{"boolValue":true}},
+				{"key":"took","value":{"doubleValue":0.5}},
+				{"key":"user agent","value":{"stringValue":"curl 7.1"}},
+				{"key":"id","value":{"bytesValue":"AQI="}},
+				{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"}]}}}
+			]},
+			{"attributes":[{"key":"foo","value":{"stringValue":"bar"}}]}
+		]}]
+	}]}`, []string{
+		`{job="app"} 123 "GET / status=200 ok=true took=0.5 \"user agent\"=\"curl 7.1\" id=\"AQI=\" tags=\"[\\\"a\\\"]\""`,
+		`{job="app"} 123 "foo=bar"`,
+	})
+
+	// Resource severity attribute takes precedence
+	f(`{"resourceLogs":[{
+		"resource":{"attributes":[{"key":"severity","value":{"stringValue":"high"}}]},
+		"scopeLogs":[{"logRecords":[{"severityText":"INFO","body":{"stringValue":"foo"}}]}]
+	}]}`, []string{
+		`{severity="high"} 123 "foo"`,
+	})
+}
+
+func TestUnmarshalJSONRequestFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		var p fastjson.Parser
+		var req otlppb.ExportLogsServiceRequest
+		if err := unmarshalJSONRequest(&p, &req, []byte(s)); err == nil {
+			t.Fatalf("expecting non-nil error for %s", s)
+		}
+	}
+	f(``)
+	f(`[]`)
+	f(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"foo"}]}]}]}`)
+	f(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"severityNumber":"foo"}]}]}]}`)
+	f(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":"foo"}]}]}]}`)
+	f(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"intValue":"foo"}}]}]}]}`)
+	f(`{"resourceLogs":[{"resource":{"attributes":[{"key":"foo","value":{"bytesValue":"%"}}]}}]}`)
+}
+
+func rowString(r *Row) string {
+	var labels []string
+	for _, label := range r.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.Name, label.Value))
+	}
+	return fmt.Sprintf("{%s} %d %q", strings.Join(labels, ","), r.Timestamp, r.Message)
+}
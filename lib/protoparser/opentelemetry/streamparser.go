@@ -0,0 +1,122 @@
+package opentelemetry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/otlppb"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/contentencoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/valyala/fastjson"
+)
+
+var maxRequestSize = flagutil.NewBytes("opentelemetry.maxRequestSize", 64*1024*1024, "The maximum size in bytes of a single uncompressed OpenTelemetry logs request "+
+	"at /insert/<tenant>/opentelemetry/v1/logs")
+
+// ParseStream parses OTLP logs export request req and calls callback for the parsed rows.
+//
+// See https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/protocol/otlp.md#otlphttp
+//
+// The request body is parsed as JSON if it has `Content-Type: application/json` header.
+// Otherwise it is parsed as protobuf.
+// The request body is additionally decompressed according to `Content-Encoding` header.
+//
+// callback shouldn't hold rows after returning.
+func ParseStream(req *http.Request, callback func(rows []Row) error) error {
+	zr, err := contentencoding.GetReader(req.Body, req.Header.Get("Content-Encoding"), int64(maxRequestSize.N))
+	if err != nil {
+		return err
+	}
+	defer contentencoding.PutReader(zr)
+
+	ctx := getStreamContext()
+	defer putStreamContext(ctx)
+	if err := ctx.Read(zr); err != nil {
+		return err
+	}
+	if err := ctx.unmarshal(IsJSONRequest(req)); err != nil {
+		unmarshalErrors.Inc()
+		return err
+	}
+	ctx.rows.addRequest(&ctx.req, time.Now().UnixNano())
+	rowsRead.Add(len(ctx.rows.Rows))
+	return callback(ctx.rows.Rows)
+}
+
+// IsJSONRequest returns true if req contains OTLP request in JSON format.
+func IsJSONRequest(req *http.Request) bool {
+	contentType := req.Header.Get("Content-Type")
+	if n := strings.IndexByte(contentType, ';'); n >= 0 {
+		contentType = contentType[:n]
+	}
+	return strings.TrimSpace(contentType) == "application/json"
+}
+
+type streamContext struct {
+	reqBuf bytesutil.ByteBuffer
+	p      fastjson.Parser
+	req    otlppb.ExportLogsServiceRequest
+	rows   Rows
+}
+
+func (ctx *streamContext) reset() {
+	ctx.reqBuf.Reset()
+	ctx.req.Reset()
+	ctx.rows.Reset()
+}
+
+func (ctx *streamContext) Read(r io.Reader) error {
+	readCalls.Inc()
+	lr := io.LimitReader(r, int64(maxRequestSize.N)+1)
+	reqLen, err := ctx.reqBuf.ReadFrom(lr)
+	if err != nil {
+		readErrors.Inc()
+		return fmt.Errorf("cannot read request: %w", err)
+	}
+	if reqLen > int64(maxRequestSize.N) {
+		readErrors.Inc()
+		return fmt.Errorf("too big request; mustn't exceed -opentelemetry.maxRequestSize=%d bytes", maxRequestSize.N)
+	}
+	return nil
+}
+
+func (ctx *streamContext) unmarshal(isJSON bool) error {
+	if isJSON {
+		if err := unmarshalJSONRequest(&ctx.p, &ctx.req, ctx.reqBuf.B); err != nil {
+			return fmt.Errorf("cannot unmarshal JSON request with size %d bytes: %w", len(ctx.reqBuf.B), err)
+		}
+		return nil
+	}
+	if err := ctx.req.Unmarshal(ctx.reqBuf.B); err != nil {
+		return fmt.Errorf("cannot unmarshal protobuf request with size %d bytes: %w", len(ctx.reqBuf.B), err)
+	}
+	return nil
+}
+
+var (
+	readCalls       = metrics.NewCounter(`vm_protoparser_read_calls_total{type="opentelemetry"}`)
+	readErrors      = metrics.NewCounter(`vm_protoparser_read_errors_total{type="opentelemetry"}`)
+	rowsRead        = metrics.NewCounter(`vm_protoparser_rows_read_total{type="opentelemetry"}`)
+	unmarshalErrors = metrics.NewCounter(`vm_protoparser_unmarshal_errors_total{type="opentelemetry"}`)
+)
+
+func getStreamContext() *streamContext {
+	v := streamContextPool.Get()
+	if v == nil {
+		return &streamContext{}
+	}
+	return v.(*streamContext)
+}
+
+func putStreamContext(ctx *streamContext) {
+	ctx.reset()
+	streamContextPool.Put(ctx)
+}
+
+var streamContextPool sync.Pool
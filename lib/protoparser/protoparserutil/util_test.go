@@ -0,0 +1,54 @@
+package protoparserutil
+
+import (
+	"testing"
+)
+
+func TestAppendSanitizedLabelName(t *testing.T) {
+	f := func(name, resultExpected string) {
+		t.Helper()
+		result := AppendSanitizedLabelName(nil, name)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for %q; got %q; want %q", name, result, resultExpected)
+		}
+	}
+	f("", "")
+	f("job", "job")
+	f("Foo_bar9", "Foo_bar9")
+	f("service.name", "service_name")
+	f("k8s.pod.name", "k8s_pod_name")
+	f("@source-ip", "_source_ip")
+	f("1foo-bar", "_foo_bar")
+}
+
+func TestAppendLogfmtValue(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+		result := AppendLogfmtValue(nil, s)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for %q; got %s; want %s", s, result, resultExpected)
+		}
+	}
+	f("", `""`)
+	f("foo", `foo`)
+	f("foo bar", `"foo bar"`)
+	f("a=b", `"a=b"`)
+	f(`"foo"`, `"\"foo\""`)
+	f("foo\nbar", `"foo\nbar"`)
+}
+
+func TestAppendJSONString(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+		result := AppendJSONString(nil, s)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for %q; got %s; want %s", s, result, resultExpected)
+		}
+	}
+	f("", `""`)
+	f("foo", `"foo"`)
+	f(`a"b\c`, `"a\"b\\c"`)
+	f("foo\nbar\r\tbaz", `"foo\nbar\r\tbaz"`)
+	f("\x01\x1f", `"\u0001\u001f"`)
+	f("привет", `"привет"`)
+}
@@ -0,0 +1,163 @@
+package remotewrite
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/lokipb"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/valyala/fastjson"
+)
+
+// jsonUnmarshaler unmarshals Loki push requests in JSON format:
+//
+//	{"streams":[{"stream":{"label":"value"},"values":[["<unix epoch in nanoseconds>","log line"]]}]}
+//
+// Strings in the unmarshaled lokipb.WriteRequest refer to jsonUnmarshaler buffers,
+// so they are valid until the next unmarshal call.
+type jsonUnmarshaler struct {
+	p fastjson.Parser
+
+	labels    []jsonLabel
+	labelsBuf []byte
+}
+
+type jsonLabel struct {
+	name  []byte
+	value []byte
+}
+
+func (ju *jsonUnmarshaler) reset() {
+	ju.labels = ju.labels[:0]
+	ju.labelsBuf = ju.labelsBuf[:0]
+}
+
+// Unmarshal unmarshals JSON push request from data into wr.
+//
+// Stream labels are converted into `{name="value",...}` string sorted by label names.
+func (ju *jsonUnmarshaler) Unmarshal(wr *lokipb.WriteRequest, data []byte) error {
+	ju.reset()
+	v, err := ju.p.ParseBytes(data)
+	if err != nil {
+		return fmt.Errorf("cannot parse JSON: %w", err)
+	}
+	streams, err := getArray(v, "streams")
+	if err != nil {
+		return err
+	}
+	for i, sv := range streams {
+		wr.Streams = append(wr.Streams, lokipb.Stream{})
+		s := &wr.Streams[len(wr.Streams)-1]
+		if err := ju.unmarshalStream(s, sv); err != nil {
+			return fmt.Errorf("cannot unmarshal stream #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (ju *jsonUnmarshaler) unmarshalStream(s *lokipb.Stream, v *fastjson.Value) error {
+	o := v.GetObject("stream")
+	if o == nil {
+		return fmt.Errorf("missing `stream` object")
+	}
+	ju.labels = ju.labels[:0]
+	var err error
+	o.Visit(func(k []byte, v *fastjson.Value) {
+		if err != nil {
+			return
+		}
+		if !isValidLabelName(k) {
+			err = fmt.Errorf("invalid label name %q; it must match `[a-zA-Z_][a-zA-Z0-9_]*`", k)
+			return
+		}
+		value, errLocal := v.StringBytes()
+		if errLocal != nil {
+			err = fmt.Errorf("cannot unmarshal value for label %q: %w", k, errLocal)
+			return
+		}
+		ju.labels = append(ju.labels, jsonLabel{
+			name:  k,
+			value: value,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(ju.labels, func(i, j int) bool {
+		return string(ju.labels[i].name) < string(ju.labels[j].name)
+	})
+	labelsBufLen := len(ju.labelsBuf)
+	ju.labelsBuf = append(ju.labelsBuf, '{')
+	for i, label := range ju.labels {
+		if i > 0 {
+			ju.labelsBuf = append(ju.labelsBuf, ',')
+		}
+		ju.labelsBuf = append(ju.labelsBuf, label.name...)
+		ju.labelsBuf = append(ju.labelsBuf, '=')
+		ju.labelsBuf = strconv.AppendQuote(ju.labelsBuf, bytesutil.ToUnsafeString(label.value))
+	}
+	ju.labelsBuf = append(ju.labelsBuf, '}')
+	// Copy labels string, since ju.labelsBuf may be re-allocated when appending labels for the next stream.
+	s.Labels = string(ju.labelsBuf[labelsBufLen:])
+
+	values, err := getArray(v, "values")
+	if err != nil {
+		return err
+	}
+	for i, ev := range values {
+		a, err := ev.Array()
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal value #%d: %w", i, err)
+		}
+		if len(a) != 2 {
+			return fmt.Errorf("value #%d must contain 2 items: timestamp and log line; got %d items", i, len(a))
+		}
+		tsStr, err := a[0].StringBytes()
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal timestamp for value #%d: %w", i, err)
+		}
+		ns, err := strconv.ParseInt(bytesutil.ToUnsafeString(tsStr), 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot parse timestamp for value #%d: %w", i, err)
+		}
+		line, err := a[1].StringBytes()
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal log line for value #%d: %w", i, err)
+		}
+		s.Entries = append(s.Entries, lokipb.Entry{
+			Timestamp: time.Unix(0, ns),
+			Line:      bytesutil.ToUnsafeString(line),
+		})
+	}
+	return nil
+}
+
+// getArray returns an array for the given key in v.
+//
+// v.GetArray cannot be used here, since it returns nil for both missing and empty arrays.
+func getArray(v *fastjson.Value, key string) ([]*fastjson.Value, error) {
+	av := v.Get(key)
+	if av == nil || av.Type() != fastjson.TypeArray {
+		return nil, fmt.Errorf("missing `%s` array", key)
+	}
+	a, _ := av.Array()
+	return a, nil
+}
+
+// isValidLabelName returns true if s matches `[a-zA-Z_][a-zA-Z0-9_]*`.
+//
+// Label names are put into stream labels without quoting, so other chars could inject additional labels.
+func isValidLabelName(s []byte) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
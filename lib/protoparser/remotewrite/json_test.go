@@ -0,0 +1,93 @@
+package remotewrite
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/lokipb"
+)
+
+func TestJSONUnmarshalerSuccess(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+		var ju jsonUnmarshaler
+		var wr lokipb.WriteRequest
+		if err := ju.Unmarshal(&wr, []byte(s)); err != nil {
+			t.Fatalf("unexpected error when unmarshaling %q: %s", s, err)
+		}
+		var result string
+		for _, st := range wr.Streams {
+			result += st.Labels
+			for _, e := range st.Entries {
+				result += fmt.Sprintf(" %d %q", e.Timestamp.UnixNano(), e.Line)
+			}
+			result += "\n"
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(`{"streams":[]}`, ``)
+	f(`{"streams":[{"stream":{"job":"foo","app":"bar"},"values":[["1604000000123456789","line 1"],["1604000000123456790","line 2"]]}]}`,
+		`{app="bar",job="foo"} 1604000000123456789 "line 1" 1604000000123456790 "line 2"`+"\n")
+
+	// Multiple streams
+	f(`{"streams":[{"stream":{"job":"foo"},"values":[["1","a"]]},{"stream":{},"values":[["2","b"]]}]}`,
+		`{job="foo"} 1 "a"`+"\n"+`{} 2 "b"`+"\n")
+
+	// Label values with special chars
+	f(`{"streams":[{"stream":{"job":"a\"b\\c"},"values":[]}]}`, `{job="a\"b\\c"}`+"\n")
+
+	// Label names with underscores and digits
+	f(`{"streams":[{"stream":{"_a1":"x","B_2":"y"},"values":[]}]}`, `{B_2="y",_a1="x"}`+"\n")
+}
+
+func TestJSONUnmarshalerFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		var ju jsonUnmarshaler
+		var wr lokipb.WriteRequest
+		if err := ju.Unmarshal(&wr, []byte(s)); err == nil {
+			t.Fatalf("expecting non-nil error when unmarshaling %q", s)
+		}
+	}
+
+	f(``)
+	f(`{}`)
+	f(`{"streams":{}}`)
+	f(`{"streams":[{"values":[]}]}`)
+	f(`{"streams":[{"stream":{}}]}`)
+	f(`{"streams":[{"stream":{"job":1},"values":[]}]}`)
+	f(`{"streams":[{"stream":{},"values":[["1"]]}]}`)
+	f(`{"streams":[{"stream":{},"values":[[1,"a"]]}]}`)
+	f(`{"streams":[{"stream":{},"values":[["foo","a"]]}]}`)
+	f(`{"streams":[{"stream":{},"values":[["1",2]]}]}`)
+	f(`{"streams":[{"stream":{},"values":{}}]}`)
+
+	// Invalid label names
+	f(`{"streams":[{"stream":{"":"a"},"values":[]}]}`)
+	f(`{"streams":[{"stream":{"1a":"a"},"values":[]}]}`)
+	f(`{"streams":[{"stream":{"a-b":"a"},"values":[]}]}`)
+	f(`{"streams":[{"stream":{"x=\"y\",job":"a"},"values":[]}]}`)
+}
+
+func TestIsJSONRequest(t *testing.T) {
+	f := func(contentType string, resultExpected bool) {
+		t.Helper()
+		req := &http.Request{
+			Header: http.Header{},
+		}
+		req.Header.Set("Content-Type", contentType)
+		result := isJSONRequest(req)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for Content-Type %q; got %v; want %v", contentType, result, resultExpected)
+		}
+	}
+
+	f("", false)
+	f("application/x-protobuf", false)
+	f("application/json", true)
+	f("application/json; charset=utf-8", true)
+}
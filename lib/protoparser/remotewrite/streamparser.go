@@ -6,33 +6,48 @@ import (
 	"io"
 	"net/http"
 	"runtime"
+	"strings"
 	"sync"
 
 	"github.com/VictoriaMetrics/VictoriaLogs/lib/lokipb"
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/protoparser/contentencoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
-	"github.com/VictoriaMetrics/VictoriaMetrics/lib/protoparser/common"
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/golang/snappy"
 )
 
 var maxInsertRequestSize = flagutil.NewBytes("maxInsertRequestSize", 32*1024*1024, "The maximum size in bytes of a single Prometheus remote_write API request")
 
-// ParseStream parses Prometheus remote_write message req and calls callback for the parsed timeseries.
+// ParseStream parses Loki push request req and calls callback for the parsed timeseries.
+//
+// The request body is parsed as JSON if it has `Content-Type: application/json` header.
+// Otherwise it is parsed as snappy-compressed protobuf.
+//
+// The request body is additionally decompressed according to `Content-Encoding` header.
+// The decompressed body size is limited by -maxInsertRequestSize.
+//
+// callback is called synchronously, so its error is returned from ParseStream.
+// This allows reporting rejected streams to the client.
 //
 // callback shouldn't hold tss after returning.
 func ParseStream(req *http.Request, callback func(tss []lokipb.Stream) error) error {
-	ctx := getPushCtx(req.Body)
+	zr, err := contentencoding.GetReader(req.Body, req.Header.Get("Content-Encoding"), int64(maxInsertRequestSize.N))
+	if err != nil {
+		return err
+	}
+	defer contentencoding.PutReader(zr)
+	ctx := getPushCtx(zr)
 	defer putPushCtx(ctx)
 	if err := ctx.Read(); err != nil {
 		return err
 	}
 	uw := getUnmarshalWork()
+	defer putUnmarshalWork(uw)
 	uw.callback = callback
+	uw.isJSON = isJSONRequest(req)
 	uw.reqBuf, ctx.reqBuf.B = ctx.reqBuf.B, uw.reqBuf
-	common.ScheduleUnmarshalWork(uw)
-	return nil
+	return uw.unmarshal()
 }
 
 type pushCtx struct {
@@ -60,6 +75,14 @@ func (ctx *pushCtx) Read() error {
 	return nil
 }
 
+func isJSONRequest(req *http.Request) bool {
+	contentType := req.Header.Get("Content-Type")
+	if n := strings.IndexByte(contentType, ';'); n >= 0 {
+		contentType = contentType[:n]
+	}
+	return strings.TrimSpace(contentType) == "application/json"
+}
+
 var (
 	readCalls       = metrics.NewCounter(`vm_protoparser_read_calls_total{type="promremotewrite"}`)
 	readErrors      = metrics.NewCounter(`vm_protoparser_read_errors_total{type="promremotewrite"}`)
@@ -98,49 +121,54 @@ var pushCtxPoolCh = make(chan *pushCtx, runtime.GOMAXPROCS(-1))
 
 type unmarshalWork struct {
 	wr       lokipb.WriteRequest
+	ju       jsonUnmarshaler
 	callback func(tss []lokipb.Stream) error
 	reqBuf   []byte
+	isJSON   bool
 }
 
 func (uw *unmarshalWork) reset() {
 	uw.wr.Reset()
+	uw.ju.reset()
 	uw.callback = nil
 	uw.reqBuf = uw.reqBuf[:0]
+	uw.isJSON = false
 }
 
-// Unmarshal implements common.UnmarshalWork
-func (uw *unmarshalWork) Unmarshal() {
+func (uw *unmarshalWork) unmarshal() error {
+	if uw.isJSON {
+		if err := uw.ju.Unmarshal(&uw.wr, uw.reqBuf); err != nil {
+			unmarshalErrors.Inc()
+			return fmt.Errorf("cannot unmarshal JSON push request with size %d bytes: %w", len(uw.reqBuf), err)
+		}
+		return uw.processStreams()
+	}
+
 	bb := bodyBufferPool.Get()
 	defer bodyBufferPool.Put(bb)
 	var err error
 	bb.B, err = snappy.Decode(bb.B[:cap(bb.B)], uw.reqBuf)
 	if err != nil {
-		logger.Errorf("cannot decompress request with length %d: %s", len(uw.reqBuf), err)
-		return
+		return fmt.Errorf("cannot decompress request with length %d: %w", len(uw.reqBuf), err)
 	}
 	if len(bb.B) > maxInsertRequestSize.N {
-		logger.Errorf("too big unpacked request; mustn't exceed `-maxInsertRequestSize=%d` bytes; got %d bytes", maxInsertRequestSize.N, len(bb.B))
-		return
+		return fmt.Errorf("too big unpacked request; mustn't exceed `-maxInsertRequestSize=%d` bytes; got %d bytes", maxInsertRequestSize.N, len(bb.B))
 	}
 	if err := uw.wr.Unmarshal(bb.B); err != nil {
 		unmarshalErrors.Inc()
-		logger.Errorf("cannot unmarshal prompb.WriteRequest with size %d bytes: %s", len(bb.B), err)
-		return
+		return fmt.Errorf("cannot unmarshal prompb.WriteRequest with size %d bytes: %w", len(bb.B), err)
 	}
+	return uw.processStreams()
+}
 
+func (uw *unmarshalWork) processStreams() error {
 	rows := 0
 	tss := uw.wr.Streams
 	for i := range tss {
 		rows += len(tss[i].Entries)
 	}
 	rowsRead.Add(rows)
-
-	if err := uw.callback(tss); err != nil {
-		logger.Errorf("error when processing imported data: %s", err)
-		putUnmarshalWork(uw)
-		return
-	}
-	putUnmarshalWork(uw)
+	return uw.callback(tss)
 }
 
 var bodyBufferPool bytesutil.ByteBufferPool
@@ -0,0 +1,312 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// Rows contains parsed syslog messages.
+type Rows struct {
+	Rows []Row
+}
+
+// Reset resets rs.
+func (rs *Rows) Reset() {
+	// Reset items, so they can be GC'ed
+	for i := range rs.Rows {
+		rs.Rows[i].reset()
+	}
+	rs.Rows = rs.Rows[:0]
+}
+
+// Unmarshal unmarshals a single syslog message from s and appends it to rs.Rows.
+//
+// Messages without timestamps get the timestamp from now.
+// Invalid messages are logged and skipped.
+//
+// s shouldn't be modified while rs is in use.
+func (rs *Rows) Unmarshal(s []byte, now time.Time) {
+	if cap(rs.Rows) > len(rs.Rows) {
+		rs.Rows = rs.Rows[:len(rs.Rows)+1]
+	} else {
+		rs.Rows = append(rs.Rows, Row{})
+	}
+	r := &rs.Rows[len(rs.Rows)-1]
+	if err := r.unmarshal(s, now); err != nil {
+		rs.Rows = rs.Rows[:len(rs.Rows)-1]
+		logger.Errorf("cannot unmarshal syslog message %q: %s", s, err)
+		invalidLines.Inc()
+	}
+}
+
+var invalidLines = metrics.NewCounter(`vm_rows_invalid_total{type="syslog"}`)
+
+// Row is a single syslog message.
+//
+// Both RFC 5424 and RFC 3164 messages are supported.
+type Row struct {
+	// Timestamp is unix timestamp in nanoseconds.
+	Timestamp int64
+
+	Facility int
+	Severity int
+
+	Hostname []byte
+	AppName  []byte
+	ProcID   []byte
+	MsgID    []byte
+	Message  []byte
+}
+
+func (r *Row) reset() {
+	r.Timestamp = 0
+	r.Facility = 0
+	r.Severity = 0
+	r.Hostname = nil
+	r.AppName = nil
+	r.ProcID = nil
+	r.MsgID = nil
+	r.Message = nil
+}
+
+// FacilityName returns the keyword for r.Facility according to RFC 5424.
+func (r *Row) FacilityName() string {
+	if r.Facility < 0 || r.Facility >= len(facilityNames) {
+		return "unknown"
+	}
+	return facilityNames[r.Facility]
+}
+
+// SeverityName returns the keyword for r.Severity according to RFC 5424.
+func (r *Row) SeverityName() string {
+	if r.Severity < 0 || r.Severity >= len(severityNames) {
+		return "unknown"
+	}
+	return severityNames[r.Severity]
+}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+func (r *Row) unmarshal(s []byte, now time.Time) error {
+	r.reset()
+	s = bytes.TrimRight(s, "\r\n\x00")
+	if len(s) == 0 || s[0] != '<' {
+		return fmt.Errorf("missing PRI part")
+	}
+	n := bytes.IndexByte(s, '>')
+	if n < 2 || n > 4 {
+		return fmt.Errorf("invalid PRI part")
+	}
+	pri := 0
+	for _, c := range s[1:n] {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("PRI must contain only digits; got %q", s[1:n])
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	if pri > 191 {
+		return fmt.Errorf("PRI cannot exceed 191; got %d", pri)
+	}
+	r.Facility = pri / 8
+	r.Severity = pri % 8
+	s = s[n+1:]
+
+	var err error
+	if len(s) >= 2 && s[0] >= '1' && s[0] <= '9' && (s[1] == ' ' || s[1] >= '0' && s[1] <= '9' && len(s) >= 3 && s[2] == ' ') {
+		err = r.unmarshalRFC5424(s)
+	} else {
+		err = r.unmarshalRFC3164(s, now)
+	}
+	if err != nil {
+		return err
+	}
+	if r.Timestamp == 0 {
+		r.Timestamp = now.UnixNano()
+	}
+	return nil
+}
+
+// unmarshalRFC5424 unmarshals the message after PRI according to https://tools.ietf.org/html/rfc5424 .
+func (r *Row) unmarshalRFC5424(s []byte) error {
+	// Skip VERSION.
+	n := bytes.IndexByte(s, ' ')
+	s = s[n+1:]
+
+	var timestamp []byte
+	timestamp, s = nextField(s)
+	if len(timestamp) > 0 {
+		t, err := time.Parse(time.RFC3339Nano, bytesutil.ToUnsafeString(timestamp))
+		if err != nil {
+			return fmt.Errorf("cannot parse TIMESTAMP %q: %w", timestamp, err)
+		}
+		r.Timestamp = t.UnixNano()
+	}
+	r.Hostname, s = nextField(s)
+	r.AppName, s = nextField(s)
+	r.ProcID, s = nextField(s)
+	r.MsgID, s = nextField(s)
+
+	// Skip STRUCTURED-DATA.
+	if len(s) == 0 {
+		return fmt.Errorf("missing STRUCTURED-DATA")
+	}
+	if s[0] == '-' {
+		s = s[1:]
+	} else {
+		for len(s) > 0 && s[0] == '[' {
+			n := findStructuredDataEnd(s)
+			if n < 0 {
+				return fmt.Errorf("missing closing bracket for STRUCTURED-DATA %q", s)
+			}
+			s = s[n+1:]
+		}
+	}
+	if len(s) == 0 {
+		// The message is empty.
+		return nil
+	}
+	if s[0] != ' ' {
+		return fmt.Errorf("missing space after STRUCTURED-DATA")
+	}
+	s = s[1:]
+	r.Message = bytes.TrimPrefix(s, utf8BOM)
+	return nil
+}
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// nextField returns the next space-delimited field from s and the remaining tail.
+//
+// Empty field is returned for NILVALUE.
+func nextField(s []byte) ([]byte, []byte) {
+	var field []byte
+	n := bytes.IndexByte(s, ' ')
+	if n < 0 {
+		field = s
+		s = s[len(s):]
+	} else {
+		field = s[:n]
+		s = s[n+1:]
+	}
+	if len(field) == 1 && field[0] == '-' {
+		field = nil
+	}
+	return field, s
+}
+
+// findStructuredDataEnd returns the index of the closing bracket for SD-ELEMENT at the start of s.
+//
+// It returns -1 if the closing bracket is missing.
+func findStructuredDataEnd(s []byte) int {
+	inQuotes := false
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inQuotes {
+				// Skip the escaped char.
+				i++
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case ']':
+			if !inQuotes {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// unmarshalRFC3164 unmarshals the message after PRI according to https://tools.ietf.org/html/rfc3164 .
+//
+// The parser is lenient, since many senders deviate from RFC 3164.
+func (r *Row) unmarshalRFC3164(s []byte, now time.Time) error {
+	s = r.unmarshalRFC3164Timestamp(s, now)
+	if r.Timestamp != 0 {
+		// HOSTNAME follows TIMESTAMP. It may be missing if the TAG follows TIMESTAMP.
+		n := bytes.IndexByte(s, ' ')
+		if n > 0 && !isTag(s[:n]) {
+			r.Hostname = s[:n]
+			s = s[n+1:]
+		}
+	}
+	n := bytes.IndexByte(s, ' ')
+	if n < 0 {
+		n = len(s)
+	}
+	if tag := s[:n]; isTag(tag) {
+		tag = bytes.TrimSuffix(tag, []byte(":"))
+		if n := bytes.IndexByte(tag, '['); n >= 0 {
+			r.ProcID = bytes.TrimSuffix(tag[n+1:], []byte("]"))
+			tag = tag[:n]
+		}
+		r.AppName = tag
+		s = s[n:]
+		if len(s) > 0 {
+			s = s[1:]
+		}
+	}
+	r.Message = s
+	return nil
+}
+
+// isTag returns true if s looks like TAG with optional PID, e.g. `sshd[123]:` or `cron:`.
+func isTag(s []byte) bool {
+	if len(s) < 2 {
+		return false
+	}
+	if s[len(s)-1] == ':' {
+		return true
+	}
+	return s[len(s)-1] == ']' && bytes.IndexByte(s, '[') > 0
+}
+
+func (r *Row) unmarshalRFC3164Timestamp(s []byte, now time.Time) []byte {
+	if len(s) > 0 && s[0] >= '0' && s[0] <= '9' {
+		// Many senders put RFC 3339 timestamp instead of RFC 3164 timestamp.
+		n := bytes.IndexByte(s, ' ')
+		if n < 0 {
+			return s
+		}
+		t, err := time.Parse(time.RFC3339Nano, bytesutil.ToUnsafeString(s[:n]))
+		if err != nil {
+			return s
+		}
+		r.Timestamp = t.UnixNano()
+		return s[n+1:]
+	}
+
+	// RFC 3164 timestamp has `Mmm dd hh:mm:ss` format without year and timezone.
+	const layout = "Jan _2 15:04:05"
+	if len(s) < len(layout) {
+		return s
+	}
+	t, err := time.ParseInLocation(layout, bytesutil.ToUnsafeString(s[:len(layout)]), now.Location())
+	if err != nil {
+		return s
+	}
+	t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+	if t.Sub(now) > 24*time.Hour {
+		// The message has been sent at the end of the previous year.
+		t = t.AddDate(-1, 0, 0)
+	}
+	r.Timestamp = t.UnixNano()
+	s = s[len(layout):]
+	if len(s) > 0 && s[0] == ' ' {
+		s = s[1:]
+	}
+	return s
+}
@@ -0,0 +1,159 @@
+package syslog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRowsUnmarshalSuccess(t *testing.T) {
+	now := time.Date(2020, 10, 20, 12, 0, 0, 0, time.UTC)
+	f := func(s string, rowExpected *Row) {
+		t.Helper()
+		var rs Rows
+		rs.Unmarshal([]byte(s), now)
+		if len(rs.Rows) != 1 {
+			t.Fatalf("unexpected number of rows parsed from %q; got %d; want 1", s, len(rs.Rows))
+		}
+		r := &rs.Rows[0]
+		if !reflect.DeepEqual(r, rowExpected) {
+			t.Fatalf("unexpected row parsed from %q;\ngot\n%+v\nwant\n%+v", s, r, rowExpected)
+		}
+	}
+
+	// RFC 5424
+	f(`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8`, &Row{
+		Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC).UnixNano(),
+		Facility:  4,
+		Severity:  2,
+		Hostname:  []byte("mymachine.example.com"),
+		AppName:   []byte("su"),
+		MsgID:     []byte("ID47"),
+		Message:   []byte("BOM'su root' failed for lonvick on /dev/pts/8"),
+	})
+	f("<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - \xEF\xBB\xBF%% It's time to make the do-nuts.\n", &Row{
+		Timestamp: time.Date(2003, 8, 24, 12, 14, 15, 3e3, time.UTC).UnixNano(),
+		Facility:  20,
+		Severity:  5,
+		Hostname:  []byte("192.0.2.1"),
+		AppName:   []byte("myproc"),
+		ProcID:    []byte("8710"),
+		Message:   []byte("%% It's time to make the do-nuts."),
+	})
+
+	// RFC 5424 with structured data
+	f(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventID="1011" x="a\"]b"][examplePriority@32473 class="high"] An application event`, &Row{
+		Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC).UnixNano(),
+		Facility:  20,
+		Severity:  5,
+		Hostname:  []byte("mymachine.example.com"),
+		AppName:   []byte("evntslog"),
+		MsgID:     []byte("ID47"),
+		Message:   []byte("An application event"),
+	})
+
+	// RFC 5424 without message and timestamp
+	f(`<13>1 - host app - - [foo@1 a="b"]`, &Row{
+		Timestamp: now.UnixNano(),
+		Facility:  1,
+		Severity:  5,
+		Hostname:  []byte("host"),
+		AppName:   []byte("app"),
+	})
+
+	// RFC 3164
+	f(`<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8`, &Row{
+		Timestamp: time.Date(2020, 10, 11, 22, 14, 15, 0, time.UTC).UnixNano(),
+		Facility:  4,
+		Severity:  2,
+		Hostname:  []byte("mymachine"),
+		AppName:   []byte("su"),
+		Message:   []byte("'su root' failed for lonvick on /dev/pts/8"),
+	})
+	f(`<86>Oct  2 08:01:02 web-1 sshd[4242]: Accepted publickey for root`, &Row{
+		Timestamp: time.Date(2020, 10, 2, 8, 1, 2, 0, time.UTC).UnixNano(),
+		Facility:  10,
+		Severity:  6,
+		Hostname:  []byte("web-1"),
+		AppName:   []byte("sshd"),
+		ProcID:    []byte("4242"),
+		Message:   []byte("Accepted publickey for root"),
+	})
+
+	// RFC 3164 timestamp from the previous year
+	f(`<13>Dec 31 23:59:59 host app: msg`, &Row{
+		Timestamp: time.Date(2019, 12, 31, 23, 59, 59, 0, time.UTC).UnixNano(),
+		Facility:  1,
+		Severity:  5,
+		Hostname:  []byte("host"),
+		AppName:   []byte("app"),
+		Message:   []byte("msg"),
+	})
+
+	// RFC 3164 without hostname
+	f(`<13>Oct 11 22:14:15 cron[1]: job started`, &Row{
+		Timestamp: time.Date(2020, 10, 11, 22, 14, 15, 0, time.UTC).UnixNano(),
+		Facility:  1,
+		Severity:  5,
+		AppName:   []byte("cron"),
+		ProcID:    []byte("1"),
+		Message:   []byte("job started"),
+	})
+
+	// RFC 3164 with RFC 3339 timestamp
+	f(`<13>2020-10-11T22:14:15.123456789Z host app: msg`, &Row{
+		Timestamp: time.Date(2020, 10, 11, 22, 14, 15, 123456789, time.UTC).UnixNano(),
+		Facility:  1,
+		Severity:  5,
+		Hostname:  []byte("host"),
+		AppName:   []byte("app"),
+		Message:   []byte("msg"),
+	})
+
+	// RFC 3164 without timestamp and tag
+	f(`<0>just a message`, &Row{
+		Timestamp: now.UnixNano(),
+		Message:   []byte("just a message"),
+	})
+}
+
+func TestRowsUnmarshalFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		var rs Rows
+		rs.Unmarshal([]byte(s), time.Now())
+		if len(rs.Rows) != 0 {
+			t.Fatalf("expecting zero rows for %q; got %d rows", s, len(rs.Rows))
+		}
+	}
+	f(``)
+	f(`foo bar`)
+	f(`<>1 - - - - - -`)
+	f(`<1234>1 - - - - - -`)
+	f(`<192>1 - - - - - -`)
+	f(`<1a>1 - - - - - -`)
+	f(`<13>1 foobar host app - - - msg`)
+	f(`<13>1 - host app - -`)
+	f(`<13>1 - host app - - [foo@1 a="]"`)
+	f(`<13>1 - host app - - -msg`)
+}
+
+func TestRowNames(t *testing.T) {
+	f := func(facility, severity int, facilityExpected, severityExpected string) {
+		t.Helper()
+		r := &Row{
+			Facility: facility,
+			Severity: severity,
+		}
+		if s := r.FacilityName(); s != facilityExpected {
+			t.Fatalf("unexpected facility name for %d; got %q; want %q", facility, s, facilityExpected)
+		}
+		if s := r.SeverityName(); s != severityExpected {
+			t.Fatalf("unexpected severity name for %d; got %q; want %q", severity, s, severityExpected)
+		}
+	}
+	f(0, 0, "kern", "emerg")
+	f(3, 3, "daemon", "err")
+	f(23, 7, "local7", "debug")
+	f(24, 8, "unknown", "unknown")
+}
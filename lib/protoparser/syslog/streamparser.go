@@ -0,0 +1,208 @@
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var maxMessageSize = flagutil.NewBytes("syslog.maxMessageSize", 64*1024, "The maximum size in bytes of a single syslog message")
+
+// The maximum size of messages passed to a single callback call.
+const maxBatchSize = 256 * 1024
+
+// ParseStream parses syslog messages from TCP stream r and calls callback for the parsed rows.
+//
+// Both octet-counting and non-transparent (newline-delimited) framing from https://tools.ietf.org/html/rfc6587 are supported.
+//
+// The callback can be called multiple times for streamed data from r.
+// Messages are passed to the callback in the order they are read from r.
+//
+// callback shouldn't hold rows after returning.
+func ParseStream(r io.Reader, callback func(rows []Row) error) error {
+	ctx := getStreamContext(r)
+	defer putStreamContext(ctx)
+	for ctx.Read() {
+		now := time.Now()
+		start := 0
+		for _, end := range ctx.msgEnds {
+			ctx.rows.Unmarshal(ctx.reqBuf[start:end], now)
+			start = end
+		}
+		rowsRead.Add(len(ctx.rows.Rows))
+		if err := callback(ctx.rows.Rows); err != nil {
+			return err
+		}
+	}
+	return ctx.Error()
+}
+
+// ParseDatagram parses a single syslog message from UDP datagram data and calls callback for the parsed row.
+//
+// callback shouldn't hold rows after returning.
+func ParseDatagram(data []byte, callback func(rows []Row) error) error {
+	rs := getRows()
+	defer putRows(rs)
+	rs.Unmarshal(data, time.Now())
+	rowsRead.Add(len(rs.Rows))
+	if len(rs.Rows) == 0 {
+		return nil
+	}
+	return callback(rs.Rows)
+}
+
+// Read reads the next batch of messages into ctx.
+//
+// It doesn't wait for more messages if the already read messages may be processed,
+// since syslog senders may keep connections open for long periods of time.
+func (ctx *streamContext) Read() bool {
+	readCalls.Inc()
+	ctx.rows.Reset()
+	ctx.reqBuf = ctx.reqBuf[:0]
+	ctx.msgEnds = ctx.msgEnds[:0]
+	if ctx.err != nil {
+		return false
+	}
+	for len(ctx.reqBuf) < maxBatchSize {
+		if err := ctx.readMessage(); err != nil {
+			if err != io.EOF {
+				readErrors.Inc()
+				err = fmt.Errorf("cannot read syslog message: %w", err)
+			}
+			ctx.err = err
+			break
+		}
+		if ctx.br.Buffered() == 0 && len(ctx.msgEnds) > 0 {
+			break
+		}
+	}
+	return len(ctx.msgEnds) > 0
+}
+
+// readMessage reads a single message from ctx.br and appends it to ctx.reqBuf.
+func (ctx *streamContext) readMessage() error {
+	b, err := ctx.br.Peek(1)
+	if err != nil {
+		return err
+	}
+	start := len(ctx.reqBuf)
+	if b[0] >= '0' && b[0] <= '9' {
+		// Octet-counting framing: MSG-LEN SP SYSLOG-MSG
+		lenStr, err := ctx.br.ReadSlice(' ')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("cannot read MSG-LEN: %w", err)
+		}
+		n, err := strconv.Atoi(bytesutil.ToUnsafeString(lenStr[:len(lenStr)-1]))
+		if err != nil {
+			return fmt.Errorf("cannot parse MSG-LEN %q: %w", lenStr[:len(lenStr)-1], err)
+		}
+		if n > maxMessageSize.N {
+			return fmt.Errorf("too big message with MSG-LEN=%d; it mustn't exceed -syslog.maxMessageSize=%d bytes", n, maxMessageSize.N)
+		}
+		ctx.reqBuf = bytesutil.Resize(ctx.reqBuf, start+n)
+		if _, err := io.ReadFull(ctx.br, ctx.reqBuf[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("cannot read message with MSG-LEN=%d: %w", n, err)
+		}
+	} else {
+		// Non-transparent framing: messages are delimited by newlines.
+		for {
+			line, err := ctx.br.ReadSlice('\n')
+			ctx.reqBuf = append(ctx.reqBuf, line...)
+			if len(ctx.reqBuf)-start > maxMessageSize.N {
+				return fmt.Errorf("too big message; it mustn't exceed -syslog.maxMessageSize=%d bytes", maxMessageSize.N)
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil && (err != io.EOF || len(ctx.reqBuf) == start) {
+				return err
+			}
+			break
+		}
+	}
+	end := len(ctx.reqBuf)
+	for end > start && (ctx.reqBuf[end-1] == '\n' || ctx.reqBuf[end-1] == '\r') {
+		end--
+	}
+	ctx.reqBuf = ctx.reqBuf[:end]
+	if end > start {
+		// Skip empty messages.
+		ctx.msgEnds = append(ctx.msgEnds, end)
+	}
+	return nil
+}
+
+type streamContext struct {
+	br      *bufio.Reader
+	reqBuf  []byte
+	msgEnds []int
+	rows    Rows
+	err     error
+}
+
+func (ctx *streamContext) Error() error {
+	if ctx.err == io.EOF {
+		return nil
+	}
+	return ctx.err
+}
+
+func (ctx *streamContext) reset() {
+	ctx.br.Reset(nil)
+	ctx.reqBuf = ctx.reqBuf[:0]
+	ctx.msgEnds = ctx.msgEnds[:0]
+	ctx.rows.Reset()
+	ctx.err = nil
+}
+
+var (
+	readCalls  = metrics.NewCounter(`vm_protoparser_read_calls_total{type="syslog"}`)
+	readErrors = metrics.NewCounter(`vm_protoparser_read_errors_total{type="syslog"}`)
+	rowsRead   = metrics.NewCounter(`vm_protoparser_rows_read_total{type="syslog"}`)
+)
+
+func getStreamContext(r io.Reader) *streamContext {
+	if v := streamContextPool.Get(); v != nil {
+		ctx := v.(*streamContext)
+		ctx.br.Reset(r)
+		return ctx
+	}
+	return &streamContext{
+		br: bufio.NewReaderSize(r, 64*1024),
+	}
+}
+
+func putStreamContext(ctx *streamContext) {
+	ctx.reset()
+	streamContextPool.Put(ctx)
+}
+
+var streamContextPool sync.Pool
+
+func getRows() *Rows {
+	v := rowsPool.Get()
+	if v == nil {
+		return &Rows{}
+	}
+	return v.(*Rows)
+}
+
+func putRows(rs *Rows) {
+	rs.Reset()
+	rowsPool.Put(rs)
+}
+
+var rowsPool sync.Pool
@@ -0,0 +1,82 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseStreamSuccess(t *testing.T) {
+	f := func(s string, messagesExpected []string) {
+		t.Helper()
+		var messages []string
+		err := ParseStream(strings.NewReader(s), func(rows []Row) error {
+			for i := range rows {
+				messages = append(messages, fmt.Sprintf("%s %s", rows[i].AppName, rows[i].Message))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(messages, "|") != strings.Join(messagesExpected, "|") {
+			t.Fatalf("unexpected messages;\ngot\n%q\nwant\n%q", messages, messagesExpected)
+		}
+	}
+
+	f("", nil)
+	f("\n\r\n", nil)
+
+	// Non-transparent framing
+	f("<13>1 - host app1 - - - foo\n<13>1 - host app2 - - - bar\r\n\n<13>Oct 11 22:14:15 host app3: baz",
+		[]string{"app1 foo", "app2 bar", "app3 baz"})
+
+	// Octet-counting framing
+	f("27 <13>1 - host app1 - - - a\nb27 <13>1 - host app2 - - - foo\n",
+		[]string{"app1 a\nb", "app2 foo"})
+
+	// Mixed framing
+	f("25 <13>1 - host app1 - - - a<13>1 - host app2 - - - b\n", []string{"app1 a", "app2 b"})
+
+	// Invalid messages are skipped
+	f("foobar\n<13>1 - host app - - - foo\n", []string{"app foo"})
+}
+
+func TestParseStreamFailure(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+		err := ParseStream(strings.NewReader(s), func(rows []Row) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+
+	// Invalid MSG-LEN
+	f("12a <13>1 - - - - - -")
+
+	// Truncated message
+	f("100 <13>1 - host app - - - foo")
+
+	// Too big messages
+	f(fmt.Sprintf("%d <13>1 - - - - - -", maxMessageSize.N+1))
+	f("<13>1 - host app - - - " + string(bytes.Repeat([]byte("a"), maxMessageSize.N)) + "\n")
+}
+
+func TestParseDatagram(t *testing.T) {
+	var messages []string
+	err := ParseDatagram([]byte("<13>1 - host app - - - foo\n"), func(rows []Row) error {
+		for i := range rows {
+			messages = append(messages, string(rows[i].Message))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(messages) != 1 || messages[0] != "foo" {
+		t.Fatalf("unexpected messages: %q", messages)
+	}
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/cespare/xxhash/v2"
+)
+
+// SetMaxActiveStreamsPerTenant sets the limit on the number of active streams per tenant.
+//
+// A stream is active if it received log lines during the given window.
+// Log lines for new streams exceeding the limit are rejected, while the existing streams keep ingesting.
+// A stream is new if it has no TSID in indexdb yet.
+// There is no limit if maxStreams is 0.
+//
+// This function must be called before initializing the storage.
+func SetMaxActiveStreamsPerTenant(maxStreams int, window time.Duration) {
+	maxActiveStreamsPerTenant = maxStreams
+	activeStreamsWindow = uint64(window.Seconds())
+	if activeStreamsWindow == 0 {
+		activeStreamsWindow = 1
+	}
+}
+
+var (
+	maxActiveStreamsPerTenant = 0
+	activeStreamsWindow       = uint64(3600)
+)
+
+// HasMaxActiveStreamsPerTenant returns true if the limit on active streams per tenant is set via SetMaxActiveStreamsPerTenant.
+func HasMaxActiveStreamsPerTenant() bool {
+	return maxActiveStreamsPerTenant > 0
+}
+
+// TooManyStreamsError is returned from Storage.AddRows if log lines for new streams are rejected
+// because of the limit set via SetMaxActiveStreamsPerTenant.
+//
+// The remaining rows are added to the storage in this case.
+type TooManyStreamsError struct {
+	// Rejected contains the number of rejected rows per tenant.
+	Rejected []RejectedRows
+
+	// Rows contains the rejected rows sorted by RejectedRow.Idx.
+	Rows []RejectedRow
+}
+
+// RejectedRow identifies the row rejected by Storage.AddRows.
+type RejectedRow struct {
+	// Idx is the index of the rejected row in the rows passed to Storage.AddRows.
+	Idx int
+
+	AccountID uint32
+	ProjectID uint32
+}
+
+// RejectedRows contains the number of rejected rows for the tenant.
+type RejectedRows struct {
+	AccountID uint32
+	ProjectID uint32
+	Rows      int
+}
+
+// Error implements error interface.
+func (e *TooManyStreamsError) Error() string {
+	a := make([]string, len(e.Rejected))
+	for i, rr := range e.Rejected {
+		a[i] = fmt.Sprintf("%d rows for tenant %d:%d", rr.Rows, rr.AccountID, rr.ProjectID)
+	}
+	return fmt.Sprintf("rejected %s, since they belong to new streams exceeding the limit of %d active streams per tenant during the last %d seconds; "+
+		"probably you need updating -storage.maxActiveStreamsPerTenant command-line flag",
+		strings.Join(a, ", "), maxActiveStreamsPerTenant, activeStreamsWindow)
+}
+
+func addRejectedRow(rejected []RejectedRows, accountID, projectID uint32) []RejectedRows {
+	for i := range rejected {
+		rr := &rejected[i]
+		if rr.AccountID == accountID && rr.ProjectID == projectID {
+			rr.Rows++
+			return rejected
+		}
+	}
+	return append(rejected, RejectedRows{
+		AccountID: accountID,
+		ProjectID: projectID,
+		Rows:      1,
+	})
+}
+
+// MarshalRejectedRows appends marshaled rows to dst and returns the result.
+func MarshalRejectedRows(dst []byte, rows []RejectedRow) []byte {
+	dst = encoding.MarshalUint64(dst, uint64(len(rows)))
+	for _, row := range rows {
+		dst = encoding.MarshalUint32(dst, uint32(row.Idx))
+		dst = encoding.MarshalUint32(dst, row.AccountID)
+		dst = encoding.MarshalUint32(dst, row.ProjectID)
+	}
+	return dst
+}
+
+// UnmarshalRejectedRows appends rows unmarshaled from src to dst and returns the result with the tail left after unmarshaling.
+func UnmarshalRejectedRows(dst []RejectedRow, src []byte) ([]RejectedRow, []byte, error) {
+	if len(src) < 8 {
+		return dst, src, fmt.Errorf("cannot unmarshal the number of rejected rows from %d bytes; need at least 8 bytes", len(src))
+	}
+	n := encoding.UnmarshalUint64(src)
+	src = src[8:]
+	if uint64(len(src)) < n*12 {
+		return dst, src, fmt.Errorf("cannot unmarshal %d rejected rows from %d bytes; need at least %d bytes", n, len(src), n*12)
+	}
+	for i := uint64(0); i < n; i++ {
+		dst = append(dst, RejectedRow{
+			Idx:       int(encoding.UnmarshalUint32(src)),
+			AccountID: encoding.UnmarshalUint32(src[4:]),
+			ProjectID: encoding.UnmarshalUint32(src[8:]),
+		})
+		src = src[12:]
+	}
+	return dst, src, nil
+}
+
+// activeStreams tracks active streams per tenant.
+type activeStreams struct {
+	maxStreams int
+	window     uint64
+
+	mu sync.Mutex
+	m  map[accountProjectKey]*tenantActiveStreams
+}
+
+func newActiveStreams(maxStreams int, window uint64) *activeStreams {
+	return &activeStreams{
+		maxStreams: maxStreams,
+		window:     window,
+		m:          make(map[accountProjectKey]*tenantActiveStreams),
+	}
+}
+
+// tenantActiveStreams contains hashes of streams for a tenant, which were active
+// during the current and the previous windows.
+type tenantActiveStreams struct {
+	mu sync.Mutex
+
+	curr map[uint64]struct{}
+	prev map[uint64]struct{}
+
+	// n is the number of distinct items in curr and prev.
+	n int
+
+	// currStart is the start time of the current window in seconds.
+	currStart uint64
+}
+
+// register registers the stream with the given metricNameRaw as active at the given time in seconds.
+//
+// isNewStream must be set if the TSID for the stream is going to be created.
+// It returns false if the stream is new and the tenant already has as.maxStreams active streams.
+// Existing streams are always registered, so they keep ingesting after vmstorage restart or after lowering the limit.
+func (as *activeStreams) register(metricNameRaw []byte, currentTime uint64, isNewStream bool) bool {
+	if len(metricNameRaw) < 8 {
+		// Invalid metricNameRaw. Let the caller deal with it.
+		return true
+	}
+	k := accountProjectKey{
+		AccountID: encoding.UnmarshalUint32(metricNameRaw),
+		ProjectID: encoding.UnmarshalUint32(metricNameRaw[4:]),
+	}
+	as.mu.Lock()
+	tas := as.m[k]
+	if tas == nil {
+		tas = &tenantActiveStreams{
+			curr:      make(map[uint64]struct{}),
+			currStart: currentTime,
+		}
+		as.m[k] = tas
+	}
+	as.mu.Unlock()
+
+	h := xxhash.Sum64(metricNameRaw)
+	tas.mu.Lock()
+	defer tas.mu.Unlock()
+
+	if currentTime-tas.currStart >= as.window {
+		// Start new window.
+		if currentTime-tas.currStart >= 2*as.window {
+			// The previous window has no activity.
+			tas.prev = nil
+		} else {
+			tas.prev = tas.curr
+		}
+		tas.curr = make(map[uint64]struct{}, len(tas.prev))
+		tas.n = len(tas.prev)
+		tas.currStart = currentTime
+	}
+	if _, ok := tas.curr[h]; ok {
+		return true
+	}
+	if _, ok := tas.prev[h]; ok {
+		tas.curr[h] = struct{}{}
+		return true
+	}
+	if isNewStream && tas.n >= as.maxStreams {
+		return false
+	}
+	tas.curr[h] = struct{}{}
+	tas.n++
+	return true
+}
+
+// Len returns the number of active streams across all the tenants.
+func (as *activeStreams) Len() int {
+	as.mu.Lock()
+	tass := make([]*tenantActiveStreams, 0, len(as.m))
+	for _, tas := range as.m {
+		tass = append(tass, tas)
+	}
+	as.mu.Unlock()
+
+	n := 0
+	for _, tas := range tass {
+		tas.mu.Lock()
+		n += tas.n
+		tas.mu.Unlock()
+	}
+	return n
+}
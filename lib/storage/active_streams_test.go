@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+func TestActiveStreamsRegister(t *testing.T) {
+	as := newActiveStreams(2, 10)
+	streamName := func(accountID, projectID uint32, name string) []byte {
+		b := encoding.MarshalUint32(nil, accountID)
+		b = encoding.MarshalUint32(b, projectID)
+		return append(b, name...)
+	}
+	f := func(metricNameRaw []byte, currentTime uint64, resultExpected bool) {
+		t.Helper()
+		result := as.register(metricNameRaw, currentTime, true)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q at %d; got %v; want %v", metricNameRaw, currentTime, result, resultExpected)
+		}
+	}
+
+	f(streamName(1, 0, "foo"), 100, true)
+	f(streamName(1, 0, "bar"), 100, true)
+	f(streamName(1, 0, "foo"), 101, true)
+
+	// The limit is exceeded for new streams.
+	f(streamName(1, 0, "baz"), 101, false)
+
+	// The limit is tracked per tenant.
+	f(streamName(1, 1, "baz"), 101, true)
+	f(streamName(2, 0, "baz"), 101, true)
+	if n := as.Len(); n != 4 {
+		t.Fatalf("unexpected number of active streams; got %d; want 4", n)
+	}
+
+	// Streams from the previous window remain active.
+	f(streamName(1, 0, "foo"), 111, true)
+	f(streamName(1, 0, "baz"), 111, false)
+
+	// Streams without activity during the previous window are removed.
+	f(streamName(1, 0, "baz"), 121, true)
+	f(streamName(1, 0, "bar"), 121, false)
+
+	// All the streams are removed after two windows without activity.
+	f(streamName(1, 0, "x"), 200, true)
+	f(streamName(1, 0, "y"), 200, true)
+	f(streamName(1, 0, "z"), 200, false)
+
+	// Existing streams are registered even if the limit is exceeded.
+	if !as.register(streamName(1, 0, "z"), 200, false) {
+		t.Fatalf("existing stream must be registered")
+	}
+	f(streamName(1, 0, "z"), 201, true)
+	f(streamName(1, 0, "w"), 201, false)
+}
+
+func TestAddRejectedRow(t *testing.T) {
+	var rejected []RejectedRows
+	rejected = addRejectedRow(rejected, 1, 2)
+	rejected = addRejectedRow(rejected, 3, 4)
+	rejected = addRejectedRow(rejected, 1, 2)
+	rejectedExpected := []RejectedRows{
+		{AccountID: 1, ProjectID: 2, Rows: 2},
+		{AccountID: 3, ProjectID: 4, Rows: 1},
+	}
+	if !reflect.DeepEqual(rejected, rejectedExpected) {
+		t.Fatalf("unexpected rejected rows;\ngot\n%+v\nwant\n%+v", rejected, rejectedExpected)
+	}
+}
+
+func TestMarshalUnmarshalRejectedRows(t *testing.T) {
+	rows := []RejectedRow{
+		{Idx: 0, AccountID: 1, ProjectID: 2},
+		{Idx: 3, AccountID: 3, ProjectID: 4},
+		{Idx: 1 << 30, AccountID: 1<<32 - 1, ProjectID: 0},
+	}
+	data := MarshalRejectedRows(nil, rows)
+	data = append(data, "tail"...)
+	result, tail, err := UnmarshalRejectedRows(nil, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(result, rows) {
+		t.Fatalf("unexpected unmarshaled rows;\ngot\n%+v\nwant\n%+v", result, rows)
+	}
+	if string(tail) != "tail" {
+		t.Fatalf("unexpected tail; got %q; want %q", tail, "tail")
+	}
+
+	// Empty rows.
+	data = MarshalRejectedRows(nil, nil)
+	result, tail, err = UnmarshalRejectedRows(nil, data)
+	if err != nil {
+		t.Fatalf("unexpected error for empty rows: %s", err)
+	}
+	if len(result) != 0 || len(tail) != 0 {
+		t.Fatalf("unexpected result for empty rows; got %+v with tail %q", result, tail)
+	}
+
+	// Truncated data.
+	data = MarshalRejectedRows(nil, rows)
+	if _, _, err := UnmarshalRejectedRows(nil, data[:len(data)-1]); err == nil {
+		t.Fatalf("expecting non-nil error for truncated data")
+	}
+	if _, _, err := UnmarshalRejectedRows(nil, data[:7]); err == nil {
+		t.Fatalf("expecting non-nil error for truncated size")
+	}
+}
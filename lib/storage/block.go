@@ -16,7 +16,23 @@ const (
 	maxRowsPerBlock = 8 * 1024
 
 	// The maximum size of values in the block.
+	//
+	// Values bigger than maxBlockSize are stored in their own blocks.
 	maxBlockSize = 8 * maxRowsPerBlock
+
+	// The maximum size of unmarshaled values in the block with values smaller than maxBlockSize.
+	maxBlockValuesSize = 16 * maxBlockSize
+
+	// MaxEntrySize is the maximum size of a single value, i.e. log line.
+	//
+	// Rows with bigger values are skipped by Storage.AddRows.
+	MaxEntrySize = 4 * 1024 * 1024
+
+	// The maximum size of marshaled values in the block.
+	//
+	// Blocks contain either values with the total size up to maxBlockValuesSize or a single value up to MaxEntrySize.
+	// Compressed values may exceed the original size for incompressible values.
+	maxValuesBlockSize = 2 * MaxEntrySize
 )
 
 // Block represents a block of time series values for a single TSID.
@@ -29,6 +45,13 @@ type Block struct {
 	timestamps []int64
 	values     [][]byte
 
+	// seqs contains ingestion sequence numbers for values.
+	//
+	// It is used for preserving ingestion order for rows with identical timestamps.
+	// seqs may be empty for blocks created without sequence numbers.
+	// Otherwise it has the same length as values.
+	seqs []uint64
+
 	// Marshaled representation of block header.
 	headerData []byte
 
@@ -37,6 +60,13 @@ type Block struct {
 
 	// Marshaled representation of values.
 	valuesData []byte
+
+	// bloomData contains marshaled bloom filter for the block read from the source part.
+	//
+	// It is valid only if hasBloomData is set. It allows writing the block
+	// passed through the merge unchanged without re-building its bloom filter.
+	bloomData    []byte
+	hasBloomData bool
 }
 
 // Reset resets b.
@@ -45,10 +75,14 @@ func (b *Block) Reset() {
 	b.nextIdx = 0
 	b.timestamps = b.timestamps[:0]
 	b.values = b.values[:0]
+	b.seqs = b.seqs[:0]
 
 	b.headerData = b.headerData[:0]
 	b.timestampsData = b.timestampsData[:0]
 	b.valuesData = b.valuesData[:0]
+
+	b.bloomData = b.bloomData[:0]
+	b.hasBloomData = false
 }
 
 // CopyFrom copies src to b.
@@ -57,10 +91,19 @@ func (b *Block) CopyFrom(src *Block) {
 	b.nextIdx = 0
 	b.timestamps = append(b.timestamps[:0], src.timestamps[src.nextIdx:]...)
 	b.values = append(b.values[:0], src.values[src.nextIdx:]...)
+	if len(src.seqs) > 0 {
+		b.seqs = append(b.seqs[:0], src.seqs[src.nextIdx:]...)
+	} else {
+		b.seqs = b.seqs[:0]
+	}
 
 	b.headerData = append(b.headerData[:0], src.headerData...)
 	b.timestampsData = append(b.timestampsData[:0], src.timestampsData...)
 	b.valuesData = append(b.valuesData[:0], src.valuesData...)
+
+	// The bloom filter remains valid for the copied rows, since they are a subset of src rows.
+	b.bloomData = append(b.bloomData[:0], src.bloomData...)
+	b.hasBloomData = src.hasBloomData
 }
 
 func getBlock() *Block {
@@ -88,13 +131,16 @@ func (b *Block) RowsCount() int {
 	return int(b.bh.RowsCount)
 }
 
-// Init initializes b with the given tsid, timestamps, values and scale.
-func (b *Block) Init(tsid *TSID, timestamps []int64, values [][]byte, precisionBits uint8) {
+// Init initializes b with the given tsid, timestamps, seqs, values and scale.
+//
+// seqs may be nil if values have no ingestion sequence numbers.
+func (b *Block) Init(tsid *TSID, timestamps []int64, seqs []uint64, values [][]byte, precisionBits uint8) {
 	b.Reset()
 	b.bh.TSID = *tsid
 	b.bh.PrecisionBits = precisionBits
 	b.timestamps = append(b.timestamps[:0], timestamps...)
 	b.values = append(b.values[:0], values...)
+	b.seqs = append(b.seqs[:0], seqs...)
 }
 
 // nextRow advances to the next row.
@@ -119,6 +165,9 @@ func (b *Block) assertUnmarshaled() {
 	if len(b.values) != len(b.timestamps) {
 		logger.Panicf("BUG: the number of values must match the number of timestamps; got %d vs %d", len(b.values), len(b.timestamps))
 	}
+	if len(b.seqs) != len(b.values) {
+		logger.Panicf("BUG: the number of seqs must match the number of values; got %d vs %d", len(b.seqs), len(b.values))
+	}
 	if b.nextIdx > len(b.values) {
 		logger.Panicf("BUG: nextIdx cannot exceed the number of values; got %d vs %d", b.nextIdx, len(b.values))
 	}
@@ -140,9 +189,51 @@ func (b *Block) tooBig() bool {
 	if len(b.valuesData) >= maxBlockSize {
 		return true
 	}
+	values := b.values[b.nextIdx:]
+	if len(values) > 0 && (len(values[0]) > maxBlockSize || valuesSize(values) >= maxBlockValuesSize) {
+		return true
+	}
 	return false
 }
 
+// blockRowsCount returns the number of leading rows from b, which may be stored in a single block.
+//
+// At least a single row is returned for non-empty b. See canAddValue for details.
+func (b *Block) blockRowsCount() int {
+	values := b.values[b.nextIdx:]
+	size := 0
+	for i, v := range values {
+		if i > 0 && !canAddValue(i, values[0], v, size) {
+			return i
+		}
+		size += len(v)
+	}
+	return len(values)
+}
+
+// canAddValue returns true if v may be added to the block with rowsCount > 0 rows starting with firstValue,
+// where valuesSize is the total size of the rows values.
+//
+// A value bigger than maxBlockSize is stored in its own block, so big log lines
+// don't bloat blocks with small log lines.
+func canAddValue(rowsCount int, firstValue, v []byte, valuesSize int) bool {
+	if rowsCount >= maxRowsPerBlock {
+		return false
+	}
+	if len(firstValue) > maxBlockSize || len(v) > maxBlockSize {
+		return false
+	}
+	return valuesSize+len(v) <= maxBlockValuesSize
+}
+
+func valuesSize(values [][]byte) int {
+	n := 0
+	for _, v := range values {
+		n += len(v)
+	}
+	return n
+}
+
 func (b *Block) deduplicateSamplesDuringMerge() {
 	if len(b.values) == 0 {
 		// Nothing to dedup or the data is already marshaled.
@@ -150,11 +241,18 @@ func (b *Block) deduplicateSamplesDuringMerge() {
 	}
 	srcTimestamps := b.timestamps[b.nextIdx:]
 	srcValues := b.values[b.nextIdx:]
-	timestamps, values := deduplicateSamplesDuringMerge(srcTimestamps, srcValues)
+	var srcSeqs []uint64
+	if len(b.seqs) > 0 {
+		srcSeqs = b.seqs[b.nextIdx:]
+	}
+	timestamps, seqs, values := deduplicateSamplesDuringMerge(srcTimestamps, srcSeqs, srcValues)
 	dedups := len(srcTimestamps) - len(timestamps)
 	atomic.AddUint64(&dedupsDuringMerge, uint64(dedups))
 	b.timestamps = b.timestamps[:b.nextIdx+len(timestamps)]
 	b.values = b.values[:b.nextIdx+len(values)]
+	if len(b.seqs) > 0 {
+		b.seqs = b.seqs[:b.nextIdx+len(seqs)]
+	}
 }
 
 var dedupsDuringMerge uint64
@@ -208,10 +306,19 @@ func (b *Block) MarshalData(timestampsBlockOffset, valuesBlockOffset uint64) ([]
 		logger.Panicf("BUG: the number of values must match the number of timestamps; got %d vs %d", len(values), len(timestamps))
 	}
 
-	b.valuesData, b.bh.ValuesMarshalType = encodingext.MarshalValues(b.valuesData[:0], values)
+	if len(b.seqs) > 0 {
+		seqs := b.seqs[b.nextIdx:]
+		if len(seqs) != len(values) {
+			logger.Panicf("BUG: the number of seqs must match the number of values; got %d vs %d", len(seqs), len(values))
+		}
+		b.valuesData, b.bh.ValuesMarshalType = encodingext.MarshalValuesWithSeqs(b.valuesData[:0], values, seqs)
+	} else {
+		b.valuesData, b.bh.ValuesMarshalType = encodingext.MarshalValues(b.valuesData[:0], values)
+	}
 	b.bh.ValuesBlockOffset = valuesBlockOffset
 	b.bh.ValuesBlockSize = uint32(len(b.valuesData))
 	b.values = b.values[:0]
+	b.seqs = b.seqs[:0]
 
 	b.timestampsData, b.bh.TimestampsMarshalType, b.bh.MinTimestamp = encoding.MarshalTimestamps(b.timestampsData[:0], timestamps, b.bh.PrecisionBits)
 	b.bh.TimestampsBlockOffset = timestampsBlockOffset
@@ -259,7 +366,7 @@ func (b *Block) UnmarshalData(checkValues bool) error {
 	b.timestampsData = b.timestampsData[:0]
 
 	if len(b.valuesData) > 0 {
-		b.values, err = encodingext.UnmarshalValues(b.values[:0], b.valuesData, b.bh.ValuesMarshalType, int(b.bh.RowsCount))
+		b.values, b.seqs, err = encodingext.UnmarshalValuesWithSeqs(b.values[:0], b.seqs[:0], b.valuesData, b.bh.ValuesMarshalType, int(b.bh.RowsCount))
 		if err != nil {
 			return err
 		}
@@ -277,17 +384,45 @@ func (b *Block) UnmarshalData(checkValues bool) error {
 	return nil
 }
 
+// convertMsecTimestamps converts timestamps in b from milliseconds to nanoseconds.
+//
+// It is used for blocks from parts with millisecond timestamps.
+// b.bh must be already converted with blockHeader.convertMsecTimestamps,
+// while b.timestampsData must contain marshaled timestamps in milliseconds.
+func (b *Block) convertMsecTimestamps() error {
+	timestamps, err := encoding.UnmarshalTimestamps(nil, b.timestampsData, b.bh.TimestampsMarshalType, b.bh.MinTimestamp/nsecPerMsec, int(b.bh.RowsCount))
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal timestamps in milliseconds: %w", err)
+	}
+	if b.bh.PrecisionBits < 64 {
+		// Recover timestamps order after lossy compression.
+		encoding.EnsureNonDecreasingSequence(timestamps, b.bh.MinTimestamp/nsecPerMsec, b.bh.MaxTimestamp/nsecPerMsec)
+	}
+	for i := range timestamps {
+		timestamps[i] *= nsecPerMsec
+	}
+	b.timestampsData, b.bh.TimestampsMarshalType, b.bh.MinTimestamp = encoding.MarshalTimestamps(b.timestampsData[:0], timestamps, b.bh.PrecisionBits)
+	b.bh.TimestampsBlockSize = uint32(len(b.timestampsData))
+	b.bh.MaxTimestamp = timestamps[len(timestamps)-1]
+	return nil
+}
+
 // AppendRowsWithTimeRangeFilter filters samples from b according to tr and appends them to dst*.
 //
+// Ingestion sequence numbers for the appended samples are appended to dstSeqs.
+// They must be used for ordering samples with identical timestamps.
+// Nothing is appended to dstSeqs if b has no values.
+//
 // It is expected that UnmarshalData has been already called on b.
-func (b *Block) AppendRowsWithTimeRangeFilter(dstTimestamps []int64, dstValues [][]byte, tr TimeRange) ([]int64, [][]byte) {
-	timestamps, values := b.filterTimestamps(tr)
+func (b *Block) AppendRowsWithTimeRangeFilter(dstTimestamps []int64, dstSeqs []uint64, dstValues [][]byte, tr TimeRange) ([]int64, []uint64, [][]byte) {
+	timestamps, seqs, values := b.filterTimestamps(tr)
 	dstTimestamps = append(dstTimestamps, timestamps...)
+	dstSeqs = append(dstSeqs, seqs...)
 	dstValues = append(dstValues, values...)
-	return dstTimestamps, dstValues
+	return dstTimestamps, dstSeqs, dstValues
 }
 
-func (b *Block) filterTimestamps(tr TimeRange) ([]int64, [][]byte) {
+func (b *Block) filterTimestamps(tr TimeRange) ([]int64, []uint64, [][]byte) {
 	timestamps := b.timestamps
 
 	// Skip timestamps smaller than tr.MinTimestamp.
@@ -303,12 +438,15 @@ func (b *Block) filterTimestamps(tr TimeRange) ([]int64, [][]byte) {
 	}
 
 	if i == j {
-		return nil, nil
+		return nil, nil, nil
 	}
 	if len(b.values) == 0 {
-		return timestamps[i:j], nil
+		return timestamps[i:j], nil, nil
+	}
+	if len(b.seqs) == 0 {
+		return timestamps[i:j], nil, b.values[i:j]
 	}
-	return timestamps[i:j], b.values[i:j]
+	return timestamps[i:j], b.seqs[i:j], b.values[i:j]
 }
 
 // MarshalPortable marshals b to dst, so it could be portably migrated to other VictoriaMetrics instance.
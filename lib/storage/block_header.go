@@ -81,6 +81,14 @@ func (bh *blockHeader) Less(src *blockHeader) bool {
 	return bh.TSID.Less(&src.TSID)
 }
 
+// convertMsecTimestamps converts MinTimestamp and MaxTimestamp from milliseconds to nanoseconds.
+//
+// It is used for block headers from parts with millisecond timestamps.
+func (bh *blockHeader) convertMsecTimestamps() {
+	bh.MinTimestamp *= nsecPerMsec
+	bh.MaxTimestamp *= nsecPerMsec
+}
+
 // marshaledBlockHeaderSize is the size of marshaled block header.
 var marshaledBlockHeaderSize = func() int {
 	var bh blockHeader
@@ -158,8 +166,8 @@ func (bh *blockHeader) validate() error {
 	if bh.TimestampsBlockSize > 2*maxBlockSize {
 		return fmt.Errorf("too big TimestampsBlockSize; got %d; cannot exceed %d", bh.TimestampsBlockSize, 2*maxBlockSize)
 	}
-	if bh.ValuesBlockSize > 2*maxBlockSize {
-		return fmt.Errorf("too big ValuesBlockSize; got %d; cannot exceed %d", bh.ValuesBlockSize, 2*maxBlockSize)
+	if bh.ValuesBlockSize > maxValuesBlockSize {
+		return fmt.Errorf("too big ValuesBlockSize; got %d; cannot exceed %d", bh.ValuesBlockSize, maxValuesBlockSize)
 	}
 	return nil
 }
@@ -33,6 +33,12 @@ type blockStreamReader struct {
 
 	indexReader filestream.ReadCloser
 
+	// bloomsReader and bloomsIndexReader contain per-block bloom filters.
+	//
+	// They are nil for parts created before bloom filters were introduced.
+	bloomsReader      filestream.ReadCloser
+	bloomsIndexReader filestream.ReadCloser
+
 	mrs []metaindexRow
 
 	// Points the current mr from mrs.
@@ -50,12 +56,14 @@ type blockStreamReader struct {
 	timestampsBlockOffset uint64
 	valuesBlockOffset     uint64
 	indexBlockOffset      uint64
+	bloomsBlockOffset     uint64
 
 	prevTimestampsBlockOffset uint64
 	prevTimestampsData        []byte
 
 	indexData           []byte
 	compressedIndexData []byte
+	bloomIndexData      []byte
 
 	// Cursor to indexData.
 	indexCursor []byte
@@ -78,6 +86,8 @@ func (bsr *blockStreamReader) reset() {
 	bsr.timestampsReader = nil
 	bsr.valuesReader = nil
 	bsr.indexReader = nil
+	bsr.bloomsReader = nil
+	bsr.bloomsIndexReader = nil
 
 	bsr.mrs = bsr.mrs[:0]
 	bsr.mr = nil
@@ -89,12 +99,14 @@ func (bsr *blockStreamReader) reset() {
 	bsr.timestampsBlockOffset = 0
 	bsr.valuesBlockOffset = 0
 	bsr.indexBlockOffset = 0
+	bsr.bloomsBlockOffset = 0
 
 	bsr.prevTimestampsBlockOffset = 0
 	bsr.prevTimestampsData = bsr.prevTimestampsData[:0]
 
 	bsr.indexData = bsr.indexData[:0]
 	bsr.compressedIndexData = bsr.compressedIndexData[:0]
+	bsr.bloomIndexData = bsr.bloomIndexData[:0]
 
 	bsr.indexCursor = nil
 
@@ -117,6 +129,8 @@ func (bsr *blockStreamReader) InitFromInmemoryPart(mp *inmemoryPart) {
 	bsr.timestampsReader = mp.timestampsData.NewReader()
 	bsr.valuesReader = mp.valuesData.NewReader()
 	bsr.indexReader = mp.indexData.NewReader()
+	bsr.bloomsReader = mp.bloomsData.NewReader()
+	bsr.bloomsIndexReader = mp.bloomsIndexData.NewReader()
 
 	var err error
 	bsr.mrs, err = unmarshalMetaindexRows(bsr.mrs[:0], mp.metaindexData.NewReader())
@@ -178,10 +192,40 @@ func (bsr *blockStreamReader) InitFromFilePart(path string) error {
 		return fmt.Errorf("cannot unmarshal metaindex rows from inmemoryPart: %w", err)
 	}
 
+	// Bloom filters are optional, since they are missing in parts created by older releases.
+	var bloomsFile, bloomsIndexFile filestream.ReadCloser
+	bloomsPath := path + "/blooms.bin"
+	bloomsIndexPath := path + "/bloomsindex.bin"
+	if fs.IsPathExist(bloomsPath) && fs.IsPathExist(bloomsIndexPath) {
+		bloomsFile, err = filestream.Open(bloomsPath, true)
+		if err != nil {
+			timestampsFile.MustClose()
+			valuesFile.MustClose()
+			indexFile.MustClose()
+			return fmt.Errorf("cannot open blooms file in stream mode: %w", err)
+		}
+		bloomsIndexFile, err = filestream.Open(bloomsIndexPath, true)
+		if err != nil {
+			timestampsFile.MustClose()
+			valuesFile.MustClose()
+			indexFile.MustClose()
+			bloomsFile.MustClose()
+			return fmt.Errorf("cannot open blooms index file in stream mode: %w", err)
+		}
+	}
+
+	if bsr.ph.msecTimestamps {
+		for i := range mrs {
+			mrs[i].convertMsecTimestamps()
+		}
+	}
+
 	bsr.path = path
 	bsr.timestampsReader = timestampsFile
 	bsr.valuesReader = valuesFile
 	bsr.indexReader = indexFile
+	bsr.bloomsReader = bloomsFile
+	bsr.bloomsIndexReader = bloomsIndexFile
 	bsr.mrs = mrs
 
 	bsr.assertWriteClosers()
@@ -196,6 +240,10 @@ func (bsr *blockStreamReader) MustClose() {
 	bsr.timestampsReader.(filestream.ReadCloser).MustClose()
 	bsr.valuesReader.(filestream.ReadCloser).MustClose()
 	bsr.indexReader.MustClose()
+	if bsr.bloomsReader != nil {
+		bsr.bloomsReader.MustClose()
+		bsr.bloomsIndexReader.MustClose()
+	}
 
 	bsr.reset()
 }
@@ -262,6 +310,9 @@ func (bsr *blockStreamReader) readBlock() error {
 	if len(tail) > 0 {
 		return fmt.Errorf("non-empty tail left after parsing block header at offset %d: %x", bsr.prevIndexBlockOffset(), tail)
 	}
+	if bsr.ph.msecTimestamps {
+		bsr.Block.bh.convertMsecTimestamps()
+	}
 
 	bsr.blocksCount++
 	if bsr.blocksCount > bsr.ph.BlocksCount {
@@ -314,6 +365,13 @@ func (bsr *blockStreamReader) readBlock() error {
 		return fmt.Errorf("cannot read values block at offset %d: %w", bsr.valuesBlockOffset, err)
 	}
 
+	// Read bloom filter data, so it could be reused if the block passes through the merge unchanged.
+	if bsr.bloomsReader != nil {
+		if err := bsr.readBloomData(); err != nil {
+			return err
+		}
+	}
+
 	// Update offsets.
 	if !usePrevTimestamps {
 		bsr.timestampsBlockOffset += uint64(bsr.Block.bh.TimestampsBlockSize)
@@ -321,6 +379,35 @@ func (bsr *blockStreamReader) readBlock() error {
 	bsr.valuesBlockOffset += uint64(bsr.Block.bh.ValuesBlockSize)
 	bsr.indexBlockHeadersCount++
 
+	if bsr.ph.msecTimestamps {
+		// Convert timestamps to nanoseconds, so they are stored in nanoseconds after the merge.
+		if err := bsr.Block.convertMsecTimestamps(); err != nil {
+			return fmt.Errorf("cannot convert timestamps for block at offset %d: %w", bsr.prevIndexBlockOffset(), err)
+		}
+	}
+
+	return nil
+}
+
+func (bsr *blockStreamReader) readBloomData() error {
+	bsr.bloomIndexData = bytesutil.Resize(bsr.bloomIndexData, bloomsIndexRowSize)
+	if err := fs.ReadFullData(bsr.bloomsIndexReader, bsr.bloomIndexData); err != nil {
+		return fmt.Errorf("cannot read blooms index row for block #%d: %w", bsr.blocksCount, err)
+	}
+	offset := encoding.UnmarshalUint64(bsr.bloomIndexData)
+	size := encoding.UnmarshalUint32(bsr.bloomIndexData[8:])
+	if offset != bsr.bloomsBlockOffset {
+		return fmt.Errorf("invalid bloom filter offset for block #%d; got %d; want %d", bsr.blocksCount, offset, bsr.bloomsBlockOffset)
+	}
+	if size > maxBloomFilterBits/8 {
+		return fmt.Errorf("too big bloom filter size for block #%d; got %d bytes; cannot exceed %d bytes", bsr.blocksCount, size, maxBloomFilterBits/8)
+	}
+	bsr.Block.bloomData = bytesutil.Resize(bsr.Block.bloomData, int(size))
+	if err := fs.ReadFullData(bsr.bloomsReader, bsr.Block.bloomData); err != nil {
+		return fmt.Errorf("cannot read bloom filter at offset %d: %w", offset, err)
+	}
+	bsr.Block.hasBloomData = true
+	bsr.bloomsBlockOffset += uint64(size)
 	return nil
 }
 
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/encodingext"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/filestream"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
@@ -25,14 +26,17 @@ type blockStreamWriter struct {
 	timestampsWriter io.Writer
 	valuesWriter     io.Writer
 
-	indexWriter     filestream.WriteCloser
-	metaindexWriter filestream.WriteCloser
+	indexWriter       filestream.WriteCloser
+	metaindexWriter   filestream.WriteCloser
+	bloomsWriter      filestream.WriteCloser
+	bloomsIndexWriter filestream.WriteCloser
 
 	mr metaindexRow
 
 	timestampsBlockOffset uint64
 	valuesBlockOffset     uint64
 	indexBlockOffset      uint64
+	bloomsBlockOffset     uint64
 
 	indexData           []byte
 	compressedIndexData []byte
@@ -46,6 +50,11 @@ type blockStreamWriter struct {
 	// since such metrics have identical timestamps.
 	prevTimestampsData        []byte
 	prevTimestampsBlockOffset uint64
+
+	bf             bloomFilter
+	bloomValues    [][]byte
+	bloomData      []byte
+	bloomIndexData []byte
 }
 
 func (bsw *blockStreamWriter) assertWriteClosers() {
@@ -62,12 +71,15 @@ func (bsw *blockStreamWriter) reset() {
 	bsw.valuesWriter = nil
 	bsw.indexWriter = nil
 	bsw.metaindexWriter = nil
+	bsw.bloomsWriter = nil
+	bsw.bloomsIndexWriter = nil
 
 	bsw.mr.Reset()
 
 	bsw.timestampsBlockOffset = 0
 	bsw.valuesBlockOffset = 0
 	bsw.indexBlockOffset = 0
+	bsw.bloomsBlockOffset = 0
 
 	bsw.indexData = bsw.indexData[:0]
 	bsw.compressedIndexData = bsw.compressedIndexData[:0]
@@ -77,6 +89,14 @@ func (bsw *blockStreamWriter) reset() {
 
 	bsw.prevTimestampsData = bsw.prevTimestampsData[:0]
 	bsw.prevTimestampsBlockOffset = 0
+
+	bsw.bf.reset()
+	for i := range bsw.bloomValues {
+		bsw.bloomValues[i] = nil
+	}
+	bsw.bloomValues = bsw.bloomValues[:0]
+	bsw.bloomData = bsw.bloomData[:0]
+	bsw.bloomIndexData = bsw.bloomIndexData[:0]
 }
 
 // InitFromInmemoryPart initialzes bsw from inmemory part.
@@ -91,6 +111,8 @@ func (bsw *blockStreamWriter) InitFromInmemoryPart(mp *inmemoryPart) {
 	bsw.valuesWriter = &mp.valuesData
 	bsw.indexWriter = &mp.indexData
 	bsw.metaindexWriter = &mp.metaindexData
+	bsw.bloomsWriter = &mp.bloomsData
+	bsw.bloomsIndexWriter = &mp.bloomsIndexData
 
 	bsw.assertWriteClosers()
 }
@@ -143,6 +165,29 @@ func (bsw *blockStreamWriter) InitFromFilePart(path string, nocache bool, compre
 		return fmt.Errorf("cannot create metaindex file: %w", err)
 	}
 
+	bloomsPath := path + "/blooms.bin"
+	bloomsFile, err := filestream.Create(bloomsPath, nocache)
+	if err != nil {
+		timestampsFile.MustClose()
+		valuesFile.MustClose()
+		indexFile.MustClose()
+		metaindexFile.MustClose()
+		fs.MustRemoveAll(path)
+		return fmt.Errorf("cannot create blooms file: %w", err)
+	}
+
+	bloomsIndexPath := path + "/bloomsindex.bin"
+	bloomsIndexFile, err := filestream.Create(bloomsIndexPath, nocache)
+	if err != nil {
+		timestampsFile.MustClose()
+		valuesFile.MustClose()
+		indexFile.MustClose()
+		metaindexFile.MustClose()
+		bloomsFile.MustClose()
+		fs.MustRemoveAll(path)
+		return fmt.Errorf("cannot create blooms index file: %w", err)
+	}
+
 	bsw.reset()
 	bsw.compressLevel = compressLevel
 	bsw.path = path
@@ -151,6 +196,8 @@ func (bsw *blockStreamWriter) InitFromFilePart(path string, nocache bool, compre
 	bsw.valuesWriter = valuesFile
 	bsw.indexWriter = indexFile
 	bsw.metaindexWriter = metaindexFile
+	bsw.bloomsWriter = bloomsFile
+	bsw.bloomsIndexWriter = bloomsIndexFile
 
 	bsw.assertWriteClosers()
 
@@ -173,6 +220,8 @@ func (bsw *blockStreamWriter) MustClose() {
 	bsw.valuesWriter.(filestream.WriteCloser).MustClose()
 	bsw.indexWriter.MustClose()
 	bsw.metaindexWriter.MustClose()
+	bsw.bloomsWriter.MustClose()
+	bsw.bloomsIndexWriter.MustClose()
 
 	// Sync bsw.path contents to make sure it doesn't disappear
 	// after system crash or power loss.
@@ -187,6 +236,7 @@ func (bsw *blockStreamWriter) MustClose() {
 func (bsw *blockStreamWriter) WriteExternalBlock(b *Block, ph *partHeader, rowsMerged *uint64) {
 	atomic.AddUint64(rowsMerged, uint64(b.rowsCount()))
 	b.deduplicateSamplesDuringMerge()
+	bsw.writeBloomFilter(b)
 	headerData, timestampsData, valuesData := b.MarshalData(bsw.timestampsBlockOffset, bsw.valuesBlockOffset)
 	usePrevTimestamps := len(bsw.prevTimestampsData) > 0 && bytes.Equal(timestampsData, bsw.prevTimestampsData)
 	if usePrevTimestamps {
@@ -212,9 +262,52 @@ func (bsw *blockStreamWriter) WriteExternalBlock(b *Block, ph *partHeader, rowsM
 	updatePartHeader(b, ph)
 }
 
+// writeBloomFilter writes bloom filter for b values to bsw.
+//
+// Bloom filters are written in the same order as block headers,
+// so the bloom filter for the N-th block in the part is referred
+// by the N-th row in bloomsindex.bin.
+//
+// The bloom filter from the source part is written as is if b has it.
+// This is safe, since the merge may only remove rows from such a block.
+func (bsw *blockStreamWriter) writeBloomFilter(b *Block) {
+	if b.hasBloomData {
+		atomic.AddUint64(&bloomFiltersReused, 1)
+		bsw.writeBloomData(b.bloomData)
+		return
+	}
+	values := b.values
+	if len(values) == 0 {
+		// The block is already marshaled. Unmarshal values from it.
+		var err error
+		bsw.bloomValues, err = encodingext.UnmarshalValues(bsw.bloomValues[:0], b.valuesData, b.bh.ValuesMarshalType, int(b.bh.RowsCount))
+		if err != nil {
+			// Write an empty bloom filter, which matches everything.
+			logger.Errorf("cannot unmarshal values for building bloom filter for block with TSID=%+v: %s", &b.bh.TSID, err)
+			bsw.bloomValues = bsw.bloomValues[:0]
+		}
+		values = bsw.bloomValues
+	} else {
+		values = values[b.nextIdx:]
+	}
+	bsw.bf.initFromLines(values)
+	bsw.bloomData = bsw.bf.Marshal(bsw.bloomData[:0])
+	bsw.writeBloomData(bsw.bloomData)
+}
+
+func (bsw *blockStreamWriter) writeBloomData(bloomData []byte) {
+	bsw.bloomIndexData = encoding.MarshalUint64(bsw.bloomIndexData[:0], bsw.bloomsBlockOffset)
+	bsw.bloomIndexData = encoding.MarshalUint32(bsw.bloomIndexData, uint32(len(bloomData)))
+	fs.MustWriteData(bsw.bloomsIndexWriter, bsw.bloomIndexData)
+	fs.MustWriteData(bsw.bloomsWriter, bloomData)
+	bsw.bloomsBlockOffset += uint64(len(bloomData))
+}
+
 var (
 	timestampsBlocksMerged uint64
 	timestampsBytesSaved   uint64
+
+	bloomFiltersReused uint64
 )
 
 func updatePartHeader(b *Block, ph *partHeader) {
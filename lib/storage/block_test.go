@@ -19,6 +19,39 @@ func TestBlockMarshalUnmarshalPortable(t *testing.T) {
 	}
 }
 
+func TestBlockConvertMsecTimestamps(t *testing.T) {
+	var b Block
+	for i := 0; i < 100; i++ {
+		b.Reset()
+		rowsCount := rand.Intn(maxRowsPerBlock) + 1
+		timestamps := getRandTimestamps(rowsCount)
+		b.timestamps = append(b.timestamps[:0], timestamps...)
+		b.values = getRandValues(rowsCount)
+		b.bh.PrecisionBits = 64
+		b.MarshalData(0, 0)
+
+		b.bh.convertMsecTimestamps()
+		if err := b.convertMsecTimestamps(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := b.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal block with converted timestamps: %s", err)
+		}
+		for j, ts := range timestamps {
+			timestamps[j] = ts * 1e6
+		}
+		if !reflect.DeepEqual(b.timestamps, timestamps) {
+			t.Fatalf("unexpected timestamps after conversion;\ngot\n%d\nwant\n%d", b.timestamps, timestamps)
+		}
+		if b.bh.MinTimestamp != timestamps[0] {
+			t.Fatalf("unexpected MinTimestamp; got %d; want %d", b.bh.MinTimestamp, timestamps[0])
+		}
+		if b.bh.MaxTimestamp != timestamps[len(timestamps)-1] {
+			t.Fatalf("unexpected MaxTimestamp; got %d; want %d", b.bh.MaxTimestamp, timestamps[len(timestamps)-1])
+		}
+	}
+}
+
 func testBlockMarshalUnmarshalPortable(t *testing.T, b *Block) {
 	var b1, b2 Block
 	b1.CopyFrom(b)
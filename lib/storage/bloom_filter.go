@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// Per-block bloom filters contain byte n-grams of all the log lines in the block.
+//
+// Byte n-grams are used instead of words, since LogQL line filters such as `|= "foo"`
+// match arbitrary substrings. Every n-gram of a substring is an n-gram of the line
+// containing this substring, so a block may be safely skipped if its bloom filter
+// doesn't contain at least a single n-gram from the `|=` filter value.
+const (
+	// bloomNgramLen is the length of byte n-grams stored in bloom filters.
+	bloomNgramLen = 4
+
+	// bloomHashesCount is the number of bits set in bloom filter per n-gram.
+	bloomHashesCount = 4
+
+	// maxBloomFilterBits is the maximum number of bits in a single bloom filter.
+	//
+	// The bloom filter is built with the size depending on the number of n-grams
+	// in the block, but no more than maxBloomFilterBits. Then it is folded in halves
+	// while its fill ratio remains small enough.
+	maxBloomFilterBits = 8 * maxBlockSize
+
+	// minBloomFilterBits is the minimum number of bits in a single bloom filter.
+	minBloomFilterBits = 64
+
+	// maxBloomFilterFillRatio is the maximum share of set bits in the stored bloom filter.
+	//
+	// Bloom filters with bigger fill ratio aren't stored, since they are useless for skipping blocks.
+	maxBloomFilterFillRatio = 0.5
+
+	// targetBloomFilterFillRatio is the fill ratio to stop folding the bloom filter at.
+	//
+	// It gives ~1% false positive rate for bloomHashesCount=4.
+	targetBloomFilterFillRatio = 0.33
+)
+
+// bloomsIndexRowSize is the size of a row in bloomsindex.bin.
+//
+// Every row contains uint64 offset and uint32 size of the bloom filter
+// in blooms.bin for the corresponding block in the part.
+const bloomsIndexRowSize = 8 + 4
+
+// bloomRef refers to a bloom filter in blooms.bin.
+type bloomRef struct {
+	offset uint64
+	size   uint32
+}
+
+// unmarshalBloomRefs appends bloomRefs unmarshaled from bloomsindex.bin data in src to dst and returns the result.
+func unmarshalBloomRefs(dst []bloomRef, src []byte) ([]bloomRef, error) {
+	if len(src)%bloomsIndexRowSize != 0 {
+		return dst, fmt.Errorf("unexpected blooms index data size; got %d bytes; it must be multiple of %d", len(src), bloomsIndexRowSize)
+	}
+	for len(src) > 0 {
+		dst = append(dst, bloomRef{
+			offset: encoding.UnmarshalUint64(src),
+			size:   encoding.UnmarshalUint32(src[8:]),
+		})
+		src = src[bloomsIndexRowSize:]
+	}
+	return dst, nil
+}
+
+// bloomFilter is a bloom filter for byte n-grams of log lines.
+//
+// Empty bloom filter matches everything. It is used for blocks
+// with too many distinct n-grams.
+type bloomFilter struct {
+	bits []uint64
+}
+
+func (bf *bloomFilter) reset() {
+	bf.bits = bf.bits[:0]
+}
+
+// initFromLines initializes bf from n-grams of the given lines.
+func (bf *bloomFilter) initFromLines(lines [][]byte) {
+	bf.reset()
+
+	buf := getBloomFilterBuf(getBloomFilterBitsLen(lines) / 64)
+	a := buf.bits
+	for _, line := range lines {
+		for i := 0; i+bloomNgramLen <= len(line); i++ {
+			h := bloomNgramHash(line[i:])
+			bloomFilterSet(a, h)
+		}
+	}
+
+	setBits := 0
+	for _, x := range a {
+		setBits += bits.OnesCount64(x)
+	}
+	if setBits == 0 || float64(setBits)/float64(len(a)*64) > maxBloomFilterFillRatio {
+		// There are no n-grams or there are too many n-grams in the block.
+		putBloomFilterBuf(buf)
+		return
+	}
+
+	// Fold a in halves while the fill ratio remains small.
+	// This is safe, since the bit index is calculated as h & (bitsLen - 1),
+	// where bitsLen is a power of two.
+	for len(a) > 1 {
+		n := len(a) / 2
+		setBits = 0
+		for i := 0; i < n; i++ {
+			setBits += bits.OnesCount64(a[i] | a[i+n])
+		}
+		if float64(setBits)/float64(n*64) > targetBloomFilterFillRatio {
+			break
+		}
+		for i := 0; i < n; i++ {
+			a[i] |= a[i+n]
+		}
+		a = a[:n]
+	}
+	bf.bits = append(bf.bits[:0], a...)
+	putBloomFilterBuf(buf)
+}
+
+// getBloomFilterBitsLen returns the number of bits for the bloom filter holding n-grams of the given lines.
+//
+// The number of bits is chosen so the fill ratio doesn't exceed targetBloomFilterFillRatio
+// even if all the n-grams are distinct. The returned value is a power of two
+// in the range [minBloomFilterBits ... maxBloomFilterBits].
+func getBloomFilterBitsLen(lines [][]byte) int {
+	ngramsCount := 0
+	for _, line := range lines {
+		if len(line) >= bloomNgramLen {
+			ngramsCount += len(line) - bloomNgramLen + 1
+		}
+	}
+	// The expected fill ratio for m bits and n items is 1 - exp(-k*n/m),
+	// so m = -k*n/ln(1 - fillRatio).
+	bitsNeeded := -float64(bloomHashesCount*ngramsCount) / math.Log(1-targetBloomFilterFillRatio)
+	if bitsNeeded >= maxBloomFilterBits {
+		return maxBloomFilterBits
+	}
+	bitsLen := minBloomFilterBits
+	for float64(bitsLen) < bitsNeeded {
+		bitsLen *= 2
+	}
+	return bitsLen
+}
+
+// containsAll returns true if bf may contain all the given hashes.
+func (bf *bloomFilter) containsAll(hashes []uint64) bool {
+	if len(bf.bits) == 0 {
+		return true
+	}
+	maxBits := uint64(len(bf.bits)) * 64
+	for _, h := range hashes {
+		h1 := h
+		h2 := (h >> 32) | 1
+		for i := 0; i < bloomHashesCount; i++ {
+			idx := h1 & (maxBits - 1)
+			if bf.bits[idx/64]&(1<<(idx%64)) == 0 {
+				return false
+			}
+			h1 += h2
+		}
+	}
+	return true
+}
+
+// Marshal appends marshaled bf to dst and returns the result.
+func (bf *bloomFilter) Marshal(dst []byte) []byte {
+	for _, x := range bf.bits {
+		dst = encoding.MarshalUint64(dst, x)
+	}
+	return dst
+}
+
+// Unmarshal unmarshals bf from src.
+func (bf *bloomFilter) Unmarshal(src []byte) error {
+	if len(src)%8 != 0 {
+		return fmt.Errorf("unexpected bloom filter size; got %d bytes; it must be multiple of 8", len(src))
+	}
+	n := len(src) / 8
+	if n&(n-1) != 0 {
+		return fmt.Errorf("unexpected number of words in bloom filter; got %d; it must be power of two", n)
+	}
+	bf.reset()
+	for len(src) > 0 {
+		bf.bits = append(bf.bits, encoding.UnmarshalUint64(src))
+		src = src[8:]
+	}
+	return nil
+}
+
+func bloomFilterSet(a []uint64, h uint64) {
+	maxBits := uint64(len(a)) * 64
+	h1 := h
+	h2 := (h >> 32) | 1
+	for i := 0; i < bloomHashesCount; i++ {
+		idx := h1 & (maxBits - 1)
+		a[idx/64] |= 1 << (idx % 64)
+		h1 += h2
+	}
+}
+
+// bloomNgramHash returns hash for the n-gram at the start of b.
+func bloomNgramHash(b []byte) uint64 {
+	// Use splitmix64 finalizer over the n-gram bytes.
+	// It is much faster than general-purpose hash functions for such short inputs.
+	x := uint64(binary.LittleEndian.Uint32(b))
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// appendBloomHashes appends sorted unique n-gram hashes for lfs to dst and returns the result.
+//
+// Only `|=` filters are taken into account, since only they require
+// the presence of the given substring in the line.
+func appendBloomHashes(dst []uint64, lfs []lineFilter) []uint64 {
+	dstLen := len(dst)
+	for i := range lfs {
+		lf := &lfs[i]
+		if lf.isNegative || lf.re != nil {
+			continue
+		}
+		for j := 0; j+bloomNgramLen <= len(lf.value); j++ {
+			dst = append(dst, bloomNgramHash(lf.value[j:]))
+		}
+	}
+	hashes := dst[dstLen:]
+	if len(hashes) == 0 {
+		return dst
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	n := 1
+	for _, h := range hashes[1:] {
+		if h != hashes[n-1] {
+			hashes[n] = h
+			n++
+		}
+	}
+	return dst[:dstLen+n]
+}
+
+type bloomFilterBuf struct {
+	bits []uint64
+}
+
+// getBloomFilterBuf returns zeroed bloomFilterBuf with the given number of words.
+func getBloomFilterBuf(wordsLen int) *bloomFilterBuf {
+	v := bloomFilterBufPool.Get()
+	if v == nil {
+		v = &bloomFilterBuf{}
+	}
+	buf := v.(*bloomFilterBuf)
+	if n := wordsLen - cap(buf.bits); n > 0 {
+		buf.bits = append(buf.bits[:cap(buf.bits)], make([]uint64, n)...)
+	}
+	buf.bits = buf.bits[:wordsLen]
+	return buf
+}
+
+// putBloomFilterBuf returns buf to the pool.
+//
+// Only the first len(buf.bits) words are cleared, since the remaining words
+// are kept zeroed by getBloomFilterBuf.
+func putBloomFilterBuf(buf *bloomFilterBuf) {
+	for i := range buf.bits {
+		buf.bits[i] = 0
+	}
+	bloomFilterBufPool.Put(buf)
+}
+
+var bloomFilterBufPool sync.Pool
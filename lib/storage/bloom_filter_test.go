@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBloomFilterContainsAll(t *testing.T) {
+	f := func(lines []string, filter string, resultExpected bool) {
+		t.Helper()
+		var a [][]byte
+		for _, line := range lines {
+			a = append(a, []byte(line))
+		}
+		var bf bloomFilter
+		bf.initFromLines(a)
+
+		// Verify the bloom filter survives marshaling.
+		data := bf.Marshal(nil)
+		var bf2 bloomFilter
+		if err := bf2.Unmarshal(data); err != nil {
+			t.Fatalf("cannot unmarshal bloom filter: %s", err)
+		}
+
+		lfs, err := NewLineFilters([]LineFilter{{Value: []byte(filter)}})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := bf2.containsAll(lfs.getBloomHashes())
+		if result != resultExpected {
+			t.Fatalf("unexpected result for filter %q; got %v; want %v", filter, result, resultExpected)
+		}
+	}
+
+	lines := []string{
+		"GET /api/v1/query trace_id=4bf92f3577b34da6 status=200",
+		"POST /api/v1/push trace_id=00f067aa0ba902b7 status=204",
+	}
+
+	// Substrings of the lines
+	f(lines, "trace_id=4bf92f", true)
+	f(lines, "race_i", true)
+	f(lines, "/push", true)
+	f(lines, lines[0], true)
+
+	// Too short filters match everything
+	f(lines, "xyz", true)
+	f(lines, "", true)
+
+	// Missing substrings
+	f(lines, "trace_id=deadbeef", false)
+	f(lines, "status=500", false)
+
+	// Substrings spanning multiple lines
+	f(lines, "status=200POST", false)
+
+	// Empty bloom filter matches everything
+	f(nil, "foobar", true)
+	f([]string{"foo"}, "foobar", true)
+}
+
+func TestBloomFilterFolding(t *testing.T) {
+	var lines [][]byte
+	for i := 0; i < 10; i++ {
+		lines = append(lines, []byte(fmt.Sprintf("line number %d", i)))
+	}
+	var bf bloomFilter
+	bf.initFromLines(lines)
+	if len(bf.bits) == 0 {
+		t.Fatalf("expecting non-empty bloom filter")
+	}
+	if len(bf.bits) >= maxBloomFilterBits/64 {
+		t.Fatalf("expecting folded bloom filter for a small number of lines; got %d words", len(bf.bits))
+	}
+}
+
+func TestBloomFilterUnmarshalError(t *testing.T) {
+	f := func(data []byte) {
+		t.Helper()
+		var bf bloomFilter
+		if err := bf.Unmarshal(data); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	f([]byte("foo"))
+	f(make([]byte, 3*8))
+}
+
+func TestPartSearchWithBloomFilters(t *testing.T) {
+	var rows []rawRow
+	for i := 0; i < maxRowsPerBlock; i++ {
+		r := rawRow{
+			Timestamp:     int64(i),
+			PrecisionBits: defaultPrecisionBits,
+		}
+		r.TSID.MetricID = uint64(i % 3)
+		r.Value = []byte(fmt.Sprintf("tsid=%d row=%d", r.TSID.MetricID, i))
+		rows = append(rows, r)
+	}
+	p := newTestPart(rows)
+	if !p.hasBlooms() {
+		t.Fatalf("expecting part with bloom filters")
+	}
+	tsids := []TSID{{MetricID: 0}, {MetricID: 1}, {MetricID: 2}}
+	tr := TimeRange{
+		MinTimestamp: 0,
+		MaxTimestamp: int64(len(rows)),
+	}
+
+	f := func(filter string, blocksExpected int) {
+		t.Helper()
+		lfs, err := NewLineFilters([]LineFilter{{Value: []byte(filter)}})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var ps partSearch
+		ps.Init(p, tsids, tr, lfs)
+		blocks := 0
+		for ps.NextBlock() {
+			blocks++
+		}
+		if err := ps.Error(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if blocks != blocksExpected {
+			t.Fatalf("unexpected number of blocks found for %q; got %d; want %d", filter, blocks, blocksExpected)
+		}
+	}
+
+	// Blocks for all the tsids
+	f("row=", 3)
+
+	// Blocks for a single tsid
+	f("tsid=1 ", 1)
+
+	// Missing substring
+	f("missing", 0)
+}
+
+func TestGetBloomFilterBitsLen(t *testing.T) {
+	f := func(lines []string, bitsLenExpected int) {
+		t.Helper()
+		var a [][]byte
+		for _, line := range lines {
+			a = append(a, []byte(line))
+		}
+		bitsLen := getBloomFilterBitsLen(a)
+		if bitsLen != bitsLenExpected {
+			t.Fatalf("unexpected bits len; got %d; want %d", bitsLen, bitsLenExpected)
+		}
+	}
+	f(nil, minBloomFilterBits)
+	f([]string{"foo", ""}, minBloomFilterBits)
+	f([]string{"foobar"}, minBloomFilterBits)
+
+	// 1000 n-grams need ~10K bits
+	f([]string{strings.Repeat("x", 1000+bloomNgramLen-1)}, 16*1024)
+	f([]string{strings.Repeat("x", 500+bloomNgramLen-1), strings.Repeat("y", 500+bloomNgramLen-1)}, 16*1024)
+
+	// Too many n-grams
+	f([]string{strings.Repeat("x", maxBloomFilterBits)}, maxBloomFilterBits)
+}
+
+func TestMergeBlockStreamsReuseBloomFilters(t *testing.T) {
+	newRows := func(metricID uint64, minTimestamp int64, prefix string) []rawRow {
+		var rows []rawRow
+		for i := 0; i < 10; i++ {
+			r := rawRow{
+				Timestamp:     minTimestamp + int64(i),
+				Value:         []byte(fmt.Sprintf("%s line %d", prefix, i)),
+				PrecisionBits: defaultPrecisionBits,
+			}
+			r.TSID.MetricID = metricID
+			rows = append(rows, r)
+		}
+		return rows
+	}
+	// The first two streams contain overlapping blocks for the same TSID, so they must be merged.
+	// The block from the third stream must pass through the merge unchanged.
+	rows1 := newRows(1, 0, "foo")
+	rows2 := newRows(1, 5, "bar")
+	rows3 := newRows(2, 0, "baz")
+	bsrs := []*blockStreamReader{
+		newTestBlockStreamReader(t, rows1),
+		newTestBlockStreamReader(t, rows2),
+		newTestBlockStreamReader(t, rows3),
+	}
+
+	var mp inmemoryPart
+	var bsw blockStreamWriter
+	bsw.InitFromInmemoryPart(&mp)
+	var rowsMerged, rowsDeleted uint64
+	reusedPrev := atomic.LoadUint64(&bloomFiltersReused)
+	if err := mergeBlockStreams(&mp.ph, &bsw, bsrs, nil, nil, nil, &rowsMerged, &rowsDeleted); err != nil {
+		t.Fatalf("unexpected error in mergeBlockStreams: %s", err)
+	}
+	if n := atomic.LoadUint64(&bloomFiltersReused) - reusedPrev; n != 1 {
+		t.Fatalf("unexpected number of reused bloom filters; got %d; want 1", n)
+	}
+
+	// Verify the bloom filters in the merged part contain n-grams for all the rows in the corresponding blocks.
+	var bsr blockStreamReader
+	bsr.InitFromInmemoryPart(&mp)
+	var bf bloomFilter
+	var hashes []uint64
+	blocks := 0
+	for bsr.NextBlock() {
+		blocks++
+		if !bsr.Block.hasBloomData {
+			t.Fatalf("missing bloom filter for block #%d", blocks)
+		}
+		if err := bf.Unmarshal(bsr.Block.bloomData); err != nil {
+			t.Fatalf("cannot unmarshal bloom filter: %s", err)
+		}
+		if err := bsr.Block.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal block: %s", err)
+		}
+		for _, v := range bsr.Block.values {
+			hashes = hashes[:0]
+			for i := 0; i+bloomNgramLen <= len(v); i++ {
+				hashes = append(hashes, bloomNgramHash(v[i:]))
+			}
+			if !bf.containsAll(hashes) {
+				t.Fatalf("bloom filter for block #%d doesn't contain n-grams for %q", blocks, v)
+			}
+		}
+	}
+	if err := bsr.Error(); err != nil {
+		t.Fatalf("unexpected error when reading merged stream: %s", err)
+	}
+	if blocks != 2 {
+		t.Fatalf("unexpected number of blocks; got %d; want 2", blocks)
+	}
+}
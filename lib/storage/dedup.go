@@ -2,6 +2,8 @@ package storage
 
 import (
 	"time"
+
+	xxhash "github.com/cespare/xxhash/v2"
 )
 
 // SetMinScrapeIntervalForDeduplication sets the minimum interval for data points during de-duplication.
@@ -10,13 +12,30 @@ import (
 //
 // This function must be called before initializing the storage.
 func SetMinScrapeIntervalForDeduplication(interval time.Duration) {
-	minScrapeInterval = interval.Milliseconds()
+	minScrapeInterval = interval.Nanoseconds()
 }
 
 var minScrapeInterval = int64(0)
 
+// SetExactDeduplication enables or disables de-duplication of exact duplicate log lines.
+//
+// Log lines are exact duplicates if they have identical timestamps and contents.
+// Such lines may appear because of replicated writes or retries from log shippers.
+//
+// This function must be called before initializing the storage.
+func SetExactDeduplication(enabled bool) {
+	exactDedup = enabled
+}
+
+var exactDedup = false
+
 // DeduplicateSamples removes samples from src* if they are closer to each other than minScrapeInterval.
+//
+// It also removes exact duplicates from src* if exact de-duplication is enabled via SetExactDeduplication.
 func DeduplicateSamples(srcTimestamps []int64, srcValues []float64, srcDatas [][]byte) ([]int64, []float64, [][]byte) {
+	if exactDedup && len(srcDatas) == len(srcTimestamps) && hasDuplicateTimestamps(srcTimestamps) {
+		srcTimestamps, srcValues, srcDatas = deduplicateExactSamples(srcTimestamps, srcValues, srcDatas)
+	}
 	if minScrapeInterval <= 0 {
 		return srcTimestamps, srcValues, srcDatas
 	}
@@ -49,18 +68,28 @@ func DeduplicateSamples(srcTimestamps []int64, srcValues []float64, srcDatas [][
 	return dstTimestamps, dstValues, dstDatas
 }
 
-func deduplicateSamplesDuringMerge(srcTimestamps []int64, srcValues [][]byte) ([]int64, [][]byte) {
+// deduplicateSamplesDuringMerge works like DeduplicateSamples for blocks being merged.
+//
+// srcSeqs may be empty if the block has no ingestion sequence numbers.
+func deduplicateSamplesDuringMerge(srcTimestamps []int64, srcSeqs []uint64, srcValues [][]byte) ([]int64, []uint64, [][]byte) {
+	if exactDedup && hasDuplicateTimestamps(srcTimestamps) {
+		srcTimestamps, srcSeqs, srcValues = deduplicateExactSamplesDuringMerge(srcTimestamps, srcSeqs, srcValues)
+	}
 	if minScrapeInterval <= 0 {
-		return srcTimestamps, srcValues
+		return srcTimestamps, srcSeqs, srcValues
 	}
 	if !needsDedup(srcTimestamps, minScrapeInterval) {
 		// Fast path - nothing to deduplicate
-		return srcTimestamps, srcValues
+		return srcTimestamps, srcSeqs, srcValues
 	}
 
 	// Slow path - dedup data points.
 	tsNext := (srcTimestamps[0] - srcTimestamps[0]%minScrapeInterval) + minScrapeInterval
 	dstTimestamps := srcTimestamps[:1]
+	dstSeqs := srcSeqs[:0]
+	if len(srcSeqs) > 0 {
+		dstSeqs = srcSeqs[:1]
+	}
 	dstValues := srcValues[:1]
 	for i := 1; i < len(srcTimestamps); i++ {
 		ts := srcTimestamps[i]
@@ -68,6 +97,9 @@ func deduplicateSamplesDuringMerge(srcTimestamps []int64, srcValues [][]byte) ([
 			continue
 		}
 		dstTimestamps = append(dstTimestamps, ts)
+		if len(srcSeqs) > 0 {
+			dstSeqs = append(dstSeqs, srcSeqs[i])
+		}
 		dstValues = append(dstValues, srcValues[i])
 
 		// Update tsNext
@@ -77,7 +109,119 @@ func deduplicateSamplesDuringMerge(srcTimestamps []int64, srcValues [][]byte) ([
 			tsNext = (ts - ts%minScrapeInterval) + minScrapeInterval
 		}
 	}
-	return dstTimestamps, dstValues
+	return dstTimestamps, dstSeqs, dstValues
+}
+
+// deduplicateExactSamples removes samples with identical timestamps and datas from src*.
+//
+// srcTimestamps must be sorted.
+func deduplicateExactSamples(srcTimestamps []int64, srcValues []float64, srcDatas [][]byte) ([]int64, []float64, [][]byte) {
+	dstTimestamps := srcTimestamps[:0]
+	dstValues := srcValues[:0]
+	dstDatas := srcDatas[:0]
+	// groupStart is the index of the first sample in dst* with the current timestamp.
+	groupStart := 0
+	var ds exactDedupSet
+	for i, ts := range srcTimestamps {
+		if len(dstTimestamps) == 0 || dstTimestamps[len(dstTimestamps)-1] != ts {
+			groupStart = len(dstTimestamps)
+			ds.reset()
+		} else if ds.contains(dstDatas, groupStart, srcDatas[i]) {
+			continue
+		}
+		dstTimestamps = append(dstTimestamps, ts)
+		dstValues = append(dstValues, srcValues[i])
+		dstDatas = append(dstDatas, srcDatas[i])
+	}
+	return dstTimestamps, dstValues, dstDatas
+}
+
+// deduplicateExactSamplesDuringMerge removes samples with identical timestamps and values from src*.
+//
+// Ingestion sequence numbers aren't taken into account, since duplicates are usually ingested at different times.
+// srcSeqs may be empty. srcTimestamps must be sorted.
+func deduplicateExactSamplesDuringMerge(srcTimestamps []int64, srcSeqs []uint64, srcValues [][]byte) ([]int64, []uint64, [][]byte) {
+	dstTimestamps := srcTimestamps[:0]
+	dstSeqs := srcSeqs[:0]
+	dstValues := srcValues[:0]
+	// groupStart is the index of the first sample in dst* with the current timestamp.
+	groupStart := 0
+	var ds exactDedupSet
+	for i, ts := range srcTimestamps {
+		if len(dstTimestamps) == 0 || dstTimestamps[len(dstTimestamps)-1] != ts {
+			groupStart = len(dstTimestamps)
+			ds.reset()
+		} else if ds.contains(dstValues, groupStart, srcValues[i]) {
+			continue
+		}
+		dstTimestamps = append(dstTimestamps, ts)
+		if len(srcSeqs) > 0 {
+			dstSeqs = append(dstSeqs, srcSeqs[i])
+		}
+		dstValues = append(dstValues, srcValues[i])
+	}
+	return dstTimestamps, dstSeqs, dstValues
+}
+
+// exactDedupSet is a set of distinct datas for samples with identical timestamps.
+type exactDedupSet struct {
+	// m maps xxhash of data to the index of the first data with this hash in datas passed to contains.
+	m map[uint64]int
+}
+
+func (ds *exactDedupSet) reset() {
+	for h := range ds.m {
+		delete(ds.m, h)
+	}
+}
+
+// contains returns true if datas[groupStart:] contain data.
+//
+// Otherwise data is registered in ds under the index len(datas),
+// so the caller must append data to datas.
+func (ds *exactDedupSet) contains(datas [][]byte, groupStart int, data []byte) bool {
+	if ds.m == nil {
+		ds.m = make(map[uint64]int)
+	}
+	if len(ds.m) == 0 {
+		// Register the datas for the current group lazily,
+		// since the majority of groups contain a single sample.
+		for j := groupStart; j < len(datas); j++ {
+			h := xxhash.Sum64(datas[j])
+			if _, ok := ds.m[h]; !ok {
+				ds.m[h] = j
+			}
+		}
+	}
+	h := xxhash.Sum64(data)
+	j, ok := ds.m[h]
+	if !ok {
+		ds.m[h] = len(datas)
+		return false
+	}
+	if string(datas[j]) == string(data) {
+		return true
+	}
+	// Hash collision. Fall back to the linear scan.
+	return containsData(datas[groupStart:], data)
+}
+
+func containsData(datas [][]byte, data []byte) bool {
+	for _, d := range datas {
+		if string(d) == string(data) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDuplicateTimestamps(timestamps []int64) bool {
+	for i := 1; i < len(timestamps); i++ {
+		if timestamps[i] == timestamps[i-1] {
+			return true
+		}
+	}
+	return false
 }
 
 func needsDedup(timestamps []int64, minDelta int64) bool {
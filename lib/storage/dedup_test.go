@@ -2,9 +2,12 @@ package storage
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
+
+	xxhash "github.com/cespare/xxhash/v2"
 )
 
 func TestDeduplicateSamples(t *testing.T) {
@@ -48,13 +51,13 @@ func TestDeduplicateSamples(t *testing.T) {
 			t.Fatalf("superflouos timestamps found starting from index %d: %v", j, timestampsCopy[j:])
 		}
 	}
-	f(time.Millisecond, nil, []int64{})
-	f(time.Millisecond, []int64{123}, []int64{123})
-	f(time.Millisecond, []int64{123, 456}, []int64{123, 456})
-	f(time.Millisecond, []int64{0, 0, 0, 1, 1, 2, 3, 3, 3, 4}, []int64{0, 1, 2, 3, 4})
+	f(time.Nanosecond, nil, []int64{})
+	f(time.Nanosecond, []int64{123}, []int64{123})
+	f(time.Nanosecond, []int64{123, 456}, []int64{123, 456})
+	f(time.Nanosecond, []int64{0, 0, 0, 1, 1, 2, 3, 3, 3, 4}, []int64{0, 1, 2, 3, 4})
 	f(0, []int64{0, 0, 0, 1, 1, 2, 3, 3, 3, 4}, []int64{0, 0, 0, 1, 1, 2, 3, 3, 3, 4})
-	f(100*time.Millisecond, []int64{0, 100, 100, 101, 150, 180, 205, 300, 1000}, []int64{0, 100, 205, 300, 1000})
-	f(10*time.Second, []int64{10e3, 13e3, 21e3, 22e3, 30e3, 33e3, 39e3, 45e3}, []int64{10e3, 21e3, 30e3, 45e3})
+	f(100*time.Nanosecond, []int64{0, 100, 100, 101, 150, 180, 205, 300, 1000}, []int64{0, 100, 205, 300, 1000})
+	f(10*time.Microsecond, []int64{10e3, 13e3, 21e3, 22e3, 30e3, 33e3, 39e3, 45e3}, []int64{10e3, 21e3, 30e3, 45e3})
 }
 
 func TestDeduplicateSamplesDuringMerge(t *testing.T) {
@@ -70,7 +73,7 @@ func TestDeduplicateSamplesDuringMerge(t *testing.T) {
 			timestampsCopy[i] = ts
 			values[i] = []byte{byte(i)}
 		}
-		timestampsCopy, values = deduplicateSamplesDuringMerge(timestampsCopy, values)
+		timestampsCopy, _, values = deduplicateSamplesDuringMerge(timestampsCopy, nil, values)
 		if !reflect.DeepEqual(timestampsCopy, timestampsExpected) {
 			t.Fatalf("invalid deduplicateSamplesDuringMerge(%v) result;\ngot\n%v\nwant\n%v", timestamps, timestampsCopy, timestampsExpected)
 		}
@@ -98,12 +101,12 @@ func TestDeduplicateSamplesDuringMerge(t *testing.T) {
 			t.Fatalf("superflouos timestamps found starting from index %d: %v", j, timestampsCopy[j:])
 		}
 	}
-	f(time.Millisecond, nil, []int64{})
-	f(time.Millisecond, []int64{123}, []int64{123})
-	f(time.Millisecond, []int64{123, 456}, []int64{123, 456})
-	f(time.Millisecond, []int64{0, 0, 0, 1, 1, 2, 3, 3, 3, 4}, []int64{0, 1, 2, 3, 4})
-	f(100*time.Millisecond, []int64{0, 100, 100, 101, 150, 180, 200, 300, 1000}, []int64{0, 100, 200, 300, 1000})
-	f(10*time.Second, []int64{10e3, 13e3, 21e3, 22e3, 30e3, 33e3, 39e3, 45e3}, []int64{10e3, 21e3, 30e3, 45e3})
+	f(time.Nanosecond, nil, []int64{})
+	f(time.Nanosecond, []int64{123}, []int64{123})
+	f(time.Nanosecond, []int64{123, 456}, []int64{123, 456})
+	f(time.Nanosecond, []int64{0, 0, 0, 1, 1, 2, 3, 3, 3, 4}, []int64{0, 1, 2, 3, 4})
+	f(100*time.Nanosecond, []int64{0, 100, 100, 101, 150, 180, 200, 300, 1000}, []int64{0, 100, 200, 300, 1000})
+	f(10*time.Microsecond, []int64{10e3, 13e3, 21e3, 22e3, 30e3, 33e3, 39e3, 45e3}, []int64{10e3, 21e3, 30e3, 45e3})
 
 	var timestamps, timestampsExpected []int64
 	for i := 0; i < 40; i++ {
@@ -113,6 +116,91 @@ func TestDeduplicateSamplesDuringMerge(t *testing.T) {
 		}
 	}
 	f(0, timestamps, timestamps)
-	f(time.Second, timestamps, timestamps)
-	f(2*time.Second, timestamps, timestampsExpected)
+	f(time.Microsecond, timestamps, timestamps)
+	f(2*time.Microsecond, timestamps, timestampsExpected)
+}
+
+func TestDeduplicateSamplesExact(t *testing.T) {
+	// Disable deduplication before exit, since the rest of tests expect disabled dedup.
+	defer SetExactDeduplication(false)
+
+	f := func(scrapeInterval time.Duration, timestamps []int64, datas []string, resultExpected []string) {
+		t.Helper()
+		SetExactDeduplication(true)
+		SetMinScrapeIntervalForDeduplication(scrapeInterval)
+		defer SetMinScrapeIntervalForDeduplication(0)
+
+		timestampsCopy := append([]int64{}, timestamps...)
+		values := make([]float64, len(timestamps))
+		var datasCopy [][]byte
+		for _, data := range datas {
+			datasCopy = append(datasCopy, []byte(data))
+		}
+		timestampsCopy, values, datasCopy = DeduplicateSamples(timestampsCopy, values, datasCopy)
+		if len(values) != len(timestampsCopy) || len(datasCopy) != len(timestampsCopy) {
+			t.Fatalf("unexpected number of values and datas; got %d and %d; want %d", len(values), len(datasCopy), len(timestampsCopy))
+		}
+		result := []string{}
+		for i, ts := range timestampsCopy {
+			result = append(result, fmt.Sprintf("%d %s", ts, datasCopy[i]))
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result;\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+
+		// deduplicateSamplesDuringMerge must return the same result.
+		timestampsCopy = append(timestampsCopy[:0], timestamps...)
+		datasCopy = datasCopy[:0]
+		for _, data := range datas {
+			datasCopy = append(datasCopy, []byte(data))
+		}
+		timestampsCopy, _, datasCopy = deduplicateSamplesDuringMerge(timestampsCopy, nil, datasCopy)
+		result = result[:0]
+		for i, ts := range timestampsCopy {
+			result = append(result, fmt.Sprintf("%d %s", ts, datasCopy[i]))
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected result during merge;\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	f(0, nil, nil, []string{})
+	f(0, []int64{1}, []string{"foo"}, []string{"1 foo"})
+
+	// Distinct lines with identical timestamps are kept
+	f(0, []int64{1, 1, 1, 2}, []string{"foo", "bar", "baz", "foo"}, []string{"1 foo", "1 bar", "1 baz", "2 foo"})
+
+	// Exact duplicates are removed
+	f(0, []int64{1, 1, 2, 2, 3}, []string{"foo", "foo", "bar", "bar", "bar"}, []string{"1 foo", "2 bar", "3 bar"})
+
+	// Non-adjacent exact duplicates with identical timestamps are removed
+	f(0, []int64{1, 1, 1, 1, 2}, []string{"foo", "bar", "foo", "bar", "bar"}, []string{"1 foo", "1 bar", "2 bar"})
+
+	// Exact dedup is applied before dedup by interval
+	f(10*time.Nanosecond, []int64{1, 1, 5, 12}, []string{"foo", "foo", "bar", "baz"}, []string{"1 foo", "12 baz"})
+
+	// Duplicates in many groups with identical timestamps
+	f(0, []int64{1, 1, 2, 2, 2, 2, 3, 3}, []string{"foo", "bar", "bar", "foo", "bar", "foo", "", ""},
+		[]string{"1 foo", "1 bar", "2 bar", "2 foo", "3 "})
+}
+
+func TestExactDedupSetHashCollision(t *testing.T) {
+	datas := [][]byte{[]byte("foo"), []byte("bar")}
+	var ds exactDedupSet
+	if ds.contains(datas, 0, []byte("baz")) {
+		t.Fatalf("unexpected data found in the set")
+	}
+	datas = append(datas, []byte("baz"))
+
+	// Simulate hash collision for "bar" and "baz" by pointing the hash of "baz" to "bar".
+	ds.m[xxhash.Sum64([]byte("baz"))] = 1
+	if !ds.contains(datas, 0, []byte("baz")) {
+		t.Fatalf("expecting data to be found in the set after hash collision")
+	}
+
+	// The next group must start with an empty set.
+	ds.reset()
+	if ds.contains(datas, len(datas), []byte("foo")) {
+		t.Fatalf("unexpected data found outside the group")
+	}
 }
@@ -11,7 +11,7 @@ func BenchmarkDeduplicateSamples(b *testing.B) {
 	timestamps := make([]int64, blockSize)
 	values := make([]float64, blockSize)
 	for i := 0; i < len(timestamps); i++ {
-		timestamps[i] = int64(i) * 1e3
+		timestamps[i] = int64(i) * 1e9
 	}
 	for _, minScrapeInterval := range []time.Duration{time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second} {
 		b.Run(fmt.Sprintf("minScrapeInterval=%s", minScrapeInterval), func(b *testing.B) {
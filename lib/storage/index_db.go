@@ -106,6 +106,9 @@ type indexDB struct {
 	// Start date fully covered by per-day inverted index.
 	startDateForPerDayInvertedIndex uint64
 
+	// The number of flushes for the added items to persistent storage.
+	flushesCount uint64
+
 	name string
 	tb   *mergeset.Table
 
@@ -156,11 +159,6 @@ func openIndexDB(path string, metricIDCache, metricNameCache, tsidCache *working
 		logger.Panicf("BUG: tsidCache must be nin-nil")
 	}
 
-	tb, err := mergeset.OpenTable(path, invalidateTagCache, mergeTagToMetricIDsRows)
-	if err != nil {
-		return nil, fmt.Errorf("cannot open indexDB %q: %w", path, err)
-	}
-
 	name := filepath.Base(path)
 
 	// Do not persist tagCache in files, since it is very volatile.
@@ -168,7 +166,6 @@ func openIndexDB(path string, metricIDCache, metricNameCache, tsidCache *working
 
 	db := &indexDB{
 		refCount: 1,
-		tb:       tb,
 		name:     name,
 
 		tagCache:                       workingsetcache.New(mem/32, time.Hour),
@@ -179,6 +176,12 @@ func openIndexDB(path string, metricIDCache, metricNameCache, tsidCache *working
 		metricIDsPerDateTagFilterCache: workingsetcache.New(mem/128, time.Hour),
 	}
 
+	tb, err := mergeset.OpenTable(path, db.flushCallback, mergeTagToMetricIDsRows)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open indexDB %q: %w", path, err)
+	}
+	db.tb = tb
+
 	is := db.getIndexSearch(0, 0, noDeadline)
 	dmis, err := is.loadDeletedMetricIDs()
 	db.putIndexSearch(is)
@@ -439,8 +442,8 @@ func marshalTagFiltersKey(dst []byte, tfss []*TagFilters, tr TimeRange, versione
 		prefix = atomic.LoadUint64(&tagFiltersKeyGen)
 	}
 	// Round start and end times to per-day granularity according to per-day inverted index.
-	startDate := uint64(tr.MinTimestamp) / msecPerDay
-	endDate := uint64(tr.MaxTimestamp) / msecPerDay
+	startDate := uint64(tr.MinTimestamp) / nsecPerDay
+	endDate := uint64(tr.MaxTimestamp) / nsecPerDay
 	dst = encoding.MarshalUint64(dst, prefix)
 	dst = encoding.MarshalUint64(dst, startDate)
 	dst = encoding.MarshalUint64(dst, endDate)
@@ -458,6 +461,31 @@ func marshalTagFiltersKey(dst []byte, tfss []*TagFilters, tr TimeRange, versione
 	return dst
 }
 
+func (db *indexDB) flushCallback() {
+	invalidateTagCache()
+	atomic.AddUint64(&db.flushesCount, 1)
+}
+
+// getFlushesCount returns the number of flushes for the items added to db to persistent storage.
+func (db *indexDB) getFlushesCount() uint64 {
+	return atomic.LoadUint64(&db.flushesCount)
+}
+
+// hasFlushedItemsSince returns true if the items added to db before getFlushesCount call, which returned flushesCount,
+// are flushed to persistent storage.
+func (db *indexDB) hasFlushedItemsSince(flushesCount uint64) bool {
+	// mergeset.Table flushes all the pending items on every flush, so the items added before the first flush
+	// after getFlushesCount call are flushed by the second flush at the latest.
+	if db.getFlushesCount() >= flushesCount+2 {
+		return true
+	}
+
+	// Items may be left unflushed only if there are pending items or active flushes.
+	var m mergeset.TableMetrics
+	db.tb.UpdateMetrics(&m)
+	return m.PendingItems == 0 && m.ActiveMerges == 0
+}
+
 func invalidateTagCache() {
 	// This function must be fast, since it is called each
 	// time new timeseries is added.
@@ -932,8 +960,8 @@ func (db *indexDB) SearchTagValueSuffixes(accountID, projectID uint32, tr TimeRa
 }
 
 func (is *indexSearch) searchTagValueSuffixesForTimeRange(tvss map[string]struct{}, tr TimeRange, tagKey, tagValuePrefix []byte, delimiter byte, maxTagValueSuffixes int) error {
-	minDate := uint64(tr.MinTimestamp) / msecPerDay
-	maxDate := uint64(tr.MaxTimestamp) / msecPerDay
+	minDate := uint64(tr.MinTimestamp) / nsecPerDay
+	maxDate := uint64(tr.MaxTimestamp) / nsecPerDay
 	if maxDate-minDate > maxDaysForDateMetricIDs {
 		return is.searchTagValueSuffixesAll(tvss, tagKey, tagValuePrefix, delimiter, maxTagValueSuffixes)
 	}
@@ -1648,7 +1676,7 @@ func (is *indexSearch) containsTimeRange(tr TimeRange) (bool, error) {
 	kb := &is.kb
 
 	// Verify whether the maximum date in `ts` covers tr.MinTimestamp.
-	minDate := uint64(tr.MinTimestamp) / msecPerDay
+	minDate := uint64(tr.MinTimestamp) / nsecPerDay
 	kb.B = is.marshalCommonPrefix(kb.B[:0], nsPrefixDateToMetricID)
 	prefix := kb.B
 	kb.B = encoding.MarshalUint64(kb.B, minDate)
@@ -2474,8 +2502,8 @@ var errMissingMetricIDsForDate = errors.New("missing metricIDs for date")
 
 func (is *indexSearch) getMetricIDsForTimeRange(tr TimeRange, maxMetrics int) (*uint64set.Set, error) {
 	atomic.AddUint64(&is.db.dateMetricIDsSearchCalls, 1)
-	minDate := uint64(tr.MinTimestamp) / msecPerDay
-	maxDate := uint64(tr.MaxTimestamp) / msecPerDay
+	minDate := uint64(tr.MinTimestamp) / nsecPerDay
+	maxDate := uint64(tr.MaxTimestamp) / nsecPerDay
 	if maxDate-minDate > maxDaysForDateMetricIDs {
 		// Too much dates must be covered. Give up.
 		return nil, errMissingMetricIDsForDate
@@ -2529,8 +2557,8 @@ const maxDaysForDateMetricIDs = 40
 
 func (is *indexSearch) tryUpdatingMetricIDsForDateRange(metricIDs *uint64set.Set, tfs *TagFilters, tr TimeRange, maxMetrics int) error {
 	atomic.AddUint64(&is.db.dateRangeSearchCalls, 1)
-	minDate := uint64(tr.MinTimestamp) / msecPerDay
-	maxDate := uint64(tr.MaxTimestamp) / msecPerDay
+	minDate := uint64(tr.MinTimestamp) / nsecPerDay
+	maxDate := uint64(tr.MaxTimestamp) / nsecPerDay
 	if minDate < is.db.startDateForPerDayInvertedIndex || maxDate < minDate {
 		// Per-day inverted index doesn't cover the selected date range.
 		return errFallbackToMetricNameMatch
@@ -712,7 +712,7 @@ func testIndexDBGetOrCreateTSIDByName(db *indexDB, accountsCount, projectsCount,
 	}
 
 	// fill Date -> MetricID cache
-	date := uint64(timestampFromTime(time.Now())) / msecPerDay
+	date := uint64(timestampFromTime(time.Now())) / nsecPerDay
 	for i := range tsids {
 		tsid := &tsids[i]
 		is.accountID = tsid.AccountID
@@ -851,8 +851,8 @@ func testIndexDBCheckTSIDByName(db *indexDB, mns []MetricName, tsids []TSID, isC
 	// Try tag filters.
 	currentTime := timestampFromTime(time.Now())
 	tr := TimeRange{
-		MinTimestamp: currentTime - msecPerDay,
-		MaxTimestamp: currentTime + msecPerDay,
+		MinTimestamp: currentTime - nsecPerDay,
+		MaxTimestamp: currentTime + nsecPerDay,
 	}
 	for i := range mns {
 		mn := &mns[i]
@@ -1554,7 +1554,7 @@ func TestSearchTSIDWithTimeRange(t *testing.T) {
 	const metricsPerDay = 1000
 	theDay := time.Date(2019, time.October, 15, 5, 1, 0, 0, time.UTC)
 	now := uint64(timestampFromTime(theDay))
-	baseDate := now / msecPerDay
+	baseDate := now / nsecPerDay
 	var metricNameBuf []byte
 	for day := 0; day < days; day++ {
 		var tsids []TSID
@@ -1613,7 +1613,7 @@ func TestSearchTSIDWithTimeRange(t *testing.T) {
 	// Perform a search within a day.
 	// This should return the metrics for the day
 	tr := TimeRange{
-		MinTimestamp: int64(now - 2*msecPerHour - 1),
+		MinTimestamp: int64(now - 2*nsecPerHour - 1),
 		MaxTimestamp: int64(now),
 	}
 	matchedTSIDs, err := db.searchTSIDs([]*TagFilters{tfs}, tr, 10000, noDeadline)
@@ -1626,7 +1626,7 @@ func TestSearchTSIDWithTimeRange(t *testing.T) {
 
 	// Perform a search across all the days, should match all metrics
 	tr = TimeRange{
-		MinTimestamp: int64(now - msecPerDay*days),
+		MinTimestamp: int64(now - nsecPerDay*days),
 		MaxTimestamp: int64(now),
 	}
 
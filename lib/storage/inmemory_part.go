@@ -17,6 +17,9 @@ type inmemoryPart struct {
 	indexData      bytesutil.ByteBuffer
 	metaindexData  bytesutil.ByteBuffer
 
+	bloomsData      bytesutil.ByteBuffer
+	bloomsIndexData bytesutil.ByteBuffer
+
 	creationTime uint64
 }
 
@@ -28,6 +31,8 @@ func (mp *inmemoryPart) Reset() {
 	mp.valuesData.Reset()
 	mp.indexData.Reset()
 	mp.metaindexData.Reset()
+	mp.bloomsData.Reset()
+	mp.bloomsIndexData.Reset()
 
 	mp.creationTime = 0
 }
@@ -51,8 +56,9 @@ func (mp *inmemoryPart) InitFromRows(rows []rawRow) {
 // It is unsafe re-using mp while the returned part is in use.
 func (mp *inmemoryPart) NewPart() (*part, error) {
 	ph := mp.ph
-	size := uint64(len(mp.timestampsData.B) + len(mp.valuesData.B) + len(mp.indexData.B) + len(mp.metaindexData.B))
-	return newPart(&ph, "", size, mp.metaindexData.NewReader(), &mp.timestampsData, &mp.valuesData, &mp.indexData)
+	size := uint64(len(mp.timestampsData.B) + len(mp.valuesData.B) + len(mp.indexData.B) + len(mp.metaindexData.B) +
+		len(mp.bloomsData.B) + len(mp.bloomsIndexData.B))
+	return newPart(&ph, "", size, mp.metaindexData.NewReader(), &mp.timestampsData, &mp.valuesData, &mp.indexData, &mp.bloomsData, &mp.bloomsIndexData)
 }
 
 func getInmemoryPart() *inmemoryPart {
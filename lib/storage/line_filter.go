@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/VictoriaMetrics/VictoriaLogs/lib/logql"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+)
+
+// LineFilter represents a single LogQL line filter from SearchQuery.
+//
+// The following LogQL operators are supported:
+//
+//   - `|= "value"` - IsNegative=false, IsRegexp=false
+//   - `!= "value"` - IsNegative=true, IsRegexp=false
+//   - `|~ "value"` - IsNegative=false, IsRegexp=true
+//   - `!~ "value"` - IsNegative=true, IsRegexp=true
+type LineFilter struct {
+	Value      []byte
+	IsNegative bool
+	IsRegexp   bool
+}
+
+// String returns string representation of lf.
+func (lf *LineFilter) String() string {
+	var bb bytesutil.ByteBuffer
+	fmt.Fprintf(&bb, "{Value=%q, IsNegative: %v, IsRegexp: %v}", lf.Value, lf.IsNegative, lf.IsRegexp)
+	return string(bb.B)
+}
+
+// Marshal appends marshaled lf to dst and returns the result.
+func (lf *LineFilter) Marshal(dst []byte) []byte {
+	dst = encoding.MarshalBytes(dst, lf.Value)
+
+	x := 0
+	if lf.IsNegative {
+		x = 2
+	}
+	if lf.IsRegexp {
+		x |= 1
+	}
+	dst = append(dst, byte(x))
+
+	return dst
+}
+
+// Unmarshal unmarshals lf from src and returns the tail.
+func (lf *LineFilter) Unmarshal(src []byte) ([]byte, error) {
+	tail, v, err := encoding.UnmarshalBytes(src)
+	if err != nil {
+		return tail, fmt.Errorf("cannot unmarshal Value: %w", err)
+	}
+	lf.Value = append(lf.Value[:0], v...)
+	src = tail
+
+	if len(src) < 1 {
+		return src, fmt.Errorf("cannot unmarshal IsNegative+IsRegexp from empty src")
+	}
+	x := src[0]
+	if x > 3 {
+		return src, fmt.Errorf("unexpected value for IsNegative+IsRegexp: %d; must be in the range [0..3]", x)
+	}
+	lf.IsNegative = x&2 != 0
+	lf.IsRegexp = x&1 != 0
+	src = src[1:]
+
+	return src, nil
+}
+
+// LineFilters is a compiled chain of line filters.
+//
+// A line matches LineFilters if it matches all the filters in the chain.
+type LineFilters struct {
+	lfs []lineFilter
+
+	// bloomHashes contains sorted n-gram hashes, which must be present
+	// in the per-block bloom filter for the block to contain matching lines.
+	bloomHashes []uint64
+}
+
+type lineFilter struct {
+	value      []byte
+	re         *regexp.Regexp
+	isNegative bool
+}
+
+// NewLineFilters returns compiled LineFilters for the given src.
+func NewLineFilters(src []LineFilter) (*LineFilters, error) {
+	var lfs LineFilters
+	for i := range src {
+		lf := &src[i]
+		var re *regexp.Regexp
+		if lf.IsRegexp {
+			var err error
+			re, err = logql.CompileRegexp(string(lf.Value))
+			if err != nil {
+				return nil, fmt.Errorf("cannot compile regexp for line filter %s: %w", lf, err)
+			}
+		}
+		lfs.lfs = append(lfs.lfs, lineFilter{
+			value:      append([]byte{}, lf.Value...),
+			re:         re,
+			isNegative: lf.IsNegative,
+		})
+	}
+	lfs.bloomHashes = appendBloomHashes(nil, lfs.lfs)
+	return &lfs, nil
+}
+
+// Len returns the number of filters in lfs.
+func (lfs *LineFilters) Len() int {
+	return len(lfs.lfs)
+}
+
+func (lfs *LineFilters) getBloomHashes() []uint64 {
+	if lfs == nil {
+		return nil
+	}
+	return lfs.bloomHashes
+}
+
+// Match returns true if line matches all the filters from lfs.
+func (lfs *LineFilters) Match(line []byte) bool {
+	for i := range lfs.lfs {
+		lf := &lfs.lfs[i]
+		var ok bool
+		if lf.re != nil {
+			ok = lf.re.Match(line)
+		} else {
+			ok = bytes.Contains(line, lf.value)
+		}
+		if ok == lf.isNegative {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterLines leaves only rows with values matching lfs in b.
+//
+// It returns false if no rows are left in b after the filtering.
+// Otherwise b is marshaled again, so it may be passed to MarshalBlock.
+//
+// b must contain both timestamps and values data, i.e. it must be read with fetchData=2.
+func (b *Block) FilterLines(lfs *LineFilters) (bool, error) {
+	if err := b.UnmarshalData(true); err != nil {
+		return false, fmt.Errorf("cannot unmarshal block for line filtering: %w", err)
+	}
+	timestamps := b.timestamps[:0]
+	seqs := b.seqs[:0]
+	values := b.values[:0]
+	for i, v := range b.values {
+		if !lfs.Match(v) {
+			continue
+		}
+		timestamps = append(timestamps, b.timestamps[i])
+		if len(b.seqs) > 0 {
+			seqs = append(seqs, b.seqs[i])
+		}
+		values = append(values, v)
+	}
+	b.timestamps = timestamps
+	b.seqs = seqs
+	b.values = values
+	if len(values) == 0 {
+		return false, nil
+	}
+	b.MarshalData(0, 0)
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestLineFiltersMatch(t *testing.T) {
+	f := func(lfs []LineFilter, line string, resultExpected bool) {
+		t.Helper()
+		m, err := NewLineFilters(lfs)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := m.Match([]byte(line))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for line %q; got %v; want %v", line, result, resultExpected)
+		}
+	}
+
+	// Empty filters match everything
+	f(nil, "", true)
+	f(nil, "foo", true)
+
+	// Contains
+	f([]LineFilter{{Value: []byte("bar")}}, "foobarbaz", true)
+	f([]LineFilter{{Value: []byte("bar")}}, "foobaz", false)
+
+	// Not contains
+	f([]LineFilter{{Value: []byte("bar"), IsNegative: true}}, "foobarbaz", false)
+	f([]LineFilter{{Value: []byte("bar"), IsNegative: true}}, "foobaz", true)
+
+	// Regexp
+	f([]LineFilter{{Value: []byte("b.r"), IsRegexp: true}}, "foobxrbaz", true)
+	f([]LineFilter{{Value: []byte("^bar"), IsRegexp: true}}, "foobar", false)
+
+	// Negative regexp
+	f([]LineFilter{{Value: []byte("b.r"), IsRegexp: true, IsNegative: true}}, "foobxrbaz", false)
+	f([]LineFilter{{Value: []byte("^bar"), IsRegexp: true, IsNegative: true}}, "foobar", true)
+
+	// Chain of filters
+	chain := []LineFilter{
+		{Value: []byte("error")},
+		{Value: []byte("timeout"), IsNegative: true},
+		{Value: []byte("code=5\\d\\d"), IsRegexp: true},
+	}
+	f(chain, "error: code=503", true)
+	f(chain, "error: code=404", false)
+	f(chain, "error: timeout code=503", false)
+	f(chain, "info: code=503", false)
+}
+
+func TestNewLineFiltersError(t *testing.T) {
+	_, err := NewLineFilters([]LineFilter{{Value: []byte("foo("), IsRegexp: true}})
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func TestBlockFilterLines(t *testing.T) {
+	var b Block
+	b.bh.PrecisionBits = 64
+	b.timestamps = []int64{10, 20, 30, 40}
+	b.values = [][]byte{[]byte("foo"), []byte("bar"), []byte("foobar"), []byte("baz")}
+	b.MarshalData(0, 0)
+
+	lfs, err := NewLineFilters([]LineFilter{{Value: []byte("foo")}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ok, err := b.FilterLines(lfs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ok {
+		t.Fatalf("expecting non-empty block after filtering")
+	}
+	if b.RowsCount() != 2 {
+		t.Fatalf("unexpected rows count; got %d; want %d", b.RowsCount(), 2)
+	}
+	if err := b.UnmarshalData(true); err != nil {
+		t.Fatalf("cannot unmarshal filtered block: %s", err)
+	}
+	if b.timestamps[0] != 10 || b.timestamps[1] != 30 {
+		t.Fatalf("unexpected timestamps: %d", b.timestamps)
+	}
+	if string(b.values[0]) != "foo" || string(b.values[1]) != "foobar" {
+		t.Fatalf("unexpected values: %q", b.values)
+	}
+	b.MarshalData(0, 0)
+
+	lfs, err = NewLineFilters([]LineFilter{{Value: []byte("missing")}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ok, err = b.FilterLines(lfs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ok {
+		t.Fatalf("expecting empty block after filtering")
+	}
+}
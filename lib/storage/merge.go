@@ -12,14 +12,16 @@ import (
 //
 // mergeBlockStreams returns immediately if stopCh is closed.
 //
+// Blocks outside the retention according to rd are dropped. rd may be nil.
+//
 // rowsMerged is atomically updated with the number of merged rows during the merge.
 func mergeBlockStreams(ph *partHeader, bsw *blockStreamWriter, bsrs []*blockStreamReader, stopCh <-chan struct{},
-	dmis *uint64set.Set, retentionDeadline int64, rowsMerged, rowsDeleted *uint64) error {
+	dmis *uint64set.Set, rd *retentionDeadlines, rowsMerged, rowsDeleted *uint64) error {
 	ph.Reset()
 
 	bsm := bsmPool.Get().(*blockStreamMerger)
 	bsm.Init(bsrs)
-	err := mergeBlockStreamsInternal(ph, bsw, bsm, stopCh, dmis, retentionDeadline, rowsMerged, rowsDeleted)
+	err := mergeBlockStreamsInternal(ph, bsw, bsm, stopCh, dmis, rd, rowsMerged, rowsDeleted)
 	bsm.reset()
 	bsmPool.Put(bsm)
 	bsw.MustClose()
@@ -38,7 +40,7 @@ var bsmPool = &sync.Pool{
 var errForciblyStopped = fmt.Errorf("forcibly stopped")
 
 func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *blockStreamMerger, stopCh <-chan struct{},
-	dmis *uint64set.Set, retentionDeadline int64, rowsMerged, rowsDeleted *uint64) error {
+	dmis *uint64set.Set, rd *retentionDeadlines, rowsMerged, rowsDeleted *uint64) error {
 	// Search for the first block to merge
 	var pendingBlock *Block
 	for bsm.NextBlock() {
@@ -52,7 +54,7 @@ func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *bloc
 			*rowsDeleted += uint64(bsm.Block.bh.RowsCount)
 			continue
 		}
-		if bsm.Block.bh.MaxTimestamp < retentionDeadline {
+		if rd.isExpired(&bsm.Block.bh) {
 			// Skip blocks out of the given retention.
 			*rowsDeleted += uint64(bsm.Block.bh.RowsCount)
 			continue
@@ -79,7 +81,7 @@ func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *bloc
 			*rowsDeleted += uint64(bsm.Block.bh.RowsCount)
 			continue
 		}
-		if bsm.Block.bh.MaxTimestamp < retentionDeadline {
+		if rd.isExpired(&bsm.Block.bh) {
 			// skip blocks out of the given retention.
 			*rowsDeleted += uint64(bsm.Block.bh.RowsCount)
 			continue
@@ -96,8 +98,9 @@ func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *bloc
 			pendingBlock.CopyFrom(bsm.Block)
 			continue
 		}
-		if pendingBlock.tooBig() && pendingBlock.bh.MaxTimestamp <= bsm.Block.bh.MinTimestamp {
+		if pendingBlock.tooBig() && pendingBlock.bh.MaxTimestamp < bsm.Block.bh.MinTimestamp {
 			// Fast path - pendingBlock is too big and it doesn't overlap with bsm.Block.
+			// Blocks with identical boundary timestamps must be merged in order to preserve ingestion order for these timestamps.
 			// Write the pendingBlock and then deal with bsm.Block.
 			bsw.WriteExternalBlock(pendingBlock, ph, rowsMerged)
 			pendingBlock.CopyFrom(bsm.Block)
@@ -113,24 +116,29 @@ func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *bloc
 		tmpBlock.bh.TSID = bsm.Block.bh.TSID
 		tmpBlock.bh.PrecisionBits = minUint8(pendingBlock.bh.PrecisionBits, bsm.Block.bh.PrecisionBits)
 		mergeBlocks(tmpBlock, pendingBlock, bsm.Block)
-		if len(tmpBlock.timestamps) <= maxRowsPerBlock {
-			// More entries may be added to tmpBlock. Swap it with pendingBlock,
-			// so more entries may be added to pendingBlock on the next iteration.
+		for {
+			n := tmpBlock.blockRowsCount()
+			if n >= len(tmpBlock.timestamps) {
+				// More entries may be added to tmpBlock. Swap it with pendingBlock,
+				// so more entries may be added to pendingBlock on the next iteration.
+				tmpBlock.fixupTimestamps()
+				pendingBlock, tmpBlock = tmpBlock, pendingBlock
+				break
+			}
+
+			// Write the first n rows of tmpBlock to bsw, so the block fits maxRowsPerBlock and maxBlockSize.
+			// Leave the rest in tmpBlock, since it may still exceed the limits because of big values.
+			tmpBlock.nextIdx = n
+			pendingBlock.CopyFrom(tmpBlock)
+			pendingBlock.fixupTimestamps()
+			tmpBlock.nextIdx = 0
+			tmpBlock.timestamps = tmpBlock.timestamps[:n]
+			tmpBlock.values = tmpBlock.values[:n]
+			tmpBlock.seqs = tmpBlock.seqs[:n]
 			tmpBlock.fixupTimestamps()
+			bsw.WriteExternalBlock(tmpBlock, ph, rowsMerged)
 			pendingBlock, tmpBlock = tmpBlock, pendingBlock
-			continue
 		}
-
-		// Write the first len(maxRowsPerBlock) of tmpBlock.timestamps to bsw,
-		// leave the rest in pendingBlock.
-		tmpBlock.nextIdx = maxRowsPerBlock
-		pendingBlock.CopyFrom(tmpBlock)
-		pendingBlock.fixupTimestamps()
-		tmpBlock.nextIdx = 0
-		tmpBlock.timestamps = tmpBlock.timestamps[:maxRowsPerBlock]
-		tmpBlock.values = tmpBlock.values[:maxRowsPerBlock]
-		tmpBlock.fixupTimestamps()
-		bsw.WriteExternalBlock(tmpBlock, ph, rowsMerged)
 	}
 	if err := bsm.Error(); err != nil {
 		return fmt.Errorf("cannot read block to be merged: %w", err)
@@ -142,6 +150,8 @@ func mergeBlockStreamsInternal(ph *partHeader, bsw *blockStreamWriter, bsm *bloc
 }
 
 // mergeBlocks merges ib1 and ib2 to ob.
+//
+// Rows with identical timestamps are ordered by their ingestion sequence numbers.
 func mergeBlocks(ob, ib1, ib2 *Block) {
 	ib1.assertMergeable(ib2)
 	ib1.assertUnmarshaled()
@@ -170,11 +180,13 @@ func mergeBlocks(ob, ib1, ib2 *Block) {
 	for {
 		i := ib1.nextIdx
 		ts2 := ib2.timestamps[ib2.nextIdx]
-		for i < len(ib1.timestamps) && ib1.timestamps[i] <= ts2 {
+		seq2 := ib2.seqs[ib2.nextIdx]
+		for i < len(ib1.timestamps) && (ib1.timestamps[i] < ts2 || ib1.timestamps[i] == ts2 && ib1.seqs[i] <= seq2) {
 			i++
 		}
 		ob.timestamps = append(ob.timestamps, ib1.timestamps[ib1.nextIdx:i]...)
 		ob.values = append(ob.values, ib1.values[ib1.nextIdx:i]...)
+		ob.seqs = append(ob.seqs, ib1.seqs[ib1.nextIdx:i]...)
 		ib1.nextIdx = i
 		if ib1.nextIdx >= len(ib1.timestamps) {
 			appendRows(ob, ib2)
@@ -187,6 +199,7 @@ func mergeBlocks(ob, ib1, ib2 *Block) {
 func appendRows(ob, ib *Block) {
 	ob.timestamps = append(ob.timestamps, ib.timestamps[ib.nextIdx:]...)
 	ob.values = append(ob.values, ib.values[ib.nextIdx:]...)
+	ob.seqs = append(ob.seqs, ib.seqs[ib.nextIdx:]...)
 }
 
 func unmarshalAndCalibrateScale(b1, b2 *Block) error {
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"testing"
 )
@@ -365,7 +366,7 @@ func TestMergeForciblyStop(t *testing.T) {
 	ch := make(chan struct{})
 	var rowsMerged, rowsDeleted uint64
 	close(ch)
-	if err := mergeBlockStreams(&mp.ph, &bsw, bsrs, ch, nil, 0, &rowsMerged, &rowsDeleted); !errors.Is(err, errForciblyStopped) {
+	if err := mergeBlockStreams(&mp.ph, &bsw, bsrs, ch, nil, nil, &rowsMerged, &rowsDeleted); !errors.Is(err, errForciblyStopped) {
 		t.Fatalf("unexpected error in mergeBlockStreams: got %v; want %v", err, errForciblyStopped)
 	}
 	if rowsMerged != 0 {
@@ -376,6 +377,156 @@ func TestMergeForciblyStop(t *testing.T) {
 	}
 }
 
+func TestMergeBlockStreamsIdenticalTimestamps(t *testing.T) {
+	// Rows with identical timestamps from distinct streams must be merged in ingestion order.
+	const rowsCount = 100
+	var rows1, rows2 []rawRow
+	for i := 0; i < rowsCount; i++ {
+		r := rawRow{
+			Timestamp:     123,
+			Seq:           uint64(i),
+			Value:         []byte(fmt.Sprintf("line %d", i)),
+			PrecisionBits: defaultPrecisionBits,
+		}
+		if i%3 == 0 {
+			rows1 = append(rows1, r)
+		} else {
+			rows2 = append(rows2, r)
+		}
+	}
+	// Raw rows must be sorted by ingestion order as well.
+	rand.Shuffle(len(rows1), func(i, j int) {
+		rows1[i], rows1[j] = rows1[j], rows1[i]
+	})
+	rand.Shuffle(len(rows2), func(i, j int) {
+		rows2[i], rows2[j] = rows2[j], rows2[i]
+	})
+	bsrs := []*blockStreamReader{
+		newTestBlockStreamReader(t, rows1),
+		newTestBlockStreamReader(t, rows2),
+	}
+
+	var mp inmemoryPart
+	var bsw blockStreamWriter
+	bsw.InitFromInmemoryPart(&mp)
+	var rowsMerged, rowsDeleted uint64
+	if err := mergeBlockStreams(&mp.ph, &bsw, bsrs, nil, nil, nil, &rowsMerged, &rowsDeleted); err != nil {
+		t.Fatalf("unexpected error in mergeBlockStreams: %s", err)
+	}
+
+	if mp.ph.BlocksCount != 1 {
+		t.Fatalf("unexpected number of blocks; got %d; want 1", mp.ph.BlocksCount)
+	}
+	var bsr blockStreamReader
+	bsr.InitFromInmemoryPart(&mp)
+	n := 0
+	for bsr.NextBlock() {
+		if err := bsr.Block.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal block from merged stream: %s", err)
+		}
+		for bsr.Block.nextRow() {
+			idx := bsr.Block.nextIdx - 1
+			valueExpected := fmt.Sprintf("line %d", n)
+			if string(bsr.Block.values[idx]) != valueExpected {
+				t.Fatalf("unexpected value for row #%d; got %q; want %q", n, bsr.Block.values[idx], valueExpected)
+			}
+			if bsr.Block.seqs[idx] != uint64(n) {
+				t.Fatalf("unexpected seq for row #%d; got %d; want %d", n, bsr.Block.seqs[idx], n)
+			}
+			n++
+		}
+	}
+	if err := bsr.Error(); err != nil {
+		t.Fatalf("unexpected error when reading merged stream: %s", err)
+	}
+	if n != rowsCount {
+		t.Fatalf("unexpected number of rows; got %d; want %d", n, rowsCount)
+	}
+}
+
+func TestMergeBlockStreamsBigValues(t *testing.T) {
+	// Values bigger than maxBlockSize must be stored in their own blocks,
+	// while the remaining values must fit maxBlockValuesSize.
+	const rowsCount = 100
+	var rows1, rows2 []rawRow
+	var valuesExpected [][]byte
+	for i := 0; i < rowsCount; i++ {
+		size := 40 * 1024
+		if i%5 == 0 {
+			size = 300 * 1024
+		}
+		value := make([]byte, size)
+		for j := range value {
+			value[j] = letterRunes[rand.Intn(len(letterRunes))]
+		}
+		valuesExpected = append(valuesExpected, value)
+		r := rawRow{
+			Timestamp:     int64(i),
+			Value:         value,
+			PrecisionBits: defaultPrecisionBits,
+		}
+		if i%2 == 0 {
+			rows1 = append(rows1, r)
+		} else {
+			rows2 = append(rows2, r)
+		}
+	}
+	// Verify blocks created from raw rows.
+	testBigValuesBlocks(t, newTestBlockStreamReader(t, rows1), nil)
+
+	bsrs := []*blockStreamReader{
+		newTestBlockStreamReader(t, rows1),
+		newTestBlockStreamReader(t, rows2),
+	}
+
+	var mp inmemoryPart
+	var bsw blockStreamWriter
+	bsw.InitFromInmemoryPart(&mp)
+	var rowsMerged, rowsDeleted uint64
+	if err := mergeBlockStreams(&mp.ph, &bsw, bsrs, nil, nil, nil, &rowsMerged, &rowsDeleted); err != nil {
+		t.Fatalf("unexpected error in mergeBlockStreams: %s", err)
+	}
+	var bsr blockStreamReader
+	bsr.InitFromInmemoryPart(&mp)
+	testBigValuesBlocks(t, &bsr, valuesExpected)
+}
+
+// testBigValuesBlocks verifies that blocks from bsr contain either a single big value or small values fitting maxBlockValuesSize.
+//
+// The read values are compared to valuesExpected if it isn't nil.
+func testBigValuesBlocks(t *testing.T, bsr *blockStreamReader, valuesExpected [][]byte) {
+	t.Helper()
+	n := 0
+	for bsr.NextBlock() {
+		if err := bsr.Block.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal block: %s", err)
+		}
+		values := bsr.Block.values
+		if len(values) > 1 {
+			for _, v := range values {
+				if len(v) > maxBlockSize {
+					t.Fatalf("the value of %d bytes must be stored in its own block; got %d rows in the block", len(v), len(values))
+				}
+			}
+			if size := valuesSize(values); size > maxBlockValuesSize {
+				t.Fatalf("too big values size in the block; got %d bytes; cannot exceed %d bytes", size, maxBlockValuesSize)
+			}
+		}
+		for _, v := range values {
+			if valuesExpected != nil && string(v) != string(valuesExpected[n]) {
+				t.Fatalf("unexpected value for row #%d", n)
+			}
+			n++
+		}
+	}
+	if err := bsr.Error(); err != nil {
+		t.Fatalf("unexpected error when reading blocks: %s", err)
+	}
+	if uint64(n) != bsr.ph.RowsCount {
+		t.Fatalf("unexpected number of rows; got %d; want %d", n, bsr.ph.RowsCount)
+	}
+}
+
 func testMergeBlockStreams(t *testing.T, bsrs []*blockStreamReader, expectedBlocksCount, expectedRowsCount int, expectedMinTimestamp, expectedMaxTimestamp int64) {
 	t.Helper()
 
@@ -385,7 +536,7 @@ func testMergeBlockStreams(t *testing.T, bsrs []*blockStreamReader, expectedBloc
 	bsw.InitFromInmemoryPart(&mp)
 
 	var rowsMerged, rowsDeleted uint64
-	if err := mergeBlockStreams(&mp.ph, &bsw, bsrs, nil, nil, 0, &rowsMerged, &rowsDeleted); err != nil {
+	if err := mergeBlockStreams(&mp.ph, &bsw, bsrs, nil, nil, nil, &rowsMerged, &rowsDeleted); err != nil {
 		t.Fatalf("unexpected error in mergeBlockStreams: %s", err)
 	}
 
@@ -61,6 +61,14 @@ func (mr *metaindexRow) RegisterBlockHeader(bh *blockHeader) {
 	}
 }
 
+// convertMsecTimestamps converts MinTimestamp and MaxTimestamp from milliseconds to nanoseconds.
+//
+// It is used for metaindex rows from parts with millisecond timestamps.
+func (mr *metaindexRow) convertMsecTimestamps() {
+	mr.MinTimestamp *= nsecPerMsec
+	mr.MaxTimestamp *= nsecPerMsec
+}
+
 // Marshal appends marshaled mr to dst and returns the result.
 func (mr *metaindexRow) Marshal(dst []byte) []byte {
 	dst = mr.TSID.Marshal(dst)
@@ -478,6 +478,13 @@ func SetMaxLabelsPerTimeseries(maxLabels int) {
 	maxLabelsPerTimeseries = maxLabels
 }
 
+// GetMaxLabelsPerTimeseries returns the limit on the number of labels per each time series.
+//
+// See SetMaxLabelsPerTimeseries.
+func GetMaxLabelsPerTimeseries() int {
+	return maxLabelsPerTimeseries
+}
+
 // Label is a timeseries label
 type Label struct {
 	Name  []byte
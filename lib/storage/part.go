@@ -45,8 +45,20 @@ type part struct {
 	valuesFile     fs.MustReadAtCloser
 	indexFile      fs.MustReadAtCloser
 
+	// bloomsFile and bloomsIndexFile contain per-block bloom filters.
+	//
+	// They are nil for parts created before bloom filters were introduced.
+	bloomsFile      fs.MustReadAtCloser
+	bloomsIndexFile fs.MustReadAtCloser
+
 	metaindex []metaindexRow
 
+	// metaindexBlockIdxs contains the index of the first block in the part
+	// for the corresponding metaindex row.
+	//
+	// It is used for locating bloom filters for blocks.
+	metaindexBlockIdxs []uint64
+
 	ibCache *indexBlockCache
 }
 
@@ -93,15 +105,56 @@ func openFilePart(path string) (*part, error) {
 	}
 	metaindexSize := fs.MustFileSize(metaindexPath)
 
-	size := timestampsSize + valuesSize + indexSize + metaindexSize
-	return newPart(&ph, path, size, metaindexFile, timestampsFile, valuesFile, indexFile)
+	// Bloom filters are optional, since they are missing in parts created by older releases.
+	var bloomsFile, bloomsIndexFile fs.MustReadAtCloser
+	var bloomsSize, bloomsIndexSize uint64
+	bloomsPath := path + "/blooms.bin"
+	bloomsIndexPath := path + "/bloomsindex.bin"
+	if fs.IsPathExist(bloomsPath) && fs.IsPathExist(bloomsIndexPath) {
+		bloomsFile, err = fs.OpenReaderAt(bloomsPath)
+		if err != nil {
+			timestampsFile.MustClose()
+			valuesFile.MustClose()
+			indexFile.MustClose()
+			metaindexFile.MustClose()
+			return nil, fmt.Errorf("cannot open blooms file: %w", err)
+		}
+		bloomsSize = fs.MustFileSize(bloomsPath)
+
+		bloomsIndexFile, err = fs.OpenReaderAt(bloomsIndexPath)
+		if err != nil {
+			timestampsFile.MustClose()
+			valuesFile.MustClose()
+			indexFile.MustClose()
+			metaindexFile.MustClose()
+			bloomsFile.MustClose()
+			return nil, fmt.Errorf("cannot open blooms index file: %w", err)
+		}
+		bloomsIndexSize = fs.MustFileSize(bloomsIndexPath)
+		if bloomsIndexSize != ph.BlocksCount*bloomsIndexRowSize {
+			timestampsFile.MustClose()
+			valuesFile.MustClose()
+			indexFile.MustClose()
+			metaindexFile.MustClose()
+			bloomsFile.MustClose()
+			bloomsIndexFile.MustClose()
+			return nil, fmt.Errorf("unexpected size of blooms index file %q; got %d bytes; want %d bytes for %d blocks",
+				bloomsIndexPath, bloomsIndexSize, ph.BlocksCount*bloomsIndexRowSize, ph.BlocksCount)
+		}
+	}
+
+	size := timestampsSize + valuesSize + indexSize + metaindexSize + bloomsSize + bloomsIndexSize
+	return newPart(&ph, path, size, metaindexFile, timestampsFile, valuesFile, indexFile, bloomsFile, bloomsIndexFile)
 }
 
 // newPart returns new part initialized with the given arguments.
 //
 // The returned part calls MustClose on all the files passed to newPart
 // when calling part.MustClose.
-func newPart(ph *partHeader, path string, size uint64, metaindexReader filestream.ReadCloser, timestampsFile, valuesFile, indexFile fs.MustReadAtCloser) (*part, error) {
+//
+// bloomsFile and bloomsIndexFile may be nil if the part has no bloom filters.
+func newPart(ph *partHeader, path string, size uint64, metaindexReader filestream.ReadCloser, timestampsFile, valuesFile, indexFile,
+	bloomsFile, bloomsIndexFile fs.MustReadAtCloser) (*part, error) {
 	var errors []error
 	metaindex, err := unmarshalMetaindexRows(nil, metaindexReader)
 	if err != nil {
@@ -116,8 +169,21 @@ func newPart(ph *partHeader, path string, size uint64, metaindexReader filestrea
 	p.timestampsFile = timestampsFile
 	p.valuesFile = valuesFile
 	p.indexFile = indexFile
+	p.bloomsFile = bloomsFile
+	p.bloomsIndexFile = bloomsIndexFile
 
+	if ph.msecTimestamps {
+		for i := range metaindex {
+			metaindex[i].convertMsecTimestamps()
+		}
+	}
 	p.metaindex = metaindex
+	p.metaindexBlockIdxs = make([]uint64, len(metaindex))
+	blockIdx := uint64(0)
+	for i := range metaindex {
+		p.metaindexBlockIdxs[i] = blockIdx
+		blockIdx += uint64(metaindex[i].BlockHeadersCount)
+	}
 	p.ibCache = newIndexBlockCache()
 
 	if len(errors) > 0 {
@@ -130,6 +196,11 @@ func newPart(ph *partHeader, path string, size uint64, metaindexReader filestrea
 	return &p, nil
 }
 
+// hasBlooms returns true if p contains per-block bloom filters.
+func (p *part) hasBlooms() bool {
+	return p.bloomsFile != nil
+}
+
 // String returns human-readable representation of p.
 func (p *part) String() string {
 	if len(p.path) > 0 {
@@ -143,6 +214,10 @@ func (p *part) MustClose() {
 	p.timestampsFile.MustClose()
 	p.valuesFile.MustClose()
 	p.indexFile.MustClose()
+	if p.bloomsFile != nil {
+		p.bloomsFile.MustClose()
+		p.bloomsIndexFile.MustClose()
+	}
 
 	isBig := p.ph.RowsCount > maxRowsPerSmallPart()
 	p.ibCache.MustClose(isBig)
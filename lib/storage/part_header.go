@@ -21,27 +21,48 @@ type partHeader struct {
 
 	// MaxTimestamp is the maximum timestamp in the part.
 	MaxTimestamp int64
+
+	// msecTimestamps is set to true if the part contains timestamps in milliseconds.
+	//
+	// Such parts were created before switching to nanosecond timestamps.
+	// MinTimestamp and MaxTimestamp are always in nanoseconds, while timestamps
+	// in block headers and blocks must be converted to nanoseconds when reading the part.
+	msecTimestamps bool
 }
 
 // String returns string representation of ph.
 func (ph *partHeader) String() string {
-	return fmt.Sprintf("%d_%d_%s_%s", ph.RowsCount, ph.BlocksCount, toUserReadableTimestamp(ph.MinTimestamp), toUserReadableTimestamp(ph.MaxTimestamp))
+	format := userReadableTimeFormat
+	if ph.msecTimestamps {
+		format = legacyUserReadableTimeFormat
+	}
+	return fmt.Sprintf("%d_%d_%s_%s", ph.RowsCount, ph.BlocksCount, toUserReadableTimestamp(ph.MinTimestamp, format), toUserReadableTimestamp(ph.MaxTimestamp, format))
 }
 
-func toUserReadableTimestamp(timestamp int64) string {
+func toUserReadableTimestamp(timestamp int64, format string) string {
 	t := timestampToTime(timestamp)
-	return t.Format(userReadableTimeFormat)
+	return t.Format(format)
 }
 
-func fromUserReadableTimestamp(s string) (int64, error) {
+// fromUserReadableTimestamp parses timestamp from s.
+//
+// It returns true if s is in legacyUserReadableTimeFormat.
+func fromUserReadableTimestamp(s string) (int64, bool, error) {
 	t, err := time.Parse(userReadableTimeFormat, s)
-	if err != nil {
-		return 0, err
+	if err == nil {
+		return timestampFromTime(t), false, nil
 	}
-	return timestampFromTime(t), nil
+	t, errLegacy := time.Parse(legacyUserReadableTimeFormat, s)
+	if errLegacy != nil {
+		return 0, false, err
+	}
+	return timestampFromTime(t), true, nil
 }
 
-const userReadableTimeFormat = "20060102150405.000"
+const userReadableTimeFormat = "20060102150405.000000000"
+
+// legacyUserReadableTimeFormat is used in names of parts with millisecond timestamps.
+const legacyUserReadableTimeFormat = "20060102150405.000"
 
 // Path returns a path to part header with the given prefix and suffix.
 //
@@ -82,14 +103,19 @@ func (ph *partHeader) ParseFromPath(path string) error {
 	if err != nil {
 		return fmt.Errorf("cannot parse blocksCount from partName %q: %w", partName, err)
 	}
-	ph.MinTimestamp, err = fromUserReadableTimestamp(a[2])
+	var isLegacyMin, isLegacyMax bool
+	ph.MinTimestamp, isLegacyMin, err = fromUserReadableTimestamp(a[2])
 	if err != nil {
 		return fmt.Errorf("cannot parse minTimestamp from partName %q: %w", partName, err)
 	}
-	ph.MaxTimestamp, err = fromUserReadableTimestamp(a[3])
+	ph.MaxTimestamp, isLegacyMax, err = fromUserReadableTimestamp(a[3])
 	if err != nil {
 		return fmt.Errorf("cannot parse maxTimestamp from partName %q: %w", partName, err)
 	}
+	if isLegacyMin != isLegacyMax {
+		return fmt.Errorf("minTimestamp and maxTimestamp must have the same format in partName %q", partName)
+	}
+	ph.msecTimestamps = isLegacyMin
 
 	if ph.MinTimestamp > ph.MaxTimestamp {
 		return fmt.Errorf("minTimestamp cannot exceed maxTimestamp; got %d vs %d", ph.MinTimestamp, ph.MaxTimestamp)
@@ -113,4 +139,5 @@ func (ph *partHeader) Reset() {
 	ph.BlocksCount = 0
 	ph.MinTimestamp = (1 << 63) - 1
 	ph.MaxTimestamp = -1 << 63
+	ph.msecTimestamps = false
 }
@@ -37,6 +37,9 @@ func TestPartHeaderParseFromPath(t *testing.T) {
 
 		// blocksCount > rowsCount
 		testParseFromPathError("123_456_20181011010203.456_20181011010203.457_garbage")
+
+		// Mixed timestamp formats
+		testParseFromPathError("1233_456_20181011010203.456_20181011010203.457000000_garbage")
 	})
 
 	testParseFromPathSuccess := func(path string, phStringExpected string) {
@@ -58,5 +61,31 @@ func TestPartHeaderParseFromPath(t *testing.T) {
 		testParseFromPathSuccess("/1233_456_20181011010203.456_20181011010203.457_garbage///", "1233_456_20181011010203.456_20181011010203.457")
 		testParseFromPathSuccess("/var/lib/tsdb/1233_456_20181011010203.456_20181011010203.457_garbage///", "1233_456_20181011010203.456_20181011010203.457")
 		testParseFromPathSuccess("/var/lib/tsdb/456_456_20181011010203.456_20181011010203.457_232345///", "456_456_20181011010203.456_20181011010203.457")
+
+		// Nanosecond timestamps
+		testParseFromPathSuccess("/1233_456_20181011010203.456789012_20181011010203.456789013_garbage", "1233_456_20181011010203.456789012_20181011010203.456789013")
+		testParseFromPathSuccess("/var/lib/tsdb/456_456_20181011010203.000000000_20181011010203.457000000_232345///", "456_456_20181011010203.000000000_20181011010203.457000000")
 	})
 }
+
+func TestPartHeaderParseFromPathMsecTimestamps(t *testing.T) {
+	f := func(path string, minTimestampExpected, maxTimestampExpected int64, msecTimestampsExpected bool) {
+		t.Helper()
+
+		var ph partHeader
+		if err := ph.ParseFromPath(path); err != nil {
+			t.Fatalf("unexpected error when parsing path %q: %s", path, err)
+		}
+		if ph.MinTimestamp != minTimestampExpected {
+			t.Fatalf("unexpected MinTimestamp for path %q; got %d; want %d", path, ph.MinTimestamp, minTimestampExpected)
+		}
+		if ph.MaxTimestamp != maxTimestampExpected {
+			t.Fatalf("unexpected MaxTimestamp for path %q; got %d; want %d", path, ph.MaxTimestamp, maxTimestampExpected)
+		}
+		if ph.msecTimestamps != msecTimestampsExpected {
+			t.Fatalf("unexpected msecTimestamps for path %q; got %v; want %v", path, ph.msecTimestamps, msecTimestampsExpected)
+		}
+	}
+	f("/1233_456_20181011010203.456_20181011010203.457_garbage", 1539219723456000000, 1539219723457000000, true)
+	f("/1233_456_20181011010203.456000001_20181011010203.457000002_garbage", 1539219723456000001, 1539219723457000002, false)
+}
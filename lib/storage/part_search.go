@@ -6,6 +6,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
@@ -36,8 +37,21 @@ type partSearch struct {
 
 	bhs []blockHeader
 
+	// bloomHashes contains n-gram hashes from line filters.
+	//
+	// Blocks with bloom filters missing these hashes are skipped.
+	bloomHashes []uint64
+
+	// bloomRefs contains references to bloom filters for bhs.
+	//
+	// It is nil if bloom filters mustn't be checked.
+	bloomRefs []bloomRef
+
 	compressedIndexBuf []byte
 	indexBuf           []byte
+	bloomsIndexBuf     []byte
+	bloomBuf           []byte
+	bf                 bloomFilter
 
 	err error
 }
@@ -50,8 +64,13 @@ func (ps *partSearch) reset() {
 	ps.metaindex = nil
 	ps.ibCache = nil
 	ps.bhs = nil
+	ps.bloomHashes = nil
+	ps.bloomRefs = ps.bloomRefs[:0]
 	ps.compressedIndexBuf = ps.compressedIndexBuf[:0]
 	ps.indexBuf = ps.indexBuf[:0]
+	ps.bloomsIndexBuf = ps.bloomsIndexBuf[:0]
+	ps.bloomBuf = ps.bloomBuf[:0]
+	ps.bf.reset()
 	ps.err = nil
 }
 
@@ -59,11 +78,14 @@ var isInTest = func() bool {
 	return strings.HasSuffix(os.Args[0], ".test")
 }()
 
-// Init initializes the ps with the given p, tsids and tr.
+// Init initializes the ps with the given p, tsids, tr and lfs.
 //
 // tsids must be sorted.
 // tsids cannot be modified after the Init call, since it is owned by ps.
-func (ps *partSearch) Init(p *part, tsids []TSID, tr TimeRange) {
+//
+// lfs may be nil. Otherwise blocks, which cannot contain lines matching lfs, are skipped
+// with the help of per-block bloom filters.
+func (ps *partSearch) Init(p *part, tsids []TSID, tr TimeRange, lfs *LineFilters) {
 	ps.reset()
 	ps.p = p
 
@@ -75,6 +97,9 @@ func (ps *partSearch) Init(p *part, tsids []TSID, tr TimeRange) {
 		ps.tsids = tsids
 	}
 	ps.tr = tr
+	if p.hasBlooms() {
+		ps.bloomHashes = lfs.getBloomHashes()
+	}
 	ps.metaindex = p.metaindex
 	ps.ibCache = p.ibCache
 
@@ -168,6 +193,14 @@ func (ps *partSearch) nextBHS() bool {
 			ps.ibCache.Put(indexBlockKey, ib)
 		}
 		ps.bhs = ib.bhs
+
+		if len(ps.bloomHashes) > 0 {
+			mrIdx := len(ps.p.metaindex) - len(ps.metaindex) - 1
+			if err := ps.readBloomRefs(mrIdx, len(ib.bhs)); err != nil {
+				ps.err = fmt.Errorf("cannot read blooms index for part %q at index block offset %d: %w", &ps.p.ph, mr.IndexBlockOffset, err)
+				return false
+			}
+		}
 		return true
 	}
 
@@ -223,9 +256,48 @@ func (ps *partSearch) readIndexBlock(mr *metaindexRow) (*indexBlock, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot unmarshal index block: %w", err)
 	}
+	if ps.p.ph.msecTimestamps {
+		for i := range ib.bhs {
+			ib.bhs[i].convertMsecTimestamps()
+		}
+	}
 	return ib, nil
 }
 
+func (ps *partSearch) readBloomRefs(mrIdx, blocksCount int) error {
+	blockIdx := ps.p.metaindexBlockIdxs[mrIdx]
+	ps.bloomsIndexBuf = bytesutil.Resize(ps.bloomsIndexBuf[:0], blocksCount*bloomsIndexRowSize)
+	ps.p.bloomsIndexFile.MustReadAt(ps.bloomsIndexBuf, int64(blockIdx*bloomsIndexRowSize))
+
+	var err error
+	ps.bloomRefs, err = unmarshalBloomRefs(ps.bloomRefs[:0], ps.bloomsIndexBuf)
+	return err
+}
+
+// bloomMayContain returns true if the block referred by br may contain lines matching ps.bloomHashes.
+func (ps *partSearch) bloomMayContain(br *bloomRef) (bool, error) {
+	if br.size == 0 {
+		// The block has no bloom filter.
+		return true, nil
+	}
+	ps.bloomBuf = bytesutil.Resize(ps.bloomBuf[:0], int(br.size))
+	ps.p.bloomsFile.MustReadAt(ps.bloomBuf, int64(br.offset))
+	if err := ps.bf.Unmarshal(ps.bloomBuf); err != nil {
+		return false, fmt.Errorf("cannot unmarshal bloom filter at offset %d with size %d: %w", br.offset, br.size, err)
+	}
+	atomic.AddUint64(&bloomFilterBlocksChecked, 1)
+	if !ps.bf.containsAll(ps.bloomHashes) {
+		atomic.AddUint64(&bloomFilterBlocksSkipped, 1)
+		return false, nil
+	}
+	return true, nil
+}
+
+var (
+	bloomFilterBlocksChecked uint64
+	bloomFilterBlocksSkipped uint64
+)
+
 func (ps *partSearch) searchBHS() bool {
 	for i := range ps.bhs {
 		bh := &ps.bhs[i]
@@ -265,13 +337,29 @@ func (ps *partSearch) searchBHS() bool {
 		}
 
 		// Found the tsid block with the matching timestamp range.
+		if len(ps.bloomRefs) > 0 {
+			ok, err := ps.bloomMayContain(&ps.bloomRefs[i])
+			if err != nil {
+				ps.err = fmt.Errorf("cannot check bloom filter for part %q: %w", &ps.p.ph, err)
+				return false
+			}
+			if !ok {
+				// The block cannot contain lines matching line filters. Skip it.
+				continue
+			}
+		}
+
 		// Read it.
 		ps.BlockRef.init(ps.p, bh)
 
 		ps.bhs = ps.bhs[i+1:]
+		if len(ps.bloomRefs) > 0 {
+			ps.bloomRefs = ps.bloomRefs[i+1:]
+		}
 		return true
 	}
 
 	ps.bhs = nil
+	ps.bloomRefs = ps.bloomRefs[:0]
 	return false
 }
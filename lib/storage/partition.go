@@ -128,20 +128,29 @@ type partition struct {
 
 	mergeIdx uint64
 
+	// The number of rows taken from rawRows, which are being converted into parts.
+	rawRowsFlushing uint64
+
 	smallPartsPath string
 	bigPartsPath   string
 
 	// The callack that returns deleted metric ids which must be skipped during merge.
 	getDeletedMetricIDs func() *uint64set.Set
 
+	// The callback for obtaining metric names for matching retention filters during merge.
+	searchMetricName searchMetricNameFunc
+
+	// The callback, which is called after inmemory parts are flushed to persistent storage.
+	flushCallback func()
+
 	// data retention in milliseconds.
 	// Used for deleting data outside the retention during background merge.
 	retentionMsecs int64
 
-	// Name is the name of the partition in the form YYYY_MM.
+	// Name is the name of the partition in the form YYYY_MM, YYYY_MM_DD or YYYY_MM_DD_HH depending on the partition interval.
 	name string
 
-	// The time range for the partition. Usually this is a whole month.
+	// The time range for the partition. See SetPartitionInterval.
 	tr TimeRange
 
 	// partsLock protects smallParts and bigParts.
@@ -159,6 +168,9 @@ type partition struct {
 	// rawRows aren't used in search for performance reasons.
 	rawRows rawRowsShards
 
+	// rawRowsFlushingSeqs contains ingestion sequence numbers for raw rows, which are being converted into inmemory parts.
+	rawRowsFlushingSeqs rowSeqsTracker
+
 	snapshotLock sync.RWMutex
 
 	stopCh chan struct{}
@@ -183,6 +195,9 @@ type partWrapper struct {
 	// non-nil if the part is inmemoryPart.
 	mp *inmemoryPart
 
+	// The minimum ingestion sequence number for rows in the inmemoryPart.
+	minRowSeq uint64
+
 	// Whether the part is in merge now.
 	isInMerge bool
 }
@@ -210,7 +225,7 @@ func (pw *partWrapper) decRef() {
 
 // createPartition creates new partition for the given timestamp and the given paths
 // to small and big partitions.
-func createPartition(timestamp int64, smallPartitionsPath, bigPartitionsPath string, getDeletedMetricIDs func() *uint64set.Set, retentionMsecs int64) (*partition, error) {
+func createPartition(timestamp int64, smallPartitionsPath, bigPartitionsPath string, getDeletedMetricIDs func() *uint64set.Set, searchMetricName searchMetricNameFunc, flushCallback func(), retentionMsecs int64) (*partition, error) {
 	name := timestampToPartitionName(timestamp)
 	smallPartsPath := filepath.Clean(smallPartitionsPath) + "/" + name
 	bigPartsPath := filepath.Clean(bigPartitionsPath) + "/" + name
@@ -223,7 +238,7 @@ func createPartition(timestamp int64, smallPartitionsPath, bigPartitionsPath str
 		return nil, fmt.Errorf("cannot create directories for big parts %q: %w", bigPartsPath, err)
 	}
 
-	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs, retentionMsecs)
+	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs, searchMetricName, flushCallback, retentionMsecs)
 	pt.tr.fromPartitionTimestamp(timestamp)
 	pt.startMergeWorkers()
 	pt.startRawRowsFlusher()
@@ -245,7 +260,7 @@ func (pt *partition) Drop() {
 }
 
 // openPartition opens the existing partition from the given paths.
-func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() *uint64set.Set, retentionMsecs int64) (*partition, error) {
+func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() *uint64set.Set, searchMetricName searchMetricNameFunc, flushCallback func(), retentionMsecs int64) (*partition, error) {
 	smallPartsPath = filepath.Clean(smallPartsPath)
 	bigPartsPath = filepath.Clean(bigPartsPath)
 
@@ -269,7 +284,7 @@ func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func
 		return nil, fmt.Errorf("cannot open big parts from %q: %w", bigPartsPath, err)
 	}
 
-	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs, retentionMsecs)
+	pt := newPartition(name, smallPartsPath, bigPartsPath, getDeletedMetricIDs, searchMetricName, flushCallback, retentionMsecs)
 	pt.smallParts = smallParts
 	pt.bigParts = bigParts
 	if err := pt.tr.fromPartitionName(name); err != nil {
@@ -282,13 +297,15 @@ func openPartition(smallPartsPath, bigPartsPath string, getDeletedMetricIDs func
 	return pt, nil
 }
 
-func newPartition(name, smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() *uint64set.Set, retentionMsecs int64) *partition {
+func newPartition(name, smallPartsPath, bigPartsPath string, getDeletedMetricIDs func() *uint64set.Set, searchMetricName searchMetricNameFunc, flushCallback func(), retentionMsecs int64) *partition {
 	p := &partition{
 		name:           name,
 		smallPartsPath: smallPartsPath,
 		bigPartsPath:   bigPartsPath,
 
 		getDeletedMetricIDs: getDeletedMetricIDs,
+		searchMetricName:    searchMetricName,
+		flushCallback:       flushCallback,
 		retentionMsecs:      retentionMsecs,
 
 		mergeIdx: uint64(time.Now().UnixNano()),
@@ -345,7 +362,10 @@ type partitionMetrics struct {
 
 // UpdateMetrics updates m with metrics from pt.
 func (pt *partition) UpdateMetrics(m *partitionMetrics) {
+	// Read rawRows, then rawRowsFlushing and then parts, since rows move in this order.
+	// This guarantees the rows aren't missed when they are concurrently converted into parts.
 	rawRowsLen := uint64(pt.rawRows.Len())
+	rawRowsLen += atomic.LoadUint64(&pt.rawRowsFlushing)
 	m.PendingRows += rawRowsLen
 	m.SmallRowsCount += rawRowsLen
 
@@ -463,6 +483,9 @@ type rawRowsShard struct {
 	lock          sync.Mutex
 	rows          []rawRow
 	lastFlushTime uint64
+
+	// minSeq is the minimum ingestion sequence number for rows.
+	minSeq uint64
 }
 
 func (rrs *rawRowsShard) Len() int {
@@ -474,6 +497,7 @@ func (rrs *rawRowsShard) Len() int {
 
 func (rrs *rawRowsShard) addRows(pt *partition, rows []rawRow) {
 	var rrss []*rawRows
+	var seqs []uint64
 
 	rrs.lock.Lock()
 	if cap(rrs.rows) == 0 {
@@ -484,27 +508,57 @@ func (rrs *rawRowsShard) addRows(pt *partition, rows []rawRow) {
 		capacity := maxRowsCount - len(rrs.rows)
 		if capacity >= len(rows) {
 			// Fast path - rows fit capacity.
-			rrs.rows = append(rrs.rows, rows...)
+			rrs.appendRowsLocked(rows)
 			break
 		}
 
 		// Slow path - rows don't fit capacity.
 		// Fill rawRows to capacity and convert it to a part.
-		rrs.rows = append(rrs.rows, rows[:capacity]...)
+		rrs.appendRowsLocked(rows[:capacity])
 		rows = rows[capacity:]
 		rr := getRawRowsMaxSize()
 		rrs.rows, rr.rows = rr.rows, rrs.rows
+		atomic.AddUint64(&pt.rawRowsFlushing, uint64(len(rr.rows)))
+		pt.rawRowsFlushingSeqs.add(rrs.minSeq)
 		rrss = append(rrss, rr)
+		seqs = append(seqs, rrs.minSeq)
 		rrs.lastFlushTime = fasttime.UnixTimestamp()
 	}
 	rrs.lock.Unlock()
 
-	for _, rr := range rrss {
-		pt.addRowsPart(rr.rows)
+	for i, rr := range rrss {
+		pt.addRowsPart(rr.rows, seqs[i])
+		pt.rawRowsFlushingSeqs.remove(seqs[i])
+		atomic.AddUint64(&pt.rawRowsFlushing, ^uint64(len(rr.rows)-1))
 		putRawRows(rr)
 	}
 }
 
+func (rrs *rawRowsShard) appendRowsLocked(rows []rawRow) {
+	if len(rows) == 0 {
+		return
+	}
+	if len(rrs.rows) == 0 {
+		rrs.minSeq = rows[0].Seq
+	}
+	for i := range rows {
+		if rows[i].Seq < rrs.minSeq {
+			rrs.minSeq = rows[i].Seq
+		}
+	}
+	rrs.rows = append(rrs.rows, rows...)
+}
+
+// getMinSeq returns the minimum ingestion sequence number for rows in rrs.
+//
+// false is returned if rrs has no rows.
+func (rrs *rawRowsShard) getMinSeq() (uint64, bool) {
+	rrs.lock.Lock()
+	minSeq, ok := rrs.minSeq, len(rrs.rows) > 0
+	rrs.lock.Unlock()
+	return minSeq, ok
+}
+
 type rawRows struct {
 	rows []rawRow
 }
@@ -548,7 +602,8 @@ func getRawRowsPool(size int) (*sync.Pool, int) {
 
 var rawRowsPools [19]sync.Pool
 
-func (pt *partition) addRowsPart(rows []rawRow) {
+// addRowsPart adds inmemory part for the given rows with the given minRowSeq to pt.
+func (pt *partition) addRowsPart(rows []rawRow, minRowSeq uint64) {
 	if len(rows) == 0 {
 		return
 	}
@@ -576,9 +631,10 @@ func (pt *partition) addRowsPart(rows []rawRow) {
 	}
 
 	pw := &partWrapper{
-		p:        p,
-		mp:       mp,
-		refCount: 1,
+		p:         p,
+		mp:        mp,
+		minRowSeq: minRowSeq,
+		refCount:  1,
 	}
 
 	pt.partsLock.Lock()
@@ -749,15 +805,21 @@ func (rrs *rawRowsShard) flush(pt *partition, isFinal bool) {
 		flushSeconds = 1
 	}
 
+	var minSeq uint64
 	rrs.lock.Lock()
 	if isFinal || currentTime-rrs.lastFlushTime > uint64(flushSeconds) {
 		rr = getRawRowsMaxSize()
 		rrs.rows, rr.rows = rr.rows, rrs.rows
+		atomic.AddUint64(&pt.rawRowsFlushing, uint64(len(rr.rows)))
+		minSeq = rrs.minSeq
+		pt.rawRowsFlushingSeqs.add(minSeq)
 	}
 	rrs.lock.Unlock()
 
 	if rr != nil {
-		pt.addRowsPart(rr.rows)
+		pt.addRowsPart(rr.rows, minSeq)
+		pt.rawRowsFlushingSeqs.remove(minSeq)
+		atomic.AddUint64(&pt.rawRowsFlushing, ^uint64(len(rr.rows)-1))
 		putRawRows(rr)
 	}
 }
@@ -814,6 +876,86 @@ func (pt *partition) flushInmemoryParts(dstPws []*partWrapper, force bool) ([]*p
 	return dstPws, nil
 }
 
+// minUnflushedRowSeq returns the minimum ingestion sequence number for rows added to pt, which aren't flushed to persistent storage yet.
+//
+// false is returned if all the rows added to pt are flushed to persistent storage.
+func (pt *partition) minUnflushedRowSeq() (uint64, bool) {
+	minSeq := uint64(1<<64 - 1)
+	found := false
+	updateMinSeq := func(seq uint64) {
+		if seq < minSeq {
+			minSeq = seq
+		}
+		found = true
+	}
+
+	// Rows move from raw rows to rawRowsFlushingSeqs and then to inmemory parts, so inspect them in this order
+	// in order to avoid missing rows moved concurrently.
+	for i := range pt.rawRows.shards {
+		if seq, ok := pt.rawRows.shards[i].getMinSeq(); ok {
+			updateMinSeq(seq)
+		}
+	}
+	if seq, ok := pt.rawRowsFlushingSeqs.min(); ok {
+		updateMinSeq(seq)
+	}
+	pt.partsLock.Lock()
+	for _, pw := range pt.smallParts {
+		if pw.mp != nil {
+			updateMinSeq(pw.minRowSeq)
+		}
+	}
+	pt.partsLock.Unlock()
+	return minSeq, found
+}
+
+// rowSeqsTracker tracks ingestion sequence numbers for raw rows, which are being converted into inmemory parts.
+type rowSeqsTracker struct {
+	lock sync.Mutex
+	m    map[uint64]int
+}
+
+func (rst *rowSeqsTracker) add(seq uint64) {
+	rst.lock.Lock()
+	if rst.m == nil {
+		rst.m = make(map[uint64]int)
+	}
+	rst.m[seq]++
+	rst.lock.Unlock()
+}
+
+func (rst *rowSeqsTracker) remove(seq uint64) {
+	rst.lock.Lock()
+	n := rst.m[seq] - 1
+	if n < 0 {
+		logger.Panicf("BUG: missing ingestion sequence number %d in rowSeqsTracker", seq)
+	}
+	if n == 0 {
+		delete(rst.m, seq)
+	} else {
+		rst.m[seq] = n
+	}
+	rst.lock.Unlock()
+}
+
+// min returns the minimum sequence number in rst.
+//
+// false is returned if rst is empty.
+func (rst *rowSeqsTracker) min() (uint64, bool) {
+	rst.lock.Lock()
+	defer rst.lock.Unlock()
+
+	minSeq := uint64(0)
+	found := false
+	for seq := range rst.m {
+		if !found || seq < minSeq {
+			minSeq = seq
+			found = true
+		}
+	}
+	return minSeq, found
+}
+
 func (pt *partition) mergePartsOptimal(pws []*partWrapper, stopCh <-chan struct{}) error {
 	defer func() {
 		// Remove isInMerge flag from pws.
@@ -1134,8 +1276,8 @@ func (pt *partition) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) erro
 		atomic.AddUint64(&pt.smallMergesCount, 1)
 		atomic.AddUint64(&pt.activeSmallMerges, 1)
 	}
-	retentionDeadline := timestampFromTime(startTime) - pt.retentionMsecs
-	err := mergeBlockStreams(&ph, bsw, bsrs, stopCh, dmis, retentionDeadline, rowsMerged, rowsDeleted)
+	rd := newRetentionDeadlines(timestampFromTime(startTime), pt.retentionMsecs, pt.searchMetricName)
+	err := mergeBlockStreams(&ph, bsw, bsrs, stopCh, dmis, rd, rowsMerged, rowsDeleted)
 	if isBigPart {
 		atomic.AddUint64(&pt.activeBigMerges, ^uint64(0))
 	} else {
@@ -1217,6 +1359,16 @@ func (pt *partition) mergeParts(pws []*partWrapper, stopCh <-chan struct{}) erro
 		logger.Panicf("BUG: unexpected number of parts removed; got %d, want %d", removedSmallParts+removedBigParts, len(m))
 	}
 
+	// Notify about flushed inmemory parts.
+	if pt.flushCallback != nil {
+		for _, pw := range pws {
+			if pw.mp != nil {
+				pt.flushCallback()
+				break
+			}
+		}
+	}
+
 	// Remove partition references from old parts.
 	for _, pw := range pws {
 		pw.decRef()
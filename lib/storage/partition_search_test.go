@@ -167,8 +167,8 @@ func testPartitionSearchEx(t *testing.T, ptt int64, tr TimeRange, partsCount, ma
 	})
 
 	// Create partition from rowss and test search on it.
-	retentionMsecs := timestampFromTime(time.Now()) - ptr.MinTimestamp + 3600*1000
-	pt, err := createPartition(ptt, "./small-table", "./big-table", nilGetDeletedMetricIDs, retentionMsecs)
+	retentionMsecs := (timestampFromTime(time.Now())-ptr.MinTimestamp)/nsecPerMsec + 3600*1000
+	pt, err := createPartition(ptt, "./small-table", "./big-table", nilGetDeletedMetricIDs, nil, nil, retentionMsecs)
 	if err != nil {
 		t.Fatalf("cannot create partition: %s", err)
 	}
@@ -192,7 +192,7 @@ func testPartitionSearchEx(t *testing.T, ptt int64, tr TimeRange, partsCount, ma
 	pt.MustClose()
 
 	// Open the created partition and test search on it.
-	pt, err = openPartition(smallPartsPath, bigPartsPath, nilGetDeletedMetricIDs, retentionMsecs)
+	pt, err = openPartition(smallPartsPath, bigPartsPath, nilGetDeletedMetricIDs, nil, nil, retentionMsecs)
 	if err != nil {
 		t.Fatalf("cannot open partition: %s", err)
 	}
@@ -241,7 +241,7 @@ func testPartitionSearchSerial(pt *partition, tsids []TSID, tr TimeRange, rbsExp
 
 	bs := []Block{}
 	var pts partitionSearch
-	pts.Init(pt, tsids, tr)
+	pts.Init(pt, tsids, tr, nil)
 	for pts.NextBlock() {
 		var b Block
 		pts.BlockRef.MustReadBlock(&b, 2)
@@ -266,7 +266,7 @@ func testPartitionSearchSerial(pt *partition, tsids []TSID, tr TimeRange, rbsExp
 	}
 
 	// verify that empty tsids returns empty result
-	pts.Init(pt, []TSID{}, tr)
+	pts.Init(pt, []TSID{}, tr, nil)
 	if pts.NextBlock() {
 		return fmt.Errorf("unexpected block got for an empty tsids list: %+v", pts.BlockRef)
 	}
@@ -12,9 +12,14 @@ type rawRow struct {
 	// TSID is time series id.
 	TSID TSID
 
-	// Timestamp is unix timestamp in milliseconds.
+	// Timestamp is unix timestamp in nanoseconds.
 	Timestamp int64
 
+	// Seq is ingestion sequence number for the row.
+	//
+	// It is used for preserving ingestion order for rows with identical timestamps.
+	Seq uint64
+
 	// Value is time series value for the given timestamp.
 	Value []byte
 
@@ -29,6 +34,7 @@ type rawRowsMarshaler struct {
 	bsw blockStreamWriter
 
 	auxTimestamps  []int64
+	auxSeqs        []uint64
 	auxValues      [][]byte
 	auxFloatValues [][]byte
 }
@@ -37,6 +43,7 @@ func (rrm *rawRowsMarshaler) reset() {
 	rrm.bsw.reset()
 
 	rrm.auxTimestamps = rrm.auxTimestamps[:0]
+	rrm.auxSeqs = rrm.auxSeqs[:0]
 	rrm.auxValues = rrm.auxValues[:0]
 	rrm.auxFloatValues = rrm.auxFloatValues[:0]
 }
@@ -76,7 +83,10 @@ func (rrs *rawRowsSort) Less(i, j int) bool {
 	if ta.MetricID != tb.MetricID {
 		return ta.MetricID < tb.MetricID
 	}
-	return a.Timestamp < b.Timestamp
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.Seq < b.Seq
 }
 func (rrs *rawRowsSort) Swap(i, j int) {
 	x := *rrs
@@ -96,7 +106,7 @@ func (rrm *rawRowsMarshaler) marshalToInmemoryPart(mp *inmemoryPart, rows []rawR
 	ph := &mp.ph
 	ph.Reset()
 
-	// Sort rows by (TSID, Timestamp) if they aren't sorted yet.
+	// Sort rows by (TSID, Timestamp, Seq) if they aren't sorted yet.
 	rrs := rawRowsSort(rows)
 	if !sort.IsSorted(&rrs) {
 		sort.Sort(&rrs)
@@ -107,28 +117,34 @@ func (rrm *rawRowsMarshaler) marshalToInmemoryPart(mp *inmemoryPart, rows []rawR
 	r := &rows[0]
 	tsid := &r.TSID
 	precisionBits := r.PrecisionBits
+	valuesSize := 0
 	tmpBlock := getBlock()
 	defer putBlock(tmpBlock)
 	for i := range rows {
 		r = &rows[i]
-		if r.TSID.MetricID == tsid.MetricID && len(rrm.auxTimestamps) < maxRowsPerBlock {
+		if r.TSID.MetricID == tsid.MetricID && (len(rrm.auxFloatValues) == 0 ||
+			canAddValue(len(rrm.auxFloatValues), rrm.auxFloatValues[0], r.Value, valuesSize)) {
 			rrm.auxTimestamps = append(rrm.auxTimestamps, r.Timestamp)
+			rrm.auxSeqs = append(rrm.auxSeqs, r.Seq)
 			rrm.auxFloatValues = append(rrm.auxFloatValues, r.Value)
+			valuesSize += len(r.Value)
 			continue
 		}
 
 		rrm.auxValues = append(rrm.auxValues[:0], rrm.auxFloatValues...)
-		tmpBlock.Init(tsid, rrm.auxTimestamps, rrm.auxValues, precisionBits)
+		tmpBlock.Init(tsid, rrm.auxTimestamps, rrm.auxSeqs, rrm.auxValues, precisionBits)
 		rrm.bsw.WriteExternalBlock(tmpBlock, ph, &rowsMerged)
 
 		tsid = &r.TSID
 		precisionBits = r.PrecisionBits
 		rrm.auxTimestamps = append(rrm.auxTimestamps[:0], r.Timestamp)
+		rrm.auxSeqs = append(rrm.auxSeqs[:0], r.Seq)
 		rrm.auxFloatValues = append(rrm.auxFloatValues[:0], r.Value)
+		valuesSize = len(r.Value)
 	}
 
 	rrm.auxValues = append(rrm.auxValues[:0], rrm.auxFloatValues...)
-	tmpBlock.Init(tsid, rrm.auxTimestamps, rrm.auxValues, precisionBits)
+	tmpBlock.Init(tsid, rrm.auxTimestamps, rrm.auxSeqs, rrm.auxValues, precisionBits)
 	rrm.bsw.WriteExternalBlock(tmpBlock, ph, &rowsMerged)
 	if rowsMerged != uint64(len(rows)) {
 		logger.Panicf("BUG: unexpected rowsMerged; got %d; want %d", rowsMerged, len(rows))
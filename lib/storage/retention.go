@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+)
+
+// TenantRetention contains retention override for the given tenant.
+type TenantRetention struct {
+	AccountID uint32
+	ProjectID uint32
+
+	// RetentionMsecs is the retention in milliseconds for the tenant data.
+	RetentionMsecs int64
+}
+
+// SetTenantRetentions sets per-tenant retention overrides.
+//
+// Data for the given tenants is deleted during background merges and is hidden from search results
+// after the tenant retention instead of the retention passed to OpenStorage.
+// Partitions are dropped after the maximum retention across all the tenants.
+//
+// This function must be called before initializing the storage.
+func SetTenantRetentions(trs []TenantRetention) {
+	m := make(map[accountProjectKey]int64, len(trs))
+	for _, tr := range trs {
+		k := accountProjectKey{
+			AccountID: tr.AccountID,
+			ProjectID: tr.ProjectID,
+		}
+		m[k] = tr.RetentionMsecs
+	}
+	tenantRetentionMsecs = m
+}
+
+var tenantRetentionMsecs map[accountProjectKey]int64
+
+// RetentionFilter contains retention for streams matching the given tag filters.
+type RetentionFilter struct {
+	// Filters contains tag filters for matching streams.
+	//
+	// The filter is applied only to streams for the tenant specified in Filters.
+	Filters *TagFilters
+
+	// RetentionMsecs is the retention in milliseconds for the matching streams.
+	RetentionMsecs int64
+}
+
+// SetRetentionFilters sets retention filters.
+//
+// Streams matching the first filter for the given tenant are deleted during background merges
+// after the filter retention instead of the tenant retention.
+// Partitions are dropped after the maximum retention across all the filters and tenants.
+//
+// This function must be called before initializing the storage.
+func SetRetentionFilters(rfs []RetentionFilter) {
+	m := make(map[accountProjectKey][]*retentionFilter)
+	for _, rf := range rfs {
+		tfs := rf.Filters
+		k := accountProjectKey{
+			AccountID: tfs.accountID,
+			ProjectID: tfs.projectID,
+		}
+		m[k] = append(m[k], &retentionFilter{
+			tfss:           append([]*TagFilters{tfs}, tfs.Finalize()...),
+			retentionMsecs: rf.RetentionMsecs,
+		})
+	}
+	retentionFilters = m
+}
+
+var retentionFilters map[accountProjectKey][]*retentionFilter
+
+// retentionFilter is a RetentionFilter prepared for matching.
+type retentionFilter struct {
+	// tfss contains the filter with complementary filters obtained via TagFilters.Finalize.
+	// The stream matches the filter if it matches any of tfss.
+	tfss []*TagFilters
+
+	retentionMsecs int64
+}
+
+var (
+	retentionFilterRowsDeleted  uint64
+	retentionFilterBytesDeleted uint64
+)
+
+// searchMetricNameFunc must append marshaled MetricName for the given metricID to dst and return the result.
+//
+// It must return io.EOF if the MetricName isn't found.
+type searchMetricNameFunc func(dst []byte, metricID uint64, accountID, projectID uint32) ([]byte, error)
+
+// getMaxRetentionMsecs returns the maximum retention across retentionMsecs, per-tenant retentions and retention filters.
+func getMaxRetentionMsecs(retentionMsecs int64) int64 {
+	for _, msecs := range tenantRetentionMsecs {
+		if msecs > retentionMsecs {
+			retentionMsecs = msecs
+		}
+	}
+	for _, rfs := range retentionFilters {
+		for _, rf := range rfs {
+			if rf.retentionMsecs > retentionMsecs {
+				retentionMsecs = rf.retentionMsecs
+			}
+		}
+	}
+	return retentionMsecs
+}
+
+// getTenantRetentionMsecs returns retention for the given tenant.
+//
+// retentionMsecs is returned if the tenant has no retention override.
+func getTenantRetentionMsecs(accountID, projectID uint32, retentionMsecs int64) int64 {
+	k := accountProjectKey{
+		AccountID: accountID,
+		ProjectID: projectID,
+	}
+	if msecs, ok := tenantRetentionMsecs[k]; ok {
+		return msecs
+	}
+	return retentionMsecs
+}
+
+// retentionDeadlines contains the minimum timestamps for the data to keep during the merge.
+//
+// It mustn't be used from concurrently running goroutines.
+type retentionDeadlines struct {
+	deadline        int64
+	tenantDeadlines map[accountProjectKey]int64
+
+	// filterDeadlines contains deadlines for retention filters per tenant.
+	filterDeadlines  map[accountProjectKey][]filterDeadline
+	searchMetricName searchMetricNameFunc
+
+	// The deadline for the last seen metricID, since blocks for the same metricID are merged in a row.
+	prevMetricID      uint64
+	prevDeadline      int64
+	prevIsFilterMatch bool
+	prevDeadlineIsSet bool
+
+	metricName []byte
+	mn         MetricName
+	kb         bytesutil.ByteBuffer
+}
+
+type filterDeadline struct {
+	// tfss contains tag filters for matchTagFilters.
+	// They are copied per retentionDeadlines, since matchTagFilters may re-order them.
+	tfss     [][]*tagFilter
+	deadline int64
+}
+
+// newRetentionDeadlines returns retention deadlines at the given timestamp in nanoseconds for the given retentionMsecs,
+// per-tenant retentions set via SetTenantRetentions and retention filters set via SetRetentionFilters.
+//
+// Retention filters are ignored if searchMetricName is nil.
+func newRetentionDeadlines(timestamp, retentionMsecs int64, searchMetricName searchMetricNameFunc) *retentionDeadlines {
+	rd := &retentionDeadlines{
+		deadline: timestamp - retentionMsecs*nsecPerMsec,
+	}
+	if len(tenantRetentionMsecs) > 0 {
+		rd.tenantDeadlines = make(map[accountProjectKey]int64, len(tenantRetentionMsecs))
+		for k, msecs := range tenantRetentionMsecs {
+			rd.tenantDeadlines[k] = timestamp - msecs*nsecPerMsec
+		}
+	}
+	if len(retentionFilters) > 0 && searchMetricName != nil {
+		rd.searchMetricName = searchMetricName
+		rd.filterDeadlines = make(map[accountProjectKey][]filterDeadline, len(retentionFilters))
+		for k, rfs := range retentionFilters {
+			fds := make([]filterDeadline, len(rfs))
+			for i, rf := range rfs {
+				fd := &fds[i]
+				for _, tfs := range rf.tfss {
+					tfsCopy := make([]*tagFilter, len(tfs.tfs))
+					for j := range tfs.tfs {
+						tfsCopy[j] = &tfs.tfs[j]
+					}
+					fd.tfss = append(fd.tfss, tfsCopy)
+				}
+				fd.deadline = timestamp - rf.retentionMsecs*nsecPerMsec
+			}
+			rd.filterDeadlines[k] = fds
+		}
+	}
+	return rd
+}
+
+// isExpired returns true if the block with the given bh is outside the retention.
+//
+// rd may be nil. In this case false is returned.
+func (rd *retentionDeadlines) isExpired(bh *blockHeader) bool {
+	if rd == nil {
+		return false
+	}
+	deadline, isFilterMatch := rd.get(&bh.TSID)
+	if bh.MaxTimestamp >= deadline {
+		return false
+	}
+	if isFilterMatch {
+		atomic.AddUint64(&retentionFilterRowsDeleted, uint64(bh.RowsCount))
+		atomic.AddUint64(&retentionFilterBytesDeleted, uint64(bh.TimestampsBlockSize)+uint64(bh.ValuesBlockSize))
+	}
+	return true
+}
+
+// get returns the minimum timestamp for the data to keep for the given tsid.
+//
+// It also returns true if the deadline is obtained from retention filter.
+func (rd *retentionDeadlines) get(tsid *TSID) (int64, bool) {
+	k := accountProjectKey{
+		AccountID: tsid.AccountID,
+		ProjectID: tsid.ProjectID,
+	}
+	deadline := rd.deadline
+	if d, ok := rd.tenantDeadlines[k]; ok {
+		deadline = d
+	}
+	fds := rd.filterDeadlines[k]
+	if len(fds) == 0 {
+		return deadline, false
+	}
+	if rd.prevDeadlineIsSet && rd.prevMetricID == tsid.MetricID {
+		return rd.prevDeadline, rd.prevIsFilterMatch
+	}
+	isFilterMatch := false
+	if d, ok := rd.getFilterDeadline(fds, tsid); ok {
+		deadline = d
+		isFilterMatch = true
+	}
+	rd.prevMetricID = tsid.MetricID
+	rd.prevDeadline = deadline
+	rd.prevIsFilterMatch = isFilterMatch
+	rd.prevDeadlineIsSet = true
+	return deadline, isFilterMatch
+}
+
+func (rd *retentionDeadlines) getFilterDeadline(fds []filterDeadline, tsid *TSID) (int64, bool) {
+	var err error
+	rd.metricName, err = rd.searchMetricName(rd.metricName[:0], tsid.MetricID, tsid.AccountID, tsid.ProjectID)
+	if err != nil {
+		if err != io.EOF {
+			logger.Errorf("cannot find metric name for metricID=%d; skipping retention filters for it: %s", tsid.MetricID, err)
+		}
+		// Keep the data for missing metric name according to the tenant retention.
+		return 0, false
+	}
+	if err := rd.mn.Unmarshal(rd.metricName); err != nil {
+		logger.Errorf("cannot unmarshal metric name for metricID=%d; skipping retention filters for it: %s", tsid.MetricID, err)
+		return 0, false
+	}
+	for i := range fds {
+		fd := &fds[i]
+		for _, tfs := range fd.tfss {
+			ok, err := matchTagFilters(&rd.mn, tfs, &rd.kb)
+			if err != nil {
+				logger.Errorf("cannot match metric name %s against retention filter: %s", &rd.mn, err)
+				continue
+			}
+			if ok {
+				return fd.deadline, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// GetRetentionDeadline returns the minimum timestamp in nanoseconds for the data to return
+// from search for the given tenant.
+//
+// The deadline corresponds to the maximum retention among the tenant retention and the retention filters for the tenant,
+// so the data for streams matching retention filters must be trimmed further according to NewSearchRetentionDeadlines.
+func (s *Storage) GetRetentionDeadline(accountID, projectID uint32) int64 {
+	retentionMsecs := getTenantRetentionMsecs(accountID, projectID, s.retentionMsecs)
+	k := accountProjectKey{
+		AccountID: accountID,
+		ProjectID: projectID,
+	}
+	for _, rf := range retentionFilters[k] {
+		if rf.retentionMsecs > retentionMsecs {
+			retentionMsecs = rf.retentionMsecs
+		}
+	}
+	return int64(fasttime.UnixTimestamp()*1e9) - retentionMsecs*nsecPerMsec
+}
+
+// SearchRetentionDeadlines contains the minimum timestamps for the data to return from search per stream.
+//
+// It mustn't be used from concurrently running goroutines.
+type SearchRetentionDeadlines struct {
+	rd *retentionDeadlines
+}
+
+// NewSearchRetentionDeadlines returns the minimum timestamps for the data to return from search
+// for the streams of the given tenant according to the tenant retention and the retention filters for the tenant.
+//
+// nil is returned if the tenant has no retention filters. GetRetentionDeadline must be used for all the streams in this case.
+func (s *Storage) NewSearchRetentionDeadlines(accountID, projectID uint32) *SearchRetentionDeadlines {
+	k := accountProjectKey{
+		AccountID: accountID,
+		ProjectID: projectID,
+	}
+	if len(retentionFilters[k]) == 0 {
+		return nil
+	}
+	timestamp := int64(fasttime.UnixTimestamp() * 1e9)
+	return &SearchRetentionDeadlines{
+		rd: newRetentionDeadlines(timestamp, s.retentionMsecs, s.searchMetricName),
+	}
+}
+
+// GetForBlock returns the minimum timestamp in nanoseconds for the data to return from search for the stream of b.
+func (srd *SearchRetentionDeadlines) GetForBlock(b *Block) int64 {
+	deadline, _ := srd.rd.get(&b.bh.TSID)
+	return deadline
+}
+
+// TrimRowsBefore removes rows with timestamps smaller than minTimestamp from b.
+//
+// It returns false if no rows are left in b. Otherwise b is marshaled again, so it may be passed to MarshalBlock.
+//
+// b must be read with non-zero fetchData.
+func (b *Block) TrimRowsBefore(minTimestamp int64) (bool, error) {
+	if b.bh.MinTimestamp >= minTimestamp {
+		// Fast path - nothing to trim.
+		return true, nil
+	}
+	if b.bh.MaxTimestamp < minTimestamp {
+		return false, nil
+	}
+	if len(b.timestampsData) == 0 {
+		// The block has been read with zero fetchData, so it contains only the header.
+		// Keep it, since it contains rows after minTimestamp.
+		return true, nil
+	}
+	if len(b.valuesData) == 0 {
+		// The block has been read with fetchData=1, so it contains only timestamps.
+		timestamps, err := encoding.UnmarshalTimestamps(nil, b.timestampsData, b.bh.TimestampsMarshalType, b.bh.MinTimestamp, int(b.bh.RowsCount))
+		if err != nil {
+			return false, fmt.Errorf("cannot unmarshal timestamps for trimming: %w", err)
+		}
+		if b.bh.PrecisionBits < 64 {
+			// Recover timestamps order after lossy compression.
+			encoding.EnsureNonDecreasingSequence(timestamps, b.bh.MinTimestamp, b.bh.MaxTimestamp)
+		}
+		timestamps = timestamps[firstTimestampIdx(timestamps, minTimestamp):]
+		b.timestampsData, b.bh.TimestampsMarshalType, b.bh.MinTimestamp = encoding.MarshalTimestamps(b.timestampsData[:0], timestamps, b.bh.PrecisionBits)
+		b.bh.TimestampsBlockSize = uint32(len(b.timestampsData))
+		b.bh.RowsCount = uint32(len(timestamps))
+		return true, nil
+	}
+	if err := b.UnmarshalData(true); err != nil {
+		return false, fmt.Errorf("cannot unmarshal block for trimming: %w", err)
+	}
+	// Timestamps are sorted, so it is enough to skip the prefix with too small timestamps.
+	b.nextIdx = firstTimestampIdx(b.timestamps, minTimestamp)
+	b.MarshalData(0, 0)
+	return true, nil
+}
+
+func firstTimestampIdx(timestamps []int64, minTimestamp int64) int {
+	i := 0
+	for i < len(timestamps) && timestamps[i] < minTimestamp {
+		i++
+	}
+	return i
+}
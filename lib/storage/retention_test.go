@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+)
+
+func TestRetentionDeadlines(t *testing.T) {
+	SetTenantRetentions([]TenantRetention{
+		{
+			AccountID:      1,
+			ProjectID:      2,
+			RetentionMsecs: 10,
+		},
+		{
+			AccountID:      3,
+			RetentionMsecs: 1000,
+		},
+	})
+	defer SetTenantRetentions(nil)
+
+	if n := getMaxRetentionMsecs(100); n != 1000 {
+		t.Fatalf("unexpected max retention; got %d; want %d", n, 1000)
+	}
+	if n := getMaxRetentionMsecs(2000); n != 2000 {
+		t.Fatalf("unexpected max retention; got %d; want %d", n, 2000)
+	}
+
+	timestamp := int64(1e12)
+	rd := newRetentionDeadlines(timestamp, 100, nil)
+	f := func(accountID, projectID uint32, retentionMsecsExpected int64) {
+		t.Helper()
+		tsid := &TSID{
+			AccountID: accountID,
+			ProjectID: projectID,
+		}
+		deadline, isFilterMatch := rd.get(tsid)
+		if isFilterMatch {
+			t.Fatalf("unexpected filter match for tenant %d:%d", accountID, projectID)
+		}
+		deadlineExpected := timestamp - retentionMsecsExpected*nsecPerMsec
+		if deadline != deadlineExpected {
+			t.Fatalf("unexpected deadline for tenant %d:%d; got %d; want %d", accountID, projectID, deadline, deadlineExpected)
+		}
+		if n := getTenantRetentionMsecs(accountID, projectID, 100); n != retentionMsecsExpected {
+			t.Fatalf("unexpected retention for tenant %d:%d; got %d; want %d", accountID, projectID, n, retentionMsecsExpected)
+		}
+	}
+	f(1, 2, 10)
+	f(3, 0, 1000)
+	f(1, 0, 100)
+	f(3, 1, 100)
+
+	var rdNil *retentionDeadlines
+	if rdNil.isExpired(&blockHeader{}) {
+		t.Fatalf("nil retentionDeadlines mustn't expire blocks")
+	}
+}
+
+func TestRetentionFilters(t *testing.T) {
+	newFilter := func(key, value string, isRegexp bool, retentionMsecs int64) RetentionFilter {
+		t.Helper()
+		tfs := NewTagFilters(1, 0)
+		if err := tfs.Add([]byte(key), []byte(value), false, isRegexp); err != nil {
+			t.Fatalf("cannot add tag filter: %s", err)
+		}
+		return RetentionFilter{
+			Filters:        tfs,
+			RetentionMsecs: retentionMsecs,
+		}
+	}
+	SetRetentionFilters([]RetentionFilter{
+		newFilter("level", "debug", false, 10),
+		newFilter("app", "pay.*", true, 1000),
+		newFilter("level", "", false, 20),
+	})
+	defer SetRetentionFilters(nil)
+
+	if n := getMaxRetentionMsecs(100); n != 1000 {
+		t.Fatalf("unexpected max retention; got %d; want %d", n, 1000)
+	}
+
+	newMetricName := func(accountID uint32, tags ...string) *MetricName {
+		mn := &MetricName{
+			AccountID: accountID,
+		}
+		for i := 0; i < len(tags); i += 2 {
+			mn.AddTag(tags[i], tags[i+1])
+		}
+		mn.sortTags()
+		return mn
+	}
+	metricNames := map[uint64]*MetricName{
+		1: newMetricName(1, "level", "debug", "app", "payments"),
+		2: newMetricName(1, "level", "info", "app", "payments"),
+		3: newMetricName(1, "level", "info", "app", "nginx"),
+		4: newMetricName(1, "app", "nginx"),
+		5: newMetricName(2, "level", "debug"),
+	}
+	searchMetricName := func(dst []byte, metricID uint64, accountID, projectID uint32) ([]byte, error) {
+		mn := metricNames[metricID]
+		if mn == nil || mn.AccountID != accountID || mn.ProjectID != projectID {
+			return dst, io.EOF
+		}
+		return mn.Marshal(dst), nil
+	}
+
+	timestamp := int64(1e12)
+	rd := newRetentionDeadlines(timestamp, 100, searchMetricName)
+	f := func(metricID uint64, accountID uint32, retentionMsecsExpected int64, isFilterMatchExpected bool) {
+		t.Helper()
+		tsid := &TSID{
+			AccountID: accountID,
+			MetricID:  metricID,
+		}
+		// Call get twice in order to verify the cache for the last metricID.
+		for i := 0; i < 2; i++ {
+			deadline, isFilterMatch := rd.get(tsid)
+			deadlineExpected := timestamp - retentionMsecsExpected*nsecPerMsec
+			if deadline != deadlineExpected {
+				t.Fatalf("unexpected deadline for metricID=%d; got %d; want %d", metricID, deadline, deadlineExpected)
+			}
+			if isFilterMatch != isFilterMatchExpected {
+				t.Fatalf("unexpected isFilterMatch for metricID=%d; got %v; want %v", metricID, isFilterMatch, isFilterMatchExpected)
+			}
+		}
+	}
+	f(1, 1, 10, true)
+	f(2, 1, 1000, true)
+	f(3, 1, 100, false)
+	f(4, 1, 20, true)
+	f(5, 2, 100, false)
+	f(6, 1, 100, false)
+
+	// Verify metrics for deleted rows.
+	rowsDeleted := atomic.LoadUint64(&retentionFilterRowsDeleted)
+	bytesDeleted := atomic.LoadUint64(&retentionFilterBytesDeleted)
+	bh := &blockHeader{
+		TSID: TSID{
+			AccountID: 1,
+			MetricID:  1,
+		},
+		MaxTimestamp:        timestamp - 11*nsecPerMsec,
+		RowsCount:           3,
+		TimestampsBlockSize: 10,
+		ValuesBlockSize:     20,
+	}
+	if !rd.isExpired(bh) {
+		t.Fatalf("expecting expired block")
+	}
+	if n := atomic.LoadUint64(&retentionFilterRowsDeleted) - rowsDeleted; n != 3 {
+		t.Fatalf("unexpected number of deleted rows; got %d; want 3", n)
+	}
+	if n := atomic.LoadUint64(&retentionFilterBytesDeleted) - bytesDeleted; n != 30 {
+		t.Fatalf("unexpected number of deleted bytes; got %d; want 30", n)
+	}
+	bh.MaxTimestamp = timestamp - 9*nsecPerMsec
+	if rd.isExpired(bh) {
+		t.Fatalf("unexpected expired block")
+	}
+}
+
+func TestSearchRetentionDeadlines(t *testing.T) {
+	tfs := NewTagFilters(1, 0)
+	if err := tfs.Add([]byte("level"), []byte("debug"), false, false); err != nil {
+		t.Fatalf("cannot add tag filter: %s", err)
+	}
+	SetRetentionFilters([]RetentionFilter{{
+		Filters:        tfs,
+		RetentionMsecs: 1000,
+	}})
+	defer SetRetentionFilters(nil)
+
+	s := &Storage{
+		retentionMsecs: 100,
+	}
+
+	// The search for the tenant with retention filters must cover the maximum retention among the filters.
+	timestamp := int64(fasttime.UnixTimestamp() * 1e9)
+	if d := s.GetRetentionDeadline(1, 0); d > timestamp-1000*nsecPerMsec {
+		t.Fatalf("too big retention deadline for the tenant with retention filters; got %d; want up to %d", d, timestamp-1000*nsecPerMsec)
+	}
+	if d := s.GetRetentionDeadline(2, 0); d < timestamp-100*nsecPerMsec {
+		t.Fatalf("too small retention deadline for the tenant without retention filters; got %d; want at least %d", d, timestamp-100*nsecPerMsec)
+	}
+	if srd := s.NewSearchRetentionDeadlines(2, 0); srd != nil {
+		t.Fatalf("expecting nil SearchRetentionDeadlines for the tenant without retention filters")
+	}
+
+	// Streams are trimmed according to the matching retention filters.
+	mnDebug := &MetricName{
+		AccountID: 1,
+	}
+	mnDebug.AddTag("level", "debug")
+	searchMetricName := func(dst []byte, metricID uint64, accountID, projectID uint32) ([]byte, error) {
+		if metricID != 1 {
+			return dst, io.EOF
+		}
+		return mnDebug.Marshal(dst), nil
+	}
+	srd := &SearchRetentionDeadlines{
+		rd: newRetentionDeadlines(timestamp, s.retentionMsecs, searchMetricName),
+	}
+	f := func(metricID uint64, retentionMsecsExpected int64) {
+		t.Helper()
+		var b Block
+		b.bh.TSID = TSID{
+			AccountID: 1,
+			MetricID:  metricID,
+		}
+		deadlineExpected := timestamp - retentionMsecsExpected*nsecPerMsec
+		if deadline := srd.GetForBlock(&b); deadline != deadlineExpected {
+			t.Fatalf("unexpected deadline for metricID=%d; got %d; want %d", metricID, deadline, deadlineExpected)
+		}
+	}
+	f(1, 1000)
+	f(2, 100)
+}
+
+func TestBlockTrimRowsBefore(t *testing.T) {
+	newBlock := func() *Block {
+		var b Block
+		b.bh.PrecisionBits = 64
+		b.timestamps = []int64{10, 20, 30, 40}
+		b.values = [][]byte{[]byte("foo"), []byte("bar"), []byte("baz"), []byte("qux")}
+		b.MarshalData(0, 0)
+		return &b
+	}
+	f := func(minTimestamp int64, timestampsExpected []int64, valuesExpected []string) {
+		t.Helper()
+		b := newBlock()
+		ok, err := b.TrimRowsBefore(minTimestamp)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !ok {
+			if len(timestampsExpected) > 0 {
+				t.Fatalf("expecting non-empty block after trimming")
+			}
+			return
+		}
+		if len(timestampsExpected) == 0 {
+			t.Fatalf("expecting empty block after trimming")
+		}
+		if b.RowsCount() != len(timestampsExpected) {
+			t.Fatalf("unexpected rows count; got %d; want %d", b.RowsCount(), len(timestampsExpected))
+		}
+		if err := b.UnmarshalData(true); err != nil {
+			t.Fatalf("cannot unmarshal trimmed block: %s", err)
+		}
+		for i, v := range b.values {
+			if b.timestamps[i] != timestampsExpected[i] || string(v) != valuesExpected[i] {
+				t.Fatalf("unexpected row #%d; got %d %q; want %d %q", i, b.timestamps[i], v, timestampsExpected[i], valuesExpected[i])
+			}
+		}
+	}
+	f(0, []int64{10, 20, 30, 40}, []string{"foo", "bar", "baz", "qux"})
+	f(10, []int64{10, 20, 30, 40}, []string{"foo", "bar", "baz", "qux"})
+	f(11, []int64{20, 30, 40}, []string{"bar", "baz", "qux"})
+	f(40, []int64{40}, []string{"qux"})
+	f(41, nil, nil)
+
+	// The block with only the header.
+	b := newBlock()
+	b.timestampsData = b.timestampsData[:0]
+	b.valuesData = b.valuesData[:0]
+	ok, err := b.TrimRowsBefore(25)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ok {
+		t.Fatalf("expecting the block with only the header to be kept")
+	}
+	if ok, _ := b.TrimRowsBefore(41); ok {
+		t.Fatalf("expecting the block with only the header to be dropped")
+	}
+
+	// The block with only timestamps.
+	b = newBlock()
+	b.valuesData = b.valuesData[:0]
+	ok, err = b.TrimRowsBefore(25)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ok {
+		t.Fatalf("expecting non-empty block after trimming")
+	}
+	if b.RowsCount() != 2 {
+		t.Fatalf("unexpected rows count; got %d; want %d", b.RowsCount(), 2)
+	}
+	if err := b.UnmarshalData(false); err != nil {
+		t.Fatalf("cannot unmarshal trimmed block: %s", err)
+	}
+	if len(b.timestamps) != 2 || b.timestamps[0] != 30 || b.timestamps[1] != 40 {
+		t.Fatalf("unexpected timestamps: %d", b.timestamps)
+	}
+}
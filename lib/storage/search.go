@@ -49,6 +49,11 @@ func (br *BlockRef) MustReadBlock(dst *Block, fetchData uint8) {
 		dst.valuesData = bytesutil.Resize(dst.valuesData[:0], int(br.bh.ValuesBlockSize))
 		br.p.valuesFile.MustReadAt(dst.valuesData, int64(br.bh.ValuesBlockOffset))
 	}
+	if fetchData != 0 && br.p.ph.msecTimestamps {
+		if err := dst.convertMsecTimestamps(); err != nil {
+			logger.Panicf("FATAL: cannot convert timestamps for block read from part %q: %s", br.p.path, err)
+		}
+	}
 }
 
 // MetricBlockRef contains reference to time series block for a single metric.
@@ -60,6 +65,11 @@ type MetricBlockRef struct {
 	BlockRef *BlockRef
 }
 
+// MaxMetricBlockSize is the maximum size of marshaled MetricBlock.
+//
+// It must fit a block with a single value up to MaxEntrySize together with the metric name and block header.
+const MaxMetricBlockSize = maxValuesBlockSize + 2*maxBlockSize + 1024*1024
+
 // MetricBlock is a time series block for a single metric.
 type MetricBlock struct {
 	// MetricName is metric name for the given Block.
@@ -164,12 +174,16 @@ func (s *Search) reset() {
 	s.loops = 0
 }
 
-// Init initializes s from the given storage, tfss and tr.
+// Init initializes s from the given storage, tfss, tr and lfs.
+//
+// lfs may be nil. Otherwise it is used for skipping blocks without lines matching lfs
+// with the help of per-block bloom filters. Lines in the returned blocks must be still
+// verified with lfs.Match.
 //
 // MustClose must be called when the search is done.
 //
 // Init returns the upper bound on the number of found time series.
-func (s *Search) Init(storage *Storage, tfss []*TagFilters, tr TimeRange, maxMetrics int, deadline uint64) int {
+func (s *Search) Init(storage *Storage, tfss []*TagFilters, tr TimeRange, lfs *LineFilters, maxMetrics int, deadline uint64) int {
 	if s.needClosing {
 		logger.Panicf("BUG: missing MustClose call before the next call to Init")
 	}
@@ -187,7 +201,7 @@ func (s *Search) Init(storage *Storage, tfss []*TagFilters, tr TimeRange, maxMet
 	// It is ok to call Init on error from storage.searchTSIDs.
 	// Init must be called before returning because it will fail
 	// on Seach.MustClose otherwise.
-	s.ts.Init(storage.tb, tsids, tr)
+	s.ts.Init(storage.tb, tsids, tr, lfs)
 
 	if err != nil {
 		s.err = err
@@ -259,6 +273,10 @@ type SearchQuery struct {
 	MinTimestamp int64
 	MaxTimestamp int64
 	TagFilterss  [][]TagFilter
+
+	// LineFilters contains line filters, which must be applied to the found rows
+	// before sending them to vmselect.
+	LineFilters []LineFilter
 }
 
 // TagFilter represents a single tag filter from SearchQuery.
@@ -346,6 +364,13 @@ func (sq *SearchQuery) String() string {
 		fmt.Fprintf(&bb, "\n")
 	}
 	fmt.Fprintf(&bb, "]")
+	if len(sq.LineFilters) > 0 {
+		fmt.Fprintf(&bb, ", LineFilters=[")
+		for i := range sq.LineFilters {
+			fmt.Fprintf(&bb, "%s", sq.LineFilters[i].String())
+		}
+		fmt.Fprintf(&bb, "]")
+	}
 	return string(bb.B)
 }
 
@@ -362,6 +387,10 @@ func (sq *SearchQuery) Marshal(dst []byte) []byte {
 			dst = tagFilters[i].Marshal(dst)
 		}
 	}
+	dst = encoding.MarshalVarUint64(dst, uint64(len(sq.LineFilters)))
+	for i := range sq.LineFilters {
+		dst = sq.LineFilters[i].Marshal(dst)
+	}
 	return dst
 }
 
@@ -425,6 +454,24 @@ func (sq *SearchQuery) Unmarshal(src []byte) ([]byte, error) {
 		sq.TagFilterss[i] = tagFilters
 	}
 
+	tail, lfsCount, err := encoding.UnmarshalVarUint64(src)
+	if err != nil {
+		return src, fmt.Errorf("cannot unmarshal the count of LineFilters: %w", err)
+	}
+	if n := int(lfsCount) - cap(sq.LineFilters); n > 0 {
+		sq.LineFilters = append(sq.LineFilters[:cap(sq.LineFilters)], make([]LineFilter, n)...)
+	}
+	sq.LineFilters = sq.LineFilters[:lfsCount]
+	src = tail
+
+	for i := 0; i < int(lfsCount); i++ {
+		tail, err := sq.LineFilters[i].Unmarshal(src)
+		if err != nil {
+			return tail, fmt.Errorf("cannot unmarshal LineFilter #%d: %w", i, err)
+		}
+		src = tail
+	}
+
 	return src, nil
 }
 
@@ -73,6 +73,22 @@ func TestSearchQueryMarshalUnmarshal(t *testing.T) {
 				}
 			}
 		}
+		if len(sq1.LineFilters) != len(sq2.LineFilters) {
+			t.Fatalf("unexpected LineFilters len; got %d; want %d", len(sq2.LineFilters), len(sq1.LineFilters))
+		}
+		for j := range sq1.LineFilters {
+			lf1 := &sq1.LineFilters[j]
+			lf2 := &sq2.LineFilters[j]
+			if string(lf1.Value) != string(lf2.Value) {
+				t.Fatalf("unexpected LineFilter Value on iteration %d,%d; got %X; want %X", i, j, lf2.Value, lf1.Value)
+			}
+			if lf1.IsNegative != lf2.IsNegative {
+				t.Fatalf("unexpected LineFilter IsNegative on iteration %d,%d; got %v; want %v", i, j, lf2.IsNegative, lf1.IsNegative)
+			}
+			if lf1.IsRegexp != lf2.IsRegexp {
+				t.Fatalf("unexpected LineFilter IsRegexp on iteration %d,%d; got %v; want %v", i, j, lf2.IsRegexp, lf1.IsRegexp)
+			}
+		}
 	}
 }
 
@@ -111,7 +127,7 @@ func testSearchGeneric(t *testing.T, forcePerDayInvertedIndex bool) {
 		{[]byte("instance"), []byte("8.8.8.8:1234")},
 	}
 	startTimestamp := timestampFromTime(time.Now())
-	startTimestamp -= startTimestamp % (1e3 * 60 * 30)
+	startTimestamp -= startTimestamp % (1e9 * 60 * 30)
 	blockRowsCount := 0
 	for i := 0; i < rowsCount; i++ {
 		mn.AccountID = uint32(i % accountsCount)
@@ -225,7 +241,7 @@ func testSearchInternal(st *Storage, tr TimeRange, mrs []MetricRow, accountsCoun
 		}
 
 		// Search
-		s.Init(st, []*TagFilters{tfs}, tr, 1e5, noDeadline)
+		s.Init(st, []*TagFilters{tfs}, tr, nil, 1e5, noDeadline)
 		var mbs []metricBlock
 		for s.NextMetricBlock() {
 			var b Block
@@ -37,6 +37,8 @@ type Storage struct {
 	// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/212 .
 	tooSmallTimestampRows uint64
 	tooBigTimestampRows   uint64
+	tooManyStreamsRows    uint64
+	tooLongEntryRows      uint64
 
 	addRowsConcurrencyLimitReached uint64
 	addRowsConcurrencyLimitTimeout uint64
@@ -49,10 +51,19 @@ type Storage struct {
 	slowPerDayIndexInserts uint64
 	slowMetricNameLoads    uint64
 
+	// nextRowSeq is the ingestion sequence number for the next added row.
+	//
+	// It is persisted on shutdown and is initialized with the maximum of the persisted value
+	// and the current time in nanoseconds on startup, so sequence numbers keep growing after restarts.
+	nextRowSeq uint64
+
 	path            string
 	cachePath       string
 	retentionMonths int
 
+	// retentionMsecs is the retention for tenants without overrides set via SetTenantRetentions.
+	retentionMsecs int64
+
 	// lock file for exclusive access to the storage on the given path.
 	flockF *os.File
 
@@ -60,6 +71,20 @@ type Storage struct {
 
 	tb *table
 
+	// wal is write-ahead log for rows passed to AppendWAL.
+	// It is nil if write-ahead log is disabled via SetWAL and there are no segments to replay.
+	wal *wal
+
+	// walTruncateCh notifies walTruncator about rows flushed to persistent storage.
+	walTruncateCh chan struct{}
+
+	// walTruncateLock prevents from concurrent truncateWAL calls.
+	walTruncateLock sync.Mutex
+
+	// activeStreams tracks active streams per tenant.
+	// It is nil if there is no limit on the number of active streams per tenant.
+	activeStreams *activeStreams
+
 	// tsidCache is MetricName -> TSID cache.
 	tsidCache *workingsetcache.Cache
 
@@ -100,6 +125,7 @@ type Storage struct {
 	currHourMetricIDsUpdaterWG sync.WaitGroup
 	nextDayMetricIDsUpdaterWG  sync.WaitGroup
 	retentionWatcherWG         sync.WaitGroup
+	walTruncatorWG             sync.WaitGroup
 
 	// The snapshotLock prevents from concurrent creation of snapshots,
 	// since this may result in snapshots without recently added data,
@@ -128,13 +154,20 @@ func OpenStorage(path string, retentionMsecs int64) (*Storage, error) {
 	if retentionMsecs <= 0 {
 		retentionMsecs = maxRetentionMsecs
 	}
-	retentionMonths := (retentionMsecs + (msecsPerMonth - 1)) / msecsPerMonth
+	// indexdb must be rotated according to the maximum retention, so it keeps the data for tenants with bigger retentions.
+	retentionMonths := (getMaxRetentionMsecs(retentionMsecs) + (msecsPerMonth - 1)) / msecsPerMonth
 	s := &Storage{
 		path:            path,
 		cachePath:       path + "/cache",
 		retentionMonths: int(retentionMonths),
+		retentionMsecs:  retentionMsecs,
 
 		stop: make(chan struct{}),
+
+		walTruncateCh: make(chan struct{}, 1),
+	}
+	if maxActiveStreamsPerTenant > 0 {
+		s.activeStreams = newActiveStreams(maxActiveStreamsPerTenant, activeStreamsWindow)
 	}
 
 	if err := fs.MkdirAllIfNotExist(path); err != nil {
@@ -172,6 +205,8 @@ func OpenStorage(path string, retentionMsecs int64) (*Storage, error) {
 
 	s.prefetchedMetricIDs.Store(&uint64set.Set{})
 
+	s.nextRowSeq = s.mustLoadNextRowSeq()
+
 	// Load indexdb
 	idbPath := path + "/indexdb"
 	idbSnapshotsPath := idbPath + "/snapshots"
@@ -187,16 +222,26 @@ func OpenStorage(path string, retentionMsecs int64) (*Storage, error) {
 
 	// Load data
 	tablePath := path + "/data"
-	tb, err := openTable(tablePath, s.getDeletedMetricIDs, retentionMsecs)
+	tb, err := openTable(tablePath, s.getDeletedMetricIDs, s.searchMetricName, s.tableFlushCallback, retentionMsecs)
 	if err != nil {
 		s.idb().MustClose()
 		return nil, fmt.Errorf("cannot open table at %q: %w", tablePath, err)
 	}
 	s.tb = tb
 
+	// Recover rows, which weren't flushed to persistent storage before the previous shutdown.
+	if err := s.replayAndOpenWAL(); err != nil {
+		s.tb.MustClose()
+		s.idb().MustClose()
+		return nil, err
+	}
+
 	s.startCurrHourMetricIDsUpdater()
 	s.startNextDayMetricIDsUpdater()
 	s.startRetentionWatcher()
+	if s.wal != nil {
+		s.startWALTruncator()
+	}
 
 	return s, nil
 }
@@ -335,8 +380,19 @@ type Metrics struct {
 	RowsAddedTotal    uint64
 	DedupsDuringMerge uint64
 
+	RetentionFilterRowsDeleted  uint64
+	RetentionFilterBytesDeleted uint64
+
+	WALSizeBytes     uint64
+	WALSegmentsCount uint64
+	WALRowsReplayed  uint64
+
 	TooSmallTimestampRows uint64
 	TooBigTimestampRows   uint64
+	TooLongEntryRows      uint64
+	TooManyStreamsRows    uint64
+
+	ActiveStreams uint64
 
 	AddRowsConcurrencyLimitReached uint64
 	AddRowsConcurrencyLimitTimeout uint64
@@ -358,6 +414,10 @@ type Metrics struct {
 	TimestampsBlocksMerged uint64
 	TimestampsBytesSaved   uint64
 
+	BloomFilterBlocksChecked uint64
+	BloomFilterBlocksSkipped uint64
+	BloomFiltersReused       uint64
+
 	TSIDCacheSize       uint64
 	TSIDCacheSizeBytes  uint64
 	TSIDCacheRequests   uint64
@@ -404,8 +464,23 @@ func (s *Storage) UpdateMetrics(m *Metrics) {
 	m.RowsAddedTotal = atomic.LoadUint64(&rowsAddedTotal)
 	m.DedupsDuringMerge = atomic.LoadUint64(&dedupsDuringMerge)
 
+	m.RetentionFilterRowsDeleted = atomic.LoadUint64(&retentionFilterRowsDeleted)
+	m.RetentionFilterBytesDeleted = atomic.LoadUint64(&retentionFilterBytesDeleted)
+
+	if s.wal != nil {
+		m.WALSizeBytes += atomic.LoadUint64(&s.wal.sizeBytes)
+		m.WALSegmentsCount += uint64(s.wal.SegmentsCount())
+	}
+	m.WALRowsReplayed = atomic.LoadUint64(&walRowsReplayed)
+
 	m.TooSmallTimestampRows += atomic.LoadUint64(&s.tooSmallTimestampRows)
 	m.TooBigTimestampRows += atomic.LoadUint64(&s.tooBigTimestampRows)
+	m.TooLongEntryRows += atomic.LoadUint64(&s.tooLongEntryRows)
+	m.TooManyStreamsRows += atomic.LoadUint64(&s.tooManyStreamsRows)
+
+	if s.activeStreams != nil {
+		m.ActiveStreams += uint64(s.activeStreams.Len())
+	}
 
 	m.AddRowsConcurrencyLimitReached += atomic.LoadUint64(&s.addRowsConcurrencyLimitReached)
 	m.AddRowsConcurrencyLimitTimeout += atomic.LoadUint64(&s.addRowsConcurrencyLimitTimeout)
@@ -427,6 +502,10 @@ func (s *Storage) UpdateMetrics(m *Metrics) {
 	m.TimestampsBlocksMerged = atomic.LoadUint64(&timestampsBlocksMerged)
 	m.TimestampsBytesSaved = atomic.LoadUint64(&timestampsBytesSaved)
 
+	m.BloomFilterBlocksChecked = atomic.LoadUint64(&bloomFilterBlocksChecked)
+	m.BloomFilterBlocksSkipped = atomic.LoadUint64(&bloomFilterBlocksSkipped)
+	m.BloomFiltersReused = atomic.LoadUint64(&bloomFiltersReused)
+
 	var cs fastcache.Stats
 	s.tsidCache.UpdateStats(&cs)
 	m.TSIDCacheSize += cs.EntriesCount
@@ -588,10 +667,16 @@ func (s *Storage) MustClose() {
 	s.retentionWatcherWG.Wait()
 	s.currHourMetricIDsUpdaterWG.Wait()
 	s.nextDayMetricIDsUpdaterWG.Wait()
+	s.walTruncatorWG.Wait()
 
 	s.tb.MustClose()
 	s.idb().MustClose()
 
+	// The added rows are flushed to persistent storage by now, so write-ahead log segments for them may be removed.
+	if s.wal != nil {
+		s.wal.MustClose()
+	}
+
 	// Save caches.
 	s.mustSaveAndStopCache(s.tsidCache, "MetricName->TSID", "metricName_tsid")
 	s.mustSaveAndStopCache(s.metricIDCache, "MetricID->TSID", "metricID_tsid")
@@ -605,12 +690,50 @@ func (s *Storage) MustClose() {
 	nextDayMetricIDs := s.nextDayMetricIDs.Load().(*byDateMetricIDEntry)
 	s.mustSaveNextDayMetricIDs(nextDayMetricIDs)
 
+	s.mustSaveNextRowSeq()
+
 	// Release lock file.
 	if err := s.flockF.Close(); err != nil {
 		logger.Panicf("FATAL: cannot close lock file %q: %s", s.flockF.Name(), err)
 	}
 }
 
+// mustLoadNextRowSeq returns the initial ingestion sequence number for s.
+//
+// The sequence number is persisted on shutdown. The current time in nanoseconds is used
+// if it is bigger than the persisted value, e.g. after unclean shutdown.
+func (s *Storage) mustLoadNextRowSeq() uint64 {
+	seq := uint64(time.Now().UnixNano())
+	name := "next_row_seq"
+	path := s.cachePath + "/" + name
+	if !fs.IsPathExist(path) {
+		return seq
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Panicf("FATAL: cannot read %s: %s", path, err)
+	}
+	if len(src) != 8 {
+		logger.Errorf("discarding %s, since it has broken size; got %d bytes; want %d bytes", path, len(src), 8)
+		return seq
+	}
+	seqLoaded := encoding.UnmarshalUint64(src)
+	if seqLoaded > seq {
+		// The clock went backwards since the previous run.
+		logger.Infof("using %s=%d loaded from %q, since it exceeds the current time in nanoseconds", name, seqLoaded, path)
+		seq = seqLoaded
+	}
+	return seq
+}
+
+func (s *Storage) mustSaveNextRowSeq() {
+	path := s.cachePath + "/next_row_seq"
+	dst := encoding.MarshalUint64(nil, atomic.LoadUint64(&s.nextRowSeq))
+	if err := ioutil.WriteFile(path, dst, 0644); err != nil {
+		logger.Panicf("FATAL: cannot write %d bytes to %q: %s", len(dst), path, err)
+	}
+}
+
 func (s *Storage) mustLoadNextDayMetricIDs(date uint64) *byDateMetricIDEntry {
 	e := &byDateMetricIDEntry{
 		date: date,
@@ -1163,10 +1286,14 @@ func (s *Storage) AddRows(mrs []MetricRow, precisionBits uint8) error {
 		}
 	}
 
+	// Reserve ingestion sequence numbers for mrs, so rows with identical timestamps
+	// are returned in the order they were added.
+	firstSeq := atomic.AddUint64(&s.nextRowSeq, uint64(len(mrs))) - uint64(len(mrs))
+
 	// Add rows to the storage.
 	var err error
 	rr := getRawRowsWithSize(len(mrs))
-	rr.rows, err = s.add(rr.rows, mrs, precisionBits)
+	rr.rows, err = s.add(rr.rows, mrs, firstSeq, precisionBits)
 	putRawRows(rr)
 
 	<-addRowsConcurrencyCh
@@ -1182,7 +1309,10 @@ var (
 	addRowsTimeout       = 30 * time.Second
 )
 
-func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]rawRow, error) {
+// add adds mrs to s.
+//
+// Rows from mrs get ingestion sequence numbers starting from firstSeq.
+func (s *Storage) add(rows []rawRow, mrs []MetricRow, firstSeq uint64, precisionBits uint8) ([]rawRow, error) {
 	idb := s.idb()
 	rowsLen := len(rows)
 	if n := rowsLen + len(mrs) - cap(rows); n > 0 {
@@ -1199,6 +1329,22 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 	minTimestamp, maxTimestamp := s.tb.getMinMaxTimestamps()
 	// Return only the first error, since it has no sense in returning all errors.
 	var firstWarn error
+	var (
+		// These vars are used for collecting rows for new streams exceeding the limit on active streams per tenant.
+		rejected     []RejectedRows
+		rejectedRows []RejectedRow
+	)
+	rejectRow := func(metricNameRaw []byte, idx int) {
+		rr := RejectedRow{
+			Idx:       idx,
+			AccountID: encoding.UnmarshalUint32(metricNameRaw),
+			ProjectID: encoding.UnmarshalUint32(metricNameRaw[4:]),
+		}
+		rejected = addRejectedRow(rejected, rr.AccountID, rr.ProjectID)
+		rejectedRows = append(rejectedRows, rr)
+		atomic.AddUint64(&s.tooManyStreamsRows, 1)
+	}
+	currentTime := fasttime.UnixTimestamp()
 	for i := range mrs {
 		mr := &mrs[i]
 		if len(mr.Value) == 0 {
@@ -1226,9 +1372,20 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 			atomic.AddUint64(&s.tooBigTimestampRows, 1)
 			continue
 		}
+		if len(mr.Value) > MaxEntrySize {
+			// Skip rows with too long values, since they cannot be stored in a block.
+			// Such rows must be truncated or rejected by vminsert. See -ingest.maxEntrySize.
+			if firstWarn == nil {
+				firstWarn = fmt.Errorf("cannot insert row with too long value of %d bytes; maximum allowed value size is %d bytes",
+					len(mr.Value), MaxEntrySize)
+			}
+			atomic.AddUint64(&s.tooLongEntryRows, 1)
+			continue
+		}
 		r := &rows[rowsLen+j]
 		j++
 		r.Timestamp = mr.Timestamp
+		r.Seq = firstSeq + uint64(i)
 		r.Value = mr.Value
 		r.PrecisionBits = precisionBits
 		if string(mr.MetricNameRaw) == string(prevMetricNameRaw) {
@@ -1242,6 +1399,9 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 			// There is no need in checking whether r.TSID.MetricID is deleted, since tsidCache doesn't
 			// contain MetricName->TSID entries for deleted time series.
 			// See Storage.DeleteMetrics code for details.
+			if s.activeStreams != nil {
+				s.activeStreams.register(mr.MetricNameRaw, currentTime, false)
+			}
 			prevTSID = r.TSID
 			prevMetricNameRaw = mr.MetricNameRaw
 			continue
@@ -1253,7 +1413,7 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 		if pmrs == nil {
 			pmrs = getPendingMetricRows()
 		}
-		if err := pmrs.addRow(mr); err != nil {
+		if err := pmrs.addRow(mr, firstSeq+uint64(i)); err != nil {
 			// Do not stop adding rows on error - just skip invalid row.
 			// This guarantees that invalid rows don't prevent
 			// from adding valid rows into the storage.
@@ -1271,13 +1431,20 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 		})
 		is := idb.getIndexSearch(0, 0, noDeadline)
 		prevMetricNameRaw = nil
+		var rejectedMetricNameRaw []byte
 		var slowInsertsCount uint64
 		for i := range pendingMetricRows {
 			pmr := &pendingMetricRows[i]
 			mr := &pmr.mr
+			if rejectedMetricNameRaw != nil && string(mr.MetricNameRaw) == string(rejectedMetricNameRaw) {
+				// The row belongs to the rejected new stream.
+				rejectRow(mr.MetricNameRaw, int(pmr.seq-firstSeq))
+				continue
+			}
 			r := &rows[rowsLen+j]
 			j++
 			r.Timestamp = mr.Timestamp
+			r.Seq = pmr.seq
 			r.Value = mr.Value
 			r.PrecisionBits = precisionBits
 			if string(mr.MetricNameRaw) == string(prevMetricNameRaw) {
@@ -1291,11 +1458,34 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 				// There is no need in checking whether r.TSID.MetricID is deleted, since tsidCache doesn't
 				// contain MetricName->TSID entries for deleted time series.
 				// See Storage.DeleteMetrics code for details.
+				if s.activeStreams != nil {
+					s.activeStreams.register(mr.MetricNameRaw, currentTime, false)
+				}
 				prevTSID = r.TSID
 				prevMetricNameRaw = mr.MetricNameRaw
 				continue
 			}
 			slowInsertsCount++
+			if s.activeStreams != nil {
+				ok, err := s.getOrCreateTSIDWithLimit(is, &r.TSID, pmr, currentTime)
+				if err != nil {
+					if firstWarn == nil {
+						firstWarn = fmt.Errorf("cannot obtain or create TSID for MetricName %q: %w", pmr.MetricName, err)
+					}
+					j--
+					continue
+				}
+				if !ok {
+					// Skip rows for new streams exceeding the limit on active streams per tenant.
+					// This prevents from uncontrolled growth of indexdb because of high cardinality labels.
+					rejectedMetricNameRaw = mr.MetricNameRaw
+					rejectRow(mr.MetricNameRaw, int(pmr.seq-firstSeq))
+					j--
+					continue
+				}
+				s.putTSIDToCache(&r.TSID, mr.MetricNameRaw)
+				continue
+			}
 			if err := is.GetOrCreateTSIDByName(&r.TSID, pmr.MetricName); err != nil {
 				// Do not stop adding rows on error - just skip invalid row.
 				// This guarantees that invalid rows don't prevent
@@ -1327,12 +1517,44 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 	if firstError != nil {
 		return rows, fmt.Errorf("error occurred during rows addition: %w", firstError)
 	}
+	if len(rejected) > 0 {
+		// Rows for new streams are rejected in the order of their metric names.
+		sort.Slice(rejectedRows, func(i, j int) bool {
+			return rejectedRows[i].Idx < rejectedRows[j].Idx
+		})
+		return rows, &TooManyStreamsError{
+			Rejected: rejected,
+			Rows:     rejectedRows,
+		}
+	}
 	return rows, nil
 }
 
+// getOrCreateTSIDWithLimit obtains TSID for pmr or creates it if pmr belongs to a new stream.
+//
+// It returns false if the new stream exceeds the limit on active streams per tenant.
+func (s *Storage) getOrCreateTSIDWithLimit(is *indexSearch, dst *TSID, pmr *pendingMetricRow, currentTime uint64) (bool, error) {
+	err := is.getTSIDByMetricName(dst, pmr.MetricName)
+	if err == nil {
+		s.activeStreams.register(pmr.mr.MetricNameRaw, currentTime, false)
+		return true, nil
+	}
+	if err != io.EOF {
+		return false, fmt.Errorf("cannot search TSID: %w", err)
+	}
+	if !s.activeStreams.register(pmr.mr.MetricNameRaw, currentTime, true) {
+		return false, nil
+	}
+	if err := is.db.createTSIDByName(dst, pmr.MetricName); err != nil {
+		return false, fmt.Errorf("cannot create TSID: %w", err)
+	}
+	return true, nil
+}
+
 type pendingMetricRow struct {
 	MetricName []byte
 	mr         MetricRow
+	seq        uint64
 }
 
 type pendingMetricRows struct {
@@ -1356,7 +1578,7 @@ func (pmrs *pendingMetricRows) reset() {
 	pmrs.mn.Reset()
 }
 
-func (pmrs *pendingMetricRows) addRow(mr *MetricRow) error {
+func (pmrs *pendingMetricRows) addRow(mr *MetricRow, seq uint64) error {
 	// Do not spend CPU time on re-calculating canonical metricName during bulk import
 	// of many rows for the same metric.
 	if string(mr.MetricNameRaw) != string(pmrs.lastMetricNameRaw) {
@@ -1372,6 +1594,7 @@ func (pmrs *pendingMetricRows) addRow(mr *MetricRow) error {
 	pmrs.pmrs = append(pmrs.pmrs, pendingMetricRow{
 		MetricName: pmrs.lastMetricName,
 		mr:         *mr,
+		seq:        seq,
 	})
 	return nil
 }
@@ -1414,8 +1637,8 @@ func (s *Storage) updatePerDateData(rows []rawRow) error {
 	for i := range rows {
 		r := &rows[i]
 		if r.Timestamp != prevTimestamp {
-			date = uint64(r.Timestamp) / msecPerDay
-			hour = uint64(r.Timestamp) / msecPerHour
+			date = uint64(r.Timestamp) / nsecPerDay
+			hour = uint64(r.Timestamp) / nsecPerHour
 			prevTimestamp = r.Timestamp
 		}
 		metricID := r.TSID.MetricID
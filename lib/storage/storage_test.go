@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -104,7 +105,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 	}
 	t.Run("empty_pending_metric_ids_stale_curr_hour", func(t *testing.T) {
 		s := newStorage()
-		hour := uint64(timestampFromTime(time.Now())) / msecPerHour
+		hour := uint64(timestampFromTime(time.Now())) / nsecPerHour
 		hmOrig := &hourMetricIDs{
 			m:    &uint64set.Set{},
 			hour: 123,
@@ -116,7 +117,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 		hmCurr := s.currHourMetricIDs.Load().(*hourMetricIDs)
 		if hmCurr.hour != hour {
 			// It is possible new hour occurred. Update the hour and verify it again.
-			hour = uint64(timestampFromTime(time.Now())) / msecPerHour
+			hour = uint64(timestampFromTime(time.Now())) / nsecPerHour
 			if hmCurr.hour != hour {
 				t.Fatalf("unexpected hmCurr.hour; got %d; want %d", hmCurr.hour, hour)
 			}
@@ -139,7 +140,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 	})
 	t.Run("empty_pending_metric_ids_valid_curr_hour", func(t *testing.T) {
 		s := newStorage()
-		hour := uint64(timestampFromTime(time.Now())) / msecPerHour
+		hour := uint64(timestampFromTime(time.Now())) / nsecPerHour
 		hmOrig := &hourMetricIDs{
 			m:    &uint64set.Set{},
 			hour: hour,
@@ -151,7 +152,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 		hmCurr := s.currHourMetricIDs.Load().(*hourMetricIDs)
 		if hmCurr.hour != hour {
 			// It is possible new hour occurred. Update the hour and verify it again.
-			hour = uint64(timestampFromTime(time.Now())) / msecPerHour
+			hour = uint64(timestampFromTime(time.Now())) / nsecPerHour
 			if hmCurr.hour != hour {
 				t.Fatalf("unexpected hmCurr.hour; got %d; want %d", hmCurr.hour, hour)
 			}
@@ -199,7 +200,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 			x.Add(e.MetricID)
 		}
 
-		hour := uint64(timestampFromTime(time.Now())) / msecPerHour
+		hour := uint64(timestampFromTime(time.Now())) / nsecPerHour
 		hmOrig := &hourMetricIDs{
 			m:    &uint64set.Set{},
 			hour: 123,
@@ -211,7 +212,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 		hmCurr := s.currHourMetricIDs.Load().(*hourMetricIDs)
 		if hmCurr.hour != hour {
 			// It is possible new hour occurred. Update the hour and verify it again.
-			hour = uint64(timestampFromTime(time.Now())) / msecPerHour
+			hour = uint64(timestampFromTime(time.Now())) / nsecPerHour
 			if hmCurr.hour != hour {
 				t.Fatalf("unexpected hmCurr.hour; got %d; want %d", hmCurr.hour, hour)
 			}
@@ -259,7 +260,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 			x.Add(e.MetricID)
 		}
 
-		hour := uint64(timestampFromTime(time.Now())) / msecPerHour
+		hour := uint64(timestampFromTime(time.Now())) / nsecPerHour
 		hmOrig := &hourMetricIDs{
 			m:    &uint64set.Set{},
 			hour: hour,
@@ -271,7 +272,7 @@ func TestUpdateCurrHourMetricIDs(t *testing.T) {
 		hmCurr := s.currHourMetricIDs.Load().(*hourMetricIDs)
 		if hmCurr.hour != hour {
 			// It is possible new hour occurred. Update the hour and verify it again.
-			hour = uint64(timestampFromTime(time.Now())) / msecPerHour
+			hour = uint64(timestampFromTime(time.Now())) / nsecPerHour
 			if hmCurr.hour != hour {
 				t.Fatalf("unexpected hmCurr.hour; got %d; want %d", hmCurr.hour, hour)
 			}
@@ -367,6 +368,139 @@ func TestStorageOpenClose(t *testing.T) {
 	}
 }
 
+func TestStorageNextRowSeqPersisted(t *testing.T) {
+	path := "TestStorageNextRowSeqPersisted"
+	s, err := OpenStorage(path, -1)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	// Simulate the clock going backwards after the restart.
+	nextRowSeq := uint64(time.Now().Add(time.Hour).UnixNano())
+	s.nextRowSeq = nextRowSeq
+	s.MustClose()
+
+	s, err = OpenStorage(path, -1)
+	if err != nil {
+		t.Fatalf("cannot re-open storage: %s", err)
+	}
+	if s.nextRowSeq != nextRowSeq {
+		t.Fatalf("unexpected nextRowSeq after re-opening the storage; got %d; want %d", s.nextRowSeq, nextRowSeq)
+	}
+	s.MustClose()
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatalf("cannot remove %q: %s", path, err)
+	}
+}
+
+func TestStorageAddRowsTooManyStreams(t *testing.T) {
+	path := "TestStorageAddRowsTooManyStreams"
+	s, err := OpenStorage(path, -1)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	s.activeStreams = newActiveStreams(1, 3600)
+
+	var mrs []MetricRow
+	timestamp := timestampFromTime(time.Now())
+	for i := 0; i < 4; i++ {
+		var mn MetricName
+		mn.AccountID = 1
+		mn.ProjectID = 2
+		mn.MetricGroup = []byte(fmt.Sprintf("stream_%d", i%2))
+		mrs = append(mrs, MetricRow{
+			MetricNameRaw: mn.marshalRaw(nil),
+			Timestamp:     timestamp,
+			Value:         []byte("line"),
+		})
+	}
+	err = s.AddRows(mrs, defaultPrecisionBits)
+	var tmse *TooManyStreamsError
+	if !errors.As(err, &tmse) {
+		t.Fatalf("expecting TooManyStreamsError; got %v", err)
+	}
+	rejectedExpected := []RejectedRows{
+		{AccountID: 1, ProjectID: 2, Rows: 2},
+	}
+	if !reflect.DeepEqual(tmse.Rejected, rejectedExpected) {
+		t.Fatalf("unexpected rejected rows;\ngot\n%+v\nwant\n%+v", tmse.Rejected, rejectedExpected)
+	}
+	rowsExpected := []RejectedRow{
+		{Idx: 1, AccountID: 1, ProjectID: 2},
+		{Idx: 3, AccountID: 1, ProjectID: 2},
+	}
+	if !reflect.DeepEqual(tmse.Rows, rowsExpected) {
+		t.Fatalf("unexpected rejected rows;\ngot\n%+v\nwant\n%+v", tmse.Rows, rowsExpected)
+	}
+
+	s.MustClose()
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatalf("cannot remove %q: %s", path, err)
+	}
+}
+
+func TestStorageAddRowsTooManyStreamsExisting(t *testing.T) {
+	path := "TestStorageAddRowsTooManyStreamsExisting"
+	newRows := func(names ...string) []MetricRow {
+		var mrs []MetricRow
+		timestamp := timestampFromTime(time.Now())
+		for _, name := range names {
+			var mn MetricName
+			mn.AccountID = 1
+			mn.ProjectID = 2
+			mn.MetricGroup = []byte(name)
+			mrs = append(mrs, MetricRow{
+				MetricNameRaw: mn.marshalRaw(nil),
+				Timestamp:     timestamp,
+				Value:         []byte("line"),
+			})
+		}
+		return mrs
+	}
+	s, err := OpenStorage(path, -1)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	if err := s.AddRows(newRows("stream_0", "stream_1"), defaultPrecisionBits); err != nil {
+		t.Fatalf("unexpected error when adding rows without limit: %s", err)
+	}
+	s.MustClose()
+
+	// Existing streams must keep ingesting after the restart with the lower limit,
+	// even if new streams arrive first.
+	s, err = OpenStorage(path, -1)
+	if err != nil {
+		t.Fatalf("cannot re-open storage: %s", err)
+	}
+	s.activeStreams = newActiveStreams(1, 3600)
+	f := func() {
+		t.Helper()
+		err := s.AddRows(newRows("stream_new", "stream_0", "stream_new", "stream_1"), defaultPrecisionBits)
+		var tmse *TooManyStreamsError
+		if !errors.As(err, &tmse) {
+			t.Fatalf("expecting TooManyStreamsError; got %v", err)
+		}
+		rowsExpected := []RejectedRow{
+			{Idx: 0, AccountID: 1, ProjectID: 2},
+			{Idx: 2, AccountID: 1, ProjectID: 2},
+		}
+		if !reflect.DeepEqual(tmse.Rows, rowsExpected) {
+			t.Fatalf("unexpected rejected rows;\ngot\n%+v\nwant\n%+v", tmse.Rows, rowsExpected)
+		}
+	}
+
+	// TSIDs for existing streams are found in tsidCache.
+	f()
+
+	// TSIDs for existing streams are found in indexdb.
+	s.tsidCache.Reset()
+	f()
+
+	s.MustClose()
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatalf("cannot remove %q: %s", path, err)
+	}
+}
+
 func TestStorageOpenMultipleTimes(t *testing.T) {
 	path := "TestStorageOpenMultipleTimes"
 	s1, err := OpenStorage(path, -1)
@@ -625,7 +759,7 @@ func testStorageDeleteMetrics(s *Storage, workerNum int) error {
 	metricBlocksCount := func(tfs *TagFilters) int {
 		// Verify the number of blocks
 		n := 0
-		sr.Init(s, []*TagFilters{tfs}, tr, 1e5, noDeadline)
+		sr.Init(s, []*TagFilters{tfs}, tr, nil, 1e5, noDeadline)
 		for sr.NextMetricBlock() {
 			n++
 		}
@@ -763,7 +897,7 @@ func testStorageAddRows(s *Storage) error {
 			mn.ProjectID = uint32(rand.Intn(3))
 			mn.MetricGroup = []byte(fmt.Sprintf("metric_%d", rand.Intn(100)))
 			metricNameRaw := mn.marshalRaw(nil)
-			timestamp := rand.Int63n(1e10)
+			timestamp := timestampFromTime(time.Now()) - rand.Int63n(1e10)
 			value := []byte{byte(rand.NormFloat64())}
 
 			mr := MetricRow{
@@ -910,7 +1044,7 @@ func testStorageAddMetrics(s *Storage, workerNum int) error {
 		mn.ProjectID = uint32(i % 3)
 		mn.MetricGroup = []byte(fmt.Sprintf("metric_%d_%d", workerNum, rand.Intn(10)))
 		metricNameRaw := mn.marshalRaw(nil)
-		timestamp := rand.Int63n(1e10)
+		timestamp := timestampFromTime(time.Now()) - rand.Int63n(1e10)
 		value := []byte{byte(rand.NormFloat64())}
 
 		mr := MetricRow{
@@ -22,6 +22,8 @@ type table struct {
 	bigPartitionsPath   string
 
 	getDeletedMetricIDs func() *uint64set.Set
+	searchMetricName    searchMetricNameFunc
+	flushCallback       func()
 	retentionMsecs      int64
 
 	ptws     []*partitionWrapper
@@ -81,8 +83,10 @@ func (ptw *partitionWrapper) scheduleToDrop() {
 //
 // The table is created if it doesn't exist.
 //
+// Optional flushCallback is called every time inmemory parts are flushed to persistent storage.
+//
 // Data older than the retentionMsecs may be dropped at any time.
-func openTable(path string, getDeletedMetricIDs func() *uint64set.Set, retentionMsecs int64) (*table, error) {
+func openTable(path string, getDeletedMetricIDs func() *uint64set.Set, searchMetricName searchMetricNameFunc, flushCallback func(), retentionMsecs int64) (*table, error) {
 	path = filepath.Clean(path)
 
 	// Create a directory for the table if it doesn't exist yet.
@@ -115,7 +119,7 @@ func openTable(path string, getDeletedMetricIDs func() *uint64set.Set, retention
 	}
 
 	// Open partitions.
-	pts, err := openPartitions(smallPartitionsPath, bigPartitionsPath, getDeletedMetricIDs, retentionMsecs)
+	pts, err := openPartitions(smallPartitionsPath, bigPartitionsPath, getDeletedMetricIDs, searchMetricName, flushCallback, retentionMsecs)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open partitions in the table %q: %w", path, err)
 	}
@@ -125,6 +129,8 @@ func openTable(path string, getDeletedMetricIDs func() *uint64set.Set, retention
 		smallPartitionsPath: smallPartitionsPath,
 		bigPartitionsPath:   bigPartitionsPath,
 		getDeletedMetricIDs: getDeletedMetricIDs,
+		searchMetricName:    searchMetricName,
+		flushCallback:       flushCallback,
 		retentionMsecs:      retentionMsecs,
 
 		flockF: flockF,
@@ -222,6 +228,28 @@ func (tb *table) flushRawRows() {
 	}
 }
 
+// minUnflushedRowSeq returns the minimum ingestion sequence number for rows added to tb, which aren't flushed to persistent storage yet.
+//
+// false is returned if all the rows added to tb are flushed to persistent storage.
+func (tb *table) minUnflushedRowSeq() (uint64, bool) {
+	ptws := tb.GetPartitions(nil)
+	defer tb.PutPartitions(ptws)
+
+	minSeq := uint64(1<<64 - 1)
+	found := false
+	for _, ptw := range ptws {
+		seq, ok := ptw.pt.minUnflushedRowSeq()
+		if !ok {
+			continue
+		}
+		if seq < minSeq {
+			minSeq = seq
+		}
+		found = true
+	}
+	return minSeq, found
+}
+
 // TableMetrics contains essential metrics for the table.
 type TableMetrics struct {
 	partitionMetrics
@@ -353,7 +381,7 @@ func (tb *table) AddRows(rows []rawRow) error {
 			continue
 		}
 
-		pt, err := createPartition(r.Timestamp, tb.smallPartitionsPath, tb.bigPartitionsPath, tb.getDeletedMetricIDs, tb.retentionMsecs)
+		pt, err := createPartition(r.Timestamp, tb.smallPartitionsPath, tb.bigPartitionsPath, tb.getDeletedMetricIDs, tb.searchMetricName, tb.flushCallback, tb.retentionMsecs)
 		if err != nil {
 			errors = append(errors, err)
 			continue
@@ -371,9 +399,9 @@ func (tb *table) AddRows(rows []rawRow) error {
 }
 
 func (tb *table) getMinMaxTimestamps() (int64, int64) {
-	now := int64(fasttime.UnixTimestamp() * 1000)
-	minTimestamp := now - tb.retentionMsecs
-	maxTimestamp := now + 2*24*3600*1000 // allow max +2 days from now due to timezones shit :)
+	now := int64(fasttime.UnixTimestamp() * 1e9)
+	minTimestamp := now - getMaxRetentionMsecs(tb.retentionMsecs)*nsecPerMsec
+	maxTimestamp := now + 2*nsecPerDay // allow max +2 days from now due to timezones shit :)
 	if minTimestamp < 0 {
 		// Negative timestamps aren't supported by the storage.
 		minTimestamp = 0
@@ -402,7 +430,9 @@ func (tb *table) retentionWatcher() {
 		case <-ticker.C:
 		}
 
-		minTimestamp := int64(fasttime.UnixTimestamp()*1000) - tb.retentionMsecs
+		// Partitions may contain data for tenants with retentions bigger than tb.retentionMsecs.
+		// Data for tenants with smaller retentions is deleted during background merges.
+		minTimestamp := int64(fasttime.UnixTimestamp()*1e9) - getMaxRetentionMsecs(tb.retentionMsecs)*nsecPerMsec
 		var ptwsDrop []*partitionWrapper
 		tb.ptwsLock.Lock()
 		dst := tb.ptws[:0]
@@ -453,7 +483,7 @@ func (tb *table) PutPartitions(ptws []*partitionWrapper) {
 	}
 }
 
-func openPartitions(smallPartitionsPath, bigPartitionsPath string, getDeletedMetricIDs func() *uint64set.Set, retentionMsecs int64) ([]*partition, error) {
+func openPartitions(smallPartitionsPath, bigPartitionsPath string, getDeletedMetricIDs func() *uint64set.Set, searchMetricName searchMetricNameFunc, flushCallback func(), retentionMsecs int64) ([]*partition, error) {
 	// Certain partition directories in either `big` or `small` dir may be missing
 	// after restoring from backup. So populate partition names from both dirs.
 	ptNames := make(map[string]bool)
@@ -467,7 +497,7 @@ func openPartitions(smallPartitionsPath, bigPartitionsPath string, getDeletedMet
 	for ptName := range ptNames {
 		smallPartsPath := smallPartitionsPath + "/" + ptName
 		bigPartsPath := bigPartitionsPath + "/" + ptName
-		pt, err := openPartition(smallPartsPath, bigPartsPath, getDeletedMetricIDs, retentionMsecs)
+		pt, err := openPartition(smallPartsPath, bigPartsPath, getDeletedMetricIDs, searchMetricName, flushCallback, retentionMsecs)
 		if err != nil {
 			mustClosePartitions(pts)
 			return nil, fmt.Errorf("cannot open partition %q: %w", ptName, err)
@@ -12,7 +12,7 @@ import (
 func TestTableSearch(t *testing.T) {
 	var trData TimeRange
 	trData.fromPartitionTime(time.Now())
-	trData.MinTimestamp -= 5 * 365 * 24 * 3600 * 1000
+	trData.MinTimestamp -= 5 * 365 * nsecPerDay
 
 	t.Run("SinglePartition", func(t *testing.T) {
 		trSearch := TimeRange{
@@ -181,7 +181,7 @@ func testTableSearchEx(t *testing.T, trData, trSearch TimeRange, partitionsCount
 	})
 
 	// Create a table from rowss and test search on it.
-	tb, err := openTable("./test-table", nilGetDeletedMetricIDs, maxRetentionMsecs)
+	tb, err := openTable("./test-table", nilGetDeletedMetricIDs, nil, nil, maxRetentionMsecs)
 	if err != nil {
 		t.Fatalf("cannot create table: %s", err)
 	}
@@ -202,7 +202,7 @@ func testTableSearchEx(t *testing.T, trData, trSearch TimeRange, partitionsCount
 	tb.MustClose()
 
 	// Open the created table and test search on it.
-	tb, err = openTable("./test-table", nilGetDeletedMetricIDs, maxRetentionMsecs)
+	tb, err = openTable("./test-table", nilGetDeletedMetricIDs, nil, nil, maxRetentionMsecs)
 	if err != nil {
 		t.Fatalf("cannot open table: %s", err)
 	}
@@ -251,7 +251,7 @@ func testTableSearchSerial(tb *table, tsids []TSID, tr TimeRange, rbsExpected []
 
 	bs := []Block{}
 	var ts tableSearch
-	ts.Init(tb, tsids, tr)
+	ts.Init(tb, tsids, tr, nil)
 	for ts.NextBlock() {
 		var b Block
 		ts.BlockRef.MustReadBlock(&b, 2)
@@ -276,7 +276,7 @@ func testTableSearchSerial(tb *table, tsids []TSID, tr TimeRange, rbsExpected []
 	}
 
 	// verify that empty tsids returns empty result
-	ts.Init(tb, []TSID{}, tr)
+	ts.Init(tb, []TSID{}, tr, nil)
 	if ts.NextBlock() {
 		return fmt.Errorf("unexpected block got for an empty tsids list: %+v", ts.BlockRef)
 	}
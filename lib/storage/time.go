@@ -5,21 +5,23 @@ import (
 	"time"
 )
 
-// timestampToTime returns time representation of the given timestamp.
+// timestampToTime returns time representation of the given timestamp in nanoseconds.
 //
 // The returned time is in UTC timezone.
 func timestampToTime(timestamp int64) time.Time {
-	return time.Unix(timestamp/1e3, (timestamp%1e3)*1e6).UTC()
+	return time.Unix(0, timestamp).UTC()
 }
 
-// timestampFromTime returns timestamp value for the given time.
+// timestampFromTime returns timestamp value in nanoseconds for the given time.
 func timestampFromTime(t time.Time) int64 {
 	// There is no need in converting t to UTC, since UnixNano must
 	// return the same value for any timezone.
-	return t.UnixNano() / 1e6
+	return t.UnixNano()
 }
 
 // TimeRange is time range.
+//
+// Timestamps are in nanoseconds.
 type TimeRange struct {
 	MinTimestamp int64
 	MaxTimestamp int64
@@ -31,20 +33,64 @@ func (tr *TimeRange) String() string {
 	return fmt.Sprintf("[%s - %s]", minTime, maxTime)
 }
 
+// Supported partition intervals. See SetPartitionInterval.
+const (
+	PartitionIntervalMonth = "month"
+	PartitionIntervalDay   = "day"
+	PartitionIntervalHour  = "hour"
+)
+
+// partitionNameFormats contains partition name formats for the supported partition intervals.
+//
+// Formats have distinct lengths, so the partition interval can be determined from the partition name.
+var partitionNameFormats = map[string]string{
+	PartitionIntervalMonth: "2006_01",
+	PartitionIntervalDay:   "2006_01_02",
+	PartitionIntervalHour:  "2006_01_02_15",
+}
+
+var partitionInterval = PartitionIntervalMonth
+
+// SetPartitionInterval sets the time interval for new partitions.
+//
+// interval must be one of PartitionInterval* constants. Smaller partitions allow deleting data outside the retention
+// with finer granularity and reduce the amount of data rewritten by final merges, forced merges and snapshots.
+//
+// Existing partitions keep their intervals, which are determined from their names, so they continue receiving rows
+// for their time ranges. New partitions are created with the given interval for rows outside existing partitions.
+//
+// This function may be called only before Storage initialization.
+func SetPartitionInterval(interval string) error {
+	if _, ok := partitionNameFormats[interval]; !ok {
+		return fmt.Errorf("unsupported partition interval %q; supported values: %q, %q, %q",
+			interval, PartitionIntervalMonth, PartitionIntervalDay, PartitionIntervalHour)
+	}
+	partitionInterval = interval
+	return nil
+}
+
 // timestampToPartitionName returns partition name for the given timestamp.
 func timestampToPartitionName(timestamp int64) string {
 	t := timestampToTime(timestamp)
-	return t.Format("2006_01")
+	return t.Format(partitionNameFormats[partitionInterval])
 }
 
 // fromPartitionName initializes tr from the given parition name.
+//
+// The partition interval is determined from the name, so partitions created with distinct intervals may be opened.
 func (tr *TimeRange) fromPartitionName(name string) error {
-	t, err := time.Parse("2006_01", name)
-	if err != nil {
-		return fmt.Errorf("cannot parse partition name %q: %w", name, err)
+	for interval, format := range partitionNameFormats {
+		if len(name) != len(format) {
+			continue
+		}
+		t, err := time.Parse(format, name)
+		if err != nil {
+			return fmt.Errorf("cannot parse partition name %q: %w", name, err)
+		}
+		tr.fromPartitionTimeInterval(t, interval)
+		return nil
 	}
-	tr.fromPartitionTime(t)
-	return nil
+	return fmt.Errorf("cannot parse partition name %q: must be in the form YYYY_MM, YYYY_MM_DD or YYYY_MM_DD_HH", name)
 }
 
 // fromPartitionTimestamp initializes tr from the given partition timestamp.
@@ -55,13 +101,31 @@ func (tr *TimeRange) fromPartitionTimestamp(timestamp int64) {
 
 // fromPartitionTime initializes tr from the given partition time t.
 func (tr *TimeRange) fromPartitionTime(t time.Time) {
-	y, m, _ := t.UTC().Date()
-	minTime := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
-	maxTime := time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
-	tr.MinTimestamp = minTime.Unix() * 1e3
-	tr.MaxTimestamp = maxTime.Unix()*1e3 - 1
+	tr.fromPartitionTimeInterval(t, partitionInterval)
 }
 
-const msecPerDay = 24 * 3600 * 1000
+// fromPartitionTimeInterval initializes tr from the given partition time t for the given partition interval.
+func (tr *TimeRange) fromPartitionTimeInterval(t time.Time, interval string) {
+	t = t.UTC()
+	y, m, d := t.Date()
+	var minTime, maxTime time.Time
+	switch interval {
+	case PartitionIntervalHour:
+		minTime = time.Date(y, m, d, t.Hour(), 0, 0, 0, time.UTC)
+		maxTime = minTime.Add(time.Hour)
+	case PartitionIntervalDay:
+		minTime = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		maxTime = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+	default:
+		minTime = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		maxTime = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	tr.MinTimestamp = minTime.UnixNano()
+	tr.MaxTimestamp = maxTime.UnixNano() - 1
+}
+
+const nsecPerDay = 24 * 3600 * 1e9
+
+const nsecPerHour = 3600 * 1e9
 
-const msecPerHour = 3600 * 1000
+const nsecPerMsec = 1e6
@@ -27,7 +27,7 @@ func testTimeRangeFromPartition(t *testing.T, initialTime time.Time) {
 		t.Fatalf("unexpected month for MinTimestamp; got %d; want %d", minM, m)
 	}
 
-	// Verify that the previous millisecond form tr.MinTimestamp belongs to the previous month.
+	// Verify that the previous nanosecond from tr.MinTimestamp belongs to the previous month.
 	tr.MinTimestamp--
 	prevTime := timestampToTime(tr.MinTimestamp)
 	prevY, prevM, _ := prevTime.Date()
@@ -44,7 +44,7 @@ func testTimeRangeFromPartition(t *testing.T, initialTime time.Time) {
 		t.Fatalf("unexpected month for MaxTimestamp; got %d; want %d", maxM, m)
 	}
 
-	// Verify that the next millisecond from tr.MaxTimestamp belongs to the next month.
+	// Verify that the next nanosecond from tr.MaxTimestamp belongs to the next month.
 	tr.MaxTimestamp++
 	nextTime := timestampToTime(tr.MaxTimestamp)
 	nextY, nextM, _ := nextTime.Date()
@@ -52,3 +52,66 @@ func testTimeRangeFromPartition(t *testing.T, initialTime time.Time) {
 		t.Fatalf("unexpected nextY, nextM; got %d, %d; want %d, %d+1;\nnextTime=%s\nmaxTime=%s", nextY, nextM, maxY, maxM, nextTime, maxTime)
 	}
 }
+
+func TestTimeRangeFromPartitionName(t *testing.T) {
+	defer func() {
+		if err := SetPartitionInterval(PartitionIntervalMonth); err != nil {
+			t.Fatalf("cannot restore partition interval: %s", err)
+		}
+	}()
+	f := func(interval, nameExpected, minTimeExpected, maxTimeExpected string) {
+		t.Helper()
+		if err := SetPartitionInterval(interval); err != nil {
+			t.Fatalf("cannot set partition interval: %s", err)
+		}
+		timestamp := timestampFromTime(time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC))
+		name := timestampToPartitionName(timestamp)
+		if name != nameExpected {
+			t.Fatalf("unexpected partition name; got %q; want %q", name, nameExpected)
+		}
+		var tr, trExpected TimeRange
+		if err := tr.fromPartitionName(name); err != nil {
+			t.Fatalf("cannot parse partition name %q: %s", name, err)
+		}
+		trExpected.fromPartitionTimestamp(timestamp)
+		if tr != trExpected {
+			t.Fatalf("unexpected time range for partition %q; got %s; want %s", name, &tr, &trExpected)
+		}
+		minTime := timestampToTime(tr.MinTimestamp).Format(time.RFC3339Nano)
+		maxTime := timestampToTime(tr.MaxTimestamp).Format(time.RFC3339Nano)
+		if minTime != minTimeExpected || maxTime != maxTimeExpected {
+			t.Fatalf("unexpected time range for partition %q; got [%s - %s]; want [%s - %s]", name, minTime, maxTime, minTimeExpected, maxTimeExpected)
+		}
+	}
+	f(PartitionIntervalMonth, "2020_12", "2020-12-01T00:00:00Z", "2020-12-31T23:59:59.999999999Z")
+	f(PartitionIntervalDay, "2020_12_31", "2020-12-31T00:00:00Z", "2020-12-31T23:59:59.999999999Z")
+	f(PartitionIntervalHour, "2020_12_31_23", "2020-12-31T23:00:00Z", "2020-12-31T23:59:59.999999999Z")
+
+	// Partitions with other intervals must keep their time ranges.
+	for name, maxTimeExpected := range map[string]string{
+		"2020_02":       "2020-02-29T23:59:59.999999999Z",
+		"2020_02_28":    "2020-02-28T23:59:59.999999999Z",
+		"2020_02_28_01": "2020-02-28T01:59:59.999999999Z",
+	} {
+		var tr TimeRange
+		if err := tr.fromPartitionName(name); err != nil {
+			t.Fatalf("cannot parse partition name %q: %s", name, err)
+		}
+		if maxTime := timestampToTime(tr.MaxTimestamp).Format(time.RFC3339Nano); maxTime != maxTimeExpected {
+			t.Fatalf("unexpected MaxTimestamp for partition %q; got %s; want %s", name, maxTime, maxTimeExpected)
+		}
+	}
+
+	// Invalid partition names.
+	for _, name := range []string{"", "foo", "2020", "2020_13", "2020_02_30", "2020_02_28_24", "2020-02-28"} {
+		var tr TimeRange
+		if err := tr.fromPartitionName(name); err == nil {
+			t.Fatalf("expecting non-nil error for partition name %q", name)
+		}
+	}
+
+	// Invalid partition interval.
+	if err := SetPartitionInterval("week"); err == nil {
+		t.Fatalf("expecting non-nil error for unsupported partition interval")
+	}
+}
@@ -0,0 +1,639 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/bytesutil"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/encoding"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+// Supported sync policies for write-ahead log. See SetWAL.
+const (
+	// WALSyncAlways syncs every entry to persistent storage before Storage.AppendWAL returns.
+	WALSyncAlways = "always"
+
+	// WALSyncInterval syncs write-ahead log to persistent storage in background with the given interval.
+	WALSyncInterval = "interval"
+
+	// WALSyncNever relies on the OS for syncing write-ahead log to persistent storage.
+	//
+	// Entries survive process crash, but may be lost on power loss.
+	WALSyncNever = "never"
+)
+
+var (
+	walEnabled      bool
+	walSyncPolicy   = WALSyncInterval
+	walSyncInterval = time.Second
+)
+
+// SetWAL enables write-ahead log with the given syncPolicy for rows passed to Storage.AppendWAL.
+//
+// syncPolicy must be one of WALSync* constants. syncInterval is used only for WALSyncInterval.
+//
+// This function must be called before initializing the storage.
+func SetWAL(syncPolicy string, syncInterval time.Duration) error {
+	switch syncPolicy {
+	case WALSyncAlways, WALSyncNever:
+	case WALSyncInterval:
+		if syncInterval <= 0 {
+			return fmt.Errorf("sync interval must be positive; got %s", syncInterval)
+		}
+	default:
+		return fmt.Errorf("unsupported sync policy %q; supported values: %q, %q, %q", syncPolicy, WALSyncAlways, WALSyncInterval, WALSyncNever)
+	}
+	walEnabled = true
+	walSyncPolicy = syncPolicy
+	walSyncInterval = syncInterval
+	return nil
+}
+
+// The minimum interval between write-ahead log segment rotations.
+//
+// Only sealed segments may be removed after the rows from them are flushed to persistent storage.
+const walRotateInterval = 10 * time.Second
+
+// WALRef is a reference to an entry appended to write-ahead log via Storage.AppendWAL.
+type WALRef struct {
+	seg *walSegment
+}
+
+// Release must be called after all the rows from the entry are passed to Storage.AddRows.
+//
+// The entry may be removed from write-ahead log after the rows are flushed to persistent storage.
+func (ref WALRef) Release() {
+	if ref.seg == nil {
+		return
+	}
+	if n := atomic.AddInt64(&ref.seg.pendingEntries, -1); n < 0 {
+		logger.Panicf("BUG: pendingEntries must be non-negative for write-ahead log segment %q; got %d", ref.seg.path, n)
+	}
+}
+
+// wal is write-ahead log for rows, which are added to the storage, but aren't flushed to persistent storage yet.
+//
+// The log consists of segments. Segments are sealed and then removed after the rows from them are flushed to persistent storage.
+type wal struct {
+	// Put atomic counters to the top of struct, so they are aligned to 8 bytes on 32-bit arch.
+	// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/212
+	sizeBytes uint64
+
+	path       string
+	syncPolicy string
+
+	// mu protects the fields below.
+	mu             sync.Mutex
+	curr           *walSegment
+	sealed         []*walSegment
+	nextSegmentIdx uint64
+	lastSyncedSize uint64
+	lastRotateTime time.Time
+
+	stopCh   chan struct{}
+	syncerWG sync.WaitGroup
+}
+
+// walSegment is a single file in write-ahead log.
+type walSegment struct {
+	// pendingEntries is the number of appended entries without WALRef.Release call.
+	pendingEntries int64
+
+	path string
+	f    *os.File
+	size uint64
+
+	// The fields below are set by setFlushState after all the entries from the sealed segment are added to the storage.
+
+	// nextRowSeq is Storage.nextRowSeq after the rows from the segment are added to the storage.
+	// It is zero until then.
+	nextRowSeq uint64
+
+	// idb is indexdb with items for the rows from the segment.
+	idb *indexDB
+
+	// idbFlushesCount is the number of idb flushes after the rows from the segment are added to the storage.
+	idbFlushesCount uint64
+}
+
+// openWAL opens write-ahead log at the given path for appending entries.
+//
+// Existing segments at the path with the given idxs must be replayed before calling openWAL.
+// They are removed after the replayed rows are flushed to persistent storage.
+func openWAL(path string, idxs []uint64, syncPolicy string, syncInterval time.Duration) (*wal, error) {
+	w := &wal{
+		path:           path,
+		syncPolicy:     syncPolicy,
+		nextSegmentIdx: 1,
+		lastRotateTime: time.Now(),
+		stopCh:         make(chan struct{}),
+	}
+	for _, idx := range idxs {
+		segPath := fmt.Sprintf("%s/%016X", path, idx)
+		fi, err := os.Stat(segPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot obtain information about write-ahead log segment: %w", err)
+		}
+		w.sealed = append(w.sealed, &walSegment{
+			path: segPath,
+			size: uint64(fi.Size()),
+		})
+		w.sizeBytes += uint64(fi.Size())
+		w.nextSegmentIdx = idx + 1
+	}
+	seg, err := w.createSegment()
+	if err != nil {
+		return nil, err
+	}
+	w.curr = seg
+	if syncPolicy == WALSyncInterval {
+		w.syncerWG.Add(1)
+		go func() {
+			defer w.syncerWG.Done()
+			w.syncer(syncInterval)
+		}()
+	}
+	return w, nil
+}
+
+func (w *wal) createSegment() (*walSegment, error) {
+	path := fmt.Sprintf("%s/%016X", w.path, w.nextSegmentIdx)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create write-ahead log segment: %w", err)
+	}
+	fs.MustSyncPath(w.path)
+	w.nextSegmentIdx++
+	return &walSegment{
+		path: path,
+		f:    f,
+	}, nil
+}
+
+// append appends data with marshaled MetricRows for the given precisionBits to w.
+func (w *wal) append(data []byte, precisionBits uint8) (WALRef, error) {
+	bb := walEntryBufPool.Get()
+	bb.B = marshalWALEntry(bb.B[:0], data, precisionBits)
+	defer walEntryBufPool.Put(bb)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seg := w.curr
+	if err := seg.write(bb.B, w.syncPolicy == WALSyncAlways); err != nil {
+		// Remove partially written entry, so it doesn't break the subsequent entries
+		// and isn't replayed after the caller re-sends the rows.
+		if err := seg.f.Truncate(int64(seg.size)); err != nil {
+			logger.Panicf("FATAL: cannot truncate write-ahead log segment %q to %d bytes: %s", seg.path, seg.size, err)
+		}
+		return WALRef{}, err
+	}
+	seg.size += uint64(len(bb.B))
+	atomic.AddUint64(&w.sizeBytes, uint64(len(bb.B)))
+	atomic.AddInt64(&seg.pendingEntries, 1)
+	return WALRef{
+		seg: seg,
+	}, nil
+}
+
+var walEntryBufPool bytesutil.ByteBufferPool
+
+func (seg *walSegment) write(data []byte, needSync bool) error {
+	if _, err := seg.f.Write(data); err != nil {
+		return fmt.Errorf("cannot write %d bytes to write-ahead log segment %q: %w", len(data), seg.path, err)
+	}
+	if needSync {
+		if err := seg.f.Sync(); err != nil {
+			return fmt.Errorf("cannot sync write-ahead log segment %q: %w", seg.path, err)
+		}
+	}
+	return nil
+}
+
+func (w *wal) syncer(syncInterval time.Duration) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.curr.size != w.lastSyncedSize {
+				if err := w.curr.f.Sync(); err != nil {
+					logger.Errorf("cannot sync write-ahead log segment %q: %s", w.curr.path, err)
+				} else {
+					w.lastSyncedSize = w.curr.size
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// sealSegments seals the current segment if forceRotate is set or if it is older than walRotateInterval
+// and returns sealed segments without pending entries.
+//
+// Rows from the returned segments are already added to the storage, so the segments may be removed
+// via removeSegments after the rows are flushed to persistent storage.
+func (w *wal) sealSegments(forceRotate bool) []*walSegment {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.curr.size > 0 && (forceRotate || time.Since(w.lastRotateTime) >= walRotateInterval) {
+		seg, err := w.createSegment()
+		if err != nil {
+			logger.Errorf("cannot rotate write-ahead log at %q: %s", w.path, err)
+		} else {
+			w.mustCloseSegment(w.curr)
+			w.sealed = append(w.sealed, w.curr)
+			w.curr = seg
+			w.lastSyncedSize = 0
+			w.lastRotateTime = time.Now()
+		}
+	}
+	var segs []*walSegment
+	for _, seg := range w.sealed {
+		if atomic.LoadInt64(&seg.pendingEntries) == 0 {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// setFlushState sets the given flush state for segs, which don't have it yet.
+//
+// The flush state must be obtained after sealSegments call, which returned segs.
+func (w *wal) setFlushState(segs []*walSegment, nextRowSeq uint64, idb *indexDB, idbFlushesCount uint64) {
+	w.mu.Lock()
+	for _, seg := range segs {
+		if seg.nextRowSeq == 0 {
+			seg.nextRowSeq = nextRowSeq
+			seg.idb = idb
+			seg.idbFlushesCount = idbFlushesCount
+		}
+	}
+	w.mu.Unlock()
+}
+
+func (w *wal) mustCloseSegment(seg *walSegment) {
+	if w.syncPolicy != WALSyncNever {
+		if err := seg.f.Sync(); err != nil {
+			logger.Panicf("FATAL: cannot sync write-ahead log segment %q: %s", seg.path, err)
+		}
+	}
+	if err := seg.f.Close(); err != nil {
+		logger.Panicf("FATAL: cannot close write-ahead log segment %q: %s", seg.path, err)
+	}
+	seg.f = nil
+}
+
+// removeSegments removes the given sealed segments from w.
+func (w *wal) removeSegments(segs []*walSegment) {
+	m := make(map[*walSegment]bool, len(segs))
+	for _, seg := range segs {
+		m[seg] = true
+	}
+
+	// Remove segments from w.sealed under the lock, so concurrent calls for the same segments remove them only once.
+	var segsToRemove []*walSegment
+	w.mu.Lock()
+	sealed := w.sealed[:0]
+	for _, seg := range w.sealed {
+		if m[seg] {
+			segsToRemove = append(segsToRemove, seg)
+		} else {
+			sealed = append(sealed, seg)
+		}
+	}
+	w.sealed = sealed
+	w.mu.Unlock()
+
+	if len(segsToRemove) == 0 {
+		return
+	}
+	for _, seg := range segsToRemove {
+		fs.MustRemoveAll(seg.path)
+		atomic.AddUint64(&w.sizeBytes, ^uint64(seg.size-1))
+	}
+	fs.MustSyncPath(w.path)
+}
+
+// SegmentsCount returns the number of segments in w.
+func (w *wal) SegmentsCount() int {
+	w.mu.Lock()
+	n := len(w.sealed) + 1
+	w.mu.Unlock()
+	return n
+}
+
+// MustClose closes w.
+//
+// The storage must be flushed to persistent storage before calling MustClose,
+// so segments without pending entries are removed.
+func (w *wal) MustClose() {
+	close(w.stopCh)
+	w.syncerWG.Wait()
+
+	segs := w.sealSegments(true)
+	w.removeSegments(segs)
+
+	w.mu.Lock()
+	seg := w.curr
+	w.curr = nil
+	w.mu.Unlock()
+	w.mustCloseSegment(seg)
+	if seg.size == 0 {
+		fs.MustRemoveAll(seg.path)
+		fs.MustSyncPath(w.path)
+	}
+	if n := len(w.sealed); n > 0 {
+		logger.Errorf("keeping %d write-ahead log segments with rows, which weren't added to the storage, at %q", n, w.path)
+	}
+}
+
+// marshalWALEntry appends write-ahead log entry for data with marshaled MetricRows to dst and returns the result.
+//
+// The entry consists of data size, data checksum, precisionBits and data.
+// The checksum allows detecting partially written entries after process crash or power loss.
+func marshalWALEntry(dst, data []byte, precisionBits uint8) []byte {
+	dst = encoding.MarshalUint32(dst, uint32(len(data)))
+	dst = encoding.MarshalUint64(dst, xxhash.Sum64(data))
+	dst = append(dst, precisionBits)
+	dst = append(dst, data...)
+	return dst
+}
+
+const walEntryHeaderSize = 4 + 8 + 1
+
+// readWALEntries calls f for each entry in write-ahead log segment at the given path.
+//
+// Partially written or corrupted entry and all the entries after it are skipped.
+func readWALEntries(path string, f func(data []byte, precisionBits uint8)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open write-ahead log segment: %w", err)
+	}
+	defer fs.MustClose(file)
+
+	br := bufio.NewReaderSize(file, 64*1024)
+	header := make([]byte, walEntryHeaderSize)
+	var data []byte
+	offset := int64(0)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			logger.Errorf("skipping partially written entry header at offset %d in write-ahead log segment %q: %s", offset, path, err)
+			return nil
+		}
+		dataSize := encoding.UnmarshalUint32(header)
+		checksum := encoding.UnmarshalUint64(header[4:])
+		precisionBits := header[12]
+		if dataSize > walMaxDataSize || precisionBits < 1 || precisionBits > 64 {
+			logger.Errorf("skipping corrupted entry header at offset %d in write-ahead log segment %q", offset, path)
+			return nil
+		}
+		data = bytesutil.Resize(data, int(dataSize))
+		if _, err := io.ReadFull(br, data); err != nil {
+			logger.Errorf("skipping partially written entry at offset %d in write-ahead log segment %q: %s", offset, path, err)
+			return nil
+		}
+		if xxhash.Sum64(data) != checksum {
+			logger.Errorf("skipping corrupted entry with checksum mismatch at offset %d in write-ahead log segment %q", offset, path)
+			return nil
+		}
+		f(data, precisionBits)
+		offset += walEntryHeaderSize + int64(dataSize)
+	}
+}
+
+// walMaxDataSize is the maximum data size for write-ahead log entry.
+//
+// It protects from allocating too much memory for corrupted entry size.
+const walMaxDataSize = 1 << 30
+
+// listWALSegments returns sorted indexes for write-ahead log segments at the given path.
+func listWALSegments(path string) ([]uint64, error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open write-ahead log directory: %w", err)
+	}
+	defer fs.MustClose(d)
+
+	fis, err := d.Readdir(-1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read write-ahead log directory: %w", err)
+	}
+	var idxs []uint64
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		idx, err := strconv.ParseUint(fi.Name(), 16, 64)
+		if err != nil {
+			logger.Errorf("skipping unexpected file %q in write-ahead log directory %q", fi.Name(), path)
+			continue
+		}
+		idxs = append(idxs, idx)
+	}
+	sort.Slice(idxs, func(i, j int) bool {
+		return idxs[i] < idxs[j]
+	})
+	return idxs, nil
+}
+
+// replayAndOpenWAL replays write-ahead log segments at s.path/wal and opens write-ahead log if it is enabled via SetWAL.
+//
+// Segments are replayed even if write-ahead log is disabled, so rows from the previous run aren't lost.
+func (s *Storage) replayAndOpenWAL() error {
+	path := filepath.Join(s.path, "wal")
+	if err := fs.MkdirAllIfNotExist(path); err != nil {
+		return fmt.Errorf("cannot create write-ahead log directory %q: %w", path, err)
+	}
+	idxs, err := listWALSegments(path)
+	if err != nil {
+		return fmt.Errorf("cannot list write-ahead log segments at %q: %w", path, err)
+	}
+	if len(idxs) > 0 {
+		logger.Infof("replaying %d write-ahead log segments from %q...", len(idxs), path)
+		startTime := time.Now()
+		rowsReplayed := 0
+		for _, idx := range idxs {
+			segPath := fmt.Sprintf("%s/%016X", path, idx)
+			n, err := s.replayWALSegment(segPath)
+			if err != nil {
+				return fmt.Errorf("cannot replay write-ahead log segment %q: %w", segPath, err)
+			}
+			rowsReplayed += n
+		}
+		atomic.AddUint64(&walRowsReplayed, uint64(rowsReplayed))
+		logger.Infof("replayed %d rows from %d write-ahead log segments at %q in %.3f seconds",
+			rowsReplayed, len(idxs), path, time.Since(startTime).Seconds())
+	}
+	if !walEnabled && len(idxs) == 0 {
+		return nil
+	}
+
+	// Open write-ahead log even if it is disabled, so the replayed segments are removed
+	// after the replayed rows are flushed to persistent storage.
+	w, err := openWAL(path, idxs, walSyncPolicy, walSyncInterval)
+	if err != nil {
+		return fmt.Errorf("cannot open write-ahead log at %q: %w", path, err)
+	}
+	s.wal = w
+	return nil
+}
+
+var walRowsReplayed uint64
+
+// replayWALSegment adds rows from write-ahead log segment at the given path to s.
+//
+// It returns the number of replayed rows.
+func (s *Storage) replayWALSegment(path string) (int, error) {
+	var mrs []MetricRow
+	rowsReplayed := 0
+	var firstErr error
+	addRows := func(precisionBits uint8) {
+		err := s.AddRows(mrs, precisionBits)
+		var tmse *TooManyStreamsError
+		if err != nil && !errors.As(err, &tmse) && firstErr == nil {
+			firstErr = err
+		}
+		rowsReplayed += len(mrs)
+		mrs = mrs[:0]
+	}
+	err := readWALEntries(path, func(data []byte, precisionBits uint8) {
+		tail := data
+		for len(tail) > 0 {
+			if len(mrs) < cap(mrs) {
+				mrs = mrs[:len(mrs)+1]
+			} else {
+				mrs = append(mrs, MetricRow{})
+			}
+			mr := &mrs[len(mrs)-1]
+			var err error
+			tail, err = mr.Unmarshal(tail)
+			if err != nil {
+				logger.Errorf("cannot unmarshal MetricRow from write-ahead log segment %q: %s", path, err)
+				mrs = mrs[:len(mrs)-1]
+				break
+			}
+			if len(mrs) >= 10000 {
+				addRows(precisionBits)
+			}
+		}
+		addRows(precisionBits)
+	})
+	if err != nil {
+		return rowsReplayed, err
+	}
+	if firstErr != nil {
+		logger.Errorf("cannot add some rows from write-ahead log segment %q: %s", path, firstErr)
+	}
+	return rowsReplayed, nil
+}
+
+// AppendWAL appends data with marshaled MetricRows to write-ahead log, so the rows may be recovered on the next OpenStorage call
+// if the process is stopped before the rows are flushed to persistent storage.
+//
+// WALRef.Release must be called on the returned ref after the rows from data are passed to AddRows with the given precisionBits.
+// An empty ref is returned if write-ahead log is disabled.
+func (s *Storage) AppendWAL(data []byte, precisionBits uint8) (WALRef, error) {
+	if s.wal == nil || !walEnabled || len(data) == 0 {
+		return WALRef{}, nil
+	}
+	return s.wal.append(data, precisionBits)
+}
+
+func (s *Storage) startWALTruncator() {
+	s.walTruncatorWG.Add(1)
+	go func() {
+		s.walTruncator()
+		s.walTruncatorWG.Done()
+	}()
+}
+
+func (s *Storage) walTruncator() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.walTruncateCh:
+			s.truncateWAL(false)
+		}
+	}
+}
+
+// tableFlushCallback is called after inmemory parts are flushed to persistent storage.
+//
+// It notifies walTruncator, so it removes write-ahead log segments for the flushed rows.
+func (s *Storage) tableFlushCallback() {
+	select {
+	case s.walTruncateCh <- struct{}{}:
+	default:
+	}
+}
+
+// truncateWAL removes write-ahead log segments for rows, which are flushed to persistent storage
+// by the background flushers for raw rows, inmemory parts and indexdb items.
+//
+// The current segment is sealed if forceRotate is set or if it is older than walRotateInterval.
+func (s *Storage) truncateWAL(forceRotate bool) {
+	s.walTruncateLock.Lock()
+	defer s.walTruncateLock.Unlock()
+
+	segs := s.wal.sealSegments(forceRotate)
+	if len(segs) == 0 {
+		return
+	}
+
+	// The rows from segs are already added to the storage, so they have ingestion sequence numbers smaller than s.nextRowSeq
+	// and index items for them are already added to the current indexdb.
+	idb := s.idb()
+	s.wal.setFlushState(segs, atomic.LoadUint64(&s.nextRowSeq), idb, idb.getFlushesCount())
+
+	minSeq, ok := s.tb.minUnflushedRowSeq()
+	var segsToRemove []*walSegment
+	for _, seg := range segs {
+		if ok && minSeq < seg.nextRowSeq {
+			// Some rows from seg may be left unflushed.
+			continue
+		}
+		if !s.hasFlushedIndexItemsSince(seg.idb, seg.idbFlushesCount) {
+			continue
+		}
+		segsToRemove = append(segsToRemove, seg)
+	}
+	s.wal.removeSegments(segsToRemove)
+}
+
+// hasFlushedIndexItemsSince returns true if items added to idb before idb.getFlushesCount call,
+// which returned flushesCount, are flushed to persistent storage.
+func (s *Storage) hasFlushedIndexItemsSince(idb *indexDB, flushesCount uint64) bool {
+	idbCurr := s.idb()
+	if idb == idbCurr {
+		return idb.hasFlushedItemsSince(flushesCount)
+	}
+	// idb may become extDB after indexdb rotation. Otherwise it is already closed and all its items are flushed.
+	flushed := true
+	idbCurr.doExtDB(func(extDB *indexDB) {
+		if extDB == idb {
+			flushed = extDB.hasFlushedItemsSince(flushesCount)
+		}
+	})
+	return flushed
+}
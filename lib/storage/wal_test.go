@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWALReadEntries(t *testing.T) {
+	path := "TestWALReadEntries"
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatalf("cannot create %q: %s", path, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	w, err := openWAL(path, nil, WALSyncAlways, 0)
+	if err != nil {
+		t.Fatalf("cannot open WAL: %s", err)
+	}
+	var entriesExpected []string
+	var refs []WALRef
+	for i := 0; i < 10; i++ {
+		data := fmt.Sprintf("entry_%d", i)
+		ref, err := w.append([]byte(data), uint8(i+1))
+		if err != nil {
+			t.Fatalf("cannot append entry: %s", err)
+		}
+		refs = append(refs, ref)
+		entriesExpected = append(entriesExpected, fmt.Sprintf("%s:%d", data, i+1))
+	}
+	segPath := w.curr.path
+
+	// Segments with pending entries mustn't be returned for removal.
+	if segs := w.sealSegments(true); len(segs) != 0 {
+		t.Fatalf("unexpected segments with pending entries: %d", len(segs))
+	}
+	for _, ref := range refs {
+		ref.Release()
+	}
+	segs := w.sealSegments(true)
+	if len(segs) != 1 || segs[0].path != segPath {
+		t.Fatalf("unexpected segments without pending entries: %d", len(segs))
+	}
+
+	readEntries := func() []string {
+		t.Helper()
+		var entries []string
+		err := readWALEntries(segPath, func(data []byte, precisionBits uint8) {
+			entries = append(entries, fmt.Sprintf("%s:%d", data, precisionBits))
+		})
+		if err != nil {
+			t.Fatalf("cannot read WAL entries: %s", err)
+		}
+		return entries
+	}
+	if entries := readEntries(); !reflect.DeepEqual(entries, entriesExpected) {
+		t.Fatalf("unexpected entries;\ngot\n%q\nwant\n%q", entries, entriesExpected)
+	}
+
+	// Partially written entry must be skipped.
+	f, err := os.OpenFile(segPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("cannot open %q: %s", segPath, err)
+	}
+	entry := marshalWALEntry(nil, []byte("partial"), 64)
+	if _, err := f.Write(entry[:len(entry)-1]); err != nil {
+		t.Fatalf("cannot write partial entry: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("cannot close %q: %s", segPath, err)
+	}
+	if entries := readEntries(); !reflect.DeepEqual(entries, entriesExpected) {
+		t.Fatalf("unexpected entries after partial write;\ngot\n%q\nwant\n%q", entries, entriesExpected)
+	}
+
+	w.removeSegments(segs)
+	w.MustClose()
+	idxs, err := listWALSegments(path)
+	if err != nil {
+		t.Fatalf("cannot list WAL segments: %s", err)
+	}
+	if len(idxs) != 0 {
+		t.Fatalf("unexpected WAL segments left after close: %d", idxs)
+	}
+}
+
+func TestStorageWALReplay(t *testing.T) {
+	path := "TestStorageWALReplay"
+	walPath := path + "/wal"
+	if err := os.MkdirAll(walPath, 0755); err != nil {
+		t.Fatalf("cannot create %q: %s", walPath, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	// Write rows to WAL without adding them to the storage in order to simulate unclean shutdown.
+	const accountID = 12
+	const projectID = 34
+	const metricsCount = 10
+	w, err := openWAL(walPath, nil, WALSyncNever, 0)
+	if err != nil {
+		t.Fatalf("cannot open WAL: %s", err)
+	}
+	timestamp := time.Now().UnixNano()
+	for i := 0; i < metricsCount; i++ {
+		var mn MetricName
+		mn.AccountID = accountID
+		mn.ProjectID = projectID
+		mn.MetricGroup = []byte(fmt.Sprintf("metric_%d", i))
+		mr := MetricRow{
+			MetricNameRaw: mn.marshalRaw(nil),
+			Timestamp:     timestamp,
+			Value:         []byte("foobar"),
+		}
+		if _, err := w.append(mr.Marshal(nil), defaultPrecisionBits); err != nil {
+			t.Fatalf("cannot append row to WAL: %s", err)
+		}
+	}
+	w.mustCloseSegment(w.curr)
+
+	rowsReplayed := atomic.LoadUint64(&walRowsReplayed)
+	s, err := OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	if n := atomic.LoadUint64(&walRowsReplayed) - rowsReplayed; n != metricsCount {
+		t.Fatalf("unexpected number of replayed rows; got %d; want %d", n, metricsCount)
+	}
+	// The replayed segment must be kept until the replayed rows are flushed to persistent storage.
+	if n := s.wal.SegmentsCount(); n != 2 {
+		t.Fatalf("unexpected number of WAL segments after replay; got %d; want 2", n)
+	}
+	s.MustClose()
+	idxs, err := listWALSegments(walPath)
+	if err != nil {
+		t.Fatalf("cannot list WAL segments: %s", err)
+	}
+	if len(idxs) != 0 {
+		t.Fatalf("unexpected WAL segments left after close: %d", idxs)
+	}
+
+	// Verify the replayed rows are persisted.
+	s, err = OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot re-open storage: %s", err)
+	}
+	n, err := s.GetSeriesCount(accountID, projectID, noDeadline)
+	if err != nil {
+		t.Fatalf("cannot get series count: %s", err)
+	}
+	if n != metricsCount {
+		t.Fatalf("unexpected series count; got %d; want %d", n, metricsCount)
+	}
+	s.MustClose()
+}
+
+func TestStorageWALTruncate(t *testing.T) {
+	path := "TestStorageWALTruncate"
+	if err := SetWAL(WALSyncInterval, time.Millisecond); err != nil {
+		t.Fatalf("cannot enable WAL: %s", err)
+	}
+	defer func() {
+		walEnabled = false
+		_ = os.RemoveAll(path)
+	}()
+	if err := SetWAL("foo", 0); err == nil {
+		t.Fatalf("expecting non-nil error for unsupported sync policy")
+	}
+	if err := SetWAL(WALSyncInterval, 0); err == nil {
+		t.Fatalf("expecting non-nil error for zero sync interval")
+	}
+
+	s, err := OpenStorage(path, 0)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	var mn MetricName
+	mn.MetricGroup = []byte("foo")
+	mrs := []MetricRow{{
+		MetricNameRaw: mn.marshalRaw(nil),
+		Timestamp:     time.Now().UnixNano(),
+		Value:         []byte("bar"),
+	}}
+	ref, err := s.AppendWAL(mrs[0].Marshal(nil), defaultPrecisionBits)
+	if err != nil {
+		t.Fatalf("cannot append rows to WAL: %s", err)
+	}
+	if err := s.AddRows(mrs, defaultPrecisionBits); err != nil {
+		t.Fatalf("cannot add rows: %s", err)
+	}
+
+	// The segment with pending entry mustn't be removed.
+	s.truncateWAL(true)
+	if n := s.wal.SegmentsCount(); n != 2 {
+		t.Fatalf("unexpected number of WAL segments; got %d; want 2", n)
+	}
+
+	// The segment with rows, which aren't flushed to persistent storage yet, mustn't be removed.
+	ref.Release()
+	s.truncateWAL(true)
+	if n := s.wal.SegmentsCount(); n != 2 {
+		t.Fatalf("unexpected number of WAL segments before flush; got %d; want 2", n)
+	}
+	s.tb.flushRawRows()
+	s.truncateWAL(false)
+	if n := s.wal.SegmentsCount(); n != 2 {
+		t.Fatalf("unexpected number of WAL segments before flushing inmemory parts; got %d; want 2", n)
+	}
+
+	// The segment must be removed after the rows and index items for them are flushed to persistent storage.
+	ptws := s.tb.GetPartitions(nil)
+	for _, ptw := range ptws {
+		if _, err := ptw.pt.flushInmemoryParts(nil, true); err != nil {
+			t.Fatalf("cannot flush inmemory parts: %s", err)
+		}
+	}
+	s.tb.PutPartitions(ptws)
+	s.idb().tb.DebugFlush()
+	s.truncateWAL(false)
+	if n := s.wal.SegmentsCount(); n != 1 {
+		t.Fatalf("unexpected number of WAL segments after truncation; got %d; want 1", n)
+	}
+	if n := atomic.LoadUint64(&s.wal.sizeBytes); n != 0 {
+		t.Fatalf("unexpected WAL size after truncation; got %d; want 0", n)
+	}
+	s.MustClose()
+
+	idxs, err := listWALSegments(path + "/wal")
+	if err != nil {
+		t.Fatalf("cannot list WAL segments: %s", err)
+	}
+	if len(idxs) != 0 {
+		t.Fatalf("unexpected WAL segments left after close: %d", idxs)
+	}
+}